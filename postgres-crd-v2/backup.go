@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// The backup image needs pg_dump, gzip and the aws or gsutil CLI
+	BACKUP_IMAGE                = "kubeplus/postgres-backup:latest"
+	BACKUP_CONTAINER_NAME       = "backup"
+	BACKUP_CREDENTIALS_VOLUME   = "backup-credentials"
+	BACKUP_CREDENTIALS_PATH     = "/var/run/backup-credentials"
+	DEFAULT_BACKUP_RETENTION    = 7
+	BACKUP_CRONJOB_NAME_POSTFIX = "-backup"
+)
+
+// The CronJob controller names every Job <cronjob>-<scheduled time in
+// minutes>, so the suffix of the job name identifies a backup both in the
+// script and in the controller (see getBackupLocation).
+const backupScript = `set -e
+ts=${JOB_NAME##*-}
+case "$BACKUP_LOCATION" in
+  gs://*) gcloud auth activate-service-account --key-file="$GOOGLE_APPLICATION_CREDENTIALS" ;;
+esac
+for db in $DATABASES; do
+  pg_dump -h "$PGHOST" -p "$PGPORT" "$db" | gzip > "/tmp/$db.sql.gz"
+  case "$BACKUP_LOCATION" in
+    gs://*) gsutil cp "/tmp/$db.sql.gz" "$BACKUP_LOCATION/$ts/$db.sql.gz" ;;
+    *) aws s3 cp "/tmp/$db.sql.gz" "$BACKUP_LOCATION/$ts/$db.sql.gz" ;;
+  esac
+done
+case "$BACKUP_LOCATION" in
+  gs://*) gsutil ls "$BACKUP_LOCATION/" | sort | head -n -"$BACKUP_RETENTION" | xargs -r gsutil -m rm -r ;;
+  *) aws s3 ls "$BACKUP_LOCATION/" | awk '{print $2}' | sort | head -n -"$BACKUP_RETENTION" |
+       xargs -r -I{} aws s3 rm --recursive "$BACKUP_LOCATION/{}" ;;
+esac
+`
+
+func getBackupCronJobName(deploymentName string) string {
+	return deploymentName + BACKUP_CRONJOB_NAME_POSTFIX
+}
+
+// getBackupPrefix returns the bucket URL under which the backups of this
+// resource are stored, one directory per run.
+func getBackupPrefix(foo *postgresv1.Postgres) string {
+	return strings.TrimSuffix(foo.Spec.Backup.Bucket, "/") + "/" + foo.Spec.DeploymentName
+}
+
+func getBackupLocation(foo *postgresv1.Postgres, scheduleTime metav1.Time) string {
+	return fmt.Sprintf("%s/%d", getBackupPrefix(foo), scheduleTime.Unix()/60)
+}
+
+func validateBackup(backup *postgresv1.BackupSpec) []string {
+	var problems []string
+	if backup.Schedule == "" {
+		problems = append(problems, "spec.backup.schedule must be specified")
+	}
+	if !strings.HasPrefix(backup.Bucket, "s3://") && !strings.HasPrefix(backup.Bucket, "gs://") {
+		problems = append(problems, "spec.backup.bucket must be an s3:// or gs:// URL")
+	}
+	if backup.Retention < 0 {
+		problems = append(problems, "spec.backup.retention must not be negative")
+	}
+	return problems
+}
+
+func getBackupCronJob(foo *postgresv1.Postgres, namespace string) *batchv1beta1.CronJob {
+	backup := foo.Spec.Backup
+	deploymentName := foo.Spec.DeploymentName
+	image := backup.Image
+	if image == "" {
+		image = BACKUP_IMAGE
+	}
+	retention := backup.Retention
+	if retention == 0 {
+		retention = DEFAULT_BACKUP_RETENTION
+	}
+	databases := getDatabaseNames(foo.Spec.Databases)
+	if len(databases) == 0 {
+		databases = []string{"postgres"}
+	}
+
+	container := apiv1.Container{
+		Name:    BACKUP_CONTAINER_NAME,
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", backupScript},
+		Env: []apiv1.EnvVar{
+			{
+				Name: "JOB_NAME",
+				ValueFrom: &apiv1.EnvVarSource{
+					FieldRef: &apiv1.ObjectFieldSelector{
+						APIVersion: "v1",
+						FieldPath:  "metadata.labels['job-name']",
+					},
+				},
+			},
+			// Connect to the instance directly, not through the pooler
+			{Name: "PGHOST", Value: getServiceHost(foo, namespace)},
+			{Name: "PGPORT", Value: "5432"},
+			getSecretEnv(foo, "PGUSER", "username"),
+			getSecretEnv(foo, "PGPASSWORD", "password"),
+			{Name: "DATABASES", Value: strings.Join(databases, " ")},
+			{Name: "BACKUP_LOCATION", Value: getBackupPrefix(foo)},
+			{Name: "BACKUP_RETENTION", Value: fmt.Sprint(retention)},
+			{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: BACKUP_CREDENTIALS_PATH + "/key.json"},
+		},
+		EnvFrom: []apiv1.EnvFromSource{
+			{
+				SecretRef: &apiv1.SecretEnvSource{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: backup.CredentialsSecretRef},
+				},
+			},
+		},
+		VolumeMounts: []apiv1.VolumeMount{
+			{
+				Name:      BACKUP_CREDENTIALS_VOLUME,
+				MountPath: BACKUP_CREDENTIALS_PATH,
+				ReadOnly:  true,
+			},
+		},
+	}
+
+	return &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getBackupCronJobName(deploymentName),
+			Labels: map[string]string{
+				"app": deploymentName,
+			},
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:          backup.Schedule,
+			ConcurrencyPolicy: batchv1beta1.ForbidConcurrent,
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: apiv1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: map[string]string{
+								"app": deploymentName,
+							},
+						},
+						Spec: apiv1.PodSpec{
+							RestartPolicy: apiv1.RestartPolicyOnFailure,
+							Containers:    []apiv1.Container{container},
+							Volumes: []apiv1.Volume{
+								{
+									Name: BACKUP_CREDENTIALS_VOLUME,
+									VolumeSource: apiv1.VolumeSource{
+										Secret: &apiv1.SecretVolumeSource{
+											SecretName: backup.CredentialsSecretRef,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// syncBackup creates, updates or deletes the backup CronJob of the resource
+// and records the last scheduled backup in the status.
+func (c *Controller) syncBackup(foo *postgresv1.Postgres) error {
+	cronJobsClient := c.kubeclientset.BatchV1beta1().CronJobs(c.getInstanceNamespace())
+	cronJobName := getBackupCronJobName(foo.Spec.DeploymentName)
+
+	current, err := cronJobsClient.Get(cronJobName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if foo.Spec.Backup == nil {
+		if err == nil {
+			fmt.Printf("Deleting backup cronjob %s...\n", cronJobName)
+			return cronJobsClient.Delete(cronJobName, &metav1.DeleteOptions{})
+		}
+		return nil
+	}
+
+	desired := getBackupCronJob(foo, c.getInstanceNamespace())
+	if errors.IsNotFound(err) {
+		fmt.Printf("Creating backup cronjob %s...\n", cronJobName)
+		_, err = cronJobsClient.Create(desired)
+		return err
+	}
+
+	if current.Spec.Schedule != desired.Spec.Schedule ||
+		!reflect.DeepEqual(current.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Image,
+			desired.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Image) ||
+		!reflect.DeepEqual(current.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env,
+			desired.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env) ||
+		!reflect.DeepEqual(current.Spec.JobTemplate.Spec.Template.Spec.Volumes,
+			desired.Spec.JobTemplate.Spec.Template.Spec.Volumes) {
+		fmt.Printf("Updating backup cronjob %s...\n", cronJobName)
+		cronJobCopy := current.DeepCopy()
+		cronJobCopy.Spec.Schedule = desired.Spec.Schedule
+		cronJobCopy.Spec.JobTemplate = desired.Spec.JobTemplate
+		current, err = cronJobsClient.Update(cronJobCopy)
+		if err != nil {
+			return err
+		}
+	}
+
+	if current.Status.LastScheduleTime == nil {
+		return nil
+	}
+	return c.updateBackupStatus(foo, *current.Status.LastScheduleTime)
+}
+
+func (c *Controller) updateBackupStatus(foo *postgresv1.Postgres, lastBackupTime metav1.Time) error {
+	location := getBackupLocation(foo, lastBackupTime)
+	if foo.Status.LastBackupLocation == location {
+		return nil
+	}
+	// Re-read the resource as the status was updated during this sync
+	latest, err := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(foo.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	fooCopy := latest.DeepCopy()
+	fooCopy.Status.LastBackupTime = &lastBackupTime
+	fooCopy.Status.LastBackupLocation = location
+	return c.patchStatus(latest, fooCopy)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestBackupCronJob(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle", "wordpress")
+	foo.Spec.Backup = &postgresv1.BackupSpec{
+		Schedule:             "0 3 * * *",
+		Bucket:               "s3://backups/",
+		CredentialsSecretRef: "aws-credentials",
+	}
+
+	cronJob := getBackupCronJob(foo, "default")
+	if cronJob.Name != "client25-backup" || cronJob.Spec.Schedule != "0 3 * * *" {
+		t.Errorf("expected cronjob client25-backup scheduled at 0 3 * * *, got %s %s",
+			cronJob.Name, cronJob.Spec.Schedule)
+	}
+	container := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
+	expected := map[string]string{
+		"PGHOST":           "client25.default.svc",
+		"DATABASES":        "moodle wordpress",
+		"BACKUP_LOCATION":  "s3://backups/client25",
+		"BACKUP_RETENTION": "7",
+	}
+	for name, value := range expected {
+		if got, _ := getEnv(container, name); got != value {
+			t.Errorf("expected %s=%q, got %q", name, value, got)
+		}
+	}
+	if container.EnvFrom[0].SecretRef.Name != "aws-credentials" {
+		t.Errorf("expected credentials from aws-credentials, got %v", container.EnvFrom)
+	}
+}
+
+func TestBackupLocationMatchesJobName(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Backup = &postgresv1.BackupSpec{Bucket: "gs://backups"}
+	scheduleTime := metav1.NewTime(time.Unix(1546300800, 0))
+	// The CronJob controller names the job client25-backup-25771680
+	expected := "gs://backups/client25/25771680"
+	if got := getBackupLocation(foo, scheduleTime); got != expected {
+		t.Errorf("expected %s\ngot %s", expected, got)
+	}
+}
+
+func TestValidateBackup(t *testing.T) {
+	problems := validateBackup(&postgresv1.BackupSpec{Bucket: "backups"})
+	if len(problems) != 2 {
+		t.Errorf("expected missing schedule and invalid bucket, got %v", problems)
+	}
+	problems = validateBackup(&postgresv1.BackupSpec{Schedule: "@daily", Bucket: "gs://backups"})
+	if len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
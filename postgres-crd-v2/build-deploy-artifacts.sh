@@ -1,6 +1,11 @@
 #!/bin/bash
 
-export GOOS=linux; go build .
+VERSION_PKG=github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/version
+GIT_VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo unknown)
+GIT_COMMIT=$(git rev-parse HEAD 2>/dev/null || echo unknown)
+BUILD_DATE=$(date -u +'%Y-%m-%dT%H:%M:%SZ')
+
+export GOOS=linux; go build -ldflags "-X ${VERSION_PKG}.gitVersion=${GIT_VERSION} -X ${VERSION_PKG}.gitCommit=${GIT_COMMIT} -X ${VERSION_PKG}.buildDate=${BUILD_DATE}" .
 cp postgres-crd-v2 ./artifacts/deployment/postgres-crd-v2
 docker build -t postgres-crd-v2:latest ./artifacts/deployment
 #docker build -t lmecld/postgres-crd-v2:latest ./artifacts/deployment
@@ -0,0 +1,56 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// Number of command results kept in the status
+const MAX_COMMAND_RESULTS = 50
+
+// getExecutedCommands returns the result of each command that ran when the
+// first done commands succeeded and, if err is set, the next one failed.
+// Commands not run and successful connect commands are left out, passwords
+// are redacted.
+func getExecutedCommands(commands []string, done int, err error) []postgresv1.CommandResult {
+	now := metav1.Now()
+	var results []postgresv1.CommandResult
+	for i, command := range commands {
+		if i > done || (i == done && err == nil) {
+			break
+		}
+		if i < done && isConnectCommand(command) {
+			continue
+		}
+		result := postgresv1.CommandResult{Command: redactCommand(command), Success: true, Time: now}
+		if i == done {
+			result.Success = false
+			result.Error = describeDatabaseError(err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// takeExecutedCommands returns and forgets the command results kept for foo.
+func (c *Controller) takeExecutedCommands(foo *postgresv1.Postgres) []postgresv1.CommandResult {
+	key := foo.Namespace + "/" + foo.Name
+	c.objectResultsLock.Lock()
+	defer c.objectResultsLock.Unlock()
+	results := c.commandResults[key]
+	delete(c.commandResults, key)
+	return results
+}
+
+// appendCommandResults appends results to the status, keeping the last
+// MAX_COMMAND_RESULTS.
+func appendCommandResults(status *postgresv1.PostgresStatus, results []postgresv1.CommandResult) {
+	if len(results) == 0 {
+		return
+	}
+	status.CommandResults = append(status.CommandResults, results...)
+	if extra := len(status.CommandResults) - MAX_COMMAND_RESULTS; extra > 0 {
+		status.CommandResults = status.CommandResults[extra:]
+	}
+}
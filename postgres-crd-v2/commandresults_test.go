@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestSetupDatabaseRecordsCommandResults(t *testing.T) {
+	commands := []string{
+		"create user devdatta with password 'pass123';",
+		"create database moodle owner devdatta;",
+		"grant all on database moodle to devdatta;",
+		"create database wordpress;",
+	}
+	executor := &fakeExecutor{errors: map[string]error{commands[2]: fmt.Errorf("permission denied")}}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+	foo := newTestPostgres(nil)
+
+	if err := c.setupDatabase(foo, dbEndpoint{}, commands, []string{"moodle"}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	var status postgresv1.PostgresStatus
+	appendCommandResults(&status, c.takeExecutedCommands(foo))
+
+	if len(status.CommandResults) != 3 {
+		t.Fatalf("expected the results of the 3 commands run, got %+v", status.CommandResults)
+	}
+	if first := status.CommandResults[0]; first.Command != "create user devdatta with password '***';" || !first.Success {
+		t.Errorf("expected the redacted user command to succeed, got %+v", first)
+	}
+	if failed := status.CommandResults[2]; failed.Success || failed.Error != "permission denied" ||
+		failed.Command != commands[2] {
+		t.Errorf("expected the grant to fail with its error, got %+v", failed)
+	}
+	if results := c.takeExecutedCommands(foo); len(results) != 0 {
+		t.Errorf("expected the results to be taken once, got %v", results)
+	}
+}
+
+func TestAppendCommandResultsKeepsTheLast(t *testing.T) {
+	var status postgresv1.PostgresStatus
+	for i := 0; i < MAX_COMMAND_RESULTS+5; i++ {
+		appendCommandResults(&status, []postgresv1.CommandResult{{Command: fmt.Sprint(i), Success: true}})
+	}
+	if len(status.CommandResults) != MAX_COMMAND_RESULTS {
+		t.Fatalf("expected %d results, got %d", MAX_COMMAND_RESULTS, len(status.CommandResults))
+	}
+	if status.CommandResults[0].Command != "5" {
+		t.Errorf("expected the oldest results to be dropped, got %s first", status.CommandResults[0].Command)
+	}
+}
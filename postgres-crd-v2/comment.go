@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// hasComments returns true if a database or user of the spec sets its
+// comment, which then has to be diffed.
+func hasComments(foo *postgresv1.Postgres) bool {
+	for _, db := range foo.Spec.Databases {
+		if db.Comment != nil {
+			return true
+		}
+	}
+	for _, user := range foo.Spec.Users {
+		if user.Comment != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// queryComments connects to the instance and returns the comments of the
+// databases and roles, so that comments changed out-of-band are reverted.
+func (c *Controller) queryComments(endpoint dbEndpoint) (map[string]string, map[string]string, error) {
+	executor := c.newDBExecutor()
+	err := executor.Connect(c.getConnectEndpoint(endpoint), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer executor.Close()
+
+	databaseComments, err := executor.QueryDatabaseComments()
+	if err != nil {
+		return nil, nil, err
+	}
+	roleComments, err := executor.QueryRoleComments()
+	if err != nil {
+		return nil, nil, err
+	}
+	return databaseComments, roleComments, nil
+}
+
+// getRecordedComments returns the comments recorded in the status, used
+// when the instance is not queried.
+func getRecordedComments(status *postgresv1.PostgresStatus) (map[string]string, map[string]string) {
+	databaseComments := map[string]string{}
+	for _, db := range status.Databases {
+		if db.Comment != nil {
+			databaseComments[strings.ToLower(db.Name)] = *db.Comment
+		}
+	}
+	roleComments := map[string]string{}
+	for _, user := range status.Users {
+		if user.Comment != nil {
+			roleComments[strings.ToLower(user.User)] = *user.Comment
+		}
+	}
+	return databaseComments, roleComments
+}
+
+// getCommentStatement renders the comment on an object, an empty comment
+// removes it.
+func getCommentStatement(kind string, name string, comment string) string {
+	value := "null"
+	if comment != "" {
+		value = "'" + strings.Replace(comment, "'", "''", -1) + "'"
+	}
+	return fmt.Sprintf("comment on %s %s is %s;", kind, pq.QuoteIdentifier(strings.ToLower(name)), value)
+}
+
+// getCommentCommands returns the commands setting the comments of the
+// databases and users that differ from the current ones. Objects whose
+// comment is not set in the spec are left as they are. New objects have no
+// comment yet.
+func getCommentCommands(databases []postgresv1.DatabaseSpec, users []postgresv1.UserSpec,
+	databaseComments map[string]string, roleComments map[string]string) []string {
+	var commands []string
+	for _, db := range databases {
+		if db.Comment != nil && databaseComments[strings.ToLower(db.Name)] != *db.Comment {
+			commands = append(commands, getCommentStatement("database", db.Name, *db.Comment))
+		}
+	}
+	for _, user := range users {
+		if user.Comment != nil && roleComments[strings.ToLower(user.User)] != *user.Comment {
+			commands = append(commands, getCommentStatement("role", user.User, *user.Comment))
+		}
+	}
+	return commands
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func stringPtr(s string) *string { return &s }
+
+func TestCommentIsSetChangedAndCleared(t *testing.T) {
+	databases := []postgresv1.DatabaseSpec{{Name: "moodle", Comment: stringPtr("Moodle's courses")}, {Name: "blog"}}
+	users := []postgresv1.UserSpec{{User: "devdatta", Comment: stringPtr("application role")}}
+
+	// Set on new objects
+	commands := getCommentCommands(databases, users, nil, nil)
+	expected := []string{
+		"comment on database \"moodle\" is 'Moodle''s courses';",
+		"comment on role \"devdatta\" is 'application role';",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+
+	// Unchanged, the database without a comment in the spec is left as is
+	databaseComments := map[string]string{"moodle": "Moodle's courses", "blog": "set out-of-band"}
+	roleComments := map[string]string{"devdatta": "application role"}
+	if commands := getCommentCommands(databases, users, databaseComments, roleComments); len(commands) != 0 {
+		t.Errorf("expected no commands, got %v", commands)
+	}
+
+	// Changed
+	users[0].Comment = stringPtr("reporting role")
+	commands = getCommentCommands(databases, users, databaseComments, roleComments)
+	if expected := []string{"comment on role \"devdatta\" is 'reporting role';"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+
+	// Cleared
+	databases[0].Comment = stringPtr("")
+	commands = getCommentCommands(databases, nil, databaseComments, nil)
+	if expected := []string{"comment on database \"moodle\" is null;"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+}
+
+func TestRecordedCommentsAreDiffed(t *testing.T) {
+	status := &postgresv1.PostgresStatus{
+		Databases: []postgresv1.DatabaseSpec{{Name: "moodle", Comment: stringPtr("courses")}},
+		Users:     []postgresv1.UserSpec{{User: "devdatta"}},
+	}
+	databaseComments, roleComments := getRecordedComments(status)
+	databases := []postgresv1.DatabaseSpec{{Name: "moodle", Comment: stringPtr("courses")}}
+	users := []postgresv1.UserSpec{{User: "devdatta", Comment: stringPtr("application role")}}
+	commands := getCommentCommands(databases, users, databaseComments, roleComments)
+	if expected := []string{"comment on role \"devdatta\" is 'application role';"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getCondition returns the condition of the given type, or nil.
+func getCondition(status *postgresv1.PostgresStatus, conditionType postgresv1.PostgresConditionType) *postgresv1.PostgresCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition adds or replaces the condition of the same type. The
+// transition time is only changed when the condition status changes.
+func setCondition(status *postgresv1.PostgresStatus, condition postgresv1.PostgresCondition) {
+	existing := getCondition(status, condition.Type)
+	if existing == nil {
+		status.Conditions = append(status.Conditions, condition)
+		return
+	}
+	if existing.Status == condition.Status {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	*existing = condition
+}
+
+func newCondition(conditionType postgresv1.PostgresConditionType, status corev1.ConditionStatus,
+	reason string, message string) postgresv1.PostgresCondition {
+	return postgresv1.PostgresCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// setPhaseConditions derives the Ready and Progressing conditions from the
+// legacy Status.Status phase string so that both are always written together.
+func setPhaseConditions(status *postgresv1.PostgresStatus, phase string, message string) {
+	switch phase {
+	case "READY", "EXTERNAL":
+		setCondition(status, newCondition(postgresv1.Ready, corev1.ConditionTrue, "Reconciled", message))
+		setCondition(status, newCondition(postgresv1.Progressing, corev1.ConditionFalse, "Reconciled", ""))
+		clearDegradedDatabase(status)
+	case "UPDATING":
+		setCondition(status, newCondition(postgresv1.Ready, corev1.ConditionFalse, "Updating", message))
+		setCondition(status, newCondition(postgresv1.Progressing, corev1.ConditionTrue, "Updating", message))
+	case "SUSPENDED":
+		setCondition(status, newCondition(postgresv1.Progressing, corev1.ConditionFalse, "Suspended",
+			"Reconciliation is suspended"))
+	case "Failed":
+		setCondition(status, newCondition(postgresv1.Ready, corev1.ConditionFalse, "ReconcileFailed", message))
+		setCondition(status, newCondition(postgresv1.Progressing, corev1.ConditionFalse, "ReconcileFailed", message))
+	}
+}
+
+// clearDegradedDatabase marks a previously failed command as resolved.
+func clearDegradedDatabase(status *postgresv1.PostgresStatus) {
+	existing := getCondition(status, postgresv1.DegradedDatabase)
+	if existing != nil && existing.Status == corev1.ConditionTrue {
+		setCondition(status, newCondition(postgresv1.DegradedDatabase, corev1.ConditionFalse, "Succeeded", ""))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func expectCondition(t *testing.T, status *postgresv1.PostgresStatus, conditionType postgresv1.PostgresConditionType,
+	expected corev1.ConditionStatus) {
+	condition := getCondition(status, conditionType)
+	if condition == nil {
+		t.Errorf("expected %s condition, got %v", conditionType, status.Conditions)
+		return
+	}
+	if condition.Status != expected {
+		t.Errorf("expected %s=%s, got %s", conditionType, expected, condition.Status)
+	}
+}
+
+func TestSetPhaseConditions(t *testing.T) {
+	status := &postgresv1.PostgresStatus{}
+
+	setPhaseConditions(status, "UPDATING", "")
+	expectCondition(t, status, postgresv1.Ready, corev1.ConditionFalse)
+	expectCondition(t, status, postgresv1.Progressing, corev1.ConditionTrue)
+
+	setCondition(status, newCondition(postgresv1.DegradedDatabase, corev1.ConditionTrue, "syntax_error", ""))
+	setPhaseConditions(status, "READY", "")
+	expectCondition(t, status, postgresv1.Ready, corev1.ConditionTrue)
+	expectCondition(t, status, postgresv1.Progressing, corev1.ConditionFalse)
+	expectCondition(t, status, postgresv1.DegradedDatabase, corev1.ConditionFalse)
+
+	setPhaseConditions(status, "Failed", "boom")
+	expectCondition(t, status, postgresv1.Ready, corev1.ConditionFalse)
+	if len(status.Conditions) != 3 {
+		t.Errorf("expected 3 conditions, got %v", status.Conditions)
+	}
+}
+
+func TestSetConditionKeepsTransitionTime(t *testing.T) {
+	status := &postgresv1.PostgresStatus{}
+	first := newCondition(postgresv1.Ready, corev1.ConditionTrue, "Reconciled", "")
+	setCondition(status, first)
+
+	second := newCondition(postgresv1.Ready, corev1.ConditionTrue, "Reconciled", "")
+	second.LastTransitionTime.Time = first.LastTransitionTime.Add(1000)
+	setCondition(status, second)
+	if !getCondition(status, postgresv1.Ready).LastTransitionTime.Equal(&first.LastTransitionTime) {
+		t.Errorf("expected transition time to be kept when the status does not change")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	CONFIG_MOUNT_PATH  = "/etc/postgresql/custom"
+	CONFIG_VOLUME_NAME = "postgres-config"
+	// Set on the pod template so that a change of the ConfigMap rolls the Pod
+	CONFIG_HASH_ANNOTATION = "postgrescontroller.kubeplus/config-hash"
+)
+
+// addConfig mounts the ConfigMap of Spec.ConfigMapRef and starts Postgres
+// with its postgresql.conf. A pg_hba.conf in the same ConfigMap is used by
+// setting hba_file = '/etc/postgresql/custom/pg_hba.conf' in postgresql.conf.
+func addConfig(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if foo.Spec.ConfigMapRef == "" {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+		Name: CONFIG_VOLUME_NAME,
+		VolumeSource: apiv1.VolumeSource{
+			ConfigMap: &apiv1.ConfigMapVolumeSource{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: foo.Spec.ConfigMapRef},
+			},
+		},
+	})
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+		Name:      CONFIG_VOLUME_NAME,
+		MountPath: CONFIG_MOUNT_PATH,
+		ReadOnly:  true,
+	})
+	container.Args = []string{"postgres", "-c", "config_file=" + path.Join(CONFIG_MOUNT_PATH, "postgresql.conf")}
+}
+
+func getConfigMapHash(configMap *apiv1.ConfigMap) string {
+	var keys []string
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, configMap.Data[key])
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// getConfigHash returns the hash of the referenced ConfigMap. The ConfigMap
+// has to be in the namespace of the Pod.
+func (c *Controller) getConfigHash(foo *postgresv1.Postgres) (string, error) {
+	configMap, err := c.kubeclientset.CoreV1().ConfigMaps(c.getInstanceNamespace()).Get(foo.Spec.ConfigMapRef, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if _, ok := configMap.Data["postgresql.conf"]; !ok {
+		return "", fmt.Errorf("configmap %s has no postgresql.conf key", foo.Spec.ConfigMapRef)
+	}
+	return getConfigMapHash(configMap), nil
+}
+
+// addConfigHash records the hash of the referenced ConfigMap on the pod
+// template so that syncConfig can detect changes.
+func (c *Controller) addConfigHash(deployment *appsv1.Deployment, foo *postgresv1.Postgres) error {
+	if foo.Spec.ConfigMapRef == "" {
+		return nil
+	}
+	configHash, err := c.getConfigHash(foo)
+	if err != nil {
+		return err
+	}
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[CONFIG_HASH_ANNOTATION] = configHash
+	return nil
+}
+
+// syncConfig restarts the Pod when the referenced ConfigMap changed, as
+// settings such as shared_buffers only take effect on restart.
+func (c *Controller) syncConfig(foo *postgresv1.Postgres) error {
+	if foo.Spec.ConfigMapRef == "" {
+		return nil
+	}
+	configHash, err := c.getConfigHash(foo)
+	if err != nil {
+		return err
+	}
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
+	deployment, err := deploymentsClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if deployment.Spec.Template.Annotations[CONFIG_HASH_ANNOTATION] == configHash {
+		return nil
+	}
+	fmt.Printf("Configuration of %s changed, restarting\n", foo.Spec.DeploymentName)
+	deploymentCopy := deployment.DeepCopy()
+	if deploymentCopy.Spec.Template.Annotations == nil {
+		deploymentCopy.Spec.Template.Annotations = map[string]string{}
+	}
+	deploymentCopy.Spec.Template.Annotations[CONFIG_HASH_ANNOTATION] = configHash
+	_, err = deploymentsClient.Update(deploymentCopy)
+	return err
+}
+
+// handleConfigMap enqueues the Postgres resources using the ConfigMap.
+func (c *Controller) handleConfigMap(obj interface{}) {
+	configMap, ok := obj.(*apiv1.ConfigMap)
+	if !ok || configMap.Namespace != c.getInstanceNamespace() {
+		return
+	}
+	all, err := c.foosLister.Postgreses(c.namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, foo := range all {
+		if foo.Spec.ConfigMapRef == configMap.Name {
+			glog.V(4).Infof("ConfigMap %s of postgres %s changed", configMap.Name, foo.Name)
+			c.enqueueFoo(foo)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestConfigMapIsMounted(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.ConfigMapRef = "client25-config"
+
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	container := podSpec.Containers[0]
+	expectedArgs := []string{"postgres", "-c", "config_file=/etc/postgresql/custom/postgresql.conf"}
+	if !reflect.DeepEqual(container.Args, expectedArgs) {
+		t.Errorf("expected %v\ngot %v", expectedArgs, container.Args)
+	}
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != CONFIG_MOUNT_PATH {
+		t.Errorf("expected config mounted at %s, got %v", CONFIG_MOUNT_PATH, container.VolumeMounts)
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].ConfigMap.Name != "client25-config" {
+		t.Errorf("expected volume from configmap client25-config, got %v", podSpec.Volumes)
+	}
+}
+
+func TestNoConfigMapKeepsImageDefaults(t *testing.T) {
+	container := getDeployment(newTestPostgres(nil)).Spec.Template.Spec.Containers[0]
+	if container.Args != nil {
+		t.Errorf("expected no args, got %v", container.Args)
+	}
+}
+
+func TestConfigMapHashChangesWithData(t *testing.T) {
+	configMap := &apiv1.ConfigMap{Data: map[string]string{
+		"postgresql.conf": "shared_buffers = 128MB\n",
+		"pg_hba.conf":     "host all all 0.0.0.0/0 md5\n",
+	}}
+	hash := getConfigMapHash(configMap)
+	if hash != getConfigMapHash(configMap.DeepCopy()) {
+		t.Errorf("expected the hash to be stable")
+	}
+	configMap.Data["postgresql.conf"] = "shared_buffers = 256MB\n"
+	if hash == getConfigMapHash(configMap) {
+		t.Errorf("expected the hash to change with the data")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ConnectRetry bounds the retries of a connection to an instance that does
+// not accept connections yet. Postgres may still be initializing for a
+// moment after its Pod is reported ready.
+type ConnectRetry struct {
+	// Attempts is the number of connection attempts, at least 1
+	Attempts int
+	// Interval is the delay before the first retry. It doubles after each
+	// retry up to MaxInterval.
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+// DefaultConnectRetry waits up to about a minute for Postgres to start.
+var DefaultConnectRetry = ConnectRetry{
+	Attempts:    8,
+	Interval:    500 * time.Millisecond,
+	MaxInterval: 16 * time.Second,
+}
+
+// isStartingUp returns true for the errors of an instance that does not
+// accept connections yet: the server refusing them while it starts or
+// recovers, or the connection being refused or dropped before the server
+// listens. Authentication and other errors are not retried.
+func isStartingUp(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "57P03"
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// openWithRetry opens a connection with open, backing off while the
+// instance is starting up. The last error is returned once the attempts are
+// exhausted so that the reconcile fails and is requeued.
+func openWithRetry(open func(endpoint dbEndpoint, dbname string) (*sql.DB, error), retry ConnectRetry,
+	sleep func(time.Duration), endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+	interval := retry.Interval
+	for attempt := 1; ; attempt++ {
+		db, err := open(endpoint, dbname)
+		if err == nil || attempt >= retry.Attempts || !isStartingUp(err) {
+			return db, err
+		}
+		fmt.Printf("Postgres at %s is not accepting connections yet (%v), retrying in %v...\n",
+			endpoint.Host, err, interval)
+		sleep(interval)
+		interval *= 2
+		if retry.MaxInterval > 0 && interval > retry.MaxInterval {
+			interval = retry.MaxInterval
+		}
+	}
+}
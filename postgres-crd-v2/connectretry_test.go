@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestOpenWithRetryWaitsForStartup(t *testing.T) {
+	db := &sql.DB{}
+	startupErrors := []error{
+		&net.OpError{Op: "dial", Net: "tcp", Err: io.EOF},
+		&pq.Error{Code: "57P03", Message: "the database system is starting up"},
+		io.EOF,
+	}
+	attempts := 0
+	open := func(endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+		attempts++
+		if attempts <= len(startupErrors) {
+			return nil, startupErrors[attempts-1]
+		}
+		return db, nil
+	}
+	var slept []time.Duration
+	sleep := func(d time.Duration) { slept = append(slept, d) }
+	retry := ConnectRetry{Attempts: 5, Interval: time.Second, MaxInterval: 3 * time.Second}
+
+	opened, err := openWithRetry(open, retry, sleep, dbEndpoint{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opened != db {
+		t.Errorf("expected the connection of the last attempt")
+	}
+	if expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; !reflect.DeepEqual(slept, expected) {
+		t.Errorf("expected backoff %v, got %v", expected, slept)
+	}
+}
+
+func TestOpenWithRetryGivesUp(t *testing.T) {
+	startupErr := &pq.Error{Code: "57P03", Message: "the database system is starting up"}
+	attempts := 0
+	open := func(endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+		attempts++
+		return nil, startupErr
+	}
+	retry := ConnectRetry{Attempts: 3, Interval: time.Second}
+	_, err := openWithRetry(open, retry, func(time.Duration) {}, dbEndpoint{}, "")
+	if err != startupErr {
+		t.Errorf("expected the last error to be returned, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	// Wrong credentials do not go away by waiting
+	attempts = 0
+	authErr := &pq.Error{Code: "28P01", Message: "password authentication failed"}
+	open = func(endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+		attempts++
+		return nil, authErr
+	}
+	_, err = openWithRetry(open, retry, func(time.Duration) {}, dbEndpoint{}, "")
+	if err != authErr || attempts != 1 {
+		t.Errorf("expected a single attempt failing with %v, got %d attempts and %v", authErr, attempts, err)
+	}
+}
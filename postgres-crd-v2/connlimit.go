@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func validateDatabaseConnectionLimits(foo *postgresv1.Postgres) []string {
+	var problems []string
+	for _, db := range foo.Spec.Databases {
+		if db.ConnectionLimit != nil && *db.ConnectionLimit < -1 {
+			problems = append(problems, fmt.Sprintf("database %s: invalid connectionLimit %d, must be -1 or greater",
+				db.Name, *db.ConnectionLimit))
+		}
+	}
+	return problems
+}
+
+// hasDatabaseConnectionLimits returns true if a database of the spec sets
+// its connection limit, which then has to be diffed.
+func hasDatabaseConnectionLimits(foo *postgresv1.Postgres) bool {
+	for _, db := range foo.Spec.Databases {
+		if db.ConnectionLimit != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// getRecordedConnectionLimits returns the connection limits recorded in the
+// status, used when the instance is not queried. Databases created without a
+// limit have none.
+func getRecordedConnectionLimits(statusList []postgresv1.DatabaseSpec) map[string]int32 {
+	limits := map[string]int32{}
+	for _, db := range statusList {
+		limits[db.Name] = -1
+		if db.ConnectionLimit != nil {
+			limits[db.Name] = *db.ConnectionLimit
+		}
+	}
+	return limits
+}
+
+// queryConnectionLimits connects to the instance and returns the connection
+// limit of each database, so that limits changed out-of-band are reverted.
+func (c *Controller) queryConnectionLimits(endpoint dbEndpoint) (map[string]int32, error) {
+	executor := c.newDBExecutor()
+	err := executor.Connect(c.getConnectEndpoint(endpoint), "")
+	if err != nil {
+		return nil, err
+	}
+	defer executor.Close()
+
+	limits, err := executor.QueryConnectionLimits()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Live Connection Limits:%v\n", limits)
+	return limits, nil
+}
+
+// getAlterDatabaseConnectionLimitCommands sets the connection limit of the
+// existing databases whose limit differs from the one in the spec. New
+// databases are created with their limit.
+func getAlterDatabaseConnectionLimitCommands(desiredList []postgresv1.DatabaseSpec, currentLimits map[string]int32,
+	currentList []string) []string {
+	var cmdList []string
+	for _, db := range desiredList {
+		if db.ConnectionLimit == nil || !contains(currentList, db.Name) {
+			continue
+		}
+		if limit, ok := currentLimits[db.Name]; ok && limit == *db.ConnectionLimit {
+			continue
+		}
+		alterDBCmd := "alter database " + db.Name + " connection limit " + strconv.Itoa(int(*db.ConnectionLimit)) + ";"
+		fmt.Printf("AlterDBCmd: %v\n", alterDBCmd)
+		cmdList = append(cmdList, alterDBCmd)
+	}
+	return cmdList
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestCreateDatabaseWithConnectionLimit(t *testing.T) {
+	limit := int32(20)
+	commands := getCreateDatabaseCommands([]postgresv1.DatabaseSpec{{Name: "moodle", ConnectionLimit: &limit}})
+	if expected := []string{"create database moodle connection limit 20;"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+}
+
+func TestAlterDatabaseConnectionLimit(t *testing.T) {
+	limit := int32(20)
+	unlimited := int32(-1)
+	desired := []postgresv1.DatabaseSpec{
+		{Name: "moodle", ConnectionLimit: &limit},
+		{Name: "wordpress", ConnectionLimit: &unlimited},
+		{Name: "blog"},
+		{Name: "lms", ConnectionLimit: &limit},
+	}
+	// lms is created with its limit
+	current := []string{"moodle", "wordpress", "blog"}
+	live := map[string]int32{"moodle": 5, "wordpress": -1, "blog": 3}
+
+	commands := getAlterDatabaseConnectionLimitCommands(desired, live, current)
+	if expected := []string{"alter database moodle connection limit 20;"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+
+	// Without the live state the status is diffed
+	recorded := getRecordedConnectionLimits([]postgresv1.DatabaseSpec{{Name: "moodle", ConnectionLimit: &limit},
+		{Name: "wordpress"}})
+	if commands := getAlterDatabaseConnectionLimitCommands(desired, recorded, current); len(commands) > 0 {
+		t.Errorf("expected no commands for the recorded limits, got %v", commands)
+	}
+}
+
+func TestSyncRevertsDatabaseConnectionLimit(t *testing.T) {
+	f := newFixture(t)
+	limit := int32(20)
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = []postgresv1.DatabaseSpec{{Name: "moodle", ConnectionLimit: &limit}}
+	foo.Status.Status = "READY"
+	foo.Status.Databases = []postgresv1.DatabaseSpec{{Name: "moodle", ConnectionLimit: &limit}}
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+
+	// Live state, the limit was changed out-of-band
+	mock := f.expectConnection()
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectQuery("SELECT rolname FROM pg_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname"}).AddRow("postgres"))
+	mock.ExpectClose()
+	mock = f.expectConnection()
+	mock.ExpectQuery("SELECT datname, datconnlimit FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname", "datconnlimit"}).AddRow("postgres", -1).AddRow("moodle", 100))
+	mock.ExpectClose()
+	// Reconcile
+	mock = f.expectConnection()
+	expectExec(mock, "alter database moodle connection limit 20;")
+	mock.ExpectClose()
+
+	f.run("default/client25")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// addContainerOptions adds Spec.Env and Spec.Args to the Postgres container
+// once the controller has set up its own. Variables the controller sets win
+// over those of the same name in Spec.Env so that e.g. the superuser
+// password cannot be overridden. The arguments the controller sets follow
+// Spec.Args, without the leading postgres command.
+func addContainerOptions(deployment *appsv1.Deployment, foo *postgresv1.Postgres) {
+	container := getPostgresContainer(deployment)
+	if container == nil {
+		return
+	}
+	for _, env := range foo.Spec.Env {
+		if !hasEnv(container, env.Name) {
+			container.Env = append(container.Env, *env.DeepCopy())
+		}
+	}
+	if len(foo.Spec.Args) == 0 {
+		return
+	}
+	controllerArgs := container.Args
+	if len(controllerArgs) > 0 && controllerArgs[0] == "postgres" {
+		controllerArgs = controllerArgs[1:]
+	}
+	args := append([]string{}, foo.Spec.Args...)
+	container.Args = append(args, controllerArgs...)
+}
+
+func hasEnv(container *apiv1.Container, name string) bool {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
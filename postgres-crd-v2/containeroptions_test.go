@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestDeploymentEnvKeepsControllerPassword(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Env = []apiv1.EnvVar{
+		{Name: "POSTGRES_INITDB_ARGS", Value: "--data-checksums"},
+		{Name: "POSTGRES_PASSWORD", Value: "override"},
+	}
+	container := getPostgresContainer(getDeployment(foo))
+
+	if value, ok := getEnv(*container, "POSTGRES_INITDB_ARGS"); !ok || value != "--data-checksums" {
+		t.Errorf("expected POSTGRES_INITDB_ARGS to be set, got %q", value)
+	}
+	var passwords []apiv1.EnvVar
+	for _, env := range container.Env {
+		if env.Name == "POSTGRES_PASSWORD" {
+			passwords = append(passwords, env)
+		}
+	}
+	expected := []apiv1.EnvVar{getSuperuserPasswordEnv(foo, "POSTGRES_PASSWORD")}
+	if !reflect.DeepEqual(passwords, expected) {
+		t.Errorf("expected the password from the superuser secret\ngot %v", passwords)
+	}
+}
+
+func TestDeploymentArgsPrecedeControllerArgs(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Args = []string{"postgres", "-c", "max_connections=200"}
+	if args := getPostgresContainer(getDeployment(foo)).Args; !reflect.DeepEqual(args, foo.Spec.Args) {
+		t.Errorf("expected args %v\ngot %v", foo.Spec.Args, args)
+	}
+
+	foo.Spec.ConfigMapRef = "client25-config"
+	expected := []string{"postgres", "-c", "max_connections=200", "-c", "config_file=/etc/postgresql/custom/postgresql.conf"}
+	if args := getPostgresContainer(getDeployment(foo)).Args; !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected args %v\ngot %v", expected, args)
+	}
+}
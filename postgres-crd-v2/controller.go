@@ -22,11 +22,9 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"database/sql"
-	_ "github.com/lib/pq"
-
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	apiutil "k8s.io/apimachinery/pkg/util/intstr"
@@ -36,6 +34,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -45,6 +44,7 @@ import (
 	appslisters "k8s.io/client-go/listers/apps/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 
 	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
@@ -52,6 +52,7 @@ import (
 	postgresscheme "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned/scheme"
 	informers "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/informers/externalversions"
 	listers "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/listers/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/connection"
 )
 
 const controllerAgentName = "postgres-controller"
@@ -87,6 +88,7 @@ type Controller struct {
 	deploymentsSynced cache.InformerSynced
 	foosLister        listers.PostgresLister
 	foosSynced        cache.InformerSynced
+	configMapsSynced  cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -97,6 +99,35 @@ type Controller struct {
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
+	// newDBExecutor returns the executor used for each connection to Postgres
+	newDBExecutor func() DBExecutor
+	// failoverRetry bounds the retries of a sync against an external
+	// instance that turned read-only
+	failoverRetry ConnectRetry
+	// cachesSynced is set to 1 once the informer caches have synced and
+	// backs the /readyz check
+	cachesSynced int32
+
+	// maxRetries is the number of retries after which a resource is given
+	// up, abandoned holds the spec hashes of the resources given up
+	maxRetries    int
+	abandoned     map[string]string
+	abandonedLock sync.Mutex
+
+	// objectResults and commandResults hold the outcome of the database
+	// and user commands, and of each command, run for each resource until
+	// its next status update
+	objectResults     map[string][]objectResult
+	commandResults    map[string][]postgresv1.CommandResult
+	objectResultsLock sync.Mutex
+
+	// serviceDNS is set when the controller runs in the cluster and
+	// connects to instances through their Service DNS name
+	serviceDNS bool
+
+	// namespace is the only namespace watched by the controller, empty when
+	// watching all namespaces
+	namespace string
 }
 
 // NewController returns a new sample controller
@@ -104,12 +135,15 @@ func NewController(
 	kubeclientset kubernetes.Interface,
 	sampleclientset clientset.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
-	sampleInformerFactory informers.SharedInformerFactory) *Controller {
+	sampleInformerFactory informers.SharedInformerFactory,
+	retryPolicy RetryPolicy,
+	namespace string) *Controller {
 
 	// obtain references to shared index informers for the Deployment and Foo
 	// types.
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
 	fooInformer := sampleInformerFactory.Postgrescontroller().V1().Postgreses()
+	configMapInformer := kubeInformerFactory.Core().V1().ConfigMaps()
 
 	// Create event broadcaster
 	// Add postgres-controller types to the default Kubernetes Scheme so Events can be
@@ -128,8 +162,14 @@ func NewController(
 		deploymentsSynced: deploymentInformer.Informer().HasSynced,
 		foosLister:        fooInformer.Lister(),
 		foosSynced:        fooInformer.Informer().HasSynced,
-		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Postgreses"),
+		configMapsSynced:  configMapInformer.Informer().HasSynced,
+		workqueue:         workqueue.NewNamedRateLimitingQueue(retryPolicy.rateLimiter(), "Postgreses"),
 		recorder:          recorder,
+		newDBExecutor:     func() DBExecutor { return newPQExecutor(DefaultConnectRetry) },
+		failoverRetry:     DefaultFailoverRetry,
+		maxRetries:        retryPolicy.MaxRetries,
+		abandoned:         map[string]string{},
+		namespace:         namespace,
 	}
 
 	glog.Info("Setting up event handlers")
@@ -160,10 +200,32 @@ func NewController(
 		},
 		DeleteFunc: controller.handleObject,
 	})
+	// Postgres resources referencing a ConfigMap are not its owners, so they
+	// are looked up by Spec.ConfigMapRef.
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			newConfigMap := new.(*corev1.ConfigMap)
+			oldConfigMap := old.(*corev1.ConfigMap)
+			if newConfigMap.ResourceVersion == oldConfigMap.ResourceVersion {
+				return
+			}
+			controller.handleConfigMap(new)
+		},
+	})
 
 	return controller
 }
 
+// getInstanceNamespace returns the namespace the Deployments, Services and
+// other objects of the instances are created in. A namespaced controller
+// only sees its own namespace, otherwise the default namespace is used.
+func (c *Controller) getInstanceNamespace() string {
+	if c.namespace != "" {
+		return c.namespace
+	}
+	return apiv1.NamespaceDefault
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -177,7 +239,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.foosSynced); !ok {
+	if ok := c.waitForCacheSync(stopCh); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -238,12 +300,21 @@ func (c *Controller) processNextWorkItem() bool {
 		// Run the syncHandler, passing it the namespace/name string of the
 		// Foo resource to be synced.
 		if err := c.syncHandler(key); err != nil {
-			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+			// A resource failing permanently is given up instead of
+			// being retried forever
+			if c.maxRetries > 0 && c.workqueue.NumRequeues(obj) >= c.maxRetries {
+				c.workqueue.Forget(obj)
+				c.abandon(key)
+				return fmt.Errorf("error syncing '%s', giving up after %d retries: %s", key, c.maxRetries, err.Error())
+			}
+			c.workqueue.AddRateLimited(obj)
+			return fmt.Errorf("error syncing '%s', requeuing: %s", key, err.Error())
 		}
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
 		c.workqueue.Forget(obj)
 		glog.Infof("Successfully synced '%s'", key)
+		c.requeueForDriftCheck(key)
 		return nil
 	}(obj)
 
@@ -258,7 +329,7 @@ func (c *Controller) processNextWorkItem() bool {
 // syncHandler compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the Foo resource
 // with the current status of the resource.
-func (c *Controller) syncHandler(key string) error {
+func (c *Controller) syncHandler(key string) (err error) {
 	//fmt.Println("Inside syncHandler 1")
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
@@ -279,41 +350,155 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
+	// Resources admitted without the mutating webhook are defaulted here.
+	// The defaults are saved with the status once the instance is created.
+	if defaulted := foo.DeepCopy(); setDefaults(defaulted) {
+		fmt.Printf("Defaulting deployment name of %s to %s\n", key, defaulted.Spec.DeploymentName)
+		foo = defaulted
+	}
+
+	// The instance is deleted even if the resource was given up
+	if foo.DeletionTimestamp != nil {
+		return c.finalizePostgres(foo)
+	}
+
+	// A resource given up is skipped until its spec changes
+	if c.isAbandoned(key, foo) {
+		return nil
+	}
+	// Outcomes left over from a sync that did not update the status
+	c.takeCommandResults(foo)
+	c.takeExecutedCommands(foo)
+
+	// Surface any reconcile error in the status before the key is requeued
+	defer func() {
+		if err != nil {
+			c.updateFooStatusFailed(foo, err, c.getRetryCount(key))
+		}
+	}()
+
 	//fmt.Println("Inside syncHandler 2")
 
 	deploymentName := foo.Spec.DeploymentName
-	if deploymentName == "" {
-		// We choose to absorb the error here as the worker would requeue the
-		// resource otherwise. Instead, the next time the resource is updated
-		// the resource will be queued again.
-		runtime.HandleError(fmt.Errorf("%s: deployment name must be specified", key))
+
+	// A suspended resource is left untouched. Once Suspend is flipped back
+	// the next sync diffs the spec against the status as usual.
+	if foo.Spec.Suspend {
+		fmt.Printf("CRD %s is suspended, skipping reconcile\n", key)
+		if foo.Status.Status == "SUSPENDED" {
+			return nil
+		}
+		return c.updateFooStatus(foo, foo, &foo.Status.ActionHistory, &foo.Status.Users, &foo.Status.Databases,
+			foo.Status.VerifyCmd, foo.Status.ServiceIP, foo.Status.ServicePort,
+			foo.Status.ConnectionString, foo.Status.SecretName, "SUSPENDED")
+	}
+
+	// Databases and users must not collide with those of other resources
+	// running on the same instance.
+	all, err := c.foosLister.Postgreses(foo.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	err = checkSharedInstanceConflicts(foo, all)
+	if err != nil {
+		// Absorb the error, the resource is queued again when its spec changes.
+		c.recorder.Event(foo, corev1.EventTypeWarning, ErrNameConflict, err.Error())
+		runtime.HandleError(err)
 		return nil
 	}
 
+	if foo.Spec.SharedInstance != "" {
+		return c.syncSharedInstance(foo)
+	}
+
+	// Only databases and users are managed on an external instance
+	if foo.Spec.ExternalEndpoint != nil {
+		return c.syncExternal(foo)
+	}
+
+	// Passwords are read from their Secrets, the resolved users are only
+	// used for the commands and never written to the spec.
+	users, credentialSecrets, err := c.resolveUsers(foo)
+	if err != nil {
+		return err
+	}
+
 	var verifyCmd string
 	var actionHistory []string
 	var serviceIP string
 	var servicePort string
 	var setupCommands []string
-	var databases []string
-	var users []postgresv1.UserSpec
+	var databases []postgresv1.DatabaseSpec
 
 	// Get the deployment with the name specified in Foo.spec
-	_, err = c.deploymentsLister.Deployments(foo.Namespace).Get(deploymentName)
+	_, err = c.deploymentsLister.Deployments(c.getInstanceNamespace()).Get(deploymentName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	// A Deployment deleted out-of-band is re-created and the instance is
+	// then reconciled like on any update
+	if errors.IsNotFound(err) && isCreated(foo) && !foo.Spec.DryRun {
+		err = c.recreateDeployment(foo)
+		if err != nil {
+			return err
+		}
+	}
 	// If the resource doesn't exist, we'll create it
 	if errors.IsNotFound(err) {
 		fmt.Printf("Received request to create CRD %s\n", deploymentName)
-		serviceIP, servicePort, setupCommands, databases, users, verifyCmd = createDeployment(foo, c)
-		for _, cmds := range setupCommands {
-			// Don't save the connect command as we might connect later and perform more operations
-			if !strings.Contains(cmds, "\\c") {
-				actionHistory = append(actionHistory, cmds)
-			}
+		serviceIP, servicePort, setupCommands, databases, verifyCmd, err = createDeployment(foo, users, c)
+		if err != nil {
+			c.recordDatabaseError(foo, err)
+			return err
+		}
+		if foo.Spec.DryRun {
+			return c.recordPlannedCommands(foo, setupCommands)
 		}
+		actionHistory = getActionHistoryEntries(setupCommands)
 		fmt.Printf("Setup Commands: %v\n", setupCommands)
 		fmt.Printf("Verify using: %v\n", verifyCmd)
-		err = c.updateFooStatus(foo, &actionHistory, &users, &databases,
-			verifyCmd, serviceIP, servicePort, "READY")
+		endpoint, err := c.getInstanceEndpoint(foo, serviceIP, servicePort)
+		if err != nil {
+			return err
+		}
+		appliedFiles, err := c.applySetupFiles(foo, endpoint)
+		if err != nil {
+			c.recordDatabaseError(foo, err)
+			return err
+		}
+		err = c.checkHealthDatabase(foo, endpoint)
+		if err != nil {
+			return err
+		}
+		// Recorded together with READY so that the instance is never
+		// restored into again, even if its Deployment is re-created.
+		original := foo
+		foo = foo.DeepCopy()
+		foo.Status.Restored = foo.Status.Restored || foo.Spec.RestoreFrom != nil
+		foo.Status.AppliedSetupFiles = append(foo.Status.AppliedSetupFiles, appliedFiles...)
+		foo.Status.CredentialSecrets = credentialSecrets
+		foo.Status.Tablespaces = getTablespaceNames(foo.Spec.Tablespaces)
+		foo.Status.SuperuserSecret = getSuperuserSecretName(foo)
+		foo.Status.Parameters = foo.Spec.Parameters
+		foo.Status.Extensions = foo.Spec.Extensions
+		foo.Status.ServerVersion = c.queryServerVersion(foo, endpoint)
+		foo.Status.AppliedCommandChecksums = getCommandChecksums(foo)
+		info := getConnectionInfo(foo, users, endpoint)
+		err = usePooler(foo, c, &info)
+		if err != nil {
+			return err
+		}
+		secretName, err := createOrUpdateConnectionSecret(foo, c, info)
+		if err != nil {
+			return err
+		}
+		err = c.injectConnectionSecret(foo, secretName, info)
+		if err != nil {
+			return err
+		}
+		statusUsers := getStatusUsers(users)
+		err = c.updateFooStatus(original, foo, &actionHistory, &statusUsers, &databases,
+			verifyCmd, serviceIP, servicePort, info.ConnectionString(), secretName, "READY")
 		if err != nil {
 			return err
 		}
@@ -321,56 +506,248 @@ func (c *Controller) syncHandler(key string) error {
 		fmt.Printf("CRD %s created\n", deploymentName)
 		fmt.Printf("Check using: kubectl describe postgres %s \n", deploymentName)
 
-		pgresObj, err := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(deploymentName,
+		pgresObj, err := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(foo.Name,
 			metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
 
 		actionHistory := pgresObj.Status.ActionHistory
 		serviceIP := pgresObj.Status.ServiceIP
 		servicePort := pgresObj.Status.ServicePort
 		verifyCmd := pgresObj.Status.VerifyCmd
+		connectionString := pgresObj.Status.ConnectionString
+		secretName := pgresObj.Status.SecretName
+		// The type or ports of the Service may have changed since its creation
+		if !foo.Spec.DryRun {
+			syncedIP, syncedPort, err := c.syncService(foo)
+			if err != nil {
+				return err
+			}
+			if syncedPort != "" {
+				serviceIP, servicePort = syncedIP, syncedPort
+			}
+		}
 		fmt.Printf("Action History:[%s]\n", actionHistory)
 		fmt.Printf("Service IP:[%s]\n", serviceIP)
 		fmt.Printf("Service Port:[%s]\n", servicePort)
 		fmt.Printf("Verify cmd: %v\n", verifyCmd)
 
-		// 1. Find directly provided commands
-		//setupCommands1 := canonicalize(foo.Spec.Commands)
-		//setupCommands = getCommandsToRun(actionHistory, setupCommands1)
-		//fmt.Printf("setupCommands: %v\n", setupCommands)
+		// 1. Find directly provided commands that were not run yet, unless
+		// the commands did not change since they were applied
+		if !commandsApplied(foo, &pgresObj.Status) {
+			setupCommands = getCommandsToRun(actionHistory, getSetupCommands(foo))
+		}
+		fmt.Printf("setupCommands: %v\n", setupCommands)
 
 		var commandsToRun []string
+		var endpoint dbEndpoint
+		var liveDatabases, liveRoles []string
+		var liveLimits map[string]int32
+		var liveDatabaseComments, liveRoleComments map[string]string
+		var liveExtensions map[string]map[string]string
+
+		if foo.Spec.DryRun {
+			// No connection is opened, the state recorded in the status is
+			// diffed against the spec instead
+			liveDatabases, liveRoles = getRecordedState(&pgresObj.Status)
+		} else {
+			endpoint, err = c.getInstanceEndpoint(foo, serviceIP, servicePort)
+			if err != nil {
+				return err
+			}
+			cleanup, err := c.setupSSL(foo, &endpoint)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			restarted, err := c.rotateSuperuserPassword(foo, &endpoint)
+			if err != nil {
+				return err
+			}
+			if restarted {
+				err = waitForPods(c, deploymentName)
+				if err != nil {
+					return err
+				}
+			}
+
+			// New tablespaces need their volumes mounted before they are created
+			updated, err := c.syncTablespaces(foo)
+			if err != nil {
+				return err
+			}
+			if updated {
+				err = waitForPods(c, deploymentName)
+				if err != nil {
+					return err
+				}
+			}
+
+			if usesSetupJob(foo) {
+				// The controller does not connect to the instance, the
+				// state recorded in the status is diffed instead
+				liveDatabases, liveRoles = getRecordedState(&pgresObj.Status)
+			} else {
+				// Derive the current state from the instance itself so that
+				// databases or users removed out-of-band are re-created.
+				liveDatabases, liveRoles, err = c.queryCurrentState(endpoint)
+				if err != nil {
+					return err
+				}
+				if hasDatabaseConnectionLimits(foo) {
+					liveLimits, err = c.queryConnectionLimits(endpoint)
+					if err != nil {
+						return err
+					}
+				}
+				if hasComments(foo) {
+					liveDatabaseComments, liveRoleComments, err = c.queryComments(endpoint)
+					if err != nil {
+						return err
+					}
+				}
+				liveExtensions, err = c.queryExtensions(endpoint, getExtensionDatabases(foo, liveDatabases))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Renamed databases and roles are diffed under their new names
+		renameCmds := getRenameCommands(foo, liveDatabases, liveRoles, &pgresObj.Status)
 
 		// 2. Reconcile databases
 		desiredDatabases := foo.Spec.Databases
-		currentDatabases := pgresObj.Status.Databases
+		desiredNames := getDatabaseNames(desiredDatabases)
+		currentDatabases := getCurrentDatabases(liveDatabases, getManagedDatabases(&pgresObj.Status), desiredNames)
 		fmt.Printf("Current Databases:%v\n", currentDatabases)
 		fmt.Printf("Desired Databases:%v\n", desiredDatabases)
 		createDBCommands, dropDBCommands := getDatabaseCommands(desiredDatabases,
 			currentDatabases)
-		appendList(&commandsToRun, createDBCommands)
-		appendList(&commandsToRun, dropDBCommands)
-
-		// 3. Reconcile users
-		desiredUsers := foo.Spec.Users
-		currentUsers := pgresObj.Status.Users
+		dropDBCommands, orphanedDatabases := c.guardDatabaseDeletion(foo, desiredNames,
+			currentDatabases, dropDBCommands)
+		c.warnDatabaseOptionChanges(foo, pgresObj.Status.Databases, currentDatabases)
+		appliedDatabases := getAppliedDatabases(desiredDatabases, pgresObj.Status.Databases, currentDatabases)
+		alterDBCommands := getAlterDatabaseOwnerCommands(desiredDatabases, pgresObj.Status.Databases, currentDatabases)
+		if liveLimits == nil {
+			liveLimits = getRecordedConnectionLimits(pgresObj.Status.Databases)
+		}
+		appendList(&alterDBCommands, getAlterDatabaseConnectionLimitCommands(desiredDatabases, liveLimits,
+			currentDatabases))
+		createSchemaCommands, dropSchemaCommands := getSchemaCommands(desiredDatabases,
+			pgresObj.Status.Databases, currentDatabases)
+		dropSchemaCommands, keptSchemas := c.guardSchemaDeletion(foo, pgresObj.Status.Databases,
+			currentDatabases, dropSchemaCommands)
+		appliedDatabases = addKeptSchemas(appliedDatabases, keptSchemas)
+		revokePublicCmds := getRevokePublicAccessCommands(desiredDatabases, pgresObj.Status.Databases,
+			currentDatabases)
+		if liveExtensions == nil {
+			liveExtensions = getRecordedExtensions(pgresObj.Status.Extensions, currentDatabases)
+		}
+		createExtensionCmds, dropExtensionCmds := getExtensionCommands(foo.Spec.Extensions,
+			pgresObj.Status.Extensions, liveExtensions, desiredNames)
+		dropExtensionCmds, keptExtensions := c.guardExtensionDeletion(foo, pgresObj.Status.Extensions,
+			liveExtensions, dropExtensionCmds)
+		updateExtensionCmds := getUpdateExtensionCommands(foo.Spec.Extensions, liveExtensions)
+
+		// 3. Reconcile tablespaces
+		currentTablespaces := pgresObj.Status.Tablespaces
+		createTablespaceCmds, dropTablespaceCmds := getTablespaceCommands(foo.Spec.Tablespaces,
+			currentTablespaces)
+		dropTablespaceCmds, keptTablespaces := c.guardTablespaceDeletion(foo, currentTablespaces,
+			dropTablespaceCmds)
+
+		// 4. Reconcile users
+		desiredUsers := users
+		currentUsers := getCurrentUsers(liveRoles, pgresObj.Status.Users, desiredUsers)
 		fmt.Printf("Current Users:%v\n", currentUsers)
 		fmt.Printf("Desired Users:%v\n", desiredUsers)
 		createUserCmds, dropUserCmds, alterUserCmds := getUserCommands(desiredUsers,
-			currentUsers)
+			currentUsers, desiredNames, getSuperuserName(foo))
+		createUserCmds = setPasswordEncryption(createUserCmds, getPasswordEncryption(foo))
+		alterUserCmds = setPasswordEncryption(alterUserCmds, getPasswordEncryption(foo))
+		grantCmds, revokeCmds := getGrantCommands(desiredUsers, currentUsers, currentDatabases)
+		if liveDatabaseComments == nil {
+			liveDatabaseComments, liveRoleComments = getRecordedComments(&pgresObj.Status)
+		}
+		commentCmds := getCommentCommands(desiredDatabases, desiredUsers, liveDatabaseComments, liveRoleComments)
+
+		// 5. Reconcile parameters
+		parameterCmds := getParameterCommands(foo.Spec.Parameters, pgresObj.Status.Parameters)
+
+		// Renames run first, before any command connects to a renamed
+		// database. Users are created next as they may own the new
+		// databases. Tablespaces are dropped last, once no database may use
+		// them.
+		appendList(&commandsToRun, renameCmds)
+		appendList(&commandsToRun, createTablespaceCmds)
 		appendList(&commandsToRun, createUserCmds)
+		appendList(&commandsToRun, createDBCommands)
+		appendList(&commandsToRun, alterDBCommands)
+		appendList(&commandsToRun, createSchemaCommands)
+		appendList(&commandsToRun, revokePublicCmds)
+		appendList(&commandsToRun, createExtensionCmds)
+		appendList(&commandsToRun, updateExtensionCmds)
+		appendList(&commandsToRun, revokeCmds)
+		appendList(&commandsToRun, grantCmds)
+		appendList(&commandsToRun, dropExtensionCmds)
+		appendList(&commandsToRun, dropSchemaCommands)
+		appendList(&commandsToRun, dropDBCommands)
 		appendList(&commandsToRun, dropUserCmds)
 		appendList(&commandsToRun, alterUserCmds)
+		appendList(&commandsToRun, commentCmds)
+		appendList(&commandsToRun, dropTablespaceCmds)
+		appendList(&commandsToRun, parameterCmds)
 
-		// 4. So what all commands should we run??
+		// 6. So what all commands should we run??
 		fmt.Printf("commandsToRun:%v\n", commandsToRun)
 
+		if foo.Spec.DryRun {
+			var planned []string
+			appendList(&planned, commandsToRun)
+			appendList(&planned, setupCommands)
+			return c.recordPlannedCommands(pgresObj, planned)
+		}
+
+		if len(commandsToRun) > 0 || len(setupCommands) > 0 {
+			err = c.updateFooStatus(foo, foo, nil, &currentUsers, &appliedDatabases,
+				verifyCmd, serviceIP, servicePort, connectionString, secretName, "UPDATING")
+			if err != nil {
+				return err
+			}
+		}
 		if len(commandsToRun) > 0 {
-			err = c.updateFooStatus(foo, &actionHistory, &currentUsers, &desiredDatabases,
-				verifyCmd, serviceIP, servicePort, "UPDATING")
+			err = c.updateCRD(pgresObj, endpoint, commandsToRun)
+			if err != nil {
+				c.recordDatabaseError(foo, err)
+				return err
+			}
+			err = c.appendActionHistory(foo, commandsToRun)
+			if err != nil {
+				return err
+			}
+		}
+		// Setup commands run against the first database like on creation,
+		// once the databases and users they may refer to exist
+		if len(setupCommands) > 0 {
+			err = c.setupDatabase(pgresObj, endpoint, setupCommands, desiredNames)
 			if err != nil {
+				c.recordDatabaseError(foo, err)
 				return err
 			}
-			updateCRD(pgresObj, c, commandsToRun)
+			err = c.appendActionHistory(foo, setupCommands)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Files added to the ConfigMap since the last sync
+		appliedFiles, err := c.applySetupFiles(pgresObj, endpoint)
+		if err != nil {
+			c.recordDatabaseError(foo, err)
+			return err
 		}
 
 		/*
@@ -386,63 +763,179 @@ func (c *Controller) syncHandler(key string) error {
 				  }
 		*/
 
-		pgresObj2, err := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(deploymentName,
+		pgresObj2, err := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(foo.Name,
 			metav1.GetOptions{})
-		actionHistory = pgresObj2.Status.ActionHistory
-		fmt.Printf("1111 Action History:%s\n", actionHistory)
-		for _, cmds := range commandsToRun {
-			actionHistory = append(actionHistory, cmds)
+		if err != nil {
+			return err
+		}
+		original := pgresObj2
+		pgresObj2 = pgresObj2.DeepCopy()
+		pgresObj2.Status.AppliedSetupFiles = append(pgresObj2.Status.AppliedSetupFiles, appliedFiles...)
+		pgresObj2.Status.OrphanedDatabases = orphanedDatabases
+		pgresObj2.Status.CredentialSecrets = credentialSecrets
+		pgresObj2.Status.Parameters = foo.Spec.Parameters
+		pgresObj2.Status.Extensions = setInstalledVersions(getAppliedExtensions(foo.Spec.Extensions, keptExtensions),
+			liveExtensions)
+		pgresObj2.Status.AppliedCommandChecksums = getCommandChecksums(foo)
+		pgresObj2.Status.Tablespaces = nil
+		appendList(&pgresObj2.Status.Tablespaces, getTablespaceNames(foo.Spec.Tablespaces))
+		appendList(&pgresObj2.Status.Tablespaces, keptTablespaces)
+		// PgBouncer authenticates the managed users from its userlist
+		if isPoolerEnabled(foo) {
+			err = createOrUpdatePoolerSecret(foo, desiredUsers, c)
+			if err != nil {
+				return err
+			}
 		}
 
-		/*
-		  fmt.Printf("2222 Action History:%s\n", actionHistory)
-		  if len(setupCommands) > 1 {
-		     for _, cmds := range setupCommands {
-		     	   // Don't save the connect command as we might connect later and perform more operations
-		       	   if ! strings.Contains(cmds, "\\c") {
-		       	      actionHistory = append(actionHistory, cmds)
-		       	   }
-		     }
-		     fmt.Printf("3333 Action History:%s\n", actionHistory)
-		  }
-		*/
+		// Refresh the connection Secret as the first user/database may have changed
+		info := getConnectionInfo(foo, desiredUsers, endpoint)
+		err = usePooler(foo, c, &info)
+		if err != nil {
+			return err
+		}
+		secretName, err = createOrUpdateConnectionSecret(foo, c, info)
+		if err != nil {
+			return err
+		}
+		err = c.injectConnectionSecret(foo, secretName, info)
+		if err != nil {
+			return err
+		}
+		connectionString = info.ConnectionString()
+		pgresObj2.Status.ServerVersion = c.queryServerVersion(pgresObj2, endpoint)
+		err = c.checkHealthDatabase(foo, endpoint)
+		if err != nil {
+			return err
+		}
 
-		err = c.updateFooStatus(pgresObj2, &actionHistory, &desiredUsers, &desiredDatabases,
-			verifyCmd, serviceIP, servicePort, "READY")
+		statusUsers := getStatusUsers(desiredUsers)
+		err = c.updateFooStatus(original, pgresObj2, nil, &statusUsers, &appliedDatabases,
+			verifyCmd, serviceIP, servicePort, connectionString, secretName, "READY")
 		if err != nil {
-			panic(err)
 			return err
 		}
 	}
+
+	err = c.syncConfig(foo)
+	if err != nil {
+		return err
+	}
+	err = c.syncParameters(foo)
+	if err != nil {
+		return err
+	}
+	err = c.syncImage(foo)
+	if err != nil {
+		return err
+	}
+	err = c.syncResources(foo)
+	if err != nil {
+		return err
+	}
+	err = c.syncBackup(foo)
+	if err != nil {
+		return err
+	}
+	err = c.runOnce(foo)
+	if err != nil {
+		return err
+	}
 	c.recorder.Event(foo, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 	return nil
 }
 
-func (c *Controller) updateFooStatus(foo *postgresv1.Postgres,
-	actionHistory *[]string, users *[]postgresv1.UserSpec, databases *[]string,
+// updateFooStatus records the outcome of a sync in the status. original is
+// the resource as read and foo the same or a copy of it with status fields
+// set by the caller. Only the changes between them are patched, so that
+// status fields written since original was read, e.g. by
+// appendActionHistory, are kept. A nil actionHistory keeps the history of
+// foo, which is appended to with appendActionHistory.
+func (c *Controller) updateFooStatus(original *postgresv1.Postgres, foo *postgresv1.Postgres,
+	actionHistory *[]string, users *[]postgresv1.UserSpec, databases *[]postgresv1.DatabaseSpec,
 	verifyCmd string, serviceIP string, servicePort string,
+	connectionString string, secretName string,
 	status string) error {
 	// NEVER modify objects from the store. It's a read-only, local cache.
 	// You can use DeepCopy() to make a deep copy of original object and modify this copy
 	// Or create a copy manually for better performance
 	fooCopy := foo.DeepCopy()
-	//fooCopy.Status.AvailableReplicas = deployment.Status.AvailableReplicas
-	fooCopy.Status.AvailableReplicas = 1
+	fooCopy.Status.AvailableReplicas, fooCopy.Status.ReadyReplicas = c.getReplicaCounts(foo)
+	fooCopy.Status.TLSEnabled = c.getServerTLSEnabled(foo)
 
 	//fooCopy.Status.ActionHistory = strings.Join(*actionHistory, " ")
 	fooCopy.Status.VerifyCmd = verifyCmd
-	fooCopy.Status.ActionHistory = *actionHistory
+	if actionHistory != nil {
+		fooCopy.Status.ActionHistory = *actionHistory
+	}
 	fooCopy.Status.Users = *users
 	fooCopy.Status.Databases = *databases
+	fooCopy.Status.DatabaseCount = len(*databases)
 	fooCopy.Status.ServiceIP = serviceIP
 	fooCopy.Status.ServicePort = servicePort
+	fooCopy.Status.ConnectionString = connectionString
+	fooCopy.Status.SecretName = secretName
 	fooCopy.Status.Status = status
-	// Until #38113 is merged, we must use Update instead of UpdateStatus to
-	// update the Status block of the Foo resource. UpdateStatus will not
-	// allow changes to the Spec of the resource, which is ideal for ensuring
-	// nothing other than resource status has been updated.
-	_, err := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Update(fooCopy)
-	return err
+	fooCopy.Status.PlannedCommands = nil
+	fooCopy.Status.RetryCount = 0
+	addFinalizer(fooCopy)
+	applyObjectResults(&fooCopy.Status, c.takeCommandResults(foo))
+	appendCommandResults(&fooCopy.Status, c.takeExecutedCommands(foo))
+	setPhaseConditions(&fooCopy.Status, status, "")
+	// Until #38113 is merged, there is no UpdateStatus for the Foo resource.
+	// Only the changes to the status are patched, so that the spec is never
+	// written back.
+	return c.patchStatus(original, fooCopy)
+}
+
+// getReplicaCounts returns the available and ready replicas of the
+// Deployment of the instance. Resources without an instance of their own,
+// or whose Deployment does not exist (yet), have none.
+func (c *Controller) getReplicaCounts(foo *postgresv1.Postgres) (int32, int32) {
+	if foo.Spec.DeploymentName == "" || foo.Spec.SharedInstance != "" || foo.Spec.ExternalEndpoint != nil {
+		return 0, 0
+	}
+	// Read the Deployment from the API server as the cache may lag behind
+	// the Pods just waited for
+	deployment, err := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace()).Get(foo.Spec.DeploymentName,
+		metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			runtime.HandleError(err)
+		}
+		return 0, 0
+	}
+	return deployment.Status.AvailableReplicas, deployment.Status.ReadyReplicas
+}
+
+// updateFooStatusFailed marks the Foo resource as Failed and records the
+// error that made the reconcile fail.
+func (c *Controller) updateFooStatusFailed(foo *postgresv1.Postgres, syncErr error, retryCount int) {
+	commandResults := c.takeCommandResults(foo)
+	executedCommands := c.takeExecutedCommands(foo)
+	availableReplicas, readyReplicas := c.getReplicaCounts(foo)
+	now := metav1.Now()
+	// Re-read the resource as the status may have been updated during this sync
+	foosClient := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := foosClient.Get(foo.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		fooCopy := latest.DeepCopy()
+		fooCopy.Status.Status = "Failed"
+		fooCopy.Status.LastError = syncErr.Error()
+		fooCopy.Status.LastErrorTime = &now
+		fooCopy.Status.RetryCount = retryCount
+		applyObjectResults(&fooCopy.Status, commandResults)
+		appendCommandResults(&fooCopy.Status, executedCommands)
+		fooCopy.Status.AvailableReplicas, fooCopy.Status.ReadyReplicas = availableReplicas, readyReplicas
+		setPhaseConditions(&fooCopy.Status, "Failed", syncErr.Error())
+		return c.patchStatusIfUnchanged(latest, fooCopy)
+	})
+	if err != nil {
+		runtime.HandleError(err)
+	}
 }
 
 // enqueueFoo takes a Foo resource and converts it into a namespace/name
@@ -498,7 +991,7 @@ func (c *Controller) handleObject(obj interface{}) {
 	}
 }
 
-func updateCRD(foo *postgresv1.Postgres, c *Controller, setupCommands []string) {
+func (c *Controller) updateCRD(foo *postgresv1.Postgres, endpoint dbEndpoint, setupCommands []string) error {
 	serviceIP := foo.Status.ServiceIP
 	servicePort := foo.Status.ServicePort
 
@@ -511,52 +1004,222 @@ func updateCRD(foo *postgresv1.Postgres, c *Controller, setupCommands []string)
 		fmt.Println("Now setting up the database")
 		//setupDatabase(serviceIP, servicePort, file)
 		var dummyList []string
-		setupDatabase(serviceIP, servicePort, setupCommands, dummyList)
+		return c.setupDatabase(foo, endpoint, setupCommands, dummyList)
 	}
+	return nil
 }
 
-func createDeployment(foo *postgresv1.Postgres, c *Controller) (string, string, []string, []string, []postgresv1.UserSpec, string) {
+func createDeployment(foo *postgresv1.Postgres, users []postgresv1.UserSpec, c *Controller) (string, string, []string, []postgresv1.DatabaseSpec, string, error) {
 
-	deploymentsClient := c.kubeclientset.AppsV1().Deployments(apiv1.NamespaceDefault)
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
 
 	deploymentName := foo.Spec.DeploymentName
 	image := foo.Spec.Image
-	users := foo.Spec.Users
 	databases := foo.Spec.Databases
-	setupCommands := canonicalize(foo.Spec.Commands)
+	setupCommands := getSetupCommands(foo)
 
 	var userAndDBCommands []string
 	var allCommands []string
 
 	var currentDatabases []string
 	var currentUsers []postgresv1.UserSpec
+	createTablespaceCmds, _ := getTablespaceCommands(foo.Spec.Tablespaces, nil)
 	createDBCmds, dropDBCmds := getDatabaseCommands(databases, currentDatabases)
-	createUserCmds, dropUserCmds, alterUserCmds := getUserCommands(users, currentUsers)
+	createSchemaCmds, _ := getSchemaCommands(databases, nil, currentDatabases)
+	revokePublicCmds := getRevokePublicAccessCommands(databases, nil, currentDatabases)
+	createExtensionCmds, _ := getExtensionCommands(foo.Spec.Extensions, nil, nil, getDatabaseNames(databases))
+	createUserCmds, dropUserCmds, alterUserCmds := getUserCommands(users, currentUsers, getDatabaseNames(databases), getSuperuserName(foo))
+	grantCmds, _ := getGrantCommands(users, currentUsers, currentDatabases)
+	createUserCmds = setPasswordEncryption(createUserCmds, getPasswordEncryption(foo))
+	alterUserCmds = setPasswordEncryption(alterUserCmds, getPasswordEncryption(foo))
+	commentCmds := getCommentCommands(databases, users, nil, nil)
+	parameterCmds := getParameterCommands(foo.Spec.Parameters, nil)
 
 	fmt.Printf("   Deployment:%v, Image:%v\n", deploymentName, image)
 	fmt.Printf("   Users:%v\n", users)
 	fmt.Printf("   Databases:%v\n", databases)
 	fmt.Printf("   SetupCmds:%v\n", setupCommands)
+	fmt.Printf("   CreateTablespaceCmds:%v\n", createTablespaceCmds)
 	fmt.Printf("   CreateDBCmds:%v\n", createDBCmds)
 	fmt.Printf("   DropDBCmds:%v\n", dropDBCmds)
+	fmt.Printf("   CreateSchemaCmds:%v\n", createSchemaCmds)
+	fmt.Printf("   RevokePublicCmds:%v\n", revokePublicCmds)
+	fmt.Printf("   CreateExtensionCmds:%v\n", createExtensionCmds)
+	fmt.Printf("   GrantCmds:%v\n", grantCmds)
 	fmt.Printf("   CreateUserCmds:%v\n", createUserCmds)
 	fmt.Printf("   DropUserCmds:%v\n", dropUserCmds)
 	fmt.Printf("   AlterUserCmds:%v\n", alterUserCmds)
+	fmt.Printf("   CommentCmds:%v\n", commentCmds)
+	fmt.Printf("   ParameterCmds:%v\n", parameterCmds)
 
+	// Users are created first as they may own the databases
+	appendList(&userAndDBCommands, createTablespaceCmds)
+	appendList(&userAndDBCommands, createUserCmds)
 	appendList(&userAndDBCommands, createDBCmds)
+	appendList(&userAndDBCommands, createSchemaCmds)
+	appendList(&userAndDBCommands, revokePublicCmds)
+	appendList(&userAndDBCommands, createExtensionCmds)
+	appendList(&userAndDBCommands, grantCmds)
 	appendList(&userAndDBCommands, dropDBCmds)
-	appendList(&userAndDBCommands, createUserCmds)
 	appendList(&userAndDBCommands, dropUserCmds)
 	appendList(&userAndDBCommands, alterUserCmds)
+	appendList(&userAndDBCommands, commentCmds)
+	appendList(&userAndDBCommands, parameterCmds)
 	fmt.Printf("   UserAndDBCmds:%v\n", userAndDBCommands)
 	fmt.Printf("   SetupCmds:%v\n", setupCommands)
 
 	appendList(&allCommands, userAndDBCommands)
 	appendList(&allCommands, setupCommands)
 
+	// A dry run only plans the commands, nothing is created
+	if foo.Spec.DryRun {
+		return "", "", allCommands, databases, "", nil
+	}
+
+	if foo.Spec.Storage != nil {
+		err := createPVC(foo, c)
+		if err != nil {
+			return "", "", nil, nil, "", err
+		}
+	}
+
+	err := createTablespacePVCs(foo, c)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+
+	if isPoolerEnabled(foo) {
+		err := createOrUpdatePoolerSecret(foo, users, c)
+		if err != nil {
+			return "", "", nil, nil, "", err
+		}
+	}
+
+	// The Deployment reads the superuser password from its Secret
+	err = c.createSuperuserSecret(foo)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+
+	deployment := getDeployment(foo)
+	err = c.addConfigHash(deployment, foo)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+
+	// Create Deployment
+	fmt.Println("Creating deployment...")
+	result, err := deploymentsClient.Create(deployment)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+	fmt.Printf("Created deployment %q.\n", result.GetObjectMeta().GetName())
+	fmt.Printf("------------------------------\n")
+
+	// Create Service
+	fmt.Printf("Creating service...\n")
+	serviceClient := c.kubeclientset.CoreV1().Services(c.getInstanceNamespace())
+	service := getService(foo)
+
+	result1, err1 := serviceClient.Create(service)
+	if err1 != nil {
+		return "", "", nil, nil, "", c.checkNodePortConflict(foo, err1)
+	}
+	fmt.Printf("Created service %q.\n", result1.GetObjectMeta().GetName())
+	fmt.Printf("------------------------------\n")
+
+	// Parse ServiceIP and Port
+	// Minikube VM IP
+	serviceIP := MINIKUBE_IP
+
+	nodePort1 := result1.Spec.Ports[0].NodePort
+	nodePort := fmt.Sprint(nodePort1)
+	servicePort := nodePort
+	endpoint, err := c.getInstanceEndpoint(foo, serviceIP, servicePort)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+	cleanup, err := c.setupSSL(foo, &endpoint)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+	defer cleanup()
+	//fmt.Printf("NodePort:[%v]", nodePort)
+
+	// The exporter sidecar reads its credentials from the connection Secret,
+	// so it has to exist before the Pod can become ready.
+	if isMonitoringEnabled(foo) {
+		_, err = createOrUpdateConnectionSecret(foo, c, getConnectionInfo(foo, users, endpoint))
+		if err != nil {
+			return "", "", nil, nil, "", err
+		}
+	}
+
+	err = waitForPods(c, deploymentName)
+	if err != nil {
+		return "", "", nil, nil, "", err
+	}
+
+	if len(userAndDBCommands) > 0 {
+		fmt.Printf("About to create temp db file for user and db commands")
+		//file := createTempDBFile(userAndDBCommands)
+		fmt.Println("Now setting up the database")
+		//setupDatabase_prev(serviceIP, servicePort, file)
+		var dummyList []string
+		err = c.setupDatabase(foo, endpoint, userAndDBCommands, dummyList)
+		if err != nil {
+			return "", "", nil, nil, "", err
+		}
+	}
+
+	// Restore into the empty databases before running the setup commands
+	if foo.Spec.RestoreFrom != nil && !foo.Status.Restored && !isPointInTimeRestore(foo) {
+		err = restoreDatabases(foo, c)
+		if err != nil {
+			return "", "", nil, nil, "", err
+		}
+	}
+
+	if len(setupCommands) > 0 {
+		fmt.Printf("About to create temp db file for setup commands")
+		//file := createTempDBFile(setupCommands)
+		fmt.Println("Now setting up the database")
+		//setupDatabase(serviceIP, servicePort, file)
+		err = c.setupDatabase(foo, endpoint, setupCommands, getDatabaseNames(databases))
+		if err != nil {
+			return "", "", nil, nil, "", err
+		}
+	}
+
+	// List Deployments
+	//fmt.Printf("Listing deployments in namespace %q:\n", apiv1.NamespaceDefault)
+	//list, err := deploymentsClient.List(metav1.ListOptions{})
+	//if err != nil {
+	//        panic(err)
+	//}
+	//for _, d := range list.Items {
+	//        fmt.Printf(" * %s (%d replicas)\n", d.Name, *d.Spec.Replicas)
+	//}
+
+	info := getConnectionInfo(foo, users, endpoint)
+	verifyCmd := strings.Fields("psql -h " + serviceIP + " -p " + nodePort + " -U " + info.Username + " -d " + info.Database)
+	var verifyCmdString = strings.Join(verifyCmd, " ")
+	fmt.Printf("VerifyCmd: %v\n", verifyCmd)
+	return serviceIP, servicePort, allCommands, databases, verifyCmdString, nil
+}
+
+// getDeployment builds the Deployment running the Postgres image for a
+// Postgres resource.
+func getDeployment(foo *postgresv1.Postgres) *appsv1.Deployment {
+	deploymentName := foo.Spec.DeploymentName
+	image := foo.Spec.Image
+
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: deploymentName,
+			Labels: map[string]string{
+				"app": deploymentName,
+			},
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: int32Ptr(1),
@@ -594,9 +1257,10 @@ func createDeployment(foo *postgresv1.Postgres, c *Controller) (string, string,
 							},
 							Env: []apiv1.EnvVar{
 								{
-									Name:  "POSTGRES_PASSWORD",
-									Value: PGPASSWORD,
+									Name:  "POSTGRES_USER",
+									Value: getSuperuserName(foo),
 								},
+								getSuperuserPasswordEnv(foo, "POSTGRES_PASSWORD"),
 							},
 						},
 					},
@@ -605,18 +1269,38 @@ func createDeployment(foo *postgresv1.Postgres, c *Controller) (string, string,
 		},
 	}
 
-	// Create Deployment
-	fmt.Println("Creating deployment...")
-	result, err := deploymentsClient.Create(deployment)
-	if err != nil {
-		panic(err)
+	addStorage(&deployment.Spec.Template.Spec, foo)
+	addTablespaces(&deployment.Spec.Template.Spec, foo)
+	addMonitoring(&deployment.Spec.Template, foo)
+	addPooler(&deployment.Spec.Template.Spec, foo)
+	addScheduling(&deployment.Spec.Template.Spec, foo)
+	addResources(deployment, foo)
+	addShutdown(&deployment.Spec.Template.Spec, foo)
+	addHealthCheck(&deployment.Spec.Template.Spec, foo)
+	addImagePullSecrets(&deployment.Spec.Template.Spec, foo)
+	addMetadata(&deployment.ObjectMeta, foo)
+	addMetadata(&deployment.Spec.Template.ObjectMeta, foo)
+	addConfig(&deployment.Spec.Template.Spec, foo)
+	addInitScripts(&deployment.Spec.Template.Spec, foo)
+	addWALArchive(&deployment.Spec.Template.Spec, foo)
+	addPointInTimeRestore(&deployment.Spec.Template.Spec, foo)
+	addServerTLS(&deployment.Spec.Template.Spec, foo)
+	addContainerOptions(deployment, foo)
+	addSecurityContext(&deployment.Spec.Template.Spec, foo)
+	return deployment
+}
+
+// addImagePullSecrets lets the pod pull Image from a private registry.
+func addImagePullSecrets(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	for _, secretName := range foo.Spec.ImagePullSecrets {
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets,
+			apiv1.LocalObjectReference{Name: secretName})
 	}
-	fmt.Printf("Created deployment %q.\n", result.GetObjectMeta().GetName())
-	fmt.Printf("------------------------------\n")
+}
+
+func getService(foo *postgresv1.Postgres) *apiv1.Service {
+	deploymentName := foo.Spec.DeploymentName
 
-	// Create Service
-	fmt.Printf("Creating service...\n")
-	serviceClient := c.kubeclientset.CoreV1().Services(apiv1.NamespaceDefault)
 	service := &apiv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: deploymentName,
@@ -640,132 +1324,90 @@ func createDeployment(foo *postgresv1.Postgres, c *Controller) (string, string,
 		},
 	}
 
-	result1, err1 := serviceClient.Create(service)
-	if err1 != nil {
-		panic(err1)
-	}
-	fmt.Printf("Created service %q.\n", result1.GetObjectMeta().GetName())
-	fmt.Printf("------------------------------\n")
-
-	// Parse ServiceIP and Port
-	// Minikube VM IP
-	serviceIP := MINIKUBE_IP
-
-	nodePort1 := result1.Spec.Ports[0].NodePort
-	nodePort := fmt.Sprint(nodePort1)
-	servicePort := nodePort
-	//fmt.Printf("NodePort:[%v]", nodePort)
-
-	//fmt.Println("About to get Pods")
-	time.Sleep(time.Second * 5)
-
-	for {
-		readyPods := 0
-		pods := getPods(c, deploymentName)
-		//fmt.Println("Got Pods:: %s", pods)
-		for _, d := range pods.Items {
-			//fmt.Printf(" * %s %s \n", d.Name, d.Status)
-			podConditions := d.Status.Conditions
-			for _, podCond := range podConditions {
-				if podCond.Type == corev1.PodReady {
-					if podCond.Status == corev1.ConditionTrue {
-						//fmt.Println("Pod is running.")
-						readyPods += 1
-						//fmt.Printf("ReadyPods:%d\n", readyPods)
-						//fmt.Printf("TotalPods:%d\n", len(pods.Items))
-					}
-				}
-			}
-		}
-		if readyPods >= len(pods.Items) {
-			break
-		} else {
-			fmt.Println("Waiting for Pod to get ready.")
-			// Sleep for the Pod to become active
-			time.Sleep(time.Second * 4)
-		}
-	}
-
-	// Wait couple of seconds more just to give the Pod some more time.
-	time.Sleep(time.Second * 2)
-
-	if len(userAndDBCommands) > 0 {
-		fmt.Printf("About to create temp db file for user and db commands")
-		//file := createTempDBFile(userAndDBCommands)
-		fmt.Println("Now setting up the database")
-		//setupDatabase_prev(serviceIP, servicePort, file)
-		var dummyList []string
-		setupDatabase(serviceIP, servicePort, userAndDBCommands, dummyList)
-	}
-
-	if len(setupCommands) > 0 {
-		fmt.Printf("About to create temp db file for setup commands")
-		//file := createTempDBFile(setupCommands)
-		fmt.Println("Now setting up the database")
-		//setupDatabase(serviceIP, servicePort, file)
-		setupDatabase(serviceIP, servicePort, setupCommands, databases)
-	}
-
-	// List Deployments
-	//fmt.Printf("Listing deployments in namespace %q:\n", apiv1.NamespaceDefault)
-	//list, err := deploymentsClient.List(metav1.ListOptions{})
-	//if err != nil {
-	//        panic(err)
-	//}
-	//for _, d := range list.Items {
-	//        fmt.Printf(" * %s (%d replicas)\n", d.Name, *d.Spec.Replicas)
-	//}
-
-	verifyCmd := strings.Fields("psql -h " + serviceIP + " -p " + nodePort + " -U <user> " + " -d <db-name>")
-	var verifyCmdString = strings.Join(verifyCmd, " ")
-	fmt.Printf("VerifyCmd: %v\n", verifyCmd)
-	return serviceIP, servicePort, allCommands, databases, users, verifyCmdString
+	addMetricsPort(&service.Spec, foo)
+	addPoolerPort(&service.Spec, foo)
+	addMetadata(&service.ObjectMeta, foo)
+	addServiceOptions(service, foo)
+	return service
 }
 
-func setupDatabase(serviceIP string, servicePort string, setupCommands []string, databases []string) {
+// setupDatabase runs the commands against the endpoint and records an Event
+// on foo for each database, user, extension and grant command. The commands
+// acting on the instance, e.g. create database, run against the maintenance
+// database, the others against the first of databases or the database of
+// the connect command before them. A connection is opened whenever the
+// database changes.
+func (c *Controller) setupDatabase(foo *postgresv1.Postgres, endpoint dbEndpoint, setupCommands []string, databases []string) error {
 	fmt.Println("Setting up database")
 	fmt.Println("Commands:")
 	fmt.Printf("%v", setupCommands)
 
-	var host = serviceIP
-	port := -1
-	port, _ = strconv.Atoi(servicePort)
-	var user = "postgres"
-	var password = PGPASSWORD
-
-	var psqlInfo string
+	var dbname string
 	if len(databases) > 0 {
-		dbname := databases[0]
-		fmt.Println("%s\n", dbname)
-		psqlInfo = fmt.Sprintf("host=%s port=%d user=%s "+
-			"password=%s dbname=%s sslmode=disable",
-			host, port, user, password, dbname)
-	} else {
-		psqlInfo = fmt.Sprintf("host=%s port=%d user=%s "+
-			"password=%s sslmode=disable",
-			host, port, user, password)
-	}
-
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		panic(err)
+		dbname = databases[0]
+		fmt.Printf("%s\n", dbname)
 	}
-	defer db.Close()
+	setupCommands = routeCommands(setupCommands, dbname)
 
-	err = db.Ping()
-	if err != nil {
-		panic(err)
+	if foo != nil && usesSetupJob(foo) {
+		return c.runSetupJob(foo, setupCommands, dbname)
 	}
 
-	fmt.Println("Successfully connected!")
+	endpoint = c.getConnectEndpoint(endpoint)
+	executor := c.newDBExecutor()
+	defer executor.Close()
 
-	for _, command := range setupCommands {
-		_, err = db.Exec(command)
+	for i, command := range setupCommands {
+		if isConnectCommand(command) {
+			err := executor.Connect(endpoint, getConnectDatabase(command))
+			if err != nil {
+				c.recordCommandResults(foo, setupCommands, i, err)
+				return err
+			}
+			fmt.Printf("Connected to %s\n", getConnectDatabase(command))
+			continue
+		}
+		err := executor.Exec(command)
 		if err != nil {
-			panic(err)
+			c.recordCommandResults(foo, setupCommands, i, err)
+			return &commandError{Command: command, Err: err}
 		}
+		c.recordCommandEvent(foo, command)
 	}
+	c.recordCommandResults(foo, setupCommands, len(setupCommands), nil)
 	fmt.Println("Done setting up the database")
+	return nil
+}
+
+// getPsqlInfo builds the connection string used by the controller to
+// connect to the endpoint. An empty dbname connects to the default database.
+func getPsqlInfo(endpoint dbEndpoint, dbname string) string {
+	var host = connection.DialHost(endpoint.Host)
+	port := -1
+	port, _ = strconv.Atoi(endpoint.Port)
+	var user = endpoint.User
+	var password = endpoint.Password
+	var sslmode = endpoint.SSLMode
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+
+	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s sslmode=%s",
+		quoteConnValue(host), port, quoteConnValue(user), sslmode)
+	// The password may be omitted with a client certificate
+	if password != "" {
+		psqlInfo += " password=" + quoteConnValue(password)
+	}
+	if dbname != "" {
+		psqlInfo += " dbname=" + quoteConnValue(dbname)
+	}
+	if endpoint.SSLRootCert != "" {
+		psqlInfo += " sslrootcert=" + quoteConnValue(endpoint.SSLRootCert)
+	}
+	if endpoint.SSLCert != "" {
+		psqlInfo += " sslcert=" + quoteConnValue(endpoint.SSLCert) + " sslkey=" + quoteConnValue(endpoint.SSLKey)
+	}
+	return psqlInfo
 }
 
 func setupDatabase_prev(serviceIP string, servicePort string, file *os.File) {
@@ -816,23 +1458,57 @@ func createTempDBFile(setupCommands []string) *os.File {
 	return file
 }
 
-func getPods(c *Controller, deploymentName string) *apiv1.PodList {
-	// TODO(devkulkarni): This is returning all Pods. We should change this
-	// to only return Pods whose Label matches the Deployment Name.
-	pods, err := c.kubeclientset.CoreV1().Pods("default").List(metav1.ListOptions{
-		//LabelSelector: deploymentName,
-		//LabelSelector: metav1.LabelSelector{
-		//	MatchLabels: map[string]string{
-		//	"app": deploymentName,
-		//},
-		//},
-	})
-	//fmt.Printf("There are %d pods in the cluster\n", len(pods.Items))
-	if err != nil {
-		fmt.Printf("%s", err)
+// waitForPods blocks until the Pods of the Deployment are ready. It fails
+// instead once a Pod of the Deployment is crash looping.
+func waitForPods(c *Controller, deploymentName string) error {
+	//fmt.Println("About to get Pods")
+	time.Sleep(time.Second * 5)
+
+	for {
+		readyPods := 0
+		pods, err := getPods(c, deploymentName)
+		if err != nil {
+			return err
+		}
+		//fmt.Println("Got Pods:: %s", pods)
+		for _, d := range pods.Items {
+			//fmt.Printf(" * %s %s \n", d.Name, d.Status)
+			if err := getCrashLoopError(&d); err != nil {
+				return err
+			}
+			podConditions := d.Status.Conditions
+			for _, podCond := range podConditions {
+				if podCond.Type == corev1.PodReady {
+					if podCond.Status == corev1.ConditionTrue {
+						//fmt.Println("Pod is running.")
+						readyPods += 1
+						//fmt.Printf("ReadyPods:%d\n", readyPods)
+						//fmt.Printf("TotalPods:%d\n", len(pods.Items))
+					}
+				}
+			}
+		}
+		if readyPods >= len(pods.Items) {
+			break
+		} else {
+			fmt.Println("Waiting for Pod to get ready.")
+			// Sleep for the Pod to become active
+			time.Sleep(time.Second * 4)
+		}
 	}
-	//fmt.Println("Got Pods: %s", pods)
-	return pods
+
+	// Wait couple of seconds more just to give the Pod some more time.
+	time.Sleep(time.Second * 2)
+	return nil
+}
+
+// getPods lists the Pods of the Deployment of the instance. The Pods of the
+// backup, restore and setup Jobs share its app label and are excluded by the
+// job-name label Jobs add to their Pods.
+func getPods(c *Controller, deploymentName string) (*apiv1.PodList, error) {
+	return c.kubeclientset.CoreV1().Pods(c.getInstanceNamespace()).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=%s,!job-name", deploymentName),
+	})
 }
 
 // newDeployment creates a new Deployment for a Foo resource. It also sets
@@ -878,3 +1554,7 @@ func newDeployment(foo *postgresv1.Postgres) *appsv1.Deployment {
 }
 
 func int32Ptr(i int32) *int32 { return &i }
+
+func int64Ptr(i int64) *int64 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned/fake"
+	listers "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/listers/postgrescontroller/v1"
+)
+
+type fixture struct {
+	t *testing.T
+
+	kubeclient *kubefake.Clientset
+	client     *fake.Clientset
+
+	deployments []*appsv1.Deployment
+	secrets     []*apiv1.Secret
+	foos        []*postgresv1.Postgres
+
+	// One mock per connection opened by the controller, in order
+	mocks  []sqlmock.Sqlmock
+	dbs    []*sql.DB
+	opened int
+}
+
+func newFixture(t *testing.T) *fixture {
+	return &fixture{t: t}
+}
+
+// expectConnection prepares the mock used for the next connection to Postgres.
+func (f *fixture) expectConnection() sqlmock.Sqlmock {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		f.t.Fatalf("unexpected error creating sqlmock: %v", err)
+	}
+	f.dbs = append(f.dbs, db)
+	f.mocks = append(f.mocks, mock)
+	return mock
+}
+
+// open hands out the prepared mocks to the pq executor.
+func (f *fixture) open(endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+	if f.opened >= len(f.dbs) {
+		return nil, fmt.Errorf("unexpected connection to database %q", dbname)
+	}
+	db := f.dbs[f.opened]
+	f.opened++
+	return db, nil
+}
+
+func (f *fixture) newController() *Controller {
+	var objects []runtime.Object
+	for _, foo := range f.foos {
+		objects = append(objects, foo)
+	}
+	f.client = fake.NewSimpleClientset(objects...)
+	f.kubeclient = kubefake.NewSimpleClientset()
+
+	deploymentIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, d := range f.deployments {
+		deploymentIndexer.Add(d)
+		f.kubeclient.AppsV1().Deployments(d.Namespace).Create(d)
+	}
+	for _, secret := range f.secrets {
+		f.kubeclient.CoreV1().Secrets(secret.Namespace).Create(secret)
+	}
+	fooIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, foo := range f.foos {
+		fooIndexer.Add(foo)
+	}
+
+	return &Controller{
+		kubeclientset:     f.kubeclient,
+		sampleclientset:   f.client,
+		deploymentsLister: appslisters.NewDeploymentLister(deploymentIndexer),
+		foosLister:        listers.NewPostgresLister(fooIndexer),
+		recorder:          record.NewFakeRecorder(100),
+		newDBExecutor: func() DBExecutor {
+			return &pqExecutor{open: f.open}
+		},
+	}
+}
+
+func (f *fixture) run(key string) {
+	c := f.newController()
+
+	if err := c.syncHandler(key); err != nil {
+		f.t.Fatalf("error syncing %s: %v", key, err)
+	}
+	if f.opened != len(f.dbs) {
+		f.t.Errorf("expected %d connections, got %d", len(f.dbs), f.opened)
+	}
+	for i, mock := range f.mocks {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			f.t.Errorf("connection %d: %v", i, err)
+		}
+	}
+}
+
+func (f *fixture) getPostgres(name string) *postgresv1.Postgres {
+	foo, err := f.client.PostgrescontrollerV1().Postgreses("default").Get(name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("unexpected error getting postgres %s: %v", name, err)
+	}
+	return foo
+}
+
+// newSuperuserSecret returns the superuser Secret of an instance created
+// earlier.
+func newSuperuserSecret(foo *postgresv1.Postgres) *apiv1.Secret {
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: getSuperuserSecretName(foo), Namespace: "default"},
+		Data:       map[string][]byte{CREDENTIALS_PASSWORD_KEY: []byte("generated")},
+	}
+}
+
+func expectExec(mock sqlmock.Sqlmock, command string) {
+	mock.ExpectExec(regexp.QuoteMeta(command)).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestSyncCreatesDeploymentAndService(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	f.foos = append(f.foos, foo)
+
+	mock := f.expectConnection()
+	expectExec(mock, "set password_encryption = 'scram-sha-256';")
+	expectExec(mock, "create user \"devdatta\" with password 'pass123';")
+	expectExec(mock, "create database moodle;")
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	if _, err := f.kubeclient.AppsV1().Deployments("default").Get("client25", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected deployment client25 to be created: %v", err)
+	}
+	if _, err := f.kubeclient.CoreV1().Services("default").Get("client25", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected service client25 to be created: %v", err)
+	}
+	updated := f.getPostgres("client25")
+	if updated.Status.Status != "READY" {
+		t.Errorf("expected status READY, got %s", updated.Status.Status)
+	}
+	if updated.Status.DatabaseCount != 1 {
+		t.Errorf("expected a database count of 1, got %d", updated.Status.DatabaseCount)
+	}
+	if !contains(updated.Status.ActionHistory, "create user \"devdatta\" with password '***';") ||
+		contains(updated.Status.ActionHistory, "create user \"devdatta\" with password 'pass123';") {
+		t.Errorf("expected the password to be redacted in the action history, got %v", updated.Status.ActionHistory)
+	}
+	if updated.Status.SuperuserSecret != "client25-superuser" {
+		t.Errorf("expected superuser secret client25-superuser, got %s", updated.Status.SuperuserSecret)
+	}
+	if _, err := f.kubeclient.CoreV1().Secrets("default").Get("client25-superuser", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the superuser secret to be created: %v", err)
+	}
+}
+
+func TestSyncReconcilesDatabases(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	foo.Spec.Databases = newDatabaseSpecs("moodle", "wordpress")
+	foo.Status.Status = "READY"
+	foo.Status.Databases = newDatabaseSpecs("moodle")
+	foo.Status.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+
+	// Live state
+	mock := f.expectConnection()
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectQuery("SELECT rolname FROM pg_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname"}).AddRow("postgres").AddRow("devdatta"))
+	mock.ExpectClose()
+	// Reconcile
+	mock = f.expectConnection()
+	expectExec(mock, "create database wordpress;")
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	updated := f.getPostgres("client25")
+	if updated.Status.Status != "READY" {
+		t.Errorf("expected status READY, got %s", updated.Status.Status)
+	}
+	if !contains(updated.Status.ActionHistory, "create database wordpress;") {
+		t.Errorf("expected create database wordpress; in the action history, got %v", updated.Status.ActionHistory)
+	}
+}
+
+func TestSyncDryRunPlansCommands(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.DryRun = true
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}, {User: "analyst", Password: "pass456"}}
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Status.Status = "READY"
+	foo.Status.Databases = newDatabaseSpecs("moodle")
+	foo.Status.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+
+	// No connection is expected
+	f.run("default/client25")
+
+	updated := f.getPostgres("client25")
+	expected := []string{
+		"set password_encryption = 'scram-sha-256';",
+		"create user \"analyst\" with password '***';",
+	}
+	if !reflect.DeepEqual(updated.Status.PlannedCommands, expected) {
+		t.Errorf("expected planned commands %v\ngot %v", expected, updated.Status.PlannedCommands)
+	}
+	if contains(updated.Status.ActionHistory, "create user \"analyst\" with password 'pass456';") {
+		t.Errorf("expected the planned commands not to be run, got %v", updated.Status.ActionHistory)
+	}
+}
+
+func TestImagePullSecrets(t *testing.T) {
+	foo := newTestPostgres(nil)
+	if secrets := getDeployment(foo).Spec.Template.Spec.ImagePullSecrets; secrets != nil {
+		t.Errorf("expected no image pull secrets, got %v", secrets)
+	}
+	foo.Spec.ImagePullSecrets = []string{"registry-a", "registry-b"}
+	expected := []apiv1.LocalObjectReference{{Name: "registry-a"}, {Name: "registry-b"}}
+	if secrets := getDeployment(foo).Spec.Template.Spec.ImagePullSecrets; !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("expected %v\ngot %v", expected, secrets)
+	}
+}
+
+func TestGetReplicaCounts(t *testing.T) {
+	foo := newTestPostgres(nil)
+	deployment := getDeployment(foo)
+	deployment.Namespace = "default"
+	deployment.Status.AvailableReplicas = 1
+	deployment.Status.ReadyReplicas = 0
+	c := &Controller{kubeclientset: kubefake.NewSimpleClientset(deployment)}
+	if available, ready := c.getReplicaCounts(foo); available != 1 || ready != 0 {
+		t.Errorf("expected 1 available and 0 ready replicas, got %d and %d", available, ready)
+	}
+
+	foo.Spec.DeploymentName = "missing"
+	if available, ready := c.getReplicaCounts(foo); available != 0 || ready != 0 {
+		t.Errorf("expected no replicas without a Deployment, got %d and %d", available, ready)
+	}
+}
+
+func TestGetPodsOnlyListsDeploymentPods(t *testing.T) {
+	newPod := func(name string, labels map[string]string) *apiv1.Pod {
+		return &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels}}
+	}
+	c := &Controller{kubeclientset: kubefake.NewSimpleClientset(
+		newPod("client25-abc", map[string]string{"app": "client25"}),
+		newPod("client25-backup-xyz", map[string]string{"app": "client25", "job-name": "client25-backup"}),
+		newPod("client26-abc", map[string]string{"app": "client26"}),
+		newPod("nginx", nil),
+	)}
+	pods, err := getPods(c, "client25")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "client25-abc" {
+		t.Errorf("expected only the pod of client25, got %v", pods.Items)
+	}
+}
+
+func TestSyncRecreatesDeletedDeployment(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Status.Status = "READY"
+	foo.Status.ServiceIP = MINIKUBE_IP
+	foo.Status.ServicePort = "30123"
+	foo.Status.Databases = newDatabaseSpecs("moodle")
+	foo.Status.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	f.foos = append(f.foos, foo)
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+	// No Deployment: it was deleted out-of-band
+
+	// The data was kept, so nothing has to be re-created
+	mock := f.expectConnection()
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectQuery("SELECT rolname FROM pg_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname"}).AddRow("postgres").AddRow("devdatta"))
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	if _, err := f.kubeclient.AppsV1().Deployments("default").Get("client25", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected deployment client25 to be re-created: %v", err)
+	}
+	service, err := f.kubeclient.CoreV1().Services("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected service client25 to be re-created: %v", err)
+	}
+	if service.Spec.Ports[0].NodePort != 30123 {
+		t.Errorf("expected the recorded node port 30123, got %d", service.Spec.Ports[0].NodePort)
+	}
+	if updated := f.getPostgres("client25"); updated.Status.Status != "READY" {
+		t.Errorf("expected status READY, got %s", updated.Status.Status)
+	}
+}
+
+func TestRecreateDeploymentReturnsServiceError(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Status.ServiceIP = MINIKUBE_IP
+	foo.Status.ServicePort = "30123"
+	kubeclient := kubefake.NewSimpleClientset()
+	kubeclient.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("service quota exceeded")
+	})
+	c := &Controller{kubeclientset: kubeclient, recorder: record.NewFakeRecorder(10)}
+
+	err := c.recreateDeployment(foo)
+	if err == nil || !strings.Contains(err.Error(), "service quota exceeded") {
+		t.Errorf("expected the error creating the service, got %v", err)
+	}
+}
+
+func TestSyncRunsOnlyNewSetupCommands(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.Commands = []postgresv1.CommandSpec{
+		{Statements: []string{"create table t (id int);", "insert into t values (1);"}},
+	}
+	foo.Status.Status = "READY"
+	foo.Status.Databases = newDatabaseSpecs("moodle")
+	foo.Status.ActionHistory = []string{"create database moodle;", "create table t (id int);"}
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+
+	// Live state
+	mock := f.expectConnection()
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectQuery("SELECT rolname FROM pg_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname"}).AddRow("postgres"))
+	mock.ExpectClose()
+	// Only the new setup command
+	mock = f.expectConnection()
+	expectExec(mock, "insert into t values (1);")
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	updated := f.getPostgres("client25")
+	expected := []string{"create database moodle;", "create table t (id int);", "insert into t values (1);"}
+	if !reflect.DeepEqual(updated.Status.ActionHistory, expected) {
+		t.Errorf("expected action history %#v\ngot %#v", expected, updated.Status.ActionHistory)
+	}
+	if !reflect.DeepEqual(updated.Status.AppliedCommandChecksums, getCommandChecksums(foo)) {
+		t.Errorf("expected the checksums of the commands to be recorded, got %v", updated.Status.AppliedCommandChecksums)
+	}
+}
+
+func TestSyncSkipsAppliedSetupCommands(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.Commands = []postgresv1.CommandSpec{
+		{Statements: []string{"create table t (id int);", "insert into t values (1);"}},
+	}
+	foo.Status.Status = "READY"
+	foo.Status.Databases = newDatabaseSpecs("moodle")
+	// The history was trimmed, yet the commands are recorded as applied
+	foo.Status.ActionHistory = []string{"create database moodle;"}
+	foo.Status.AppliedCommandChecksums = getCommandChecksums(foo)
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+
+	// Live state only
+	mock := f.expectConnection()
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectQuery("SELECT rolname FROM pg_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname"}).AddRow("postgres"))
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	if f.opened != 1 {
+		t.Errorf("expected no connection for the applied commands, got %d connections", f.opened)
+	}
+}
+
+func TestSyncFailsWithoutSuperuserSecret(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Status.Status = "READY"
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+
+	c := f.newController()
+	err := c.syncHandler("default/client25")
+	if err == nil || !strings.Contains(err.Error(), "superuser secret client25-superuser") {
+		t.Errorf("expected the missing superuser secret to fail the sync, got %v", err)
+	}
+	if f.opened != 0 {
+		t.Errorf("expected no connection without the superuser password, got %d", f.opened)
+	}
+}
+
+func TestSyncReturnsGetErrors(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Status.Status = "READY"
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+
+	c := f.newController()
+	f.client.PrependReactor("get", "postgreses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+	err := c.syncHandler("default/client25")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the failed get to be returned, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// A container restarted this many times is considered crash looping even
+// before the kubelet reports CrashLoopBackOff
+const MAX_CONTAINER_RESTARTS = 5
+
+// getCrashLoopError returns an error if a container of the pod is crash
+// looping, with the message of its last termination.
+func getCrashLoopError(pod *apiv1.Pod) error {
+	var statuses []apiv1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		crashLooping := status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff"
+		if !crashLooping && status.RestartCount < MAX_CONTAINER_RESTARTS {
+			continue
+		}
+		message := fmt.Sprintf("container %s of pod %s is crash looping after %d restarts",
+			status.Name, pod.Name, status.RestartCount)
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			message += fmt.Sprintf(", last exit code %d", terminated.ExitCode)
+			if terminated.Message != "" {
+				message += ": " + terminated.Message
+			} else if terminated.Reason != "" {
+				message += ": " + terminated.Reason
+			}
+		}
+		return fmt.Errorf("%s", message)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCrashLoopError(t *testing.T) {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "client25-abc"},
+		Status: apiv1.PodStatus{
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "postgres", Ready: false, RestartCount: 1},
+			},
+		},
+	}
+	if err := getCrashLoopError(pod); err != nil {
+		t.Errorf("expected no error for a single restart, got %v", err)
+	}
+
+	pod.Status.ContainerStatuses[0].State.Waiting = &apiv1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}
+	pod.Status.ContainerStatuses[0].LastTerminationState.Terminated = &apiv1.ContainerStateTerminated{
+		ExitCode: 1,
+		Message:  "initdb: directory exists but is not empty",
+	}
+	err := getCrashLoopError(pod)
+	if err == nil {
+		t.Fatalf("expected an error for a crash looping container")
+	}
+	expected := "container postgres of pod client25-abc is crash looping after 1 restarts, last exit code 1: " +
+		"initdb: directory exists but is not empty"
+	if err.Error() != expected {
+		t.Errorf("expected %s\ngot %s", expected, err.Error())
+	}
+
+	pod.Status.ContainerStatuses[0].State.Waiting = nil
+	pod.Status.ContainerStatuses[0].RestartCount = MAX_CONTAINER_RESTARTS
+	if err := getCrashLoopError(pod); err == nil || !strings.Contains(err.Error(), "crash looping") {
+		t.Errorf("expected repeated restarts to be reported, got %v", err)
+	}
+}
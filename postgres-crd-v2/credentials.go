@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	CREDENTIALS_PASSWORD_KEY = "password"
+	CREDENTIALS_USERNAME_KEY = "username"
+	GENERATED_PASSWORD_BYTES = 16
+)
+
+func getCredentialsSecretName(foo *postgresv1.Postgres, user string) string {
+	return foo.Name + "-" + user + "-credentials"
+}
+
+// usesPasswordSecret returns true if the password of the user is kept in a
+// Secret, either referenced or generated, instead of the spec.
+func usesPasswordSecret(user postgresv1.UserSpec) bool {
+	return user.PasswordSecretRef != "" || (user.Password == "" && user.PasswordFromFile == "")
+}
+
+// hidesPassword returns true if the password of the user is not written in
+// the spec and must therefore only be recorded as a hash.
+func hidesPassword(user postgresv1.UserSpec) bool {
+	return user.PasswordSecretRef != "" || user.PasswordFromFile != ""
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// samePassword compares a resolved password against the one recorded in the
+// status, which only holds a hash for passwords kept in Secrets or files.
+func samePassword(desired postgresv1.UserSpec, current postgresv1.UserSpec) bool {
+	if hidesPassword(current) {
+		return hashPassword(desired.Password) == current.Password
+	}
+	return desired.Password == current.Password
+}
+
+func generatePassword() (string, error) {
+	data := make([]byte, GENERATED_PASSWORD_BYTES)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// resolveUsers returns the users of the spec with the passwords read from
+// their Secrets or files. Users without a password get a generated one which
+// is kept in a Secret named <cr>-<user>-credentials; the names of these
+// Secrets are returned as well. The resolved users must never be written to
+// the spec.
+func (c *Controller) resolveUsers(foo *postgresv1.Postgres) ([]postgresv1.UserSpec, []string, error) {
+	var users []postgresv1.UserSpec
+	var generated []string
+	for _, user := range foo.Spec.Users {
+		user = *user.DeepCopy()
+		if user.PasswordFromFile != "" {
+			password, err := readPasswordFile(user.PasswordFromFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("user %s: %v", user.User, err)
+			}
+			user.Password = password
+			users = append(users, user)
+			continue
+		}
+		if !usesPasswordSecret(user) {
+			users = append(users, user)
+			continue
+		}
+		if user.PasswordSecretRef == "" {
+			user.PasswordSecretRef = getCredentialsSecretName(foo, user.User)
+			err := c.createCredentialsSecret(foo, user.User)
+			if err != nil {
+				return nil, nil, err
+			}
+			generated = append(generated, user.PasswordSecretRef)
+		}
+		secret, err := c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace()).Get(user.PasswordSecretRef, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		password, ok := secret.Data[CREDENTIALS_PASSWORD_KEY]
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %s has no %s key", user.PasswordSecretRef, CREDENTIALS_PASSWORD_KEY)
+		}
+		user.Password = string(password)
+		users = append(users, user)
+	}
+	return users, generated, nil
+}
+
+// createCredentialsSecret generates a password for the user unless its
+// Secret already exists. An existing password is never replaced.
+func (c *Controller) createCredentialsSecret(foo *postgresv1.Postgres, user string) error {
+	secretName := getCredentialsSecretName(foo, user)
+	secretsClient := c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace())
+
+	_, err := secretsClient.Get(secretName, metav1.GetOptions{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName,
+			Labels: map[string]string{
+				"app": foo.Name,
+			},
+		},
+		Type: apiv1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			CREDENTIALS_USERNAME_KEY: []byte(user),
+			CREDENTIALS_PASSWORD_KEY: []byte(password),
+		},
+	}
+	fmt.Printf("Creating secret %s...\n", secretName)
+	_, err = secretsClient.Create(secret)
+	return err
+}
+
+// getStatusUsers returns the users to record in the status. Passwords kept
+// in Secrets or files are replaced by their hash.
+func getStatusUsers(users []postgresv1.UserSpec) []postgresv1.UserSpec {
+	var statusUsers []postgresv1.UserSpec
+	for _, user := range users {
+		user = *user.DeepCopy()
+		if hidesPassword(user) {
+			user.Password = hashPassword(user.Password)
+		}
+		statusUsers = append(statusUsers, user)
+	}
+	return statusUsers
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestResolveUsers(t *testing.T) {
+	appSecret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-password", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("fromsecret")},
+	}
+	c := &Controller{kubeclientset: fake.NewSimpleClientset(appSecret)}
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{
+		{User: "devdatta", Password: "pass123"},
+		{User: "app", PasswordSecretRef: "app-password"},
+		{User: "pallavi"},
+	}
+
+	users, generated, err := c.resolveUsers(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"client25-pallavi-credentials"}; !reflect.DeepEqual(generated, expected) {
+		t.Errorf("expected generated secrets %v, got %v", expected, generated)
+	}
+	if users[0].Password != "pass123" || users[1].Password != "fromsecret" {
+		t.Errorf("unexpected passwords %#v", users)
+	}
+	if users[2].Password == "" || users[2].PasswordSecretRef != "client25-pallavi-credentials" {
+		t.Errorf("expected a generated password, got %#v", users[2])
+	}
+	if foo.Spec.Users[2].Password != "" {
+		t.Errorf("the spec must not be modified")
+	}
+
+	// The generated password is kept across syncs
+	again, _, err := c.resolveUsers(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again[2].Password != users[2].Password {
+		t.Errorf("expected the generated password to be reused")
+	}
+}
+
+func TestStatusUsersHidePasswords(t *testing.T) {
+	users := []postgresv1.UserSpec{
+		{User: "devdatta", Password: "pass123"},
+		{User: "app", Password: "fromsecret", PasswordSecretRef: "app-password"},
+	}
+	statusUsers := getStatusUsers(users)
+	if statusUsers[0].Password != "pass123" {
+		t.Errorf("expected spec passwords to be kept, got %s", statusUsers[0].Password)
+	}
+	if statusUsers[1].Password == "fromsecret" {
+		t.Errorf("expected the password of a secret to be hashed")
+	}
+
+	// No alter for unchanged passwords, an alter once the secret changes
+	_, _, alter := getUserCommands(users, statusUsers, nil, "postgres")
+	if len(alter) != 0 {
+		t.Errorf("expected no alter commands, got %#v", alter)
+	}
+	users[1].Password = "rotated"
+	_, _, alter = getUserCommands(users, statusUsers, nil, "postgres")
+	if expected := []string{"alter user \"app\" with password 'rotated';"}; !reflect.DeepEqual(alter, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, alter)
+	}
+}
@@ -2,30 +2,98 @@ package main
 
 import (
         "fmt"
+	"strconv"
 	"strings"
+	"github.com/lib/pq"
+        postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
 )
 
-func getDatabaseCommands(desiredList []string, currentList []string) ([]string, []string) {
+func getDatabaseNames(dbList []postgresv1.DatabaseSpec) []string {
+     var names []string
+     for _, db := range dbList {
+     	 names = append(names, db.Name)
+     }
+     return names
+}
+
+func getDatabaseCommands(desiredList []postgresv1.DatabaseSpec, currentList []string) ([]string, []string) {
      var createDatabaseCommands []string
      var deleteDatabaseCommands []string
 
      if len(currentList) == 0 {
      	createDatabaseCommands = getCreateDatabaseCommands(desiredList)
      } else {
-	  addList := getDiffList(desiredList, currentList)
+	  var addList []postgresv1.DatabaseSpec
+	  for _, db := range desiredList {
+	      if !contains(currentList, db.Name) {
+	      	 addList = append(addList, db)
+	      }
+	  }
 	  createDatabaseCommands = getCreateDatabaseCommands(addList)
 
-	  dropList := getDiffList(currentList, desiredList)
+	  dropList := getDiffList(currentList, getDatabaseNames(desiredList))
 	  deleteDatabaseCommands = getDropDatabaseCommands(dropList)
      }
      return createDatabaseCommands, deleteDatabaseCommands
 }
 
-func getCreateDatabaseCommands(dbList []string) []string {
+// getDatabaseOptions renders the options of CREATE DATABASE that are set.
+// A database with its own encoding or locale is created from template0 by
+// default, as template1 may contain data in the cluster default locale and
+// CREATE DATABASE would fail. Encoding and locales are quoted as literals,
+// the template and owner as identifiers.
+func getDatabaseOptions(db postgresv1.DatabaseSpec) string {
+     options := ""
+     if db.Encoding != "" {
+     	options = options + " encoding " + pq.QuoteLiteral(db.Encoding)
+     }
+     if db.LCCollate != "" {
+     	options = options + " lc_collate " + pq.QuoteLiteral(db.LCCollate)
+     }
+     if db.LCCtype != "" {
+     	options = options + " lc_ctype " + pq.QuoteLiteral(db.LCCtype)
+     }
+     template := db.Template
+     if template == "" && (db.Encoding != "" || db.LCCollate != "" || db.LCCtype != "") {
+     	template = "template0"
+     }
+     if template != "" {
+     	options = options + " template " + pq.QuoteIdentifier(strings.ToLower(template))
+     }
+     if db.Owner != "" {
+     	options = options + " owner " + getQuotedUserName(db.Owner)
+     }
+     if db.ConnectionLimit != nil {
+     	options = options + " connection limit " + strconv.Itoa(int(*db.ConnectionLimit))
+     }
+     return options
+}
+
+// getAlterDatabaseOwnerCommands transfers existing databases whose owner in
+// the spec differs from the one recorded in the status.
+func getAlterDatabaseOwnerCommands(desiredList []postgresv1.DatabaseSpec, statusList []postgresv1.DatabaseSpec,
+     currentList []string) []string {
+     var cmdList []string
+     for _, db := range desiredList {
+     	 if db.Owner == "" || !contains(currentList, db.Name) {
+	    continue
+	 }
+	 statusDB, _ := findDatabase(statusList, db.Name)
+	 if statusDB.Owner == db.Owner {
+	    continue
+	 }
+	 cmdString := "alter database " + db.Name + " owner to " + getQuotedUserName(db.Owner) + ";"
+	 fmt.Printf("AlterDBCmd: %v\n", cmdString)
+	 cmdList = append(cmdList, cmdString)
+     }
+     return cmdList
+}
+
+func getCreateDatabaseCommands(dbList []postgresv1.DatabaseSpec) []string {
      var cmdList []string
      for _, db := range dbList {
-     	 createDBCmd := strings.Fields("create database " + db + ";")
-    	 var cmdString = strings.Join(createDBCmd, " ")
+	 // The options are not split on whitespace, which is kept in quoted values
+	 cmdString := "create database " + db.Name + getDatabaseOptions(db) + ";"
 	 fmt.Printf("CreateDBCmd: %v\n", cmdString)
 	 cmdList = append(cmdList, cmdString)
      }
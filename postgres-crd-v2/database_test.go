@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newDatabaseSpecs(names ...string) []postgresv1.DatabaseSpec {
+	var dbList []postgresv1.DatabaseSpec
+	for _, name := range names {
+		dbList = append(dbList, postgresv1.DatabaseSpec{Name: name})
+	}
+	return dbList
+}
+
+func TestGetDatabaseCommands(t *testing.T) {
+	testCases := []struct {
+		name           string
+		desired        []string
+		current        []string
+		expectedCreate []string
+		expectedDrop   []string
+	}{
+		{
+			name:           "all creates when nothing exists",
+			desired:        []string{"moodle", "wordpress"},
+			current:        nil,
+			expectedCreate: []string{"create database moodle;", "create database wordpress;"},
+		},
+		{
+			name:    "no commands for matching sets",
+			desired: []string{"moodle", "wordpress"},
+			current: []string{"wordpress", "moodle"},
+		},
+		{
+			name:           "added database is created",
+			desired:        []string{"moodle", "wordpress"},
+			current:        []string{"moodle"},
+			expectedCreate: []string{"create database wordpress;"},
+		},
+		{
+			name:         "removed database is dropped",
+			desired:      []string{"moodle"},
+			current:      []string{"moodle", "wordpress"},
+			expectedDrop: []string{"drop database wordpress;"},
+		},
+		{
+			name:         "all dropped when none desired",
+			desired:      nil,
+			current:      []string{"moodle"},
+			expectedDrop: []string{"drop database moodle;"},
+		},
+		{
+			name:    "duplicate names",
+			desired: []string{"moodle", "moodle"},
+			current: []string{"moodle"},
+		},
+	}
+	for _, tc := range testCases {
+		create, drop := getDatabaseCommands(newDatabaseSpecs(tc.desired...), tc.current)
+		if !reflect.DeepEqual(create, tc.expectedCreate) {
+			t.Errorf("%s: expected creates %#v\ngot %#v", tc.name, tc.expectedCreate, create)
+		}
+		if !reflect.DeepEqual(drop, tc.expectedDrop) {
+			t.Errorf("%s: expected drops %#v\ngot %#v", tc.name, tc.expectedDrop, drop)
+		}
+	}
+}
+
+func TestCreateDatabaseWithOptions(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{{Name: "moodle", Encoding: "UTF8", LCCollate: "de_DE.UTF-8",
+		LCCtype: "de_DE.UTF-8", Template: "template0"}}
+	create, _ := getDatabaseCommands(desired, nil)
+	expected := []string{"create database moodle encoding 'UTF8' lc_collate 'de_DE.UTF-8' lc_ctype 'de_DE.UTF-8' template \"template0\";"}
+	if !reflect.DeepEqual(create, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, create)
+	}
+}
+
+func TestCreateDatabaseOptionsAreQuoted(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{{Name: "moodle", LCCollate: "it's  quoted", Template: "My Template",
+		Owner: "App\"Owner"}}
+	create, _ := getDatabaseCommands(desired, nil)
+	expected := []string{"create database moodle lc_collate 'it''s  quoted' template \"my template\" owner \"app\"\"owner\";"}
+	if !reflect.DeepEqual(create, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, create)
+	}
+}
+
+func TestCreateDatabaseWithLocaleUsesTemplate0(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{
+		{Name: "moodle", LCCollate: "de_DE.UTF-8", LCCtype: "de_DE.UTF-8"},
+		{Name: "wordpress", Encoding: "LATIN1", Template: "mytemplate"},
+		{Name: "drupal"},
+	}
+	create, _ := getDatabaseCommands(desired, nil)
+	expected := []string{
+		"create database moodle lc_collate 'de_DE.UTF-8' lc_ctype 'de_DE.UTF-8' template \"template0\";",
+		"create database wordpress encoding 'LATIN1' template \"mytemplate\";",
+		"create database drupal;",
+	}
+	if !reflect.DeepEqual(create, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, create)
+	}
+}
+
+func TestDatabaseSpecAcceptsNames(t *testing.T) {
+	var spec postgresv1.PostgresSpec
+	data := `{"databases": ["moodle", {"name": "wordpress", "encoding": "UTF8"}]}`
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []postgresv1.DatabaseSpec{{Name: "moodle"}, {Name: "wordpress", Encoding: "UTF8"}}
+	if !reflect.DeepEqual(spec.Databases, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, spec.Databases)
+	}
+}
+
+func TestDatabaseOptionChanges(t *testing.T) {
+	status := []postgresv1.DatabaseSpec{{Name: "moodle", Encoding: "UTF8"}, {Name: "wordpress"}}
+	desired := []postgresv1.DatabaseSpec{{Name: "moodle", Encoding: "LATIN1"}, {Name: "wordpress"},
+		{Name: "drupal", Encoding: "LATIN1"}}
+	current := []string{"moodle", "wordpress"}
+
+	if changed := getChangedDatabaseOptions(desired, status, current); !reflect.DeepEqual(changed, []string{"moodle"}) {
+		t.Errorf("expected moodle to be changed, got %v", changed)
+	}
+	// The status keeps the options moodle was created with
+	expected := []postgresv1.DatabaseSpec{{Name: "moodle", Encoding: "UTF8"}, {Name: "wordpress"},
+		{Name: "drupal", Encoding: "LATIN1"}}
+	if applied := getAppliedDatabases(desired, status, current); !reflect.DeepEqual(applied, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, applied)
+	}
+	// A database dropped out-of-band is re-created with the new options
+	if changed := getChangedDatabaseOptions(desired, status, []string{"wordpress"}); len(changed) != 0 {
+		t.Errorf("expected no changes for missing databases, got %v", changed)
+	}
+}
+
+func TestDatabaseOwner(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{{Name: "moodle", Owner: "devdatta"}, {Name: "wordpress", Owner: "pallavi"}}
+	status := []postgresv1.DatabaseSpec{{Name: "moodle", Owner: "pallavi"}}
+	current := []string{"moodle"}
+
+	create, _ := getDatabaseCommands(desired, current)
+	if expected := []string{"create database wordpress owner \"pallavi\";"}; !reflect.DeepEqual(create, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, create)
+	}
+	alter := getAlterDatabaseOwnerCommands(desired, status, current)
+	if expected := []string{"alter database moodle owner to \"devdatta\";"}; !reflect.DeepEqual(alter, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, alter)
+	}
+	if changed := getChangedDatabaseOptions(desired, status, current); len(changed) != 0 {
+		t.Errorf("expected owner changes not to be reported as immutable, got %v", changed)
+	}
+	if applied := getAppliedDatabases(desired, status, current); applied[0].Owner != "devdatta" {
+		t.Errorf("expected the new owner in the status, got %#v", applied[0])
+	}
+	if alter := getAlterDatabaseOwnerCommands(desired, getAppliedDatabases(desired, status, current), current); len(alter) != 0 {
+		t.Errorf("expected no commands once the owner is recorded, got %#v", alter)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// WarnDatabaseOptionsImmutable is used as part of the Event 'reason' when
+	// the options of an existing database are changed in the spec.
+	WarnDatabaseOptionsImmutable = "DatabaseOptionsImmutable"
+)
+
+func findDatabase(dbList []postgresv1.DatabaseSpec, name string) (postgresv1.DatabaseSpec, bool) {
+	for _, db := range dbList {
+		if db.Name == name {
+			return db, true
+		}
+	}
+	return postgresv1.DatabaseSpec{}, false
+}
+
+// getAppliedDatabases returns the databases to record in the status. The
+// options of databases that already existed are taken from the status as
+// they were not changed, only new databases get the options of the spec.
+func getAppliedDatabases(desired []postgresv1.DatabaseSpec, status []postgresv1.DatabaseSpec,
+	current []string) []postgresv1.DatabaseSpec {
+	var applied []postgresv1.DatabaseSpec
+	for _, db := range desired {
+		if contains(current, db.Name) {
+			if statusDB, ok := findDatabase(status, db.Name); ok {
+				// The owner, the connection limit, the schemas, the public
+				// access and the comment are altered, not ignored
+				statusDB.Owner = db.Owner
+				statusDB.ConnectionLimit = db.ConnectionLimit
+				statusDB.Schemas = db.Schemas
+				statusDB.RevokePublicAccess = db.RevokePublicAccess
+				statusDB.Comment = db.Comment
+				db = statusDB
+			}
+		}
+		applied = append(applied, db)
+	}
+	return applied
+}
+
+// getChangedDatabaseOptions returns the existing databases whose options in
+// the spec differ from the ones they were created with. The owner, the
+// connection limit and the schemas can be changed and are not compared.
+func getChangedDatabaseOptions(desired []postgresv1.DatabaseSpec, status []postgresv1.DatabaseSpec,
+	current []string) []string {
+	var changed []string
+	for _, db := range desired {
+		if !contains(current, db.Name) {
+			continue
+		}
+		statusDB, ok := findDatabase(status, db.Name)
+		statusDB.Owner = db.Owner
+		statusDB.ConnectionLimit = db.ConnectionLimit
+		if ok && getDatabaseOptions(statusDB) != getDatabaseOptions(db) {
+			changed = append(changed, db.Name)
+		}
+	}
+	return changed
+}
+
+// warnDatabaseOptionChanges records a warning for databases whose options
+// were changed in the spec. The options are only used by CREATE DATABASE.
+func (c *Controller) warnDatabaseOptionChanges(foo *postgresv1.Postgres, status []postgresv1.DatabaseSpec,
+	current []string) {
+	changed := getChangedDatabaseOptions(foo.Spec.Databases, status, current)
+	if len(changed) > 0 {
+		c.recorder.Event(foo, corev1.EventTypeWarning, WarnDatabaseOptionsImmutable,
+			fmt.Sprintf("Options of databases %s cannot be changed after creation and are ignored",
+				strings.Join(changed, ", ")))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// patchOperation is an operation of a JSON patch (RFC 6902)
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// setDefaults fills in the fields of the spec left empty. An instance
+// created by the controller gets its Deployment named after the resource.
+// It returns true if the spec was changed.
+func setDefaults(foo *postgresv1.Postgres) bool {
+	if foo.Spec.DeploymentName != "" || foo.Spec.SharedInstance != "" || foo.Spec.ExternalEndpoint != nil {
+		return false
+	}
+	foo.Spec.DeploymentName = foo.Name
+	return true
+}
+
+// getDefaultsPatch returns the JSON patch setting the defaults of the
+// resource, or nil if there are none to set.
+func getDefaultsPatch(foo *postgresv1.Postgres) ([]byte, error) {
+	defaulted := foo.DeepCopy()
+	if !setDefaults(defaulted) {
+		return nil, nil
+	}
+	return json.Marshal([]patchOperation{
+		{Op: "add", Path: "/spec/deploymentName", Value: defaulted.Spec.DeploymentName},
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newAdmissionRequest(t *testing.T, foo *postgresv1.Postgres) *admissionv1beta1.AdmissionRequest {
+	raw, err := json.Marshal(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &admissionv1beta1.AdmissionRequest{
+		Operation: admissionv1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.DeploymentName = ""
+	if !setDefaults(foo) || foo.Spec.DeploymentName != "client25" {
+		t.Errorf("expected the deployment name to default to client25, got %q", foo.Spec.DeploymentName)
+	}
+
+	foo.Spec.DeploymentName = "moodle-db"
+	if setDefaults(foo) || foo.Spec.DeploymentName != "moodle-db" {
+		t.Errorf("expected an explicit deployment name to be kept, got %q", foo.Spec.DeploymentName)
+	}
+
+	// Resources on a shared instance have no Deployment of their own
+	foo.Spec.DeploymentName = ""
+	foo.Spec.SharedInstance = "client24"
+	if setDefaults(foo) || foo.Spec.DeploymentName != "" {
+		t.Errorf("expected no deployment name on a shared instance, got %q", foo.Spec.DeploymentName)
+	}
+}
+
+func TestMutateDefaultsDeploymentName(t *testing.T) {
+	c := newTestWebhookController(nil, nil)
+	foo := newTestPostgres(nil)
+	foo.Spec.DeploymentName = ""
+
+	response := c.mutate(newAdmissionRequest(t, foo))
+	if !response.Allowed || response.PatchType == nil || *response.PatchType != admissionv1beta1.PatchTypeJSONPatch {
+		t.Fatalf("expected an allowed JSON patch, got %+v", response)
+	}
+	expected := `[{"op":"add","path":"/spec/deploymentName","value":"client25"}]`
+	if string(response.Patch) != expected {
+		t.Errorf("expected patch %s, got %s", expected, response.Patch)
+	}
+
+	if response := c.mutate(newAdmissionRequest(t, newTestPostgres(nil))); response.Patch != nil {
+		t.Errorf("expected no patch for an explicit deployment name, got %s", response.Patch)
+	}
+}
+
+func TestAdmitDefaultsBeforeValidating(t *testing.T) {
+	c := newTestWebhookController(nil, nil)
+	foo := newTestPostgres(nil)
+	foo.Spec.DeploymentName = ""
+	if response := c.admit(newAdmissionRequest(t, foo)); !response.Allowed {
+		t.Errorf("expected an empty deployment name to be allowed, got %v", response.Result)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// MIN_DRIFT_CHECK_INTERVAL bounds how often the live state is queried
+const MIN_DRIFT_CHECK_INTERVAL = 10 * time.Second
+
+func validateDriftCheckInterval(foo *postgresv1.Postgres) error {
+	interval := foo.Spec.DriftCheckInterval
+	if interval != nil && interval.Duration < MIN_DRIFT_CHECK_INTERVAL {
+		return fmt.Errorf("spec.driftCheckInterval %s must be at least %s", interval.Duration, MIN_DRIFT_CHECK_INTERVAL)
+	}
+	return nil
+}
+
+// getDriftCheckInterval returns the interval after which a synced resource
+// is reconciled again against the live database, or zero when it is not.
+// Suspended and deleted resources are not checked.
+func getDriftCheckInterval(foo *postgresv1.Postgres) time.Duration {
+	if foo.Spec.DriftCheckInterval == nil || foo.Spec.Suspend || foo.DeletionTimestamp != nil {
+		return 0
+	}
+	return foo.Spec.DriftCheckInterval.Duration
+}
+
+// requeueForDriftCheck requeues a successfully synced resource after its
+// drift check interval so that changes made to the databases and users
+// out-of-band are reverted without an update of the resource.
+func (c *Controller) requeueForDriftCheck(key string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	foo, err := c.foosLister.Postgreses(namespace).Get(name)
+	if err != nil {
+		// A deleted resource is not checked anymore
+		return
+	}
+	if interval := getDriftCheckInterval(foo); interval > 0 {
+		c.workqueue.AddAfter(key, interval)
+	}
+}
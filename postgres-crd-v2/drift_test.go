@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetDriftCheckInterval(t *testing.T) {
+	foo := newTestPostgres(nil)
+	if interval := getDriftCheckInterval(foo); interval != 0 {
+		t.Errorf("expected no drift check by default, got %s", interval)
+	}
+
+	foo.Spec.DriftCheckInterval = &metav1.Duration{Duration: 5 * time.Minute}
+	if interval := getDriftCheckInterval(foo); interval != 5*time.Minute {
+		t.Errorf("expected a drift check every 5m, got %s", interval)
+	}
+
+	foo.Spec.Suspend = true
+	if interval := getDriftCheckInterval(foo); interval != 0 {
+		t.Errorf("expected no drift check while suspended, got %s", interval)
+	}
+}
+
+func TestValidateDriftCheckInterval(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.DriftCheckInterval = &metav1.Duration{Duration: time.Second}
+	if err := validateDriftCheckInterval(foo); err == nil {
+		t.Errorf("expected an interval below %s to be rejected", MIN_DRIFT_CHECK_INTERVAL)
+	}
+	foo.Spec.DriftCheckInterval.Duration = time.Minute
+	if err := validateDriftCheckInterval(foo); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// CommandsPlanned is used as part of the Event 'reason' when a dry run
+	// has computed the commands a sync would run.
+	CommandsPlanned = "CommandsPlanned"
+)
+
+// getRecordedState returns the databases and roles recorded in the status.
+// A dry run diffs the spec against them instead of the live instance, so
+// objects removed out-of-band are not planned to be re-created.
+func getRecordedState(status *postgresv1.PostgresStatus) ([]string, []string) {
+	var roles []string
+	for _, user := range status.Users {
+		roles = append(roles, user.User)
+	}
+	return getManagedDatabases(status), roles
+}
+
+// recordPlannedCommands records the commands in Status.PlannedCommands with
+// their passwords redacted, instead of running them.
+func (c *Controller) recordPlannedCommands(foo *postgresv1.Postgres, commands []string) error {
+	var planned []string
+	for _, command := range commands {
+		planned = append(planned, redactCommand(command))
+	}
+	fooCopy := foo.DeepCopy()
+	fooCopy.Status.PlannedCommands = planned
+	fooCopy.Status.RetryCount = 0
+	err := c.patchStatus(foo, fooCopy)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Planned commands:%v\n", planned)
+	c.recorder.Event(foo, corev1.EventTypeNormal, CommandsPlanned,
+		fmt.Sprintf("Dry run planned %d commands, see status.plannedCommands", len(planned)))
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// dbEndpoint is where, and as which admin user, the controller connects
+// to Postgres.
+type dbEndpoint struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	SSLMode  string
+	// SSLRootCert is the path of the CA certificate file, if any
+	SSLRootCert string
+	// SSLCert and SSLKey are the paths of the client certificate and key
+	// files, if any
+	SSLCert string
+	SSLKey  string
+	// ServiceHost is the cluster DNS name of the Service of an instance
+	// created by the controller, empty for external instances
+	ServiceHost string
+	// Hosts are the other candidate hosts of an external instance, the
+	// primary among them is looked up before connecting
+	Hosts []string
+}
+
+// getServiceHost returns the cluster DNS name of the Service created for
+// the instance.
+func getServiceHost(foo *postgresv1.Postgres, namespace string) string {
+	return fmt.Sprintf("%s.%s.svc", foo.Spec.DeploymentName, namespace)
+}
+
+// quoteConnValue quotes a value of a keyword/value connection string if it
+// is empty or contains spaces, quotes or backslashes.
+func quoteConnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " '\\") {
+		return value
+	}
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "'", "\\'", -1)
+	return "'" + value + "'"
+}
+
+// getConnectEndpoint returns the endpoint the controller connects to. When
+// running in the cluster the Service is reached directly through its DNS
+// name and port instead of the node IP and NodePort.
+func (c *Controller) getConnectEndpoint(endpoint dbEndpoint) dbEndpoint {
+	if c.serviceDNS && endpoint.ServiceHost != "" {
+		endpoint.Host = endpoint.ServiceHost
+		endpoint.Port = "5432"
+	}
+	return endpoint
+}
+
+var passwordEncryptions = []string{"md5", "scram-sha-256"}
+
+// getPasswordEncryption returns the method used to hash role passwords,
+// scram-sha-256 by default.
+func getPasswordEncryption(foo *postgresv1.Postgres) string {
+	if foo.Spec.PasswordEncryption != "" {
+		return foo.Spec.PasswordEncryption
+	}
+	return "scram-sha-256"
+}
+
+func validatePasswordEncryption(method string) error {
+	if method == "" || contains(passwordEncryptions, method) {
+		return nil
+	}
+	return fmt.Errorf("invalid passwordEncryption %q, must be one of %v", method, passwordEncryptions)
+}
+
+// getSuperuserName returns the admin role of the instance, "postgres" by default.
+func getSuperuserName(foo *postgresv1.Postgres) string {
+	if foo.Spec.SuperuserName != "" {
+		return foo.Spec.SuperuserName
+	}
+	return "postgres"
+}
+
+// getDefaultEndpoint returns the endpoint of an instance created by the
+// controller itself.
+func getDefaultEndpoint(foo *postgresv1.Postgres, namespace string, serviceIP string, servicePort string) dbEndpoint {
+	return dbEndpoint{
+		Host:        serviceIP,
+		Port:        servicePort,
+		User:        getSuperuserName(foo),
+		SSLMode:     getSSLMode(foo),
+		ServiceHost: getServiceHost(foo, namespace),
+	}
+}
+
+// getExternalEndpoint resolves the endpoint of an externally managed
+// instance. The admin credentials are read from the 'username' and
+// 'password' keys of the referenced Secret. The password may be left out
+// when a client certificate is configured.
+func (c *Controller) getExternalEndpoint(foo *postgresv1.Postgres) (dbEndpoint, error) {
+	external := foo.Spec.ExternalEndpoint
+	endpoint := dbEndpoint{
+		Host:    external.Host,
+		Port:    fmt.Sprint(external.Port),
+		User:    getSuperuserName(foo),
+		SSLMode: getSSLMode(foo),
+		Hosts:   external.Hosts,
+	}
+	if external.Port == 0 {
+		endpoint.Port = "5432"
+	}
+	if external.AdminSecretRef == "" {
+		return endpoint, fmt.Errorf("externalEndpoint.adminSecretRef must be specified")
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets(foo.Namespace).Get(external.AdminSecretRef, metav1.GetOptions{})
+	if err != nil {
+		return endpoint, err
+	}
+	if username, ok := secret.Data["username"]; ok {
+		endpoint.User = string(username)
+	}
+	endpoint.Password = string(secret.Data["password"])
+	return endpoint, nil
+}
+
+// syncExternal reconciles the databases and users of a Postgres resource
+// pointing at a pre-existing instance. No Deployment or Service is created.
+func (c *Controller) syncExternal(foo *postgresv1.Postgres) error {
+	endpoint, err := c.getExternalEndpoint(foo)
+	if errors.IsNotFound(err) {
+		// Retry until the admin Secret shows up
+		return err
+	}
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("%s/%s: %s", foo.Namespace, foo.Name, err.Error()))
+		return nil
+	}
+	err = c.syncWithFailover(foo, endpoint, "EXTERNAL")
+	if err != nil {
+		return err
+	}
+	return c.runOnceOn(foo, endpoint)
+}
+
+// syncDatabasesAndUsers diffs the desired databases and users against the
+// live state of the endpoint, runs the resulting commands and records the
+// outcome in the status using the given phase.
+func (c *Controller) syncDatabasesAndUsers(foo *postgresv1.Postgres, endpoint dbEndpoint, phase string) error {
+	cleanup, err := c.setupSSL(foo, &endpoint)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	endpoint, err = c.findPrimary(endpoint)
+	if err != nil {
+		return err
+	}
+
+	liveDatabases, liveRoles, err := c.queryCurrentState(endpoint)
+	if err != nil {
+		return err
+	}
+	liveExtensions, err := c.queryExtensions(endpoint, getExtensionDatabases(foo, liveDatabases))
+	if err != nil {
+		return err
+	}
+	var liveDatabaseComments, liveRoleComments map[string]string
+	if hasComments(foo) {
+		liveDatabaseComments, liveRoleComments, err = c.queryComments(endpoint)
+		if err != nil {
+			return err
+		}
+	}
+	users, credentialSecrets, err := c.resolveUsers(foo)
+	if err != nil {
+		return err
+	}
+	desiredNames := getDatabaseNames(foo.Spec.Databases)
+	currentDatabases := getCurrentDatabases(liveDatabases, getManagedDatabases(&foo.Status), desiredNames)
+	currentUsers := getCurrentUsers(liveRoles, foo.Status.Users, users)
+
+	var commandsToRun []string
+	createDBCommands, dropDBCommands := getDatabaseCommands(foo.Spec.Databases, currentDatabases)
+	dropDBCommands, orphanedDatabases := c.guardDatabaseDeletion(foo, desiredNames,
+		currentDatabases, dropDBCommands)
+	c.warnDatabaseOptionChanges(foo, foo.Status.Databases, currentDatabases)
+	alterDBCommands := getAlterDatabaseOwnerCommands(foo.Spec.Databases, foo.Status.Databases, currentDatabases)
+	createSchemaCommands, dropSchemaCommands := getSchemaCommands(foo.Spec.Databases, foo.Status.Databases,
+		currentDatabases)
+	dropSchemaCommands, keptSchemas := c.guardSchemaDeletion(foo, foo.Status.Databases, currentDatabases,
+		dropSchemaCommands)
+	revokePublicCmds := getRevokePublicAccessCommands(foo.Spec.Databases, foo.Status.Databases, currentDatabases)
+	createExtensionCmds, dropExtensionCmds := getExtensionCommands(foo.Spec.Extensions, foo.Status.Extensions,
+		liveExtensions, desiredNames)
+	dropExtensionCmds, keptExtensions := c.guardExtensionDeletion(foo, foo.Status.Extensions, liveExtensions,
+		dropExtensionCmds)
+	updateExtensionCmds := getUpdateExtensionCommands(foo.Spec.Extensions, liveExtensions)
+	createUserCmds, dropUserCmds, alterUserCmds := getUserCommands(users, currentUsers, desiredNames, endpoint.User)
+	createUserCmds = setPasswordEncryption(createUserCmds, getPasswordEncryption(foo))
+	alterUserCmds = setPasswordEncryption(alterUserCmds, getPasswordEncryption(foo))
+	grantCmds, revokeCmds := getGrantCommands(users, currentUsers, currentDatabases)
+	commentCmds := getCommentCommands(foo.Spec.Databases, users, liveDatabaseComments, liveRoleComments)
+	// Users are created first as they may own the new databases
+	appendList(&commandsToRun, createUserCmds)
+	appendList(&commandsToRun, createDBCommands)
+	appendList(&commandsToRun, alterDBCommands)
+	appendList(&commandsToRun, createSchemaCommands)
+	appendList(&commandsToRun, revokePublicCmds)
+	appendList(&commandsToRun, createExtensionCmds)
+	appendList(&commandsToRun, updateExtensionCmds)
+	appendList(&commandsToRun, revokeCmds)
+	appendList(&commandsToRun, grantCmds)
+	appendList(&commandsToRun, dropExtensionCmds)
+	appendList(&commandsToRun, dropSchemaCommands)
+	appendList(&commandsToRun, dropDBCommands)
+	appendList(&commandsToRun, dropUserCmds)
+	appendList(&commandsToRun, alterUserCmds)
+	appendList(&commandsToRun, commentCmds)
+	fmt.Printf("commandsToRun on %s:%s:%v\n", endpoint.Host, endpoint.Port, commandsToRun)
+
+	if len(commandsToRun) > 0 {
+		var dummyList []string
+		err = c.setupDatabase(foo, endpoint, commandsToRun, dummyList)
+		if err != nil {
+			c.recordDatabaseError(foo, err)
+			return err
+		}
+		err = c.appendActionHistory(foo, commandsToRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	info := getConnectionInfo(foo, users, endpoint)
+	secretName, err := createOrUpdateConnectionSecret(foo, c, info)
+	if err != nil {
+		return err
+	}
+	err = c.injectConnectionSecret(foo, secretName, info)
+	if err != nil {
+		return err
+	}
+	err = c.checkHealthDatabase(foo, endpoint)
+	if err != nil {
+		return err
+	}
+	verifyCmd := "psql -h " + endpoint.Host + " -p " + endpoint.Port + " -U " + info.Username + " -d " + info.Database
+
+	statusUsers := getStatusUsers(users)
+	databases := getAppliedDatabases(foo.Spec.Databases, foo.Status.Databases, currentDatabases)
+	databases = addKeptSchemas(databases, keptSchemas)
+	original := foo
+	foo = foo.DeepCopy()
+	foo.Status.OrphanedDatabases = orphanedDatabases
+	foo.Status.CredentialSecrets = credentialSecrets
+	foo.Status.Extensions = setInstalledVersions(getAppliedExtensions(foo.Spec.Extensions, keptExtensions),
+		liveExtensions)
+	foo.Status.ServerVersion = c.queryServerVersion(foo, endpoint)
+	return c.updateFooStatus(original, foo, nil, &statusUsers, &databases,
+		verifyCmd, endpoint.Host, endpoint.Port, info.ConnectionString(), secretName, phase)
+}
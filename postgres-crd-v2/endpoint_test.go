@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/connection"
+)
+
+func TestConnectThroughServiceDNSInCluster(t *testing.T) {
+	foo := newTestPostgres(nil)
+	endpoint := getDefaultEndpoint(foo, "default", MINIKUBE_IP, "30123")
+
+	c := &Controller{}
+	if connect := c.getConnectEndpoint(endpoint); connect.Host != MINIKUBE_IP || connect.Port != "30123" {
+		t.Errorf("expected the NodePort endpoint out-of-cluster, got %s:%s", connect.Host, connect.Port)
+	}
+
+	c.serviceDNS = true
+	connect := c.getConnectEndpoint(endpoint)
+	if connect.Host != "client25.default.svc" || connect.Port != "5432" {
+		t.Errorf("expected client25.default.svc:5432 in-cluster, got %s:%s", connect.Host, connect.Port)
+	}
+	if endpoint.Host != MINIKUBE_IP {
+		t.Errorf("expected the endpoint used for the connection Secret to be unchanged, got %s", endpoint.Host)
+	}
+
+	// External instances are always reached through their configured host
+	external := dbEndpoint{Host: "db.example.com", Port: "5432"}
+	if connect := c.getConnectEndpoint(external); connect.Host != "db.example.com" {
+		t.Errorf("expected the external host to be kept, got %s", connect.Host)
+	}
+}
+
+func TestGetPsqlInfoIPv6(t *testing.T) {
+	for _, serviceIP := range []string{"fd00::1", "[fd00::1]"} {
+		foo := newTestPostgres(nil)
+		endpoint := getDefaultEndpoint(foo, "default", serviceIP, "30123")
+		endpoint.Password = "pass 'word'"
+		psqlInfo := getPsqlInfo(endpoint, "moodle")
+		for _, expected := range []string{"host=fd00::1 ", "port=30123", `password='pass \'word\''`} {
+			if !strings.Contains(psqlInfo, expected) {
+				t.Errorf("expected %s\ngot %s", expected, psqlInfo)
+			}
+		}
+
+		info := connection.Info{Host: serviceIP, Port: "30123", Database: "moodle", Username: "devdatta", SSLMode: "disable"}
+		if url := info.URL().String(); !strings.Contains(url, "@[fd00::1]:30123/moodle") {
+			t.Errorf("expected the IPv6 host in brackets, got %s", url)
+		}
+		if connString := info.ConnectionString(); !strings.HasPrefix(connString, "host=fd00::1 port=30123") {
+			t.Errorf("expected the IPv6 host without brackets, got %s", connString)
+		}
+	}
+}
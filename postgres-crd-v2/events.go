@@ -0,0 +1,117 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// The following are used as part of the Event 'reason' for each
+	// database, user, extension and grant command that was run.
+	DatabaseCreated   = "DatabaseCreated"
+	DatabaseDropped   = "DatabaseDropped"
+	DatabaseAltered   = "DatabaseAltered"
+	UserCreated       = "UserCreated"
+	UserDropped       = "UserDropped"
+	UserAltered       = "UserAltered"
+	ExtensionEnabled  = "ExtensionEnabled"
+	ExtensionDisabled = "ExtensionDisabled"
+	GrantApplied      = "GrantApplied"
+	GrantRevoked      = "GrantRevoked"
+	TablespaceCreated = "TablespaceCreated"
+	TablespaceDropped = "TablespaceDropped"
+	SchemaCreated     = "SchemaCreated"
+	SchemaDropped     = "SchemaDropped"
+	SchemaAltered     = "SchemaAltered"
+	ParameterSet      = "ParameterSet"
+	ParameterReset    = "ParameterReset"
+)
+
+// commandEvent is the Event reason and message prefix recorded once a
+// command starting with prefix has run.
+type commandEvent struct {
+	prefix  string
+	reason  string
+	message string
+}
+
+// commandEvents maps the leading keywords of a command to its event.
+var commandEvents = []commandEvent{
+	{"create database ", DatabaseCreated, "Created database "},
+	{"drop database ", DatabaseDropped, "Dropped database "},
+	{"alter database ", DatabaseAltered, "Altered database "},
+	{"create user ", UserCreated, "Created user "},
+	{"create role ", UserCreated, "Created user "},
+	{"drop user ", UserDropped, "Dropped user "},
+	{"drop role ", UserDropped, "Dropped user "},
+	{"alter user ", UserAltered, "Altered user "},
+	{"alter role ", UserAltered, "Altered user "},
+	{"create extension ", ExtensionEnabled, "Enabled extension "},
+	{"drop extension ", ExtensionDisabled, "Disabled extension "},
+	{"create tablespace ", TablespaceCreated, "Created tablespace "},
+	{"drop tablespace ", TablespaceDropped, "Dropped tablespace "},
+	{"create schema ", SchemaCreated, "Created schema "},
+	{"drop schema ", SchemaDropped, "Dropped schema "},
+	{"alter schema ", SchemaAltered, "Altered schema "},
+	{"alter system set ", ParameterSet, "Set parameter "},
+	{"alter system reset ", ParameterReset, "Reset parameter "},
+	{"grant ", GrantApplied, "Applied "},
+	{"revoke ", GrantRevoked, "Applied "},
+}
+
+var passwordPattern = regexp.MustCompile(`(?i)password\s+E?'(?:[^']|'')*'`)
+
+// redactCommand hides the passwords of a command before it is recorded.
+func redactCommand(command string) string {
+	return passwordPattern.ReplaceAllString(command, "password '***'")
+}
+
+// getCommandObject returns the event of a command and the name of the
+// object it acts on. Grants are named by the whole command. Other commands
+// (e.g. set, reassign owned) are not recognized.
+func getCommandObject(command string) (commandEvent, string, bool) {
+	lower := strings.ToLower(strings.TrimSpace(command))
+	for _, event := range commandEvents {
+		if !strings.HasPrefix(lower, event.prefix) {
+			continue
+		}
+		if event.reason == GrantApplied || event.reason == GrantRevoked {
+			return event, redactCommand(strings.TrimSpace(command)), true
+		}
+		rest := strings.TrimSpace(command)[len(event.prefix):]
+		for _, clause := range []string{"if not exists ", "if exists "} {
+			if strings.HasPrefix(strings.ToLower(rest), clause) {
+				rest = rest[len(clause):]
+			}
+		}
+		fields := strings.Fields(strings.TrimSuffix(rest, ";"))
+		if len(fields) == 0 {
+			return commandEvent{}, "", false
+		}
+		return event, strings.Trim(fields[0], "\";"), true
+	}
+	return commandEvent{}, "", false
+}
+
+// getCommandEvent returns the Event reason and message for a command.
+func getCommandEvent(command string) (string, string, bool) {
+	event, name, ok := getCommandObject(command)
+	if !ok {
+		return "", "", false
+	}
+	return event.reason, event.message + name, true
+}
+
+// recordCommandEvent records a Normal event for a command that was run.
+func (c *Controller) recordCommandEvent(foo *postgresv1.Postgres, command string) {
+	if foo == nil || c.recorder == nil {
+		return
+	}
+	if reason, message, ok := getCommandEvent(command); ok {
+		c.recorder.Event(foo, corev1.EventTypeNormal, reason, message)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetCommandEvent(t *testing.T) {
+	testCases := []struct {
+		command string
+		reason  string
+		message string
+	}{
+		{"create database moodle owner devdatta;", DatabaseCreated, "Created database moodle"},
+		{"drop database wordpress;", DatabaseDropped, "Dropped database wordpress"},
+		{"alter database moodle owner to pallavi;", DatabaseAltered, "Altered database moodle"},
+		{"create user devdatta with password 'pass123';", UserCreated, "Created user devdatta"},
+		{"drop user pallavi;", UserDropped, "Dropped user pallavi"},
+		{"alter user devdatta with password 'newpass';", UserAltered, "Altered user devdatta"},
+		{"CREATE EXTENSION IF NOT EXISTS postgis;", ExtensionEnabled, "Enabled extension postgis"},
+		{"grant all on database moodle to devdatta;", GrantApplied, "Applied grant all on database moodle to devdatta;"},
+	}
+	for _, tc := range testCases {
+		reason, message, ok := getCommandEvent(tc.command)
+		if !ok || reason != tc.reason || message != tc.message {
+			t.Errorf("%s: expected %s %q, got %s %q", tc.command, tc.reason, tc.message, reason, message)
+		}
+	}
+	for _, command := range []string{"set password_encryption = 'md5';", "reassign owned by \"pallavi\" to \"postgres\";",
+		"drop owned by \"pallavi\";", "create table t (id int);"} {
+		if _, _, ok := getCommandEvent(command); ok {
+			t.Errorf("%s: expected no event", command)
+		}
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	command := "alter user devdatta with PASSWORD 'it''s secret' createdb;"
+	expected := "alter user devdatta with password '***' createdb;"
+	if got := redactCommand(command); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	// Passwords with backslashes are quoted as escape strings
+	command = "create user \"devdatta\" with password  E'back\\\\slash';"
+	expected = "create user \"devdatta\" with password '***';"
+	if got := redactCommand(command); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
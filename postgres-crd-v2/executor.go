@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// DBExecutor runs commands against a Postgres instance. Connect may be
+// called again to switch to another database.
+type DBExecutor interface {
+	Connect(endpoint dbEndpoint, dbname string) error
+	Exec(command string) error
+	QueryDatabases() ([]string, error)
+	QueryRoles() ([]string, error)
+	QueryConnectionLimits() (map[string]int32, error)
+	QueryExtensions() (map[string]string, error)
+	QueryDatabaseComments() (map[string]string, error)
+	QueryRoleComments() (map[string]string, error)
+	QueryServerVersion() (string, error)
+	QueryInRecovery() (bool, error)
+	Close() error
+}
+
+// pqExecutor is the DBExecutor backed by lib/pq.
+type pqExecutor struct {
+	// open returns a verified connection, openPostgres unless replaced in tests
+	open func(endpoint dbEndpoint, dbname string) (*sql.DB, error)
+	db   *sql.DB
+	// retry is how long Connect waits for an instance that is starting up
+	retry ConnectRetry
+	sleep func(time.Duration)
+}
+
+func newPQExecutor(retry ConnectRetry) DBExecutor {
+	return &pqExecutor{open: openPostgres, retry: retry, sleep: time.Sleep}
+}
+
+// openPostgres opens a connection and verifies that Postgres accepts it.
+func openPostgres(endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", getPsqlInfo(endpoint, dbname))
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (e *pqExecutor) Connect(endpoint dbEndpoint, dbname string) error {
+	if err := e.Close(); err != nil {
+		return err
+	}
+	db, err := openWithRetry(e.open, e.retry, e.sleep, endpoint, dbname)
+	if err != nil {
+		return err
+	}
+	e.db = db
+	return nil
+}
+
+func (e *pqExecutor) Exec(command string) error {
+	if e.db == nil {
+		return fmt.Errorf("not connected")
+	}
+	_, err := e.db.Exec(command)
+	return err
+}
+
+func (e *pqExecutor) QueryDatabases() ([]string, error) {
+	return e.queryNames("SELECT datname FROM pg_database")
+}
+
+func (e *pqExecutor) QueryRoles() ([]string, error) {
+	return e.queryNames("SELECT rolname FROM pg_roles")
+}
+
+// QueryConnectionLimits returns the connection limit of each database, -1
+// for no limit.
+func (e *pqExecutor) QueryConnectionLimits() (map[string]int32, error) {
+	if e.db == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	rows, err := e.db.Query("SELECT datname, datconnlimit FROM pg_database")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := map[string]int32{}
+	for rows.Next() {
+		var name string
+		var limit int32
+		if err := rows.Scan(&name, &limit); err != nil {
+			return nil, err
+		}
+		limits[name] = limit
+	}
+	return limits, rows.Err()
+}
+
+// QueryExtensions returns the extensions installed in the connected
+// database and their versions.
+func (e *pqExecutor) QueryExtensions() (map[string]string, error) {
+	if e.db == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	rows, err := e.db.Query("SELECT extname, extversion FROM pg_extension")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	extensions := map[string]string{}
+	for rows.Next() {
+		var name, version string
+		if err := rows.Scan(&name, &version); err != nil {
+			return nil, err
+		}
+		extensions[name] = version
+	}
+	return extensions, rows.Err()
+}
+
+// QueryDatabaseComments returns the comment of each database, empty for
+// none.
+func (e *pqExecutor) QueryDatabaseComments() (map[string]string, error) {
+	return e.queryComments("SELECT datname, coalesce(shobj_description(oid, 'pg_database'), '') FROM pg_database")
+}
+
+// QueryRoleComments returns the comment of each role, empty for none.
+func (e *pqExecutor) QueryRoleComments() (map[string]string, error) {
+	return e.queryComments("SELECT rolname, coalesce(shobj_description(oid, 'pg_authid'), '') FROM pg_roles")
+}
+
+func (e *pqExecutor) queryComments(query string) (map[string]string, error) {
+	if e.db == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	rows, err := e.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := map[string]string{}
+	for rows.Next() {
+		var name, comment string
+		if err := rows.Scan(&name, &comment); err != nil {
+			return nil, err
+		}
+		comments[name] = comment
+	}
+	return comments, rows.Err()
+}
+
+func (e *pqExecutor) QueryServerVersion() (string, error) {
+	if e.db == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	var version string
+	err := e.db.QueryRow("SHOW server_version").Scan(&version)
+	return version, err
+}
+
+// QueryInRecovery returns true when the instance is a replica.
+func (e *pqExecutor) QueryInRecovery() (bool, error) {
+	if e.db == nil {
+		return false, fmt.Errorf("not connected")
+	}
+	var inRecovery bool
+	err := e.db.QueryRow("SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	return inRecovery, err
+}
+
+func (e *pqExecutor) queryNames(query string) ([]string, error) {
+	if e.db == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	rows, err := e.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (e *pqExecutor) Close() error {
+	if e.db == nil {
+		return nil
+	}
+	err := e.db.Close()
+	e.db = nil
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeExecutor records the commands run through it. Commands listed in
+// errors fail with the given error.
+type fakeExecutor struct {
+	connects  []string
+	commands  []string
+	databases []string
+	roles     []string
+	limits    map[string]int32
+	// extensions are the installed extensions by database
+	extensions map[string]map[string]string
+	version    string
+	// databaseComments and roleComments are the comments by name
+	databaseComments map[string]string
+	roleComments     map[string]string
+	// hosts are the hosts connected to, inRecovery the replicas among them
+	hosts      []string
+	inRecovery map[string]bool
+	closed     bool
+	errors     map[string]error
+}
+
+func (e *fakeExecutor) Connect(endpoint dbEndpoint, dbname string) error {
+	e.connects = append(e.connects, dbname)
+	e.hosts = append(e.hosts, endpoint.Host)
+	return nil
+}
+
+func (e *fakeExecutor) Exec(command string) error {
+	e.commands = append(e.commands, command)
+	return e.errors[command]
+}
+
+func (e *fakeExecutor) QueryDatabases() ([]string, error) { return e.databases, nil }
+
+func (e *fakeExecutor) QueryRoles() ([]string, error) { return e.roles, nil }
+
+func (e *fakeExecutor) QueryConnectionLimits() (map[string]int32, error) { return e.limits, nil }
+
+func (e *fakeExecutor) QueryExtensions() (map[string]string, error) {
+	return e.extensions[e.connects[len(e.connects)-1]], nil
+}
+
+func (e *fakeExecutor) QueryDatabaseComments() (map[string]string, error) {
+	return e.databaseComments, nil
+}
+
+func (e *fakeExecutor) QueryRoleComments() (map[string]string, error) { return e.roleComments, nil }
+
+func (e *fakeExecutor) QueryServerVersion() (string, error) { return e.version, nil }
+
+func (e *fakeExecutor) QueryInRecovery() (bool, error) {
+	return e.inRecovery[e.hosts[len(e.hosts)-1]], nil
+}
+
+func (e *fakeExecutor) Close() error {
+	e.closed = true
+	return nil
+}
+
+func TestSetupDatabaseReconnectsOnConnectCommand(t *testing.T) {
+	executor := &fakeExecutor{}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+
+	commands := []string{
+		"create table a (id int);",
+		getConnectCommand("wordpress"),
+		"drop owned by \"tableowner\";",
+	}
+	if err := c.setupDatabase(nil, dbEndpoint{}, commands, []string{"moodle"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"moodle", "wordpress"}; !reflect.DeepEqual(executor.connects, expected) {
+		t.Errorf("expected connects %v\ngot %v", expected, executor.connects)
+	}
+	if expected := []string{commands[0], commands[2]}; !reflect.DeepEqual(executor.commands, expected) {
+		t.Errorf("expected commands %v\ngot %v", expected, executor.commands)
+	}
+	if !executor.closed {
+		t.Errorf("expected the executor to be closed")
+	}
+}
+
+func TestPQExecutorQueriesNames(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unexpected error creating sqlmock: %v", err)
+	}
+	executor := &pqExecutor{open: func(endpoint dbEndpoint, dbname string) (*sql.DB, error) { return db, nil }}
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectClose()
+
+	if err := executor.Connect(dbEndpoint{}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	databases, err := executor.QueryDatabases()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"postgres", "moodle"}; !reflect.DeepEqual(databases, expected) {
+		t.Errorf("expected %v\ngot %v", expected, databases)
+	}
+	if err := executor.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// WarnExtensionOrphaned is used as part of the Event 'reason' when an
+	// extension removed from the spec is kept.
+	WarnExtensionOrphaned = "ExtensionOrphaned"
+)
+
+// extensionVersionPattern matches the versions of extension packages, e.g.
+// 1.5 or 2.4.4, so that they can be quoted as literals
+var extensionVersionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func validateExtensions(foo *postgresv1.Postgres) []string {
+	var problems []string
+	databases := getDatabaseNames(foo.Spec.Databases)
+	for _, extension := range foo.Spec.Extensions {
+		if !identifierPattern.MatchString(extension.Name) {
+			problems = append(problems, fmt.Sprintf("invalid extension name %q", extension.Name))
+		}
+		if !contains(databases, extension.Database) {
+			problems = append(problems, fmt.Sprintf("extension %s: database %q is not declared in spec.databases",
+				extension.Name, extension.Database))
+		}
+		if extension.Version != "" && !extensionVersionPattern.MatchString(extension.Version) {
+			problems = append(problems, fmt.Sprintf("extension %s: invalid version %q", extension.Name,
+				extension.Version))
+		}
+	}
+	return problems
+}
+
+func findExtension(extensions []postgresv1.ExtensionSpec, database string, name string) (postgresv1.ExtensionSpec, bool) {
+	for _, extension := range extensions {
+		if extension.Database == database && extension.Name == name {
+			return extension, true
+		}
+	}
+	return postgresv1.ExtensionSpec{}, false
+}
+
+// getExtensionDatabases returns the existing databases with extensions in
+// the spec or the status, the ones queried for their installed extensions.
+func getExtensionDatabases(foo *postgresv1.Postgres, liveDatabases []string) []string {
+	var databases []string
+	for _, extensions := range [][]postgresv1.ExtensionSpec{foo.Spec.Extensions, foo.Status.Extensions} {
+		for _, extension := range extensions {
+			if contains(liveDatabases, extension.Database) && !contains(databases, extension.Database) {
+				databases = append(databases, extension.Database)
+			}
+		}
+	}
+	return databases
+}
+
+// queryExtensions connects to each database and returns its installed
+// extensions and their versions, so that extensions dropped or created
+// out-of-band are diffed correctly.
+func (c *Controller) queryExtensions(endpoint dbEndpoint, databases []string) (map[string]map[string]string, error) {
+	installed := map[string]map[string]string{}
+	if len(databases) == 0 {
+		return installed, nil
+	}
+	executor := c.newDBExecutor()
+	defer executor.Close()
+	for _, database := range databases {
+		err := executor.Connect(c.getConnectEndpoint(endpoint), database)
+		if err != nil {
+			return nil, err
+		}
+		extensions, err := executor.QueryExtensions()
+		if err != nil {
+			return nil, err
+		}
+		installed[database] = extensions
+	}
+	fmt.Printf("Live Extensions:%v\n", installed)
+	return installed, nil
+}
+
+// getRecordedExtensions returns the extensions recorded in the status of
+// the existing databases and their versions, used when the instance is not
+// queried.
+func getRecordedExtensions(status []postgresv1.ExtensionSpec, currentDatabases []string) map[string]map[string]string {
+	installed := map[string]map[string]string{}
+	for _, extension := range status {
+		if !contains(currentDatabases, extension.Database) {
+			continue
+		}
+		if installed[extension.Database] == nil {
+			installed[extension.Database] = map[string]string{}
+		}
+		installed[extension.Database][extension.Name] = extension.Version
+	}
+	return installed
+}
+
+func isExtensionInstalled(installed map[string]map[string]string, database string, name string) bool {
+	_, ok := installed[database][name]
+	return ok
+}
+
+// getRemovedExtensions returns the installed extensions recorded in the
+// status that are no longer in the spec. Extensions of databases that are
+// dropped go away with their database.
+func getRemovedExtensions(desired []postgresv1.ExtensionSpec, status []postgresv1.ExtensionSpec,
+	installed map[string]map[string]string, desiredDatabases []string) []postgresv1.ExtensionSpec {
+	var removed []postgresv1.ExtensionSpec
+	for _, extension := range status {
+		if _, ok := findExtension(desired, extension.Database, extension.Name); ok {
+			continue
+		}
+		if contains(desiredDatabases, extension.Database) &&
+			isExtensionInstalled(installed, extension.Database, extension.Name) {
+			removed = append(removed, extension)
+		}
+	}
+	return removed
+}
+
+// getExtensionCommands returns the commands creating the extensions of the
+// spec that are not installed, and the commands dropping the extensions
+// removed from the spec. Extensions are created in their database, so the
+// commands of each database are preceded by a connect command.
+func getExtensionCommands(desired []postgresv1.ExtensionSpec, status []postgresv1.ExtensionSpec,
+	installed map[string]map[string]string, desiredDatabases []string) ([]string, []string) {
+	var createCommands []string
+	var dropCommands []string
+	connected := ""
+	for _, extension := range desired {
+		if isExtensionInstalled(installed, extension.Database, extension.Name) {
+			continue
+		}
+		if extension.Database != connected {
+			createCommands = append(createCommands, getConnectCommand(extension.Database))
+			connected = extension.Database
+		}
+		command := fmt.Sprintf("create extension if not exists \"%s\"", extension.Name)
+		if extension.Version != "" {
+			command = command + fmt.Sprintf(" version '%s'", extension.Version)
+		}
+		createCommands = append(createCommands, command+";")
+	}
+	connected = ""
+	for _, extension := range getRemovedExtensions(desired, status, installed, desiredDatabases) {
+		if extension.Database != connected {
+			dropCommands = append(dropCommands, getConnectCommand(extension.Database))
+			connected = extension.Database
+		}
+		dropCommands = append(dropCommands, fmt.Sprintf("drop extension if exists \"%s\";", extension.Name))
+	}
+	return createCommands, dropCommands
+}
+
+// getUpdateExtensionCommands returns the commands updating the installed
+// extensions whose version differs from the one pinned in the spec. An
+// unknown installed version, e.g. one recorded before the version was
+// pinned, is updated too as updating to the installed version does nothing.
+func getUpdateExtensionCommands(desired []postgresv1.ExtensionSpec, installed map[string]map[string]string) []string {
+	var commands []string
+	connected := ""
+	for _, extension := range desired {
+		if extension.Version == "" || !isExtensionInstalled(installed, extension.Database, extension.Name) ||
+			installed[extension.Database][extension.Name] == extension.Version {
+			continue
+		}
+		if extension.Database != connected {
+			commands = append(commands, getConnectCommand(extension.Database))
+			connected = extension.Database
+		}
+		commands = append(commands, fmt.Sprintf("alter extension \"%s\" update to '%s';", extension.Name,
+			extension.Version))
+	}
+	return commands
+}
+
+// guardExtensionDeletion returns the drop commands to run and the
+// extensions that are kept instead. Dropping an extension fails while
+// objects depend on it, and drops its own objects (e.g. the functions of
+// postgis), so like databases, extensions removed from the spec are only
+// dropped when Spec.AllowDatabaseDeletion is set.
+func (c *Controller) guardExtensionDeletion(foo *postgresv1.Postgres, status []postgresv1.ExtensionSpec,
+	installed map[string]map[string]string, dropCommands []string) ([]string, []postgresv1.ExtensionSpec) {
+	if foo.Spec.AllowDatabaseDeletion {
+		return dropCommands, nil
+	}
+	kept := getRemovedExtensions(foo.Spec.Extensions, status, installed, getDatabaseNames(foo.Spec.Databases))
+	var names []string
+	for _, extension := range kept {
+		names = append(names, extension.Database+"."+extension.Name)
+	}
+	if len(names) > 0 {
+		c.recorder.Event(foo, corev1.EventTypeWarning, WarnExtensionOrphaned,
+			fmt.Sprintf("Extensions %s were removed from the spec but not dropped, set allowDatabaseDeletion to drop them",
+				strings.Join(names, ", ")))
+	}
+	return nil, kept
+}
+
+// getAppliedExtensions returns the extensions recorded in the status: the
+// ones of the spec and the kept ones, which are dropped once deletion is
+// allowed.
+func getAppliedExtensions(desired []postgresv1.ExtensionSpec, kept []postgresv1.ExtensionSpec) []postgresv1.ExtensionSpec {
+	var applied []postgresv1.ExtensionSpec
+	applied = append(applied, desired...)
+	applied = append(applied, kept...)
+	return applied
+}
+
+// setInstalledVersions records the installed version of the extensions
+// that do not pin one, so that the status shows the versions in use.
+func setInstalledVersions(extensions []postgresv1.ExtensionSpec, installed map[string]map[string]string) []postgresv1.ExtensionSpec {
+	var applied []postgresv1.ExtensionSpec
+	for _, extension := range extensions {
+		if extension.Version == "" {
+			extension.Version = installed[extension.Database][extension.Name]
+		}
+		applied = append(applied, extension)
+	}
+	return applied
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestExtensionIsAddedThenRemoved(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.Extensions = []postgresv1.ExtensionSpec{{Name: "postgis", Database: "moodle"}}
+	databases := []string{"moodle"}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder}
+
+	// Added
+	installed := map[string]map[string]string{"moodle": {"plpgsql": "1.0"}}
+	createCommands, dropCommands := getExtensionCommands(foo.Spec.Extensions, nil, installed, databases)
+	if expected := []string{"\\c moodle;", "create extension if not exists \"postgis\";"}; !reflect.DeepEqual(createCommands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, createCommands)
+	}
+	if len(dropCommands) != 0 {
+		t.Errorf("expected no drop commands, got %v", dropCommands)
+	}
+	status := getAppliedExtensions(foo.Spec.Extensions, nil)
+
+	// Removed, kept by default
+	installed["moodle"]["postgis"] = "2.4.4"
+	foo.Spec.Extensions = nil
+	createCommands, dropCommands = getExtensionCommands(foo.Spec.Extensions, status, installed, databases)
+	if len(createCommands) != 0 {
+		t.Errorf("expected no create commands, got %v", createCommands)
+	}
+	dropCommands, kept := c.guardExtensionDeletion(foo, status, installed, dropCommands)
+	if len(dropCommands) != 0 {
+		t.Errorf("expected no drop commands, got %v", dropCommands)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnExtensionOrphaned) || !strings.Contains(event, "moodle.postgis") {
+		t.Errorf("expected an orphaned warning for moodle.postgis, got %q", event)
+	}
+	status = getAppliedExtensions(foo.Spec.Extensions, kept)
+	if !reflect.DeepEqual(status, []postgresv1.ExtensionSpec{{Name: "postgis", Database: "moodle"}}) {
+		t.Errorf("expected the kept extension in the status, got %v", status)
+	}
+
+	// Dropped once deletion is allowed, plpgsql is not managed
+	foo.Spec.AllowDatabaseDeletion = true
+	_, dropCommands = getExtensionCommands(foo.Spec.Extensions, status, installed, databases)
+	dropCommands, kept = c.guardExtensionDeletion(foo, status, installed, dropCommands)
+	if expected := []string{"\\c moodle;", "drop extension if exists \"postgis\";"}; !reflect.DeepEqual(dropCommands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, dropCommands)
+	}
+	if len(kept) != 0 {
+		t.Errorf("expected nothing kept, got %v", kept)
+	}
+}
+
+func TestExtensionDroppedOutOfBandIsCreatedAgain(t *testing.T) {
+	desired := []postgresv1.ExtensionSpec{{Name: "hstore", Database: "moodle"}}
+	installed := map[string]map[string]string{"moodle": {"plpgsql": "1.0"}}
+	createCommands, _ := getExtensionCommands(desired, desired, installed, []string{"moodle"})
+	if expected := []string{"\\c moodle;", "create extension if not exists \"hstore\";"}; !reflect.DeepEqual(createCommands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, createCommands)
+	}
+
+	// The status alone does not see the drop
+	recorded := getRecordedExtensions(desired, []string{"moodle"})
+	if createCommands, _ := getExtensionCommands(desired, desired, recorded, []string{"moodle"}); len(createCommands) != 0 {
+		t.Errorf("expected no commands for the recorded extensions, got %v", createCommands)
+	}
+}
+
+func TestQueryExtensionsConnectsToEachDatabase(t *testing.T) {
+	executor := &fakeExecutor{extensions: map[string]map[string]string{
+		"moodle":    {"plpgsql": "1.0", "hstore": "1.4"},
+		"wordpress": {"plpgsql": "1.0"},
+	}}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+
+	foo := newTestPostgres(nil)
+	foo.Spec.Extensions = []postgresv1.ExtensionSpec{{Name: "hstore", Database: "moodle"}}
+	foo.Status.Extensions = []postgresv1.ExtensionSpec{{Name: "citext", Database: "wordpress"},
+		{Name: "citext", Database: "blog"}}
+	// blog was dropped
+	databases := getExtensionDatabases(foo, []string{"postgres", "moodle", "wordpress"})
+	installed, err := c.queryExtensions(dbEndpoint{}, databases)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(executor.connects, []string{"moodle", "wordpress"}) {
+		t.Errorf("expected connects to moodle and wordpress, got %v", executor.connects)
+	}
+	if !reflect.DeepEqual(installed, executor.extensions) {
+		t.Errorf("expected %v\ngot %v", executor.extensions, installed)
+	}
+	if !executor.closed {
+		t.Errorf("expected the executor to be closed")
+	}
+}
+
+func TestValidateExtensions(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.Extensions = []postgresv1.ExtensionSpec{{Name: "postgis", Database: "moodle"}}
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	foo.Spec.Extensions = []postgresv1.ExtensionSpec{{Name: "postgis; drop", Database: "wordpress"}}
+	if problems := validatePostgresSpec(foo); len(problems) != 2 {
+		t.Errorf("expected 2 problems, got %v", problems)
+	}
+}
+
+func TestExtensionVersionIsPinned(t *testing.T) {
+	desired := []postgresv1.ExtensionSpec{
+		{Name: "hstore", Database: "moodle", Version: "1.4"},
+		{Name: "pg_trgm", Database: "moodle", Version: "1.3"},
+		{Name: "postgis", Database: "moodle"},
+	}
+	databases := []string{"moodle"}
+
+	// Created with its version
+	createCommands, _ := getExtensionCommands(desired[:1], nil, nil, databases)
+	if expected := []string{"\\c moodle;", "create extension if not exists \"hstore\" version '1.4';"}; !reflect.DeepEqual(createCommands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, createCommands)
+	}
+
+	// Only the installed extension of another version is updated
+	installed := map[string]map[string]string{"moodle": {"hstore": "1.3", "pg_trgm": "1.3", "postgis": "2.4.4"}}
+	commands := getUpdateExtensionCommands(desired, installed)
+	if expected := []string{"\\c moodle;", "alter extension \"hstore\" update to '1.4';"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+
+	// The status shows the installed versions
+	status := setInstalledVersions(desired, installed)
+	if status[0].Version != "1.4" || status[2].Version != "2.4.4" {
+		t.Errorf("expected the pinned and installed versions in the status, got %v", status)
+	}
+	// and is diffed when the instance is not queried
+	if commands := getUpdateExtensionCommands(desired, getRecordedExtensions(status, databases)); len(commands) != 0 {
+		t.Errorf("expected no update of the recorded versions, got %v", commands)
+	}
+}
+
+func TestValidateExtensionVersion(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.Extensions = []postgresv1.ExtensionSpec{{Name: "hstore", Database: "moodle", Version: "1.4'; drop table t; --"}}
+	if problems := validateExtensions(foo); len(problems) != 1 {
+		t.Errorf("expected the version to be rejected, got %v", problems)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// DefaultFailoverRetry retries a sync for about half a minute while an
+// external instance fails over to a new primary.
+var DefaultFailoverRetry = ConnectRetry{
+	Attempts:    5,
+	Interval:    2 * time.Second,
+	MaxInterval: 16 * time.Second,
+}
+
+// isReadOnly returns true for the error of a command run against a replica,
+// e.g. after the primary of an external instance failed over.
+func isReadOnly(err error) bool {
+	if cmdErr, ok := err.(*commandError); ok {
+		err = cmdErr.Err
+	}
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "25006"
+}
+
+// validateExternalHosts checks the candidate hosts of an external instance,
+// each a host or a host:port.
+func validateExternalHosts(hosts []string) []string {
+	var problems []string
+	for _, host := range hosts {
+		if _, _, err := splitCandidateHost(host, "5432"); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid externalEndpoint host %q: %v", host, err))
+		}
+	}
+	return problems
+}
+
+// splitCandidateHost splits a host:port, defaulting to port when the host
+// has none. An IPv6 address with a port is given in brackets.
+func splitCandidateHost(host string, port string) (string, string, error) {
+	if host == "" {
+		return "", "", fmt.Errorf("empty host")
+	}
+	if !strings.Contains(host, ":") || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return host, port, nil
+	}
+	candidateHost, candidatePort, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := strconv.ParseUint(candidatePort, 10, 16); err != nil {
+		return "", "", fmt.Errorf("invalid port %q", candidatePort)
+	}
+	return candidateHost, candidatePort, nil
+}
+
+// getCandidateEndpoints returns the endpoint followed by one endpoint per
+// candidate host, skipping duplicates.
+func getCandidateEndpoints(endpoint dbEndpoint) []dbEndpoint {
+	var candidates []dbEndpoint
+	seen := map[string]bool{}
+	add := func(host string, port string) {
+		key := net.JoinHostPort(strings.Trim(host, "[]"), port)
+		if host == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		candidate := endpoint
+		candidate.Host = host
+		candidate.Port = port
+		candidate.Hosts = nil
+		candidates = append(candidates, candidate)
+	}
+	add(endpoint.Host, endpoint.Port)
+	for _, host := range endpoint.Hosts {
+		if candidateHost, candidatePort, err := splitCandidateHost(host, endpoint.Port); err == nil {
+			add(candidateHost, candidatePort)
+		}
+	}
+	return candidates
+}
+
+// findPrimary returns the first candidate of an external instance that is
+// not in recovery, i.e. its writable primary. An endpoint without
+// candidate hosts is returned as is.
+func (c *Controller) findPrimary(endpoint dbEndpoint) (dbEndpoint, error) {
+	if len(endpoint.Hosts) == 0 {
+		return endpoint, nil
+	}
+	candidates := getCandidateEndpoints(endpoint)
+	var problems []string
+	for _, candidate := range candidates {
+		inRecovery, err := c.queryInRecovery(candidate)
+		address := net.JoinHostPort(strings.Trim(candidate.Host, "[]"), candidate.Port)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", address, err))
+			continue
+		}
+		if !inRecovery {
+			fmt.Printf("Found the primary at %s\n", address)
+			return candidate, nil
+		}
+		problems = append(problems, fmt.Sprintf("%s: in recovery", address))
+	}
+	return endpoint, fmt.Errorf("no writable primary found (%s)", strings.Join(problems, "; "))
+}
+
+func (c *Controller) queryInRecovery(endpoint dbEndpoint) (bool, error) {
+	executor := c.newDBExecutor()
+	err := executor.Connect(c.getConnectEndpoint(endpoint), "")
+	if err != nil {
+		return false, err
+	}
+	defer executor.Close()
+	return executor.QueryInRecovery()
+}
+
+// syncWithFailover syncs the databases and users on the endpoint, backing
+// off and looking up the primary again when the commands ran against a
+// replica, e.g. while an external instance fails over.
+func (c *Controller) syncWithFailover(foo *postgresv1.Postgres, endpoint dbEndpoint, phase string) error {
+	interval := c.failoverRetry.Interval
+	for attempt := 1; ; attempt++ {
+		err := c.syncDatabasesAndUsers(foo, endpoint, phase)
+		if err == nil || attempt >= c.failoverRetry.Attempts || !isReadOnly(err) {
+			return err
+		}
+		fmt.Printf("%s:%s is read-only (%v), looking up the primary again in %v...\n",
+			endpoint.Host, endpoint.Port, err, interval)
+		time.Sleep(interval)
+		interval *= 2
+		if c.failoverRetry.MaxInterval > 0 && interval > c.failoverRetry.MaxInterval {
+			interval = c.failoverRetry.MaxInterval
+		}
+	}
+}
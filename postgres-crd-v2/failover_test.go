@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestGetCandidateEndpoints(t *testing.T) {
+	endpoint := dbEndpoint{
+		Host:  "db1",
+		Port:  "5432",
+		User:  "postgres",
+		Hosts: []string{"db2", "db3:5433", "[fd00::1]:5434", "fd00::2", "db1:5432"},
+	}
+	var addresses []string
+	for _, candidate := range getCandidateEndpoints(endpoint) {
+		if candidate.User != "postgres" || candidate.Hosts != nil {
+			t.Errorf("expected the credentials to be kept and no hosts, got %+v", candidate)
+		}
+		addresses = append(addresses, candidate.Host+" "+candidate.Port)
+	}
+	expected := []string{"db1 5432", "db2 5432", "db3 5433", "fd00::1 5434", "fd00::2 5432"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected candidates %v\ngot %v", expected, addresses)
+	}
+}
+
+func TestFindPrimarySkipsReplicas(t *testing.T) {
+	executor := &fakeExecutor{inRecovery: map[string]bool{"db1": true}}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+
+	primary, err := c.findPrimary(dbEndpoint{Host: "db1", Port: "5432", Hosts: []string{"db2", "db3"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if primary.Host != "db2" {
+		t.Errorf("expected the primary db2, got %s", primary.Host)
+	}
+	if expected := []string{"db1", "db2"}; !reflect.DeepEqual(executor.hosts, expected) {
+		t.Errorf("expected hosts %v to be checked, got %v", expected, executor.hosts)
+	}
+}
+
+func TestFindPrimaryWithoutWritableHost(t *testing.T) {
+	executor := &fakeExecutor{inRecovery: map[string]bool{"db1": true, "db2": true}}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+
+	_, err := c.findPrimary(dbEndpoint{Host: "db1", Port: "5432", Hosts: []string{"db2"}})
+	if err == nil || !strings.Contains(err.Error(), "no writable primary") {
+		t.Errorf("expected no writable primary to be found, got %v", err)
+	}
+
+	// An endpoint without candidates is used as is
+	executor.hosts = nil
+	endpoint, err := c.findPrimary(dbEndpoint{Host: "db1", Port: "5432"})
+	if err != nil || endpoint.Host != "db1" || len(executor.hosts) != 0 {
+		t.Errorf("expected db1 without a lookup, got %s, %v", endpoint.Host, err)
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	readOnly := &pq.Error{Code: "25006", Message: "cannot execute CREATE DATABASE in a read-only transaction"}
+	if !isReadOnly(&commandError{Command: "create database moodle;", Err: readOnly}) {
+		t.Errorf("expected the read-only error of a command to be detected")
+	}
+	if isReadOnly(&pq.Error{Code: "42601"}) {
+		t.Errorf("expected a syntax error not to be read-only")
+	}
+}
+
+func TestValidateExternalHosts(t *testing.T) {
+	problems := validateExternalHosts([]string{"db2", "db3:5433", "fd00::1", "", "db4:port"})
+	if len(problems) != 2 {
+		t.Errorf("expected 2 problems, got %v", problems)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Finalizer of the Postgres resources whose instance is created by the
+	// controller, removed once the instance is deleted
+	CLEANUP_FINALIZER = "postgres.kubeplus.cloud-ark.io/cleanup"
+	// Annotation confirming that the volumes of the instance are deleted
+	// along with the Postgres resource
+	CONFIRM_DELETE_ANNOTATION = "postgres.kubeplus.cloud-ark.io/confirm-delete"
+
+	// DataDeleted is used as part of the Event 'reason' when the volumes of
+	// a deleted Postgres resource are deleted.
+	DataDeleted = "DataDeleted"
+	// WarnDataRetained is used as part of the Event 'reason' when the
+	// volumes of a deleted Postgres resource are kept as the deletion was
+	// not confirmed.
+	WarnDataRetained = "RetainedData"
+)
+
+// ownsInstance tells whether the instance of foo is created by the
+// controller and deleted along with foo.
+func ownsInstance(foo *postgresv1.Postgres) bool {
+	return foo.Spec.DeploymentName != "" && foo.Spec.SharedInstance == "" && foo.Spec.ExternalEndpoint == nil
+}
+
+func hasFinalizer(foo *postgresv1.Postgres) bool {
+	return contains(foo.Finalizers, CLEANUP_FINALIZER)
+}
+
+// addFinalizer adds the cleanup finalizer to a copy of the resource about
+// to be updated. Finalizers cannot be added once deletion has started.
+func addFinalizer(foo *postgresv1.Postgres) {
+	if ownsInstance(foo) && foo.DeletionTimestamp == nil && !hasFinalizer(foo) {
+		foo.Finalizers = append(foo.Finalizers, CLEANUP_FINALIZER)
+	}
+}
+
+// getClaimNames returns the persistent volume claims holding the data of
+// the instance.
+func getClaimNames(foo *postgresv1.Postgres) []string {
+	var names []string
+	if foo.Spec.Storage != nil {
+		names = append(names, getPVCName(foo.Spec.DeploymentName))
+	}
+	for _, tablespace := range foo.Spec.Tablespaces {
+		names = append(names, getTablespacePVCName(foo.Spec.DeploymentName, tablespace.Name))
+	}
+	return names
+}
+
+// finalizePostgres deletes the Deployment and Service of a deleted Postgres
+// resource before releasing it. The volumes are only deleted when the
+// deletion is confirmed by the confirm-delete annotation, otherwise they are
+// kept, e.g. to be reused by a Postgres resource of the same deploymentName,
+// and the status is set to RetainedData. The status is patched before the
+// finalizer is removed, as the resource is gone right after.
+func (c *Controller) finalizePostgres(foo *postgresv1.Postgres) error {
+	if !hasFinalizer(foo) {
+		return nil
+	}
+	namespace := c.getInstanceNamespace()
+	deploymentName := foo.Spec.DeploymentName
+	fmt.Printf("Deleting deployment and service %s...\n", deploymentName)
+	err := c.kubeclientset.AppsV1().Deployments(namespace).Delete(deploymentName, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	err = c.kubeclientset.CoreV1().Services(namespace).Delete(deploymentName, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	claimNames := getClaimNames(foo)
+	if foo.Annotations[CONFIRM_DELETE_ANNOTATION] == "true" {
+		for _, claimName := range claimNames {
+			err = c.kubeclientset.CoreV1().PersistentVolumeClaims(namespace).Delete(claimName, &metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		if len(claimNames) > 0 {
+			c.recorder.Event(foo, apiv1.EventTypeNormal, DataDeleted,
+				fmt.Sprintf("Deleted persistent volume claims %v", claimNames))
+		}
+	} else if len(claimNames) > 0 {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnDataRetained,
+			fmt.Sprintf("Kept persistent volume claims %v, annotate with %s: \"true\" to delete them",
+				claimNames, CONFIRM_DELETE_ANNOTATION))
+		fooCopy := foo.DeepCopy()
+		fooCopy.Status.Status = "RetainedData"
+		err = c.patchStatus(foo, fooCopy)
+		if err != nil {
+			return err
+		}
+		// The finalizer is removed with the resourceVersion of the status
+		foo, err = c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(foo.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	fooCopy := foo.DeepCopy()
+	var finalizers []string
+	for _, finalizer := range fooCopy.Finalizers {
+		if finalizer != CLEANUP_FINALIZER {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	fooCopy.Finalizers = finalizers
+	return c.patchStatus(foo, fooCopy)
+}
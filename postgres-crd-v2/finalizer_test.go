@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned/fake"
+)
+
+func newFinalizerTestController(foo *postgresv1.Postgres) (*Controller, *record.FakeRecorder) {
+	deployment := getDeployment(foo)
+	deployment.Namespace = "default"
+	service := getService(foo)
+	service.Namespace = "default"
+	pvc, _ := getPVC(foo)
+	pvc.Namespace = "default"
+	recorder := record.NewFakeRecorder(10)
+	return &Controller{
+		kubeclientset:   kubefake.NewSimpleClientset(deployment, service, pvc),
+		sampleclientset: fake.NewSimpleClientset(foo),
+		recorder:        recorder,
+	}, recorder
+}
+
+func newDeletedPostgres() *postgresv1.Postgres {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	now := metav1.Now()
+	foo.DeletionTimestamp = &now
+	foo.Finalizers = []string{CLEANUP_FINALIZER}
+	return foo
+}
+
+func TestFinalizePostgresRetainsData(t *testing.T) {
+	foo := newDeletedPostgres()
+	c, recorder := newFinalizerTestController(foo)
+
+	if err := c.finalizePostgres(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.kubeclientset.AppsV1().Deployments("default").Get("client25", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the deployment to be deleted, got %v", err)
+	}
+	if _, err := c.kubeclientset.CoreV1().Services("default").Get("client25", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the service to be deleted, got %v", err)
+	}
+	if _, err := c.kubeclientset.CoreV1().PersistentVolumeClaims("default").Get(getPVCName("client25"), metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the claim to be kept, got %v", err)
+	}
+	updated, err := c.sampleclientset.PostgrescontrollerV1().Postgreses("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated.Finalizers) != 0 {
+		t.Errorf("expected the finalizer to be removed, got %v", updated.Finalizers)
+	}
+	if updated.Status.Status != "RetainedData" {
+		t.Errorf("expected status RetainedData, got %s", updated.Status.Status)
+	}
+	// The status is patched on its own, before the finalizer is removed
+	var patches []string
+	for _, action := range c.sampleclientset.(*fake.Clientset).Actions() {
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			patches = append(patches, string(patch.GetPatch()))
+		}
+	}
+	if len(patches) != 2 || !strings.Contains(patches[0], "RetainedData") || strings.Contains(patches[0], "finalizers") ||
+		!strings.Contains(patches[1], "finalizers") {
+		t.Errorf("expected a status patch followed by a finalizer patch, got %v", patches)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnDataRetained) {
+		t.Errorf("expected a %s event, got %s", WarnDataRetained, event)
+	}
+}
+
+func TestFinalizePostgresDeletesConfirmedData(t *testing.T) {
+	foo := newDeletedPostgres()
+	foo.Annotations = map[string]string{CONFIRM_DELETE_ANNOTATION: "true"}
+	c, recorder := newFinalizerTestController(foo)
+
+	if err := c.finalizePostgres(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.kubeclientset.CoreV1().PersistentVolumeClaims("default").Get(getPVCName("client25"), metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the claim to be deleted, got %v", err)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, DataDeleted) {
+		t.Errorf("expected a %s event, got %s", DataDeleted, event)
+	}
+}
+
+func TestAddFinalizer(t *testing.T) {
+	foo := newTestPostgres(nil)
+	addFinalizer(foo)
+	addFinalizer(foo)
+	if len(foo.Finalizers) != 1 || foo.Finalizers[0] != CLEANUP_FINALIZER {
+		t.Errorf("expected the cleanup finalizer once, got %v", foo.Finalizers)
+	}
+
+	shared := newTestPostgres(nil)
+	shared.Spec.SharedInstance = "client26"
+	addFinalizer(shared)
+	if len(shared.Finalizers) != 0 {
+		t.Errorf("expected no finalizer on a shared instance, got %v", shared.Finalizers)
+	}
+}
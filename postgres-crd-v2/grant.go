@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lib/pq"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+var tablePrivileges = []string{"select", "insert", "update", "delete", "truncate", "references", "trigger", "all"}
+
+func getGrantSchema(grant postgresv1.GrantSpec) string {
+	if grant.Schema != "" {
+		return grant.Schema
+	}
+	return "public"
+}
+
+// getGrantKey identifies the grant of a user on the tables of a schema.
+func getGrantKey(grant postgresv1.GrantSpec) string {
+	return grant.Database + "." + getGrantSchema(grant)
+}
+
+func findGrant(grants []postgresv1.GrantSpec, key string) (postgresv1.GrantSpec, bool) {
+	for _, grant := range grants {
+		if getGrantKey(grant) == key {
+			return grant, true
+		}
+	}
+	return postgresv1.GrantSpec{}, false
+}
+
+func sameGrant(desired postgresv1.GrantSpec, current postgresv1.GrantSpec) bool {
+	return reflect.DeepEqual(desired.Privileges, current.Privileges) && desired.Default == current.Default &&
+		desired.ForRole == current.ForRole
+}
+
+// getDefaultPrivilegesTarget renders the role and schema whose tables
+// created later are covered.
+func getDefaultPrivilegesTarget(grant postgresv1.GrantSpec) string {
+	target := "in schema " + pq.QuoteIdentifier(getGrantSchema(grant))
+	if grant.ForRole != "" {
+		target = "for role " + pq.QuoteIdentifier(strings.ToLower(grant.ForRole)) + " " + target
+	}
+	return target
+}
+
+// getGrantStatements renders the grant of privileges on the existing tables
+// and, for default grants, on the tables created later.
+func getGrantStatements(username string, grant postgresv1.GrantSpec) []string {
+	privileges := strings.Join(grant.Privileges, ", ")
+	schema := pq.QuoteIdentifier(getGrantSchema(grant))
+	role := pq.QuoteIdentifier(strings.ToLower(username))
+	statements := []string{fmt.Sprintf("grant %s on all tables in schema %s to %s;", privileges, schema, role)}
+	if grant.Default {
+		statements = append(statements, fmt.Sprintf("alter default privileges %s grant %s on tables to %s;",
+			getDefaultPrivilegesTarget(grant), privileges, role))
+	}
+	return statements
+}
+
+func getRevokeStatements(username string, grant postgresv1.GrantSpec) []string {
+	privileges := strings.Join(grant.Privileges, ", ")
+	schema := pq.QuoteIdentifier(getGrantSchema(grant))
+	role := pq.QuoteIdentifier(strings.ToLower(username))
+	statements := []string{fmt.Sprintf("revoke %s on all tables in schema %s from %s;", privileges, schema, role)}
+	if grant.Default {
+		statements = append(statements, fmt.Sprintf("alter default privileges %s revoke %s on tables from %s;",
+			getDefaultPrivilegesTarget(grant), privileges, role))
+	}
+	return statements
+}
+
+// getCurrentGrants returns the grants recorded in the status of a user.
+// Grants on databases that do not currently exist are gone with them.
+func getCurrentGrants(current []postgresv1.UserSpec, username string, currentDatabases []string) []postgresv1.GrantSpec {
+	var grants []postgresv1.GrantSpec
+	for _, user := range current {
+		if user.User != username {
+			continue
+		}
+		for _, grant := range user.Grants {
+			if contains(currentDatabases, grant.Database) {
+				grants = append(grants, grant)
+			}
+		}
+	}
+	return grants
+}
+
+// getGrantCommands returns the commands granting the privileges of the spec
+// that are not current, and the commands revoking the current privileges
+// that were removed or changed. Grants of dropped users go away with the
+// role. The revoke commands have to run first as changed grants are
+// revoked and granted again.
+func getGrantCommands(desired []postgresv1.UserSpec, current []postgresv1.UserSpec,
+	currentDatabases []string) ([]string, []string) {
+	grants := map[string][]string{}
+	revokes := map[string][]string{}
+	var databases []string
+	add := func(commands map[string][]string, database string, statements []string) {
+		if !contains(databases, database) {
+			databases = append(databases, database)
+		}
+		commands[database] = append(commands[database], statements...)
+	}
+
+	for _, user := range desired {
+		currentGrants := getCurrentGrants(current, user.User, currentDatabases)
+		for _, grant := range user.Grants {
+			currentGrant, ok := findGrant(currentGrants, getGrantKey(grant))
+			if ok && sameGrant(grant, currentGrant) {
+				continue
+			}
+			if ok {
+				add(revokes, grant.Database, getRevokeStatements(user.User, currentGrant))
+			}
+			add(grants, grant.Database, getGrantStatements(user.User, grant))
+		}
+		for _, currentGrant := range currentGrants {
+			if _, ok := findGrant(user.Grants, getGrantKey(currentGrant)); !ok {
+				add(revokes, currentGrant.Database, getRevokeStatements(user.User, currentGrant))
+			}
+		}
+	}
+
+	var grantCommands []string
+	var revokeCommands []string
+	for _, database := range databases {
+		if len(grants[database]) > 0 {
+			grantCommands = append(grantCommands, getConnectCommand(database))
+			appendList(&grantCommands, grants[database])
+		}
+		if len(revokes[database]) > 0 {
+			revokeCommands = append(revokeCommands, getConnectCommand(database))
+			appendList(&revokeCommands, revokes[database])
+		}
+	}
+	return grantCommands, revokeCommands
+}
+
+// validateGrants checks that the grants refer to databases of the spec and
+// name table privileges.
+func validateGrants(foo *postgresv1.Postgres) []string {
+	var problems []string
+	databases := getDatabaseNames(foo.Spec.Databases)
+	for _, user := range foo.Spec.Users {
+		var keys []string
+		for _, grant := range user.Grants {
+			if !contains(databases, grant.Database) {
+				problems = append(problems, fmt.Sprintf("user %s: grant on database %s which is not in spec.databases",
+					user.User, grant.Database))
+			}
+			if len(grant.Privileges) == 0 {
+				problems = append(problems, fmt.Sprintf("user %s: grant on %s has no privileges", user.User, getGrantKey(grant)))
+			}
+			for _, privilege := range grant.Privileges {
+				if !contains(tablePrivileges, strings.ToLower(privilege)) {
+					problems = append(problems, fmt.Sprintf("user %s: invalid privilege %q, must be one of %v",
+						user.User, privilege, tablePrivileges))
+				}
+			}
+			if contains(keys, getGrantKey(grant)) {
+				problems = append(problems, fmt.Sprintf("user %s: more than one grant on %s", user.User, getGrantKey(grant)))
+			}
+			keys = append(keys, getGrantKey(grant))
+		}
+	}
+	return problems
+}
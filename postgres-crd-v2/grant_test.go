@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestGetGrantCommands(t *testing.T) {
+	desired := []postgresv1.UserSpec{
+		{User: "devdatta", Grants: []postgresv1.GrantSpec{
+			{Database: "moodle", Privileges: []string{"select", "insert"}, Default: true},
+			{Database: "moodle", Schema: "reporting", Privileges: []string{"select"}},
+		}},
+		{User: "analyst", Grants: []postgresv1.GrantSpec{
+			{Database: "moodle", Privileges: []string{"select"}, Default: true, ForRole: "devdatta"},
+		}},
+	}
+	current := []postgresv1.UserSpec{
+		{User: "devdatta", Grants: []postgresv1.GrantSpec{
+			{Database: "moodle", Privileges: []string{"select"}, Default: true},
+			{Database: "moodle", Schema: "app", Privileges: []string{"all"}},
+		}},
+		{User: "analyst", Grants: []postgresv1.GrantSpec{
+			{Database: "moodle", Privileges: []string{"select"}},
+		}},
+	}
+	grantCommands, revokeCommands := getGrantCommands(desired, current, []string{"moodle"})
+
+	expectedGrant := []string{
+		"\\c moodle;",
+		"grant select, insert on all tables in schema \"public\" to \"devdatta\";",
+		"alter default privileges in schema \"public\" grant select, insert on tables to \"devdatta\";",
+		"grant select on all tables in schema \"reporting\" to \"devdatta\";",
+		"grant select on all tables in schema \"public\" to \"analyst\";",
+		"alter default privileges for role \"devdatta\" in schema \"public\" grant select on tables to \"analyst\";",
+	}
+	if !reflect.DeepEqual(grantCommands, expectedGrant) {
+		t.Errorf("expected %v\ngot %v", expectedGrant, grantCommands)
+	}
+	expectedRevoke := []string{
+		"\\c moodle;",
+		"revoke select on all tables in schema \"public\" from \"devdatta\";",
+		"alter default privileges in schema \"public\" revoke select on tables from \"devdatta\";",
+		"revoke all on all tables in schema \"app\" from \"devdatta\";",
+		"revoke select on all tables in schema \"public\" from \"analyst\";",
+	}
+	if !reflect.DeepEqual(revokeCommands, expectedRevoke) {
+		t.Errorf("expected %v\ngot %v", expectedRevoke, revokeCommands)
+	}
+}
+
+func TestGrantsOfMissingDatabaseAreAppliedAgain(t *testing.T) {
+	users := []postgresv1.UserSpec{
+		{User: "devdatta", Grants: []postgresv1.GrantSpec{{Database: "moodle", Privileges: []string{"select"}}}},
+	}
+	grantCommands, revokeCommands := getGrantCommands(users, users, nil)
+	expected := []string{"\\c moodle;", "grant select on all tables in schema \"public\" to \"devdatta\";"}
+	if !reflect.DeepEqual(grantCommands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, grantCommands)
+	}
+	if len(revokeCommands) != 0 {
+		t.Errorf("expected no revoke commands, got %v", revokeCommands)
+	}
+}
+
+func TestValidateGrants(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123", Grants: []postgresv1.GrantSpec{
+		{Database: "moodle", Privileges: []string{"SELECT", "insert"}, Default: true},
+	}}}
+	if problems := validateGrants(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	foo.Spec.Users[0].Grants = append(foo.Spec.Users[0].Grants,
+		postgresv1.GrantSpec{Database: "wordpress", Privileges: []string{"select; drop table t"}},
+		postgresv1.GrantSpec{Database: "moodle"})
+	if problems := validateGrants(foo); len(problems) != 4 {
+		t.Errorf("expected 4 problems, got %v", problems)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/version"
+)
+
+// waitForCacheSync waits for the informer caches and records the result for
+// the readiness check.
+func (c *Controller) waitForCacheSync(stopCh <-chan struct{}) bool {
+	if !cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.foosSynced, c.configMapsSynced) {
+		return false
+	}
+	atomic.StoreInt32(&c.cachesSynced, 1)
+	return true
+}
+
+// Ready returns true once the informer caches have synced.
+func (c *Controller) Ready() bool {
+	return atomic.LoadInt32(&c.cachesSynced) == 1
+}
+
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func (c *Controller) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !c.Ready() {
+		http.Error(w, "informer caches not synced", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// serveVersion returns the build information of the controller as JSON.
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(version.Get()); err != nil {
+		glog.Errorf("Error writing version: %s", err.Error())
+	}
+}
+
+// RunHealthServer serves /healthz, /readyz and /version over plain HTTP. It
+// blocks until the server fails.
+func (c *Controller) RunHealthServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.HandleFunc("/readyz", c.serveReadyz)
+	mux.HandleFunc("/version", serveVersion)
+	server := &http.Server{Addr: addr, Handler: mux}
+	glog.Infof("Starting health server on %s", addr)
+	return server.ListenAndServe()
+}
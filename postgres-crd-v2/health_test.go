@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/version"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestReadyzFollowsCacheSync(t *testing.T) {
+	synced := false
+	hasSynced := func() bool { return synced }
+	c := &Controller{
+		deploymentsSynced: hasSynced,
+		foosSynced:        hasSynced,
+		configMapsSynced:  hasSynced,
+	}
+
+	w := httptest.NewRecorder()
+	c.serveReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d before sync, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if c.waitForCacheSync(stopCh) || c.Ready() {
+		t.Errorf("expected not ready when stopped before sync")
+	}
+
+	synced = true
+	if !c.waitForCacheSync(make(chan struct{})) {
+		t.Fatalf("expected caches to sync")
+	}
+	w = httptest.NewRecorder()
+	c.serveReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d after sync, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveVersion(w, httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+	info := version.Info{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("unexpected error decoding %s: %v", w.Body.String(), err)
+	}
+	if info.GitVersion == "" || info.GitCommit == "" || info.GoVersion != runtime.Version() {
+		t.Errorf("expected the build information, got %#v", info)
+	}
+}
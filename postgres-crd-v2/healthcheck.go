@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// Checks that the health check database ($2) accepts connections of the
+// superuser ($1) over the local socket. pg_isready does not, it reports a
+// server rejecting the database as ready. The controller creates the
+// database only once the Pod is ready, so until the database exists only
+// the server is checked.
+const healthCheckScript = `psql -U "$1" -d "$2" -tAc 'SELECT 1' >/dev/null 2>&1 && exit 0
+exists=$(echo "SELECT 1 FROM pg_database WHERE datname = :'db'" | psql -U "$1" -d postgres -tA -v db="$2" 2>/dev/null)
+[ "$exists" = 1 ] && exit 1
+exec pg_isready -q -U "$1"
+`
+
+// addHealthCheck replaces the TCP readiness probe with one checking the
+// health check database.
+func addHealthCheck(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if foo.Spec.HealthCheckDatabase == "" {
+		return
+	}
+	container := &podSpec.Containers[0]
+	container.ReadinessProbe.Handler = apiv1.Handler{
+		Exec: &apiv1.ExecAction{
+			Command: []string{"/bin/sh", "-c", healthCheckScript, "healthcheck",
+				getSuperuserName(foo), foo.Spec.HealthCheckDatabase},
+		},
+	}
+}
+
+// checkHealthDatabase connects to the health check database, if any, so
+// that the resource is not marked ready while it cannot be connected to.
+func (c *Controller) checkHealthDatabase(foo *postgresv1.Postgres, endpoint dbEndpoint) error {
+	database := foo.Spec.HealthCheckDatabase
+	if database == "" {
+		return nil
+	}
+	executor := c.newDBExecutor()
+	defer executor.Close()
+	err := executor.Connect(c.getConnectEndpoint(endpoint), database)
+	if err == nil {
+		err = executor.Exec("SELECT 1")
+	}
+	if err != nil {
+		return fmt.Errorf("health check database %s is not available: %s", database,
+			describeDatabaseError(err))
+	}
+	return nil
+}
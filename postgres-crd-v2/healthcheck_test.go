@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddHealthCheck(t *testing.T) {
+	foo := newTestPostgres(nil)
+	if probe := getDeployment(foo).Spec.Template.Spec.Containers[0].ReadinessProbe; probe.TCPSocket == nil {
+		t.Errorf("expected the TCP probe by default, got %+v", probe.Handler)
+	}
+
+	foo.Spec.HealthCheckDatabase = "moodle"
+	probe := getDeployment(foo).Spec.Template.Spec.Containers[0].ReadinessProbe
+	if probe.TCPSocket != nil || probe.Exec == nil {
+		t.Fatalf("expected an exec probe only, got %+v", probe.Handler)
+	}
+	if expected := []string{"healthcheck", "postgres", "moodle"}; !reflect.DeepEqual(probe.Exec.Command[3:], expected) {
+		t.Errorf("expected the arguments %v, got %v", expected, probe.Exec.Command[3:])
+	}
+}
+
+func TestCheckHealthDatabase(t *testing.T) {
+	executor := &fakeExecutor{}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+	foo := newTestPostgres(nil)
+
+	if err := c.checkHealthDatabase(foo, dbEndpoint{}); err != nil || len(executor.connects) != 0 {
+		t.Errorf("expected no check without a health check database, got %v %v", err, executor.connects)
+	}
+
+	foo.Spec.HealthCheckDatabase = "moodle"
+	if err := c.checkHealthDatabase(foo, dbEndpoint{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(executor.connects, []string{"moodle"}) || !executor.closed {
+		t.Errorf("expected a connection to moodle to be opened and closed, got %v", executor.connects)
+	}
+
+	executor.errors = map[string]error{"SELECT 1": fmt.Errorf("database is being initialized")}
+	err := c.checkHealthDatabase(foo, dbEndpoint{})
+	if err == nil || !strings.Contains(err.Error(), "health check database moodle") {
+		t.Errorf("expected the health check to fail, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	HEARTBEAT_HOLDER_KEY     = "holder"
+	HEARTBEAT_RENEW_TIME_KEY = "renewTime"
+	// A heartbeat not renewed for HEARTBEAT_STALE_INTERVALS intervals is
+	// left by an instance that is gone
+	HEARTBEAT_STALE_INTERVALS = 3
+)
+
+// heartbeat guards against two controller instances running at the same
+// time without leader election, e.g. after the Deployment was scaled to 2.
+// The running instance renews its identity and the time in a ConfigMap;
+// another instance refuses to start while the heartbeat is fresh. Unlike a
+// Lease two instances starting at the same time may both start, the check
+// is only meant to catch the common mistakes.
+type heartbeat struct {
+	kubeclientset kubernetes.Interface
+	namespace     string
+	name          string
+	id            string
+	interval      time.Duration
+	now           func() time.Time
+}
+
+func newHeartbeat(kubeclientset kubernetes.Interface, namespace string, name string, id string,
+	interval time.Duration) *heartbeat {
+	return &heartbeat{
+		kubeclientset: kubeclientset,
+		namespace:     namespace,
+		name:          name,
+		id:            id,
+		interval:      interval,
+		now:           time.Now,
+	}
+}
+
+func (h *heartbeat) getData() map[string]string {
+	return map[string]string{
+		HEARTBEAT_HOLDER_KEY:     h.id,
+		HEARTBEAT_RENEW_TIME_KEY: h.now().UTC().Format(time.RFC3339),
+	}
+}
+
+// isFresh returns true if the heartbeat of the ConfigMap was renewed
+// recently enough for its holder to still be running.
+func (h *heartbeat) isFresh(configMap *corev1.ConfigMap) bool {
+	renewTime, err := time.Parse(time.RFC3339, configMap.Data[HEARTBEAT_RENEW_TIME_KEY])
+	if err != nil {
+		return false
+	}
+	return h.now().Sub(renewTime) < HEARTBEAT_STALE_INTERVALS*h.interval
+}
+
+// acquire records this instance in the heartbeat ConfigMap. It fails when
+// another instance renewed it recently.
+func (h *heartbeat) acquire() error {
+	configMapsClient := h.kubeclientset.CoreV1().ConfigMaps(h.namespace)
+	configMap, err := configMapsClient.Get(h.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMapsClient.Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: h.name},
+			Data:       h.getData(),
+		})
+		if errors.IsAlreadyExists(err) {
+			return fmt.Errorf("another controller instance created heartbeat %s/%s", h.namespace, h.name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	holder := configMap.Data[HEARTBEAT_HOLDER_KEY]
+	if holder != "" && holder != h.id && h.isFresh(configMap) {
+		return fmt.Errorf("another controller instance %s is running, its heartbeat in %s/%s was renewed at %s",
+			holder, h.namespace, h.name, configMap.Data[HEARTBEAT_RENEW_TIME_KEY])
+	}
+	configMapCopy := configMap.DeepCopy()
+	configMapCopy.Data = h.getData()
+	// A conflict means another instance updated it first
+	_, err = configMapsClient.Update(configMapCopy)
+	return err
+}
+
+// renew updates the time of the heartbeat. It fails if another instance
+// took the heartbeat over, after this one failed to renew it in time.
+func (h *heartbeat) renew() error {
+	configMapsClient := h.kubeclientset.CoreV1().ConfigMaps(h.namespace)
+	configMap, err := configMapsClient.Get(h.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if holder := configMap.Data[HEARTBEAT_HOLDER_KEY]; holder != h.id {
+		return &takenOverError{namespace: h.namespace, name: h.name, holder: holder}
+	}
+	configMapCopy := configMap.DeepCopy()
+	configMapCopy.Data = h.getData()
+	_, err = configMapsClient.Update(configMapCopy)
+	return err
+}
+
+// takenOverError is returned by renew when another instance holds the
+// heartbeat.
+type takenOverError struct {
+	namespace string
+	name      string
+	holder    string
+}
+
+func (e *takenOverError) Error() string {
+	return fmt.Sprintf("heartbeat %s/%s was taken over by %s", e.namespace, e.name, e.holder)
+}
+
+func isTakenOver(err error) bool {
+	_, ok := err.(*takenOverError)
+	return ok
+}
+
+// release clears the heartbeat on shutdown so that a replacement, e.g.
+// during a rolling update, can start right away.
+func (h *heartbeat) release() error {
+	configMapsClient := h.kubeclientset.CoreV1().ConfigMaps(h.namespace)
+	configMap, err := configMapsClient.Get(h.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if configMap.Data[HEARTBEAT_HOLDER_KEY] != h.id {
+		return nil
+	}
+	configMapCopy := configMap.DeepCopy()
+	configMapCopy.Data = map[string]string{}
+	_, err = configMapsClient.Update(configMapCopy)
+	return err
+}
+
+// run renews the heartbeat every interval until stopCh is closed. The
+// process exits when the heartbeat was taken over, as another instance is
+// now processing the Postgres resources.
+func (h *heartbeat) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			err := h.renew()
+			if errors.IsNotFound(err) {
+				err = h.acquire()
+			}
+			if err == nil {
+				continue
+			}
+			if !errors.IsConflict(err) && !isTakenOver(err) {
+				glog.Errorf("Error renewing heartbeat %s/%s: %s", h.namespace, h.name, err.Error())
+				continue
+			}
+			glog.Fatalf("Lost heartbeat %s/%s: %s", h.namespace, h.name, err.Error())
+		}
+	}
+}
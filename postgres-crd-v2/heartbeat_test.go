@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHeartbeatRefusesSecondInstance(t *testing.T) {
+	kubeclientset := kubefake.NewSimpleClientset()
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	first := newHeartbeat(kubeclientset, "default", "postgres-controller-heartbeat", "pod-a", 10*time.Second)
+	first.now = func() time.Time { return now }
+	second := newHeartbeat(kubeclientset, "default", "postgres-controller-heartbeat", "pod-b", 10*time.Second)
+	second.now = func() time.Time { return now.Add(20 * time.Second) }
+
+	if err := first.acquire(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := second.acquire(); err == nil {
+		t.Errorf("expected a fresh heartbeat to refuse the second instance")
+	}
+
+	// Stale after 3 intervals the second instance takes over
+	second.now = func() time.Time { return now.Add(31 * time.Second) }
+	if err := second.acquire(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := first.renew(); !isTakenOver(err) {
+		t.Errorf("expected the first instance to notice the take over, got %v", err)
+	}
+}
+
+func TestHeartbeatRelease(t *testing.T) {
+	kubeclientset := kubefake.NewSimpleClientset()
+	first := newHeartbeat(kubeclientset, "default", "postgres-controller-heartbeat", "pod-a", 10*time.Second)
+	second := newHeartbeat(kubeclientset, "default", "postgres-controller-heartbeat", "pod-b", 10*time.Second)
+
+	if err := first.acquire(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := first.renew(); err != nil {
+		t.Errorf("unexpected error renewing: %v", err)
+	}
+	if err := first.release(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configMap, _ := kubeclientset.CoreV1().ConfigMaps("default").Get("postgres-controller-heartbeat", metav1.GetOptions{})
+	if len(configMap.Data) != 0 {
+		t.Errorf("expected the heartbeat to be cleared, got %v", configMap.Data)
+	}
+	// A replacement starts right away
+	if err := second.acquire(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
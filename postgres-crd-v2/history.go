@@ -0,0 +1,43 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getActionHistoryEntries returns the commands to record in the action
+// history. Connect commands are left out as later commands may connect
+// elsewhere, and passwords are redacted so that they can not be read from
+// the resource.
+func getActionHistoryEntries(commands []string) []string {
+	var entries []string
+	for _, command := range commands {
+		if !isConnectCommand(command) {
+			entries = append(entries, redactCommand(command))
+		}
+	}
+	return entries
+}
+
+// appendActionHistory records the commands that ran in the action history
+// of the latest version of the resource. The resource is read again and the
+// status patch retried on a conflict, so that the history of a concurrent
+// update is kept rather than overwritten by a copy read before it.
+func (c *Controller) appendActionHistory(foo *postgresv1.Postgres, commands []string) error {
+	entries := getActionHistoryEntries(commands)
+	if len(entries) == 0 {
+		return nil
+	}
+	foosClient := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := foosClient.Get(foo.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		latestCopy := latest.DeepCopy()
+		latestCopy.Status.ActionHistory = append(latestCopy.Status.ActionHistory, entries...)
+		return c.patchStatusIfUnchanged(latest, latestCopy)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned/fake"
+)
+
+func TestAppendActionHistoryRetriesConflicts(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Status.ActionHistory = []string{"create database moodle;"}
+	client := fake.NewSimpleClientset(foo)
+	c := &Controller{sampleclientset: client}
+
+	// A concurrent update lands between the read and the first patch
+	conflicts := 0
+	client.PrependReactor("patch", "postgreses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		concurrent := foo.DeepCopy()
+		concurrent.Status.ActionHistory = append(concurrent.Status.ActionHistory, "create user analyst;")
+		client.Tracker().Update(postgresv1.SchemeGroupVersion.WithResource("postgreses"), concurrent, "default")
+		return true, nil, errors.NewConflict(schema.GroupResource{Resource: "postgreses"}, foo.Name, nil)
+	})
+
+	err := c.appendActionHistory(foo, []string{"\\c moodle;", "create table t (id int);"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, _ := client.PostgrescontrollerV1().Postgreses("default").Get("client25", metav1.GetOptions{})
+	expected := []string{"create database moodle;", "create user analyst;", "create table t (id int);"}
+	if !reflect.DeepEqual(updated.Status.ActionHistory, expected) {
+		t.Errorf("expected action history %#v\ngot %#v", expected, updated.Status.ActionHistory)
+	}
+}
+
+func TestAppendActionHistorySkipsConnectCommands(t *testing.T) {
+	foo := newTestPostgres(nil)
+	client := fake.NewSimpleClientset(foo)
+	c := &Controller{sampleclientset: client}
+
+	if err := c.appendActionHistory(foo, []string{"\\c moodle;"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected no update without commands to record, got %v", client.Actions())
+	}
+}
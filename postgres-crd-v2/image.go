@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// ImageUpdated is used as part of the Event 'reason' when the Deployment
+	// is rolled to a new image.
+	ImageUpdated = "ImageUpdated"
+	// WarnImageChangeBlocked is used as part of the Event 'reason' when the
+	// image cannot be changed as the data would be lost.
+	WarnImageChangeBlocked = "ImageChangeBlocked"
+)
+
+// getPostgresContainer returns the Postgres container of the Deployment,
+// which is named after the Deployment.
+func getPostgresContainer(deployment *appsv1.Deployment) *apiv1.Container {
+	containers := deployment.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == deployment.Name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// syncImage rolls the Deployment to Spec.Image. Without persistent storage
+// the data directory lives in the Pod and would be lost, so the change is
+// refused with a warning. Major version upgrades still require the data
+// directory to be upgraded, e.g. by a dump and restore.
+func (c *Controller) syncImage(foo *postgresv1.Postgres) error {
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
+	deployment, err := deploymentsClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	container := getPostgresContainer(deployment)
+	if container == nil || container.Image == foo.Spec.Image {
+		return nil
+	}
+	if foo.Spec.Storage == nil {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnImageChangeBlocked,
+			fmt.Sprintf("Image of %s is kept at %s as the data is not on persistent storage",
+				foo.Spec.DeploymentName, container.Image))
+		return nil
+	}
+
+	fmt.Printf("Image of %s changed from %s to %s\n", foo.Spec.DeploymentName, container.Image, foo.Spec.Image)
+	deploymentCopy := deployment.DeepCopy()
+	getPostgresContainer(deploymentCopy).Image = foo.Spec.Image
+	// Two Pods must never run on the same data directory
+	deploymentCopy.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	_, err = deploymentsClient.Update(deploymentCopy)
+	if err != nil {
+		return err
+	}
+	c.recorder.Event(foo, apiv1.EventTypeNormal, ImageUpdated,
+		fmt.Sprintf("Updated image of %s from %s to %s", foo.Spec.DeploymentName, container.Image, foo.Spec.Image))
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newImageTestController(foo *postgresv1.Postgres) (*Controller, *record.FakeRecorder) {
+	deployment := getDeployment(foo)
+	deployment.Namespace = "default"
+	recorder := record.NewFakeRecorder(10)
+	return &Controller{kubeclientset: fake.NewSimpleClientset(deployment), recorder: recorder}, recorder
+}
+
+func getTestDeployment(t *testing.T, c *Controller) *appsv1.Deployment {
+	deployment, err := c.kubeclientset.AppsV1().Deployments("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return deployment
+}
+
+func TestSyncImageUpdatesDeployment(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Image = "postgres:10"
+
+	if err := c.syncImage(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment := getTestDeployment(t, c)
+	if image := getPostgresContainer(deployment).Image; image != "postgres:10" {
+		t.Errorf("expected image postgres:10, got %s", image)
+	}
+	if deployment.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+		t.Errorf("expected the Recreate strategy, got %s", deployment.Spec.Strategy.Type)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, ImageUpdated) {
+		t.Errorf("expected an %s event, got %s", ImageUpdated, event)
+	}
+}
+
+func TestSyncImageRequiresStorage(t *testing.T) {
+	foo := newTestPostgres(nil)
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Image = "postgres:10"
+
+	if err := c.syncImage(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image := getPostgresContainer(getTestDeployment(t, c)).Image; image != "postgres:9.3" {
+		t.Errorf("expected image to be kept at postgres:9.3, got %s", image)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnImageChangeBlocked) {
+		t.Errorf("expected a %s event, got %s", WarnImageChangeBlocked, event)
+	}
+}
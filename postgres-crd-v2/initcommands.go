@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"strings"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getSetupCommands flattens Spec.Commands into the setup commands, in lower
+// case, with a connect command before each group that targets another
+// database than the previous one. setupDatabase starts out connected to the
+// first database, which groups without a database target.
+func getSetupCommands(foo *postgresv1.Postgres) []string {
+	var defaultDatabase string
+	if len(foo.Spec.Databases) > 0 {
+		defaultDatabase = foo.Spec.Databases[0].Name
+	}
+	var commands []string
+	current := defaultDatabase
+	for _, group := range foo.Spec.Commands {
+		target := group.Database
+		if target == "" {
+			target = defaultDatabase
+		}
+		if target != current {
+			connectTo := target
+			if connectTo == "" {
+				// The database connected to when none is named
+				connectTo = MAINTENANCE_DATABASE
+			}
+			commands = append(commands, getConnectCommand(connectTo))
+			current = target
+		}
+		commands = append(commands, group.Statements...)
+	}
+	return canonicalize(commands)
+}
+
+// getCommandChecksums returns the checksum of the statements targeting
+// each database, in the order they are run. Statements run against the
+// default database when there are no databases are keyed by postgres.
+func getCommandChecksums(foo *postgresv1.Postgres) map[string]string {
+	if len(foo.Spec.Commands) == 0 {
+		return nil
+	}
+	statements := map[string][]string{}
+	for _, group := range foo.Spec.Commands {
+		target := group.Database
+		if target == "" && len(foo.Spec.Databases) > 0 {
+			target = foo.Spec.Databases[0].Name
+		}
+		if target == "" {
+			target = MAINTENANCE_DATABASE
+		}
+		statements[target] = append(statements[target], canonicalize(group.Statements)...)
+	}
+	checksums := map[string]string{}
+	for database, list := range statements {
+		checksums[database] = fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(list, "\n"))))
+	}
+	return checksums
+}
+
+// commandsApplied tells whether the setup commands are those recorded as
+// applied, in which case they are not diffed against the action history.
+func commandsApplied(foo *postgresv1.Postgres, status *postgresv1.PostgresStatus) bool {
+	return reflect.DeepEqual(getCommandChecksums(foo), status.AppliedCommandChecksums)
+}
+
+// validateCommands checks the databases targeted by Spec.Commands.
+func validateCommands(foo *postgresv1.Postgres) []string {
+	var problems []string
+	for _, group := range foo.Spec.Commands {
+		if group.Database != "" && !identifierPattern.MatchString(group.Database) {
+			problems = append(problems, fmt.Sprintf("invalid database name %q in initcommands", group.Database))
+		}
+		if len(group.Statements) == 0 {
+			problems = append(problems, "initcommands groups must have statements")
+		}
+	}
+	return problems
+}
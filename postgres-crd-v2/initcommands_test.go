@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestCommandSpecAcceptsStatements(t *testing.T) {
+	var spec postgresv1.PostgresSpec
+	data := `{"initcommands": ["create table t (id int);",
+		{"database": "wordpress", "statements": ["create table u (id int);"]}]}`
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []postgresv1.CommandSpec{
+		{Statements: []string{"create table t (id int);"}},
+		{Database: "wordpress", Statements: []string{"create table u (id int);"}},
+	}
+	if !reflect.DeepEqual(spec.Commands, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, spec.Commands)
+	}
+}
+
+func TestGetSetupCommandsConnectsToEachDatabase(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle", "wordpress")
+	foo.Spec.Commands = []postgresv1.CommandSpec{
+		{Statements: []string{"create table t (id int);"}},
+		{Database: "moodle", Statements: []string{"create table u (id int);"}},
+		{Database: "wordpress", Statements: []string{"CREATE TABLE v (id int);", "create table w (id int);"}},
+		{Statements: []string{"create table x (id int);"}},
+	}
+	expected := []string{
+		"create table t (id int);",
+		"create table u (id int);",
+		getConnectCommand("wordpress"),
+		"create table v (id int);",
+		"create table w (id int);",
+		getConnectCommand("moodle"),
+		"create table x (id int);",
+	}
+	if commands := getSetupCommands(foo); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, commands)
+	}
+}
+
+func TestGetCommandChecksumsPerDatabase(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle", "wordpress")
+	foo.Spec.Commands = []postgresv1.CommandSpec{
+		{Statements: []string{"create table t (id int);"}},
+		{Database: "wordpress", Statements: []string{"create table u (id int);"}},
+	}
+	checksums := getCommandChecksums(foo)
+	if len(checksums) != 2 || checksums["moodle"] == "" || checksums["wordpress"] == "" {
+		t.Fatalf("expected a checksum for moodle and wordpress, got %v", checksums)
+	}
+
+	foo.Spec.Commands[1].Statements = append(foo.Spec.Commands[1].Statements, "create table v (id int);")
+	changed := getCommandChecksums(foo)
+	if changed["moodle"] != checksums["moodle"] || changed["wordpress"] == checksums["wordpress"] {
+		t.Errorf("expected only the checksum of wordpress to change, got %v and %v", checksums, changed)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// The official images run the .sql, .sql.gz and .sh files of this
+	// directory when they initialize an empty data directory
+	INIT_SCRIPTS_MOUNT_PATH  = "/docker-entrypoint-initdb.d"
+	INIT_SCRIPTS_VOLUME_NAME = "init-scripts"
+)
+
+// validateInitScripts rejects init scripts together with the setup Job,
+// which would leave two sources of bootstrap SQL run at different times.
+func validateInitScripts(foo *postgresv1.Postgres) []string {
+	if foo.Spec.InitScriptsConfigMapRef != "" && foo.Spec.UseSetupJob {
+		return []string{"spec.initScriptsConfigMapRef cannot be used with spec.useSetupJob"}
+	}
+	return nil
+}
+
+// addInitScripts mounts the ConfigMap of Spec.InitScriptsConfigMapRef into
+// the init directory of the image. Its scripts run once, when the
+// entrypoint initializes the data directory, before the Pod is ready and
+// the controller creates the databases and users of the spec.
+func addInitScripts(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if foo.Spec.InitScriptsConfigMapRef == "" {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+		Name: INIT_SCRIPTS_VOLUME_NAME,
+		VolumeSource: apiv1.VolumeSource{
+			ConfigMap: &apiv1.ConfigMapVolumeSource{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: foo.Spec.InitScriptsConfigMapRef},
+			},
+		},
+	})
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+		Name:      INIT_SCRIPTS_VOLUME_NAME,
+		MountPath: INIT_SCRIPTS_MOUNT_PATH,
+		ReadOnly:  true,
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestInitScriptsAreMounted(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.InitScriptsConfigMapRef = "client25-init"
+
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	container := podSpec.Containers[0]
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != INIT_SCRIPTS_MOUNT_PATH ||
+		!container.VolumeMounts[0].ReadOnly {
+		t.Errorf("expected the scripts mounted read-only at %s, got %v", INIT_SCRIPTS_MOUNT_PATH, container.VolumeMounts)
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].ConfigMap.Name != "client25-init" {
+		t.Errorf("expected a volume from configmap client25-init, got %v", podSpec.Volumes)
+	}
+}
+
+func TestValidateInitScripts(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.InitScriptsConfigMapRef = "client25-init"
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	foo.Spec.UseSetupJob = true
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected init scripts with the setup job to be rejected, got %v", problems)
+	}
+
+	external := newTestPostgres(nil)
+	external.Spec.ExternalEndpoint = &postgresv1.ExternalEndpointSpec{Host: "db.example.com", AdminSecretRef: "admin"}
+	external.Spec.InitScriptsConfigMapRef = "client25-init"
+	if problems := validatePostgresSpec(external); len(problems) != 1 {
+		t.Errorf("expected init scripts on an external instance to be rejected, got %v", problems)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/connection"
+)
+
+const (
+	// CONNECTION_HASH_ANNOTATION is set on the pod template of the
+	// Deployment of Spec.InjectInto so that its Pods are restarted, and
+	// pick up the new environment, when the connection Secret changes.
+	CONNECTION_HASH_ANNOTATION = "postgrescontroller.kubeplus/connection-hash"
+
+	// WarnInjectTargetNotFound is used as part of the Event 'reason' when
+	// the Deployment of Spec.InjectInto does not exist.
+	WarnInjectTargetNotFound = "InjectTargetNotFound"
+)
+
+func getSecretDataHash(data map[string]string) string {
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, data[key])
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+func hasSecretEnvFrom(container *corev1.Container, secretName string) bool {
+	for _, envFrom := range container.EnvFrom {
+		if envFrom.SecretRef != nil && envFrom.SecretRef.Name == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+// injectConnectionSecret adds the connection Secret to the environment of
+// the containers of the Deployment of Spec.InjectInto, so that e.g.
+// DATABASE_URL is set. The Deployment belongs to the application: the
+// controller only adds the envFrom and its annotation, and never removes
+// or changes anything else. A missing Deployment is reported with an Event
+// and injected into once it exists and the resource is synced again.
+func (c *Controller) injectConnectionSecret(foo *postgresv1.Postgres, secretName string, info connection.Info) error {
+	if foo.Spec.InjectInto == "" {
+		return nil
+	}
+	// envFrom can only refer to Secrets in the namespace of the Pod
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
+	deployment, err := deploymentsClient.Get(foo.Spec.InjectInto, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		c.recorder.Event(foo, corev1.EventTypeWarning, WarnInjectTargetNotFound,
+			fmt.Sprintf("Deployment %s to inject the connection Secret into does not exist", foo.Spec.InjectInto))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	deploymentCopy := deployment.DeepCopy()
+	changed := false
+	podSpec := &deploymentCopy.Spec.Template.Spec
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if !hasSecretEnvFrom(container, secretName) {
+			container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				},
+			})
+			changed = true
+		}
+	}
+	hash := getSecretDataHash(info.SecretData())
+	if deploymentCopy.Spec.Template.Annotations[CONNECTION_HASH_ANNOTATION] != hash {
+		if deploymentCopy.Spec.Template.Annotations == nil {
+			deploymentCopy.Spec.Template.Annotations = map[string]string{}
+		}
+		deploymentCopy.Spec.Template.Annotations[CONNECTION_HASH_ANNOTATION] = hash
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	fmt.Printf("Injecting connection Secret %s into Deployment %s\n", secretName, foo.Spec.InjectInto)
+	_, err = deploymentsClient.Update(deploymentCopy)
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/connection"
+)
+
+func newInjectTargetDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "moodle", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "moodle",
+						EnvFrom: []corev1.EnvFromSource{{
+							ConfigMapRef: &corev1.ConfigMapEnvSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "moodle-config"},
+							},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestInjectConnectionSecret(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.InjectInto = "moodle"
+	kubeclientset := kubefake.NewSimpleClientset(newInjectTargetDeployment())
+	c := &Controller{kubeclientset: kubeclientset, recorder: record.NewFakeRecorder(10)}
+	info := connection.Info{Host: "10.0.0.1", Port: "5432", Database: "moodle", Username: "devdatta",
+		Password: "pass123", SSLMode: "disable"}
+
+	if err := c.injectConnectionSecret(foo, "client25-connection", info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment, _ := kubeclientset.AppsV1().Deployments("default").Get("moodle", metav1.GetOptions{})
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if len(container.EnvFrom) != 2 || container.EnvFrom[0].ConfigMapRef == nil ||
+		!hasSecretEnvFrom(&container, "client25-connection") {
+		t.Errorf("expected the Secret to be added to the existing envFrom, got %v", container.EnvFrom)
+	}
+	hash := deployment.Spec.Template.Annotations[CONNECTION_HASH_ANNOTATION]
+	if hash == "" {
+		t.Errorf("expected the %s annotation", CONNECTION_HASH_ANNOTATION)
+	}
+
+	// Unchanged, the Deployment is not updated again
+	kubeclientset.ClearActions()
+	if err := c.injectConnectionSecret(foo, "client25-connection", info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, action := range kubeclientset.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("expected no update, got %v", action)
+		}
+	}
+
+	// A changed password rolls the Pods
+	info.Password = "pass456"
+	if err := c.injectConnectionSecret(foo, "client25-connection", info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment, _ = kubeclientset.AppsV1().Deployments("default").Get("moodle", metav1.GetOptions{})
+	if deployment.Spec.Template.Annotations[CONNECTION_HASH_ANNOTATION] == hash {
+		t.Errorf("expected the hash to change with the Secret")
+	}
+	if envFrom := deployment.Spec.Template.Spec.Containers[0].EnvFrom; len(envFrom) != 2 {
+		t.Errorf("expected the Secret to be added once, got %v", envFrom)
+	}
+}
+
+func TestInjectIntoMissingDeployment(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.InjectInto = "moodle"
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{kubeclientset: kubefake.NewSimpleClientset(), recorder: recorder}
+	if err := c.injectConnectionSecret(foo, "client25-connection", connection.Info{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnInjectTargetNotFound) {
+		t.Errorf("expected a %s warning, got %q", WarnInjectTargetNotFound, event)
+	}
+}
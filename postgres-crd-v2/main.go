@@ -18,28 +18,83 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	clientset "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned"
 	informers "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/informers/externalversions"
 	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/signals"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/version"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
+	masterURL    string
+	kubeconfig   string
+	workers      int
+	resyncPeriod time.Duration
+	namespace    string
+
+	healthAddr string
+
+	externalAccess bool
+
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+
+	connectAttempts      int
+	connectRetryInterval time.Duration
+
+	webhookAddr   string
+	tlsCertFile   string
+	tlsPrivateKey string
+
+	leaderElect        bool
+	leaseName          string
+	leaseNamespace     string
+	leaseDuration      time.Duration
+	leaseRenewDeadline time.Duration
+	leaseRetryPeriod   time.Duration
+
+	heartbeatName     string
+	heartbeatInterval time.Duration
+)
+
+const (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	serviceAccountTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
 )
 
 func main() {
 	flag.Parse()
 
+	glog.Infof("Starting postgres controller %s", version.Get())
+
+	if workers < 1 {
+		glog.Fatalf("Invalid value for -workers: %d, must be at least 1", workers)
+	}
+	if maxRetries < 0 {
+		glog.Fatalf("Invalid value for -max-retries: %d, must not be negative", maxRetries)
+	}
+	if connectAttempts < 1 {
+		glog.Fatalf("Invalid value for -connect-attempts: %d, must be at least 1", connectAttempts)
+	}
+	if heartbeatInterval <= 0 {
+		glog.Fatalf("Invalid value for -heartbeat-interval: %s, must be positive", heartbeatInterval)
+	}
+
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
@@ -58,20 +113,159 @@ func main() {
 		glog.Fatalf("Error building example clientset: %s", err.Error())
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	exampleInformerFactory := informers.NewSharedInformerFactory(exampleClient, time.Second*30)
+	// An empty namespace watches all namespaces
+	kubeInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, resyncPeriod, namespace, nil)
+	exampleInformerFactory := informers.NewFilteredSharedInformerFactory(exampleClient, resyncPeriod, namespace, nil)
 
-	controller := NewController(kubeClient, exampleClient, kubeInformerFactory, exampleInformerFactory)
+	retryPolicy := RetryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  retryBaseDelay,
+		MaxDelay:   retryMaxDelay,
+	}
+	controller := NewController(kubeClient, exampleClient, kubeInformerFactory, exampleInformerFactory, retryPolicy, namespace)
+	controller.serviceDNS = !externalAccess && isInCluster()
+	connectRetry := ConnectRetry{
+		Attempts:    connectAttempts,
+		Interval:    connectRetryInterval,
+		MaxInterval: DefaultConnectRetry.MaxInterval,
+	}
+	controller.newDBExecutor = func() DBExecutor { return newPQExecutor(connectRetry) }
 
 	go kubeInformerFactory.Start(stopCh)
 	go exampleInformerFactory.Start(stopCh)
 
-	if err = controller.Run(2, stopCh); err != nil {
-		glog.Fatalf("Error running controller: %s", err.Error())
+	go func() {
+		if err := controller.RunHealthServer(healthAddr); err != nil {
+			glog.Fatalf("Error running health server: %s", err.Error())
+		}
+	}()
+
+	// The webhook is served by every replica, not only the leader
+	if tlsCertFile != "" {
+		go func() {
+			if err := controller.RunWebhookServer(webhookAddr, tlsCertFile, tlsPrivateKey); err != nil {
+				glog.Fatalf("Error running webhook server: %s", err.Error())
+			}
+		}()
+	}
+
+	run := func(stopCh <-chan struct{}) {
+		if err := controller.Run(workers, stopCh); err != nil {
+			glog.Fatalf("Error running controller: %s", err.Error())
+		}
+	}
+
+	id, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Error getting hostname: %s", err.Error())
+	}
+	leaseNS := leaseNamespace
+	if leaseNS == "" {
+		leaseNS = getControllerNamespace()
+	}
+
+	// Without leader election a heartbeat keeps a second instance from
+	// processing the same Postgres resources
+	if !leaderElect {
+		hb := newHeartbeat(kubeClient, leaseNS, heartbeatName, id, heartbeatInterval)
+		if err := hb.acquire(); err != nil {
+			glog.Fatalf("Error acquiring heartbeat %s/%s: %s", leaseNS, heartbeatName, err.Error())
+		}
+		glog.Infof("Acquired heartbeat %s/%s as %s", leaseNS, heartbeatName, id)
+		go hb.run(stopCh)
+		run(stopCh)
+		if err := hb.release(); err != nil {
+			glog.Errorf("Error releasing heartbeat %s/%s: %s", leaseNS, heartbeatName, err.Error())
+		}
+		return
+	}
+
+	// Replicas waiting for the lease report ready once their caches have
+	// synced so that they can take over without delay
+	go controller.waitForCacheSync(stopCh)
+	lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, leaseNS, leaseName,
+		kubeClient.CoreV1(), resourcelock.ResourceLockConfig{
+			Identity: id,
+		})
+	if err != nil {
+		glog.Fatalf("Error creating lease lock: %s", err.Error())
+	}
+
+	// Only the elected leader runs the controller. The election of client-go
+	// v6 cannot be stopped, so it runs until the process exits: the leader
+	// once its workers stopped on the first shutdown signal, other replicas
+	// right away.
+	var leading int32
+	stopped := make(chan struct{})
+	glog.Infof("Starting leader election for lease %s/%s as %s", leaseNS, leaseName, id)
+	go leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: leaseRenewDeadline,
+		RetryPeriod:   leaseRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				atomic.StoreInt32(&leading, 1)
+				glog.Infof("Acquired lease %s/%s", leaseNS, leaseName)
+				run(stopCh)
+				close(stopped)
+			},
+			OnStoppedLeading: func() {
+				// Exit so that a restarted replica cannot race with the new leader
+				glog.Fatalf("Lost lease %s/%s", leaseNS, leaseName)
+			},
+		},
+	})
+	<-stopCh
+	if atomic.LoadInt32(&leading) == 1 {
+		<-stopped
+	}
+}
+
+// isInCluster returns true when a service account token is mounted, i.e.
+// the controller runs in a Pod.
+func isInCluster() bool {
+	_, err := os.Stat(serviceAccountTokenFile)
+	return err == nil
+}
+
+// getControllerNamespace returns the namespace the controller runs in. It is
+// read from the POD_NAMESPACE env variable or the service account mount and
+// defaults to "default" when running out-of-cluster.
+func getControllerNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
 	}
+	return "default"
 }
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&namespace, "namespace", "", "Namespace of the Postgres resources watched by the controller, which also holds their instances. All namespaces are watched when empty.")
+	flag.IntVar(&workers, "workers", 2, "Number of workers processing Postgres resources concurrently.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 30*time.Second, "Period at which the informers resync all Postgres resources and Deployments.")
+	flag.IntVar(&maxRetries, "max-retries", DefaultRetryPolicy.MaxRetries, "Number of retries after which a failing Postgres resource is given up until its spec changes. 0 retries forever.")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", DefaultRetryPolicy.BaseDelay, "Initial backoff before retrying a failed Postgres resource.")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", DefaultRetryPolicy.MaxDelay, "Maximum backoff before retrying a failed Postgres resource.")
+	flag.IntVar(&connectAttempts, "connect-attempts", DefaultConnectRetry.Attempts, "Number of attempts to connect to a Postgres instance that is still starting up.")
+	flag.DurationVar(&connectRetryInterval, "connect-retry-interval", DefaultConnectRetry.Interval, "Initial delay between connection attempts, doubled after each attempt.")
+	flag.BoolVar(&externalAccess, "external-access", false, "Connect to Postgres through the node IP and NodePort even when running in the cluster. By default the Service DNS name is used in-cluster.")
+	flag.StringVar(&healthAddr, "health-addr", ":8080", "Address serving the /healthz and /readyz endpoints.")
+	flag.StringVar(&webhookAddr, "webhook-addr", ":8443", "Address the validating admission webhook listens on.")
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "", "TLS certificate of the validating admission webhook. The webhook is disabled when not set.")
+	flag.StringVar(&tlsPrivateKey, "tls-private-key-file", "", "TLS private key of the validating admission webhook.")
+	flag.BoolVar(&leaderElect, "leader-elect", true, "Use leader election so that only one controller replica processes Postgres resources.")
+	flag.StringVar(&leaseName, "lease-name", "postgres-controller", "Name of the ConfigMap used as the leader election lock.")
+	flag.StringVar(&leaseNamespace, "lease-namespace", "", "Namespace of the leader election ConfigMap, or of the heartbeat ConfigMap with -leader-elect=false. Defaults to the controller's namespace.")
+	flag.DurationVar(&leaseDuration, "lease-duration", 15*time.Second, "Duration non-leader replicas wait before trying to acquire the lease.")
+	flag.DurationVar(&leaseRenewDeadline, "lease-renew-deadline", 10*time.Second, "Duration the leader retries renewing the lease before giving it up.")
+	flag.DurationVar(&leaseRetryPeriod, "lease-retry-period", 2*time.Second, "Duration between leader election attempts.")
+	flag.StringVar(&heartbeatName, "heartbeat-name", "postgres-controller-heartbeat", "Name of the ConfigMap the controller renews with -leader-elect=false. A second instance refuses to start while it is fresh.")
+	flag.DurationVar(&heartbeatInterval, "heartbeat-interval", 10*time.Second, "Interval at which the heartbeat is renewed with -leader-elect=false. It is considered stale after 3 intervals.")
 }
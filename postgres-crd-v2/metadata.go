@@ -0,0 +1,33 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// addMetadata merges the labels and annotations of Spec.Metadata into the
+// object. Keys already set by the controller, such as the 'app' label used
+// by the selectors, are not overwritten.
+func addMetadata(objectMeta *metav1.ObjectMeta, foo *postgresv1.Postgres) {
+	if foo.Spec.Metadata == nil {
+		return
+	}
+	objectMeta.Labels = mergeMap(objectMeta.Labels, foo.Spec.Metadata.Labels)
+	objectMeta.Annotations = mergeMap(objectMeta.Annotations, foo.Spec.Metadata.Annotations)
+}
+
+func mergeMap(current map[string]string, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return current
+	}
+	if current == nil {
+		current = map[string]string{}
+	}
+	for key, value := range extra {
+		if _, ok := current[key]; !ok {
+			current[key] = value
+		}
+	}
+	return current
+}
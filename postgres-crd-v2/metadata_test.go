@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestMetadataIsPropagated(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Metadata = &postgresv1.MetadataSpec{
+		Labels: map[string]string{"team": "payments", "app": "other"},
+		Annotations: map[string]string{
+			"service.beta.kubernetes.io/aws-load-balancer-ssl-cert": "arn:aws:acm:cert",
+		},
+	}
+
+	expectedLabels := map[string]string{"team": "payments", "app": "client25"}
+	deployment := getDeployment(foo)
+	service := getService(foo)
+	for name, labels := range map[string]map[string]string{
+		"deployment":   deployment.Labels,
+		"pod template": deployment.Spec.Template.Labels,
+		"service":      service.Labels,
+	} {
+		if !reflect.DeepEqual(labels, expectedLabels) {
+			t.Errorf("expected %s labels %v\ngot %v", name, expectedLabels, labels)
+		}
+	}
+	if !reflect.DeepEqual(service.Annotations, foo.Spec.Metadata.Annotations) {
+		t.Errorf("expected service annotations %v\ngot %v", foo.Spec.Metadata.Annotations, service.Annotations)
+	}
+	if deployment.Spec.Selector.MatchLabels["app"] != "client25" {
+		t.Errorf("expected selector to be unchanged, got %v", deployment.Spec.Selector.MatchLabels)
+	}
+}
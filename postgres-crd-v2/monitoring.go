@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	apiutil "k8s.io/apimachinery/pkg/util/intstr"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	EXPORTER_IMAGE          = "wrouesnel/postgres_exporter:v0.4.7"
+	EXPORTER_CONTAINER_NAME = "postgres-exporter"
+	EXPORTER_PORT           = 9187
+)
+
+func isMonitoringEnabled(foo *postgresv1.Postgres) bool {
+	return foo.Spec.Monitoring != nil && foo.Spec.Monitoring.Enabled
+}
+
+// getSecretEnv returns an env var read from the connection Secret.
+func getSecretEnv(foo *postgresv1.Postgres, name string, key string) apiv1.EnvVar {
+	return apiv1.EnvVar{
+		Name: name,
+		ValueFrom: &apiv1.EnvVarSource{
+			SecretKeyRef: &apiv1.SecretKeySelector{
+				LocalObjectReference: apiv1.LocalObjectReference{
+					Name: getConnectionSecretName(foo),
+				},
+				Key: key,
+			},
+		},
+	}
+}
+
+// addMonitoring adds the postgres_exporter sidecar and the Prometheus scrape
+// annotations to the pod template. The exporter connects over localhost with
+// the credentials of the connection Secret.
+func addMonitoring(template *apiv1.PodTemplateSpec, foo *postgresv1.Postgres) {
+	if !isMonitoringEnabled(foo) {
+		return
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations["prometheus.io/scrape"] = "true"
+	template.Annotations["prometheus.io/port"] = fmt.Sprint(EXPORTER_PORT)
+
+	database := "postgres"
+	if len(foo.Spec.Databases) > 0 {
+		database = foo.Spec.Databases[0].Name
+	}
+	template.Spec.Containers = append(template.Spec.Containers, apiv1.Container{
+		Name:  EXPORTER_CONTAINER_NAME,
+		Image: EXPORTER_IMAGE,
+		Ports: []apiv1.ContainerPort{
+			{
+				Name:          "metrics",
+				ContainerPort: EXPORTER_PORT,
+			},
+		},
+		Env: []apiv1.EnvVar{
+			{
+				Name:  "DATA_SOURCE_URI",
+				Value: "localhost:5432/" + database + "?sslmode=disable",
+			},
+			getSecretEnv(foo, "DATA_SOURCE_USER", "username"),
+			getSecretEnv(foo, "DATA_SOURCE_PASS", "password"),
+		},
+	})
+}
+
+func addMetricsPort(serviceSpec *apiv1.ServiceSpec, foo *postgresv1.Postgres) {
+	if !isMonitoringEnabled(foo) {
+		return
+	}
+	serviceSpec.Ports = append(serviceSpec.Ports, apiv1.ServicePort{
+		Name:       "metrics",
+		Port:       EXPORTER_PORT,
+		TargetPort: apiutil.FromInt(EXPORTER_PORT),
+		Protocol:   apiv1.ProtocolTCP,
+	})
+}
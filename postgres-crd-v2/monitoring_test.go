@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestMonitoringAddsExporterSidecar(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Monitoring = &postgresv1.MonitoringSpec{Enabled: true}
+
+	template := getDeployment(foo).Spec.Template
+	containers := template.Spec.Containers
+	if len(containers) != 2 || containers[1].Name != EXPORTER_CONTAINER_NAME {
+		t.Fatalf("expected exporter sidecar, got %v", containers)
+	}
+	if containers[1].Ports[0].ContainerPort != EXPORTER_PORT {
+		t.Errorf("expected exporter port %d, got %v", EXPORTER_PORT, containers[1].Ports)
+	}
+	for _, env := range containers[1].Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef.Name != "client25-connection" {
+			t.Errorf("expected %s to come from client25-connection, got %s", env.Name, env.ValueFrom.SecretKeyRef.Name)
+		}
+	}
+	expected := map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "9187"}
+	if !reflect.DeepEqual(template.Annotations, expected) {
+		t.Errorf("expected annotations %v\ngot %v", expected, template.Annotations)
+	}
+	ports := getService(foo).Spec.Ports
+	if len(ports) != 2 || ports[1].Port != EXPORTER_PORT {
+		t.Errorf("expected metrics port on the service, got %v", ports)
+	}
+}
+
+func TestMonitoringDisabledLeavesPodUnchanged(t *testing.T) {
+	foo := newTestPostgres(nil)
+	expected := getDeployment(foo)
+
+	foo.Spec.Monitoring = &postgresv1.MonitoringSpec{Enabled: false}
+	if got := getDeployment(foo); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v\ngot %v", expected, got)
+	}
+	if ports := getService(foo).Spec.Ports; len(ports) != 1 {
+		t.Errorf("expected only the postgres port, got %v", ports)
+	}
+}
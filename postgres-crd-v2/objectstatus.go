@@ -0,0 +1,114 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// objectResult is the outcome of a command for a database or user, kept
+// until the next status update of the resource.
+type objectResult struct {
+	database bool
+	name     string
+	dropped  bool
+	state    postgresv1.ObjectState
+	message  string
+	time     metav1.Time
+}
+
+// getObjectResults returns the outcome of the database and user commands
+// when the first done commands succeeded and, if err is set, the next one
+// failed. The remaining commands were not run and are Pending.
+func getObjectResults(commands []string, done int, err error) []objectResult {
+	now := metav1.Now()
+	var results []objectResult
+	for i, command := range commands {
+		event, name, ok := getCommandObject(command)
+		if !ok {
+			continue
+		}
+		result := objectResult{name: name, state: postgresv1.ObjectPending, time: now}
+		switch event.reason {
+		case DatabaseCreated, DatabaseAltered:
+			result.database = true
+		case DatabaseDropped:
+			result.database, result.dropped = true, true
+		case UserCreated, UserAltered:
+		case UserDropped:
+			result.dropped = true
+		default:
+			continue
+		}
+		if i < done {
+			result.state = postgresv1.ObjectPresent
+		} else if i == done && err != nil {
+			result.state = postgresv1.ObjectFailed
+			result.message = describeDatabaseError(err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// recordCommandResults keeps the outcome of the commands run for foo so
+// that the next status update records it per database and user, and per
+// command.
+func (c *Controller) recordCommandResults(foo *postgresv1.Postgres, commands []string, done int, err error) {
+	if foo == nil {
+		return
+	}
+	results := getObjectResults(commands, done, err)
+	executed := getExecutedCommands(commands, done, err)
+	key := foo.Namespace + "/" + foo.Name
+	c.objectResultsLock.Lock()
+	defer c.objectResultsLock.Unlock()
+	if len(results) > 0 {
+		if c.objectResults == nil {
+			c.objectResults = map[string][]objectResult{}
+		}
+		c.objectResults[key] = append(c.objectResults[key], results...)
+	}
+	if len(executed) > 0 {
+		if c.commandResults == nil {
+			c.commandResults = map[string][]postgresv1.CommandResult{}
+		}
+		c.commandResults[key] = append(c.commandResults[key], executed...)
+	}
+}
+
+// takeCommandResults returns and forgets the outcomes kept for foo.
+func (c *Controller) takeCommandResults(foo *postgresv1.Postgres) []objectResult {
+	key := foo.Namespace + "/" + foo.Name
+	c.objectResultsLock.Lock()
+	defer c.objectResultsLock.Unlock()
+	results := c.objectResults[key]
+	delete(c.objectResults, key)
+	return results
+}
+
+// applyObjectResults updates the database and user statuses in the order
+// the commands ran. Objects dropped successfully are removed.
+func applyObjectResults(status *postgresv1.PostgresStatus, results []objectResult) {
+	for _, result := range results {
+		statuses := &status.UserStatuses
+		if result.database {
+			statuses = &status.DatabaseStatuses
+		}
+		if result.dropped && result.state == postgresv1.ObjectPresent {
+			delete(*statuses, result.name)
+			continue
+		}
+		if *statuses == nil {
+			*statuses = map[string]postgresv1.ObjectStatus{}
+		}
+		objectStatus := (*statuses)[result.name]
+		objectStatus.State = result.state
+		objectStatus.Message = result.message
+		if result.state == postgresv1.ObjectPresent {
+			succeeded := result.time
+			objectStatus.LastSucceededTime = &succeeded
+		}
+		(*statuses)[result.name] = objectStatus
+	}
+}
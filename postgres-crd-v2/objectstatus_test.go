@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestSetupDatabaseRecordsObjectStatuses(t *testing.T) {
+	commands := []string{
+		"create user devdatta with password 'pass123';",
+		"create database moodle owner devdatta;",
+		"create user \"reporter\" with password 'pass456';",
+		"create database wordpress;",
+		"drop database olddb;",
+	}
+	executor := &fakeExecutor{errors: map[string]error{commands[2]: fmt.Errorf("role exists")}}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+	foo := newTestPostgres(nil)
+
+	if err := c.setupDatabase(foo, dbEndpoint{}, commands, []string{"moodle"}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	status := postgresv1.PostgresStatus{
+		DatabaseStatuses: map[string]postgresv1.ObjectStatus{"olddb": {State: postgresv1.ObjectPresent}},
+	}
+	applyObjectResults(&status, c.takeCommandResults(foo))
+
+	expectedUsers := map[string]postgresv1.ObjectState{
+		"devdatta": postgresv1.ObjectPresent,
+		"reporter": postgresv1.ObjectFailed,
+	}
+	for name, state := range expectedUsers {
+		if status.UserStatuses[name].State != state {
+			t.Errorf("expected user %s to be %s, got %+v", name, state, status.UserStatuses[name])
+		}
+	}
+	expectedDatabases := map[string]postgresv1.ObjectState{
+		"moodle":    postgresv1.ObjectPresent,
+		"wordpress": postgresv1.ObjectPending,
+		"olddb":     postgresv1.ObjectPending,
+	}
+	for name, state := range expectedDatabases {
+		if status.DatabaseStatuses[name].State != state {
+			t.Errorf("expected database %s to be %s, got %+v", name, state, status.DatabaseStatuses[name])
+		}
+	}
+	if status.UserStatuses["devdatta"].LastSucceededTime == nil {
+		t.Errorf("expected the success time of devdatta to be recorded")
+	}
+	if status.UserStatuses["reporter"].Message != "role exists" {
+		t.Errorf("expected the error of reporter to be recorded, got %q", status.UserStatuses["reporter"].Message)
+	}
+	if results := c.takeCommandResults(foo); len(results) != 0 {
+		t.Errorf("expected the results to be taken once, got %v", results)
+	}
+}
+
+func TestApplyObjectResultsRemovesDropped(t *testing.T) {
+	status := postgresv1.PostgresStatus{
+		DatabaseStatuses: map[string]postgresv1.ObjectStatus{"olddb": {State: postgresv1.ObjectPresent}},
+		UserStatuses:     map[string]postgresv1.ObjectStatus{"olduser": {State: postgresv1.ObjectFailed}},
+	}
+	commands := []string{"drop database if exists olddb;", "drop user \"olduser\";"}
+	applyObjectResults(&status, getObjectResults(commands, len(commands), nil))
+	if len(status.DatabaseStatuses) != 0 || len(status.UserStatuses) != 0 {
+		t.Errorf("expected dropped objects to be removed, got %v %v", status.DatabaseStatuses, status.UserStatuses)
+	}
+}
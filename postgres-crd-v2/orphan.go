@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// WarnDatabaseOrphaned is used as part of the Event 'reason' when a
+	// database removed from the spec is kept.
+	WarnDatabaseOrphaned = "DatabaseOrphaned"
+)
+
+// getManagedDatabases returns the databases recorded in the status,
+// including the orphaned ones so that they are dropped once deletion is
+// allowed.
+func getManagedDatabases(status *postgresv1.PostgresStatus) []string {
+	var managed []string
+	appendList(&managed, getDatabaseNames(status.Databases))
+	appendList(&managed, status.OrphanedDatabases)
+	return managed
+}
+
+// guardDatabaseDeletion returns the drop commands to run and the databases
+// that are kept instead. Unless Spec.AllowDatabaseDeletion is set, databases
+// removed from the spec are never dropped.
+func (c *Controller) guardDatabaseDeletion(foo *postgresv1.Postgres, desiredDatabases []string,
+	currentDatabases []string, dropCommands []string) ([]string, []string) {
+	if foo.Spec.AllowDatabaseDeletion {
+		return dropCommands, nil
+	}
+	orphaned := getDiffList(currentDatabases, desiredDatabases)
+	if len(orphaned) > 0 {
+		c.recorder.Event(foo, corev1.EventTypeWarning, WarnDatabaseOrphaned,
+			fmt.Sprintf("Databases %s were removed from the spec but not dropped, set allowDatabaseDeletion to drop them",
+				strings.Join(orphaned, ", ")))
+	}
+	return nil, orphaned
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRemovedDatabaseIsOrphanedByDefault(t *testing.T) {
+	foo := newTestPostgres(nil)
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder}
+
+	desired := []string{"moodle"}
+	current := []string{"moodle", "wordpress"}
+	_, dropCommands := getDatabaseCommands(newDatabaseSpecs(desired...), current)
+
+	dropCommands, orphaned := c.guardDatabaseDeletion(foo, desired, current, dropCommands)
+	if len(dropCommands) != 0 {
+		t.Errorf("expected no drop commands, got %v", dropCommands)
+	}
+	if !reflect.DeepEqual(orphaned, []string{"wordpress"}) {
+		t.Errorf("expected [wordpress] to be orphaned\ngot %v", orphaned)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, WarnDatabaseOrphaned) || !strings.Contains(event, "wordpress") {
+			t.Errorf("expected an orphaned warning for wordpress, got %q", event)
+		}
+	default:
+		t.Errorf("expected a warning event to be recorded")
+	}
+}
+
+func TestRemovedDatabaseIsDroppedWhenAllowed(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.AllowDatabaseDeletion = true
+	c := &Controller{recorder: record.NewFakeRecorder(10)}
+
+	desired := []string{"moodle"}
+	current := []string{"moodle", "wordpress"}
+	_, dropCommands := getDatabaseCommands(newDatabaseSpecs(desired...), current)
+
+	dropCommands, orphaned := c.guardDatabaseDeletion(foo, desired, current, dropCommands)
+	if !reflect.DeepEqual(dropCommands, []string{"drop database wordpress;"}) {
+		t.Errorf("expected [drop database wordpress;]\ngot %v", dropCommands)
+	}
+	if len(orphaned) != 0 {
+		t.Errorf("expected no orphaned databases, got %v", orphaned)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Set on the pod template so that a change of a parameter that only
+	// takes effect on restart rolls the Pod
+	PARAMETERS_HASH_ANNOTATION = "postgrescontroller.kubeplus/parameters-hash"
+
+	// RestartedForParameters is used as part of the Event 'reason' when the
+	// Pod is restarted for changed parameters.
+	RestartedForParameters = "RestartedForParameters"
+	// WarnParametersRestartBlocked is used as part of the Event 'reason' when
+	// the Pod is not restarted as its data is not on persistent storage.
+	WarnParametersRestartBlocked = "ParametersRestartBlocked"
+)
+
+// tunableParameters are the settings that can be set through
+// Spec.Parameters, mapped to whether they require a restart.
+var tunableParameters = map[string]bool{
+	"max_connections":                     true,
+	"shared_buffers":                      true,
+	"wal_buffers":                         true,
+	"max_worker_processes":                true,
+	"max_wal_senders":                     true,
+	"max_prepared_transactions":           true,
+	"work_mem":                            false,
+	"maintenance_work_mem":                false,
+	"effective_cache_size":                false,
+	"effective_io_concurrency":            false,
+	"random_page_cost":                    false,
+	"default_statistics_target":           false,
+	"checkpoint_completion_target":        false,
+	"max_wal_size":                        false,
+	"min_wal_size":                        false,
+	"max_parallel_workers_per_gather":     false,
+	"statement_timeout":                   false,
+	"idle_in_transaction_session_timeout": false,
+	"log_min_duration_statement":          false,
+	"log_statement":                       false,
+	"timezone":                            false,
+}
+
+func getSortedKeys(parameters map[string]string) []string {
+	var keys []string
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getParameterCommands returns the alter system commands setting the
+// parameters that changed and resetting those removed from the spec,
+// followed by a reload of the configuration.
+func getParameterCommands(desired map[string]string, current map[string]string) []string {
+	var commands []string
+	for _, key := range getSortedKeys(desired) {
+		if value, ok := current[key]; ok && value == desired[key] {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("alter system set %s = %s;", key, pq.QuoteLiteral(desired[key])))
+	}
+	for _, key := range getSortedKeys(current) {
+		if _, ok := desired[key]; !ok {
+			commands = append(commands, fmt.Sprintf("alter system reset %s;", key))
+		}
+	}
+	if len(commands) > 0 {
+		commands = append(commands, "select pg_reload_conf();")
+	}
+	return commands
+}
+
+// getParametersHash returns the hash of the parameters that require a
+// restart, or an empty string when none is set.
+func getParametersHash(parameters map[string]string) string {
+	hash := sha256.New()
+	found := false
+	for _, key := range getSortedKeys(parameters) {
+		if tunableParameters[key] {
+			fmt.Fprintf(hash, "%s=%s\n", key, parameters[key])
+			found = true
+		}
+	}
+	if !found {
+		return ""
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+func validateParameters(parameters map[string]string) []string {
+	var problems []string
+	for _, key := range getSortedKeys(parameters) {
+		if _, ok := tunableParameters[key]; !ok {
+			problems = append(problems, fmt.Sprintf("parameter %s cannot be set through spec.parameters, use configMapRef instead", key))
+		}
+	}
+	return problems
+}
+
+// syncParameters restarts the Pod once parameters that only take effect on
+// restart have been set with alter system. The settings are kept in
+// postgresql.auto.conf in the data directory, so without storage the
+// restart would lose them along with the data and is refused with a warning.
+func (c *Controller) syncParameters(foo *postgresv1.Postgres) error {
+	parametersHash := getParametersHash(foo.Spec.Parameters)
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
+	deployment, err := deploymentsClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if deployment.Spec.Template.Annotations[PARAMETERS_HASH_ANNOTATION] == parametersHash {
+		return nil
+	}
+	if foo.Spec.Storage == nil {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnParametersRestartBlocked,
+			fmt.Sprintf("%s is not restarted for the changed parameters as the data is not on persistent storage",
+				foo.Spec.DeploymentName))
+		return nil
+	}
+	fmt.Printf("Parameters of %s changed, restarting\n", foo.Spec.DeploymentName)
+	deploymentCopy := deployment.DeepCopy()
+	if deploymentCopy.Spec.Template.Annotations == nil {
+		deploymentCopy.Spec.Template.Annotations = map[string]string{}
+	}
+	deploymentCopy.Spec.Template.Annotations[PARAMETERS_HASH_ANNOTATION] = parametersHash
+	_, err = deploymentsClient.Update(deploymentCopy)
+	if err != nil {
+		return err
+	}
+	c.recorder.Event(foo, apiv1.EventTypeNormal, RestartedForParameters,
+		fmt.Sprintf("Restarted %s as parameters requiring a restart changed", foo.Spec.DeploymentName))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestGetParameterCommands(t *testing.T) {
+	desired := map[string]string{"max_connections": "200", "work_mem": "8MB"}
+	current := map[string]string{"work_mem": "4MB", "statement_timeout": "30s"}
+	expected := []string{
+		"alter system set max_connections = '200';",
+		"alter system set work_mem = '8MB';",
+		"alter system reset statement_timeout;",
+		"select pg_reload_conf();",
+	}
+	if commands := getParameterCommands(desired, current); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+	if commands := getParameterCommands(desired, desired); commands != nil {
+		t.Errorf("expected no commands for unchanged parameters, got %v", commands)
+	}
+}
+
+func TestGetParametersHash(t *testing.T) {
+	if hash := getParametersHash(map[string]string{"work_mem": "8MB"}); hash != "" {
+		t.Errorf("expected no hash without parameters requiring a restart, got %s", hash)
+	}
+	hash := getParametersHash(map[string]string{"max_connections": "200", "work_mem": "8MB"})
+	if hash == "" || hash != getParametersHash(map[string]string{"max_connections": "200", "work_mem": "16MB"}) {
+		t.Errorf("expected the hash to only depend on parameters requiring a restart")
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	problems := validateParameters(map[string]string{"max_connections": "200", "data_directory": "/tmp"})
+	if len(problems) != 1 || !strings.Contains(problems[0], "data_directory") {
+		t.Errorf("expected data_directory to be rejected, got %v", problems)
+	}
+}
+
+func TestSyncParametersRestartsPod(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Parameters = map[string]string{"max_connections": "200"}
+
+	if err := c.syncParameters(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	annotations := getTestDeployment(t, c).Spec.Template.Annotations
+	if annotations[PARAMETERS_HASH_ANNOTATION] != getParametersHash(foo.Spec.Parameters) {
+		t.Errorf("expected the parameters hash on the pod template, got %v", annotations)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, RestartedForParameters) {
+		t.Errorf("expected a %s event, got %s", RestartedForParameters, event)
+	}
+}
+
+func TestSyncParametersRequiresStorage(t *testing.T) {
+	foo := newTestPostgres(nil)
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Parameters = map[string]string{"max_connections": "200"}
+
+	if err := c.syncParameters(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if annotations := getTestDeployment(t, c).Spec.Template.Annotations; annotations[PARAMETERS_HASH_ANNOTATION] != "" {
+		t.Errorf("expected the pod not to be restarted, got %v", annotations)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnParametersRestartBlocked) {
+		t.Errorf("expected a %s event, got %s", WarnParametersRestartBlocked, event)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// readPasswordFile reads a password from a file mounted in the controller's
+// Pod, e.g. by the Secrets Store CSI driver or a Vault agent. A trailing
+// newline is not part of the password.
+func readPasswordFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read password file: %v", err)
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return password, nil
+}
+
+// validatePasswordSources checks that each password is taken from a single
+// source. The files are only read by the controller, their content is not
+// checked here.
+func validatePasswordSources(foo *postgresv1.Postgres) []string {
+	var problems []string
+	for _, user := range foo.Spec.Users {
+		sources := 0
+		for _, source := range []string{user.Password, user.PasswordSecretRef, user.PasswordFromFile} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources > 1 {
+			problems = append(problems, fmt.Sprintf("user %s: only one of password, passwordSecretRef and passwordFromFile can be set", user.User))
+		}
+		if user.PasswordFromFile != "" && !filepath.IsAbs(user.PasswordFromFile) {
+			problems = append(problems, fmt.Sprintf("user %s: passwordFromFile must be an absolute path", user.User))
+		}
+	}
+	if path := foo.Spec.SuperuserPasswordFromFile; path != "" && !filepath.IsAbs(path) {
+		problems = append(problems, "spec.superuserPasswordFromFile must be an absolute path")
+	}
+	return problems
+}
+
+// getSuperuserPasswordSource returns the password the superuser Secret is
+// created with, read from Spec.SuperuserPasswordFromFile if set or else
+// generated.
+func getSuperuserPasswordSource(foo *postgresv1.Postgres) (string, error) {
+	if foo.Spec.SuperuserPasswordFromFile != "" {
+		return readPasswordFile(foo.Spec.SuperuserPasswordFromFile)
+	}
+	return generatePassword()
+}
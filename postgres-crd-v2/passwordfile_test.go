@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func writePasswordFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestResolveUsersFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Controller{kubeclientset: fake.NewSimpleClientset()}
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{
+		{User: "app", PasswordFromFile: writePasswordFile(t, dir, "app", "fromfile\n")},
+	}
+	users, generated, err := c.resolveUsers(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(generated) > 0 {
+		t.Errorf("expected no generated secrets, got %v", generated)
+	}
+	if users[0].Password != "fromfile" {
+		t.Errorf("expected the password of the file without its newline, got %q", users[0].Password)
+	}
+	if statusUsers := getStatusUsers(users); statusUsers[0].Password != hashPassword("fromfile") {
+		t.Errorf("expected the password of a file to be hashed, got %s", statusUsers[0].Password)
+	}
+
+	foo.Spec.Users[0].PasswordFromFile = filepath.Join(dir, "missing")
+	if _, _, err := c.resolveUsers(foo); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	foo.Spec.Users[0].PasswordFromFile = writePasswordFile(t, dir, "empty", "\n")
+	if _, _, err := c.resolveUsers(foo); err == nil {
+		t.Errorf("expected an error for an empty file")
+	}
+}
+
+func TestSuperuserSecretFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Controller{kubeclientset: fake.NewSimpleClientset()}
+	foo := newTestPostgres(nil)
+	foo.Spec.SuperuserPasswordFromFile = writePasswordFile(t, dir, "superuser", "s3cret")
+	if err := c.createSuperuserSecret(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets("default").Get(getSuperuserSecretName(foo), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if password := string(secret.Data[CREDENTIALS_PASSWORD_KEY]); password != "s3cret" {
+		t.Errorf("expected the password of the file, got %s", password)
+	}
+}
+
+func TestRotateSuperuserPasswordFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	foo := newTestPostgres(nil)
+	foo.Spec.PasswordRotation = PASSWORD_ROTATION_ON_SECRET_CHANGE
+	foo.Spec.SuperuserPasswordFromFile = writePasswordFile(t, dir, "superuser", "newpass")
+	c, executor, _ := newRotationTestController(foo, map[string][]byte{
+		CREDENTIALS_PASSWORD_KEY: []byte("oldpass"),
+		APPLIED_PASSWORD_KEY:     []byte("oldpass"),
+	}, "oldpass")
+
+	endpoint := &dbEndpoint{Password: "oldpass"}
+	if _, err := c.rotateSuperuserPassword(foo, endpoint); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(executor.commands) == 0 {
+		t.Errorf("expected the password to be rotated")
+	}
+	if endpoint.Password != "newpass" {
+		t.Errorf("expected the endpoint to connect with the new password, got %s", endpoint.Password)
+	}
+	secret, _ := c.kubeclientset.CoreV1().Secrets("default").Get(getSuperuserSecretName(foo), metav1.GetOptions{})
+	if password := string(secret.Data[CREDENTIALS_PASSWORD_KEY]); password != "newpass" {
+		t.Errorf("expected the password of the file to be copied to the secret, got %s", password)
+	}
+}
+
+func TestValidatePasswordSources(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{
+		{User: "app", PasswordSecretRef: "app-password", PasswordFromFile: "/etc/passwords/app"},
+		{User: "report", PasswordFromFile: "passwords/report"},
+		{User: "devdatta", PasswordFromFile: "/etc/passwords/devdatta"},
+	}
+	if problems := validatePasswordSources(foo); len(problems) != 2 {
+		t.Errorf("expected 2 problems, got %v", problems)
+	}
+}
+
+func TestSyncQuotesPasswordFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Users = []postgresv1.UserSpec{
+		{User: "app", PasswordFromFile: writePasswordFile(t, dir, "app", "it's a  secret\n")},
+	}
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	f.foos = append(f.foos, foo)
+
+	mock := f.expectConnection()
+	expectExec(mock, "set password_encryption = 'scram-sha-256';")
+	expectExec(mock, "create user \"app\" with password 'it''s a  secret';")
+	expectExec(mock, "create database moodle;")
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	updated := f.getPostgres("client25")
+	for _, command := range updated.Status.ActionHistory {
+		if strings.Contains(command, "secret") {
+			t.Errorf("expected the password to be redacted in the action history, got %q", command)
+		}
+	}
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -36,6 +39,260 @@ type Postgres struct {
 type UserSpec struct {
         User string `json:"username"`
         Password string `json:"password"`
+        // PasswordSecretRef is the name of a Secret whose password key holds
+        // the password. Without a password or a Secret one is generated.
+        PasswordSecretRef string `json:"passwordSecretRef,omitempty"`
+        // PasswordFromFile is the path of a file mounted in the controller's
+        // Pod holding the password, e.g. by an external secret store.
+        PasswordFromFile string `json:"passwordFromFile,omitempty"`
+        Superuser bool `json:"superuser,omitempty"`
+        CreateDB bool `json:"createdb,omitempty"`
+        CreateRole bool `json:"createrole,omitempty"`
+        // Login defaults to true
+        Login *bool `json:"login,omitempty"`
+        // ConnectionLimit defaults to -1, no limit
+        ConnectionLimit *int32 `json:"connectionLimit,omitempty"`
+        Grants []GrantSpec `json:"grants,omitempty"`
+        // Comment is set with comment on role, an empty comment removes it.
+        // The comment of the role is left as is when unset.
+        Comment *string `json:"comment,omitempty"`
+}
+
+// GrantSpec grants privileges on the tables of a schema to a user
+type GrantSpec struct {
+	Database string `json:"database"`
+	// Schema defaults to public
+	Schema string `json:"schema,omitempty"`
+	// Privileges are table privileges, e.g. select, insert or all
+	Privileges []string `json:"privileges"`
+	// Default also grants the privileges on tables created later
+	Default bool `json:"default,omitempty"`
+	// ForRole is the role whose tables created later are covered by a
+	// default grant, e.g. the owner of the database. Defaults to the admin
+	// role.
+	ForRole string `json:"forRole,omitempty"`
+}
+
+// DatabaseSpec describes a database and the options it is created with.
+// Apart from the owner and the connection limit the options cannot be
+// changed once the database exists.
+type DatabaseSpec struct {
+	Name string `json:"name"`
+	// Owner is a role, usually one of Users, that owns the database
+	Owner string `json:"owner,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	LCCollate string `json:"lcCollate,omitempty"`
+	LCCtype string `json:"lcCtype,omitempty"`
+	// Template defaults to template0 when Encoding, LCCollate or LCCtype
+	// is set
+	Template string `json:"template,omitempty"`
+	// ConnectionLimit caps the concurrent connections to the database, -1
+	// for no limit. The limit of the database is left as is when unset.
+	ConnectionLimit *int32 `json:"connectionLimit,omitempty"`
+	// Schemas are created within the database
+	Schemas []SchemaSpec `json:"schemas,omitempty"`
+	// RevokePublicAccess revokes connect on the database and create on its
+	// public schema from public once, so that only roles granted access
+	// can use it
+	RevokePublicAccess bool `json:"revokePublicAccess,omitempty"`
+	// Comment is set with comment on database, an empty comment removes
+	// it. The comment of the database is left as is when unset.
+	Comment *string `json:"comment,omitempty"`
+}
+
+// SchemaSpec describes a schema within a database
+type SchemaSpec struct {
+	Name string `json:"name"`
+	// Owner is a role that owns the schema, defaults to the superuser
+	Owner string `json:"owner,omitempty"`
+}
+
+// UnmarshalJSON accepts the plain database name used by earlier versions
+// of the resource as well as the object form.
+func (d *DatabaseSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*d = DatabaseSpec{Name: name}
+		return nil
+	}
+	type databaseSpec DatabaseSpec
+	return json.Unmarshal(data, (*databaseSpec)(d))
+}
+
+// CommandSpec is a group of setup statements run against one database
+type CommandSpec struct {
+	// Database the statements run against, the first of Databases if empty
+	Database string `json:"database,omitempty"`
+	Statements []string `json:"statements"`
+}
+
+// UnmarshalJSON accepts the plain statement used by earlier versions of the
+// resource, which targets the first database, as well as the object form.
+func (c *CommandSpec) UnmarshalJSON(data []byte) error {
+	var statement string
+	if err := json.Unmarshal(data, &statement); err == nil {
+		*c = CommandSpec{Statements: []string{statement}}
+		return nil
+	}
+	type commandSpec CommandSpec
+	return json.Unmarshal(data, (*commandSpec)(c))
+}
+
+// StorageSpec describes the persistent volume backing the data directory
+type StorageSpec struct {
+	Size string `json:"size"`
+	StorageClassName string `json:"storageClassName"`
+	// PGDataSubdir is the subdirectory of the volume mount that PGDATA
+	// points to. Defaults to "pgdata".
+	PGDataSubdir string `json:"pgdataSubdir"`
+	// SubPath of the volume mounted as the data directory instead of the
+	// whole volume, so that its lost+found is not visible. PGDATA points to
+	// the mount, /var/lib/postgresql/data/<subPath>.
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// TablespaceSpec describes a tablespace on a volume of its own
+type TablespaceSpec struct {
+	Name string `json:"name"`
+	// MountPath of the volume, defaults to /var/lib/postgresql/tablespaces/<name>
+	MountPath string `json:"mountPath,omitempty"`
+	Size string `json:"size"`
+	StorageClassName string `json:"storageClassName,omitempty"`
+}
+
+// ExternalEndpointSpec points to a Postgres instance not created by the controller
+type ExternalEndpointSpec struct {
+	Host string `json:"host"`
+	Port int32 `json:"port"`
+	// AdminSecretRef is the name of a Secret with 'username' and 'password'
+	// keys used by the controller to connect to the instance
+	AdminSecretRef string `json:"adminSecretRef"`
+	// Hosts are further candidate hosts, each a host or host:port. The
+	// first of Host and Hosts that is not in recovery is used as the
+	// primary, so that databases and users follow a failover.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// SSLSpec references the certificates used for connections to Postgres
+type SSLSpec struct {
+	// CASecretRef is the name of a Secret with a 'ca.crt' key used to
+	// verify the server certificate
+	CASecretRef string `json:"caSecretRef"`
+	// ClientCertSecretRef is the name of a Secret with 'tls.crt' and
+	// 'tls.key' keys the controller authenticates with. The sslMode then
+	// defaults to verify-full and the admin password may be omitted.
+	ClientCertSecretRef string `json:"clientCertSecretRef,omitempty"`
+}
+
+// TLSSpec configures the server side of TLS connections to the instance
+type TLSSpec struct {
+	// ServerCertSecretRef is the name of a Secret with 'tls.crt' and
+	// 'tls.key' keys, e.g. one issued by cert-manager. Postgres serves them
+	// with ssl=on.
+	ServerCertSecretRef string `json:"serverCertSecretRef"`
+}
+
+// ExtensionSpec is an extension created in one of the databases
+type ExtensionSpec struct {
+	Name string `json:"name"`
+	// Database is the name of a database in Databases
+	Database string `json:"database"`
+	// Version pins the version the extension is created with and updated
+	// to. The default version of the installed package is used when empty.
+	// In the status it is the installed version, when known.
+	Version string `json:"version,omitempty"`
+}
+
+// MonitoringSpec controls the postgres_exporter sidecar
+type MonitoringSpec struct {
+	Enabled bool `json:"enabled"`
+}
+
+// PoolerSpec configures a PgBouncer sidecar in front of Postgres
+type PoolerSpec struct {
+	// Mode is the pool_mode, session (default) or transaction
+	Mode string `json:"mode"`
+	MaxClientConns int32 `json:"maxClientConns"`
+	DefaultPoolSize int32 `json:"defaultPoolSize"`
+}
+
+// BackupSpec configures scheduled pg_dump backups to object storage
+type BackupSpec struct {
+	// Schedule is a cron expression
+	Schedule string `json:"schedule"`
+	// Bucket is an s3:// or gs:// URL
+	Bucket string `json:"bucket"`
+	// CredentialsSecretRef is the name of a Secret with the object storage
+	// credentials: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for S3 or
+	// key.json for GCS
+	CredentialsSecretRef string `json:"credentialsSecretRef"`
+	// Retention is the number of backups to keep. Defaults to 7.
+	Retention int32 `json:"retention"`
+	Image string `json:"image"`
+}
+
+// WALArchiveSpec configures continuous archiving of the write-ahead log and
+// periodic base backups to object storage, which allows point-in-time
+// recovery with RestoreSpec.PointInTime.
+type WALArchiveSpec struct {
+	// Bucket is an s3:// or gs:// URL
+	Bucket string `json:"bucket"`
+	// CredentialsSecretRef is the name of a Secret with the object storage
+	// credentials, see BackupSpec
+	CredentialsSecretRef string `json:"credentialsSecretRef"`
+	// BaseBackupIntervalHours is the time between base backups. Defaults to 24.
+	BaseBackupIntervalHours int32 `json:"baseBackupIntervalHours,omitempty"`
+	// Image of the archiver, defaults to the backup image
+	Image string `json:"image,omitempty"`
+}
+
+// RestoreSpec selects the data a new instance is restored from
+type RestoreSpec struct {
+	// Source is a backup run location (e.g. s3://bucket/client25/25771680),
+	// the name of another Postgres resource or, with PointInTime, the WAL
+	// archive location of an instance (e.g. s3://bucket/client25-wal)
+	Source string `json:"source"`
+	// PointInTime is an RFC 3339 timestamp up to which the WAL archive is
+	// replayed on top of the latest base backup taken before it
+	PointInTime string `json:"pointInTime,omitempty"`
+}
+
+// UnmarshalJSON accepts the plain source used by earlier versions of the
+// resource as well as the object form.
+func (r *RestoreSpec) UnmarshalJSON(data []byte) error {
+	var source string
+	if err := json.Unmarshal(data, &source); err == nil {
+		*r = RestoreSpec{Source: source}
+		return nil
+	}
+	type restoreSpec RestoreSpec
+	return json.Unmarshal(data, (*restoreSpec)(r))
+}
+
+// SchedulingSpec constrains the nodes the Postgres Pod can run on
+type SchedulingSpec struct {
+	NodeSelector map[string]string `json:"nodeSelector"`
+	Tolerations []corev1.Toleration `json:"tolerations"`
+	Affinity *corev1.Affinity `json:"affinity"`
+}
+
+// MetadataSpec is added to the Deployment, Service and Pods of the instance
+type MetadataSpec struct {
+	Labels map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ServiceSpec configures the Service exposing the instance, e.g. for the
+// LoadBalancer features of a cloud provider
+type ServiceSpec struct {
+	// Type is NodePort (default) or LoadBalancer
+	Type corev1.ServiceType `json:"type,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+	// NodePort pins the node port of the Postgres port instead of letting
+	// Kubernetes allocate one
+	NodePort int32 `json:"nodePort,omitempty"`
 }
 
 // PostgresSpec is the spec for a Foo resource
@@ -44,20 +301,257 @@ type PostgresSpec struct {
 	Image string `json:"image"`
 	Replicas       *int32 `json:"replicas"`
 	Users []UserSpec `json:"users"`
-	Databases []string `json:"databases"`
-	Commands []string `json:"initcommands"`
+	Databases []DatabaseSpec `json:"databases"`
+	// Extensions are created in their database. Extensions removed from
+	// the list are dropped when AllowDatabaseDeletion is set.
+	Extensions []ExtensionSpec `json:"extensions,omitempty"`
+	Commands []CommandSpec `json:"initcommands"`
+	// Suspend pauses reconciliation of this resource when set to true
+	Suspend bool `json:"suspend"`
+	Storage *StorageSpec `json:"storage"`
+	// SharedInstance is the name of another Postgres resource whose instance
+	// is used to manage the databases and users of this resource
+	SharedInstance string `json:"sharedInstance"`
+	// ExternalEndpoint manages databases and users on an existing instance
+	// instead of creating a Deployment
+	ExternalEndpoint *ExternalEndpointSpec `json:"externalEndpoint"`
+	// SuperuserName is the admin role the controller connects as.
+	// Defaults to "postgres".
+	SuperuserName string `json:"superuserName"`
+	// SSLMode is the sslmode the controller connects with: disable
+	// (default), require, verify-ca or verify-full
+	SSLMode string `json:"sslMode"`
+	SSL *SSLSpec `json:"ssl"`
+	Monitoring *MonitoringSpec `json:"monitoring"`
+	Pooler *PoolerSpec `json:"pooler"`
+	Backup *BackupSpec `json:"backup"`
+	// RestoreFrom is restored once into a newly created instance
+	RestoreFrom *RestoreSpec `json:"restoreFrom"`
+	// SetupFromConfigMapRef is the name of a ConfigMap whose .sql keys are
+	// run in key order after Commands. Each file is applied once.
+	SetupFromConfigMapRef string `json:"setupFromConfigMapRef"`
+	// InitScriptsConfigMapRef is the name of a ConfigMap mounted into
+	// /docker-entrypoint-initdb.d, whose scripts the image runs once when
+	// it initializes the instance
+	InitScriptsConfigMapRef string `json:"initScriptsConfigMapRef,omitempty"`
+	// AllowDatabaseDeletion drops databases removed from Databases. When
+	// false (the default) they are kept and listed in the status.
+	AllowDatabaseDeletion bool `json:"allowDatabaseDeletion"`
+	Scheduling *SchedulingSpec `json:"scheduling"`
+	// PriorityClassName and RuntimeClassName are set on the Pods, e.g. so
+	// that Postgres is not among the first Pods evicted under node pressure
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	RuntimeClassName string `json:"runtimeClassName,omitempty"`
+	TLS *TLSSpec `json:"tls,omitempty"`
+	Metadata *MetadataSpec `json:"metadata"`
+	// ConfigMapRef is the name of a ConfigMap in the default namespace with
+	// a postgresql.conf key (and optionally pg_hba.conf)
+	ConfigMapRef string `json:"configMapRef"`
+	// PasswordEncryption is the method role passwords are stored with:
+	// scram-sha-256 (default, Postgres 10 or later) or md5
+	PasswordEncryption string `json:"passwordEncryption"`
+	// ImagePullSecrets are the names of Secrets used to pull Image
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// Tablespaces are created on volumes of their own mounted into the pod
+	Tablespaces []TablespaceSpec `json:"tablespaces,omitempty"`
+	// AllowTablespaceDeletion drops tablespaces removed from Tablespaces.
+	// Their volumes are kept.
+	AllowTablespaceDeletion bool `json:"allowTablespaceDeletion,omitempty"`
+	WALArchive *WALArchiveSpec `json:"walArchive,omitempty"`
+	// Service is reconciled onto the existing Service on every update
+	Service *ServiceSpec `json:"service,omitempty"`
+	// HealthCheckDatabase is checked by the readiness probe of the Pod
+	// and connected to by the controller before the resource is READY
+	HealthCheckDatabase string `json:"healthCheckDatabase,omitempty"`
+	// Parameters are set with alter system, see tunableParameters for the
+	// settings allowed. Those requiring a restart restart the Pod.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// UseSetupJob runs the database, user and setup commands with psql in
+	// a Job instead of the controller process
+	UseSetupJob bool `json:"useSetupJob,omitempty"`
+	// DryRun computes the commands a sync would run and records them in
+	// Status.PlannedCommands instead of running them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Resources are the compute resources of the Postgres container
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// AllowRestart lets the controller restart the Pod to apply changed
+	// Resources
+	AllowRestart bool `json:"allowRestart,omitempty"`
+	// TerminationGracePeriodSeconds is the time Postgres has to shut down
+	// cleanly when its Pod is deleted. Defaults to 90.
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+	// Env is added to the environment of the Postgres container. Variables
+	// set by the controller, e.g. POSTGRES_PASSWORD, take precedence.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// Args replace the arguments of the Postgres container, e.g. postgres
+	// -c max_connections=200. The options set for ConfigMapRef and
+	// WALArchive are appended to them.
+	Args []string `json:"args,omitempty"`
+	// PasswordRotation is Disabled (default) or OnSecretChange to set the
+	// superuser password in the instance when it changes in its Secret
+	PasswordRotation string `json:"passwordRotation,omitempty"`
+	// SuperuserPasswordFromFile is the path of a file mounted in the
+	// controller's Pod the superuser Secret is created from. Changes of the
+	// file are only applied with PasswordRotation OnSecretChange.
+	SuperuserPasswordFromFile string `json:"superuserPasswordFromFile,omitempty"`
+	// DriftCheckInterval, e.g. 5m, reconciles the resource again at this
+	// interval after each successful sync to revert out-of-band changes.
+	// Suspended resources are not checked.
+	DriftCheckInterval *metav1.Duration `json:"driftCheckInterval,omitempty"`
+	// Renames rename existing databases and roles to the names used in
+	// Databases and Users instead of dropping and re-creating them
+	Renames []RenameSpec `json:"renames,omitempty"`
+	// InjectInto is the name of a Deployment in the namespace of the
+	// instances whose containers get the connection Secret as envFrom,
+	// e.g. to set DATABASE_URL
+	InjectInto string `json:"injectInto,omitempty"`
+	// SecurityContext replaces the default non-root security context of
+	// the Pod and its containers
+	SecurityContext *SecurityContextSpec `json:"securityContext,omitempty"`
+}
+
+// SecurityContextSpec is the security context of the Postgres Pod. Each
+// part left empty gets the default that runs Postgres as non-root.
+type SecurityContextSpec struct {
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+	// Container is applied to every container of the Pod
+	Container *corev1.SecurityContext `json:"container,omitempty"`
+}
+
+// RenameSpec renames a database or role from From to To. It is ignored once
+// From no longer exists.
+type RenameSpec struct {
+	// Kind is Database or Role
+	Kind string `json:"kind"`
+	From string `json:"from"`
+	To string `json:"to"`
 }
 
 // FooStatus is the status for a Foo resource
 type PostgresStatus struct {
 	AvailableReplicas int32 `json:"availableReplicas"`
+	// ReadyReplicas is the number of ready Pods of the Deployment
+	ReadyReplicas int32 `json:"readyReplicas"`
 	ActionHistory []string `json:"actionHistory"`
 	Users []UserSpec `json:"users"`
-	Databases []string `json:"databases"`
+	Databases []DatabaseSpec `json:"databases"`
+	// DatabaseCount is the number of databases, shown by kubectl get
+	DatabaseCount int `json:"databaseCount"`
 	VerifyCmd string `json:"verifyCommand"`
 	ServiceIP string `json:"serviceIP"`
 	ServicePort string `json:"servicePort"`
+	ConnectionString string `json:"connectionString"`
+	SecretName string `json:"secretName"`
 	Status string `json:"status"`
+	// LastError is the error of the last failed reconcile
+	LastError string `json:"lastError,omitempty"`
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+	// RetryCount is the number of consecutive failed reconciles, reset
+	// once a reconcile succeeds
+	RetryCount int `json:"retryCount,omitempty"`
+	Conditions []PostgresCondition `json:"conditions,omitempty"`
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+	LastBackupLocation string `json:"lastBackupLocation,omitempty"`
+	// ServerVersion is the server_version of the running instance
+	ServerVersion string `json:"serverVersion,omitempty"`
+	// TLSEnabled is set when the Pods of the instance serve TLS
+	TLSEnabled bool `json:"tlsEnabled,omitempty"`
+	// Restored is set once Spec.RestoreFrom has been restored
+	Restored bool `json:"restored,omitempty"`
+	// AppliedSetupFiles are the keys of the setup ConfigMap already run
+	AppliedSetupFiles []string `json:"appliedSetupFiles,omitempty"`
+	// OrphanedDatabases were removed from the spec but not dropped
+	OrphanedDatabases []string `json:"orphanedDatabases,omitempty"`
+	// Parameters are the parameters last set with alter system
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// PlannedCommands are the commands the last dry run would have run,
+	// with passwords redacted
+	PlannedCommands []string `json:"plannedCommands,omitempty"`
+	// SuperuserSecret is the Secret holding the password of the admin role
+	SuperuserSecret string `json:"superuserSecret,omitempty"`
+	// CredentialSecrets are the Secrets holding generated user passwords
+	CredentialSecrets []string `json:"credentialSecrets,omitempty"`
+	// Extensions are the extensions created by the controller, including
+	// the ones removed from the spec but not dropped
+	Extensions []ExtensionSpec `json:"extensions,omitempty"`
+	// Tablespaces are the names of the tablespaces created
+	Tablespaces []string `json:"tablespaces,omitempty"`
+	// DatabaseStatuses and UserStatuses are the reconcile state of each
+	// database and user the controller ran commands for, by name
+	DatabaseStatuses map[string]ObjectStatus `json:"databaseStatuses,omitempty"`
+	UserStatuses map[string]ObjectStatus `json:"userStatuses,omitempty"`
+	// AppliedCommandChecksums are the checksums of the initcommands applied
+	// to each database. Unchanged commands are not diffed again.
+	AppliedCommandChecksums map[string]string `json:"appliedCommandChecksums,omitempty"`
+	// LastAdHocResult is the outcome of the last statement run through the
+	// run-once annotation
+	LastAdHocResult *AdHocResult `json:"lastAdHocResult,omitempty"`
+	// CommandResults are the outcomes of the last commands run against
+	// the instance, oldest first
+	CommandResults []CommandResult `json:"commandResults,omitempty"`
+}
+
+// CommandResult is the outcome of a command run against the instance
+type CommandResult struct {
+	// Command is the command with its passwords redacted
+	Command string `json:"command"`
+	Success bool `json:"success"`
+	Error string `json:"error,omitempty"`
+	Time metav1.Time `json:"time"`
+}
+
+// AdHocResult is the outcome of a statement run once through an annotation
+type AdHocResult struct {
+	Statement string `json:"statement"`
+	Database string `json:"database"`
+	Succeeded bool `json:"succeeded"`
+	// Error is the error of the failed statement
+	Error string `json:"error,omitempty"`
+	RunTime metav1.Time `json:"runTime"`
+}
+
+// ObjectState is the reconcile state of a database or user
+type ObjectState string
+
+const (
+	// ObjectPresent means the last command for the object succeeded
+	ObjectPresent ObjectState = "Present"
+	// ObjectPending means the command for the object was not run as an
+	// earlier command failed
+	ObjectPending ObjectState = "Pending"
+	// ObjectFailed means the last command for the object failed
+	ObjectFailed ObjectState = "Failed"
+)
+
+// ObjectStatus is the reconcile state of a database or user
+type ObjectStatus struct {
+	State ObjectState `json:"state"`
+	// Message is the error of the failed command
+	Message string `json:"message,omitempty"`
+	// LastSucceededTime is when a command for the object last succeeded
+	LastSucceededTime *metav1.Time `json:"lastSucceededTime,omitempty"`
+}
+
+type PostgresConditionType string
+
+const (
+	// Ready is True when the instance is up and its databases and users
+	// match the spec
+	Ready PostgresConditionType = "Ready"
+	// Progressing is True while the controller is applying changes
+	Progressing PostgresConditionType = "Progressing"
+	// DegradedDatabase is True when the last command executed against
+	// Postgres failed. The message carries the server error.
+	DegradedDatabase PostgresConditionType = "DegradedDatabase"
+)
+
+// PostgresCondition describes the state of a Postgres resource at a certain point
+type PostgresCondition struct {
+	Type PostgresConditionType `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,152 @@
+// Package connection resolves how to connect to the databases of a Postgres
+// resource from its status and the connection Secret the controller writes.
+// The controller builds the status and the Secret with the same functions,
+// so that the two never drift.
+package connection
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	// Registers the postgres driver used by Open
+	_ "github.com/lib/pq"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// Keys of the connection Secret
+const (
+	HostKey        = "host"
+	PortKey        = "port"
+	DatabaseKey    = "dbname"
+	UsernameKey    = "username"
+	PasswordKey    = "password"
+	DatabaseURLKey = "DATABASE_URL"
+)
+
+// ErrNotReady is returned for a resource whose connection Secret is not
+// recorded in the status yet.
+var ErrNotReady = errors.New("the connection Secret is not recorded in the status yet")
+
+// Info holds everything an application needs to connect to a Postgres
+// instance managed by the controller.
+type Info struct {
+	Host     string
+	Port     string
+	Database string
+	Username string
+	Password string
+	SSLMode  string
+}
+
+// DialHost returns the host as given to lib/pq. An IPv6 address may be
+// written in brackets, e.g. in a URL, but lib/pq adds the brackets itself
+// when dialing.
+func DialHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// ConnectionString returns a libpq keyword/value connection string without
+// the password. This is what gets recorded in the status.
+func (info Info) ConnectionString() string {
+	return fmt.Sprintf("host=%s port=%s dbname=%s user=%s sslmode=%s",
+		DialHost(info.Host), info.Port, info.Database, info.Username, info.SSLMode)
+}
+
+// URL returns a postgres:// URL including the credentials.
+func (info Info) URL() *url.URL {
+	return &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(info.Username, info.Password),
+		Host:     net.JoinHostPort(DialHost(info.Host), info.Port),
+		Path:     "/" + info.Database,
+		RawQuery: "sslmode=" + info.SSLMode,
+	}
+}
+
+// SecretData returns the keys of the connection Secret.
+func (info Info) SecretData() map[string]string {
+	return map[string]string{
+		HostKey:        DialHost(info.Host),
+		PortKey:        info.Port,
+		DatabaseKey:    info.Database,
+		UsernameKey:    info.Username,
+		PasswordKey:    info.Password,
+		DatabaseURLKey: info.URL().String(),
+	}
+}
+
+// ParseConnectionString parses the connection string of the status. It has
+// no password.
+func ParseConnectionString(connectionString string) (Info, error) {
+	var info Info
+	for _, field := range strings.Fields(connectionString) {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return info, fmt.Errorf("invalid connection string %q", connectionString)
+		}
+		switch keyValue[0] {
+		case "host":
+			info.Host = keyValue[1]
+		case "port":
+			info.Port = keyValue[1]
+		case "dbname":
+			info.Database = keyValue[1]
+		case "user":
+			info.Username = keyValue[1]
+		case "sslmode":
+			info.SSLMode = keyValue[1]
+		}
+	}
+	if info.Host == "" || info.Port == "" {
+		return info, fmt.Errorf("connection string %q has no host or port", connectionString)
+	}
+	return info, nil
+}
+
+// Get returns the connection info of the Postgres resource, with the
+// password read from its connection Secret. namespace is the namespace the
+// controller creates instances and their Secrets in, "default" unless it
+// was started with -namespace.
+func Get(kubeclientset kubernetes.Interface, foo *postgresv1.Postgres, namespace string) (Info, error) {
+	if foo.Status.SecretName == "" || foo.Status.ConnectionString == "" {
+		return Info{}, ErrNotReady
+	}
+	info, err := ParseConnectionString(foo.Status.ConnectionString)
+	if err != nil {
+		return info, err
+	}
+	secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(foo.Status.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return info, err
+	}
+	info.Password = getSecretValue(secret, PasswordKey)
+	return info, nil
+}
+
+// Open returns a database handle for the Postgres resource. As with
+// sql.Open no connection is made until it is used.
+func Open(kubeclientset kubernetes.Interface, foo *postgresv1.Postgres, namespace string) (*sql.DB, error) {
+	info, err := Get(kubeclientset, foo, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("postgres", info.URL().String())
+}
+
+func getSecretValue(secret *apiv1.Secret, key string) string {
+	if value, ok := secret.Data[key]; ok {
+		return string(value)
+	}
+	return secret.StringData[key]
+}
@@ -0,0 +1,42 @@
+// Package version holds the build information of the controller. The
+// variables are set at build time with ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/version.gitVersion=v0.2.0"
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var (
+	gitVersion = "unknown"
+	gitCommit  = "unknown"
+	// buildDate is in ISO8601 format, e.g. $(date -u +'%Y-%m-%dT%H:%M:%SZ')
+	buildDate = "unknown"
+)
+
+// Info is the build information served at /version.
+type Info struct {
+	GitVersion string `json:"gitVersion"`
+	GitCommit  string `json:"gitCommit"`
+	BuildDate  string `json:"buildDate"`
+	GoVersion  string `json:"goVersion"`
+	Platform   string `json:"platform"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		GitVersion: gitVersion,
+		GitCommit:  gitCommit,
+		BuildDate:  buildDate,
+		GoVersion:  runtime.Version(),
+		Platform:   fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+	}
+}
+
+func (info Info) String() string {
+	return fmt.Sprintf("version %s, commit %s, built %s with %s for %s", info.GitVersion,
+		info.GitCommit, info.BuildDate, info.GoVersion, info.Platform)
+}
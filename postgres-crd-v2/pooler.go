@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiutil "k8s.io/apimachinery/pkg/util/intstr"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/connection"
+)
+
+const (
+	POOLER_IMAGE          = "edoburu/pgbouncer:1.9.0"
+	POOLER_CONTAINER_NAME = "pgbouncer"
+	POOLER_PORT           = 6432
+	POOLER_PORT_NAME      = "pgbouncer"
+	POOLER_CONFIG_PATH    = "/etc/pgbouncer"
+	POOLER_VOLUME_NAME    = "pgbouncer-config"
+
+	DEFAULT_POOL_MODE        = "session"
+	DEFAULT_MAX_CLIENT_CONNS = 100
+	DEFAULT_POOL_SIZE        = 20
+)
+
+var poolModes = []string{"session", "transaction"}
+
+func isPoolerEnabled(foo *postgresv1.Postgres) bool {
+	return foo.Spec.Pooler != nil
+}
+
+func getPoolerSecretName(deploymentName string) string {
+	return deploymentName + "-pgbouncer"
+}
+
+func validatePoolMode(mode string) error {
+	if mode == "" || contains(poolModes, mode) {
+		return nil
+	}
+	return fmt.Errorf("invalid pooler mode %q, must be one of %v", mode, poolModes)
+}
+
+// getPgbouncerIni renders pgbouncer.ini. PgBouncer runs in the same Pod so
+// all databases are forwarded to localhost.
+func getPgbouncerIni(pooler *postgresv1.PoolerSpec) string {
+	mode := pooler.Mode
+	if mode == "" {
+		mode = DEFAULT_POOL_MODE
+	}
+	maxClientConns := pooler.MaxClientConns
+	if maxClientConns == 0 {
+		maxClientConns = DEFAULT_MAX_CLIENT_CONNS
+	}
+	defaultPoolSize := pooler.DefaultPoolSize
+	if defaultPoolSize == 0 {
+		defaultPoolSize = DEFAULT_POOL_SIZE
+	}
+
+	lines := []string{
+		"[databases]",
+		"* = host=127.0.0.1 port=5432",
+		"",
+		"[pgbouncer]",
+		"listen_addr = 0.0.0.0",
+		fmt.Sprintf("listen_port = %d", POOLER_PORT),
+		"auth_type = md5",
+		"auth_file = " + path.Join(POOLER_CONFIG_PATH, "userlist.txt"),
+		"pool_mode = " + mode,
+		fmt.Sprintf("max_client_conn = %d", maxClientConns),
+		fmt.Sprintf("default_pool_size = %d", defaultPoolSize),
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// getUserlist renders userlist.txt from the managed users. Double quotes
+// are escaped by doubling them.
+func getUserlist(users []postgresv1.UserSpec) string {
+	var lines []string
+	for _, user := range users {
+		lines = append(lines, fmt.Sprintf("\"%s\" \"%s\"",
+			strings.Replace(strings.ToLower(user.User), "\"", "\"\"", -1),
+			strings.Replace(user.Password, "\"", "\"\"", -1)))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// createOrUpdatePoolerSecret writes pgbouncer.ini and userlist.txt into the
+// Secret mounted by the PgBouncer container.
+func createOrUpdatePoolerSecret(foo *postgresv1.Postgres, users []postgresv1.UserSpec, c *Controller) error {
+	secretName := getPoolerSecretName(foo.Spec.DeploymentName)
+	secretsClient := c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace())
+
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName,
+			Labels: map[string]string{
+				"app": foo.Spec.DeploymentName,
+			},
+		},
+		Type: apiv1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"pgbouncer.ini": getPgbouncerIni(foo.Spec.Pooler),
+			"userlist.txt":  getUserlist(users),
+		},
+	}
+
+	current, err := secretsClient.Get(secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		fmt.Printf("Creating secret %s...\n", secretName)
+		_, err = secretsClient.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	secretCopy := current.DeepCopy()
+	secretCopy.Data = nil
+	secretCopy.StringData = secret.StringData
+	_, err = secretsClient.Update(secretCopy)
+	return err
+}
+
+func addPooler(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if !isPoolerEnabled(foo) {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+		Name: POOLER_VOLUME_NAME,
+		VolumeSource: apiv1.VolumeSource{
+			Secret: &apiv1.SecretVolumeSource{
+				SecretName: getPoolerSecretName(foo.Spec.DeploymentName),
+			},
+		},
+	})
+	podSpec.Containers = append(podSpec.Containers, apiv1.Container{
+		Name:  POOLER_CONTAINER_NAME,
+		Image: POOLER_IMAGE,
+		Ports: []apiv1.ContainerPort{
+			{
+				Name:          POOLER_PORT_NAME,
+				ContainerPort: POOLER_PORT,
+			},
+		},
+		VolumeMounts: []apiv1.VolumeMount{
+			{
+				Name:      POOLER_VOLUME_NAME,
+				MountPath: POOLER_CONFIG_PATH,
+				ReadOnly:  true,
+			},
+		},
+	})
+}
+
+func addPoolerPort(serviceSpec *apiv1.ServiceSpec, foo *postgresv1.Postgres) {
+	if !isPoolerEnabled(foo) {
+		return
+	}
+	serviceSpec.Ports = append(serviceSpec.Ports, apiv1.ServicePort{
+		Name:       POOLER_PORT_NAME,
+		Port:       POOLER_PORT,
+		TargetPort: apiutil.FromInt(POOLER_PORT),
+		Protocol:   apiv1.ProtocolTCP,
+	})
+}
+
+// usePooler points the connection info at the node port of the pooler, so
+// that clients go through PgBouncer instead of connecting directly.
+func usePooler(foo *postgresv1.Postgres, c *Controller, info *connection.Info) error {
+	if !isPoolerEnabled(foo) {
+		return nil
+	}
+	service, err := c.kubeclientset.CoreV1().Services(c.getInstanceNamespace()).Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Name == POOLER_PORT_NAME {
+			info.Port = fmt.Sprint(port.NodePort)
+			return nil
+		}
+	}
+	return fmt.Errorf("service %s has no %s port", foo.Spec.DeploymentName, POOLER_PORT_NAME)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestPgbouncerIni(t *testing.T) {
+	ini := getPgbouncerIni(&postgresv1.PoolerSpec{})
+	for _, expected := range []string{"listen_port = 6432", "pool_mode = session",
+		"max_client_conn = 100", "default_pool_size = 20", "auth_file = /etc/pgbouncer/userlist.txt"} {
+		if !strings.Contains(ini, expected) {
+			t.Errorf("expected %q in\n%s", expected, ini)
+		}
+	}
+
+	ini = getPgbouncerIni(&postgresv1.PoolerSpec{Mode: "transaction", MaxClientConns: 500, DefaultPoolSize: 5})
+	for _, expected := range []string{"pool_mode = transaction", "max_client_conn = 500", "default_pool_size = 5"} {
+		if !strings.Contains(ini, expected) {
+			t.Errorf("expected %q in\n%s", expected, ini)
+		}
+	}
+}
+
+func TestUserlist(t *testing.T) {
+	users := []postgresv1.UserSpec{
+		{User: "devdatta", Password: "pass123"},
+		{User: "Bob", Password: `pa"ss`},
+	}
+	expected := "\"devdatta\" \"pass123\"\n\"bob\" \"pa\"\"ss\"\n"
+	if got := getUserlist(users); got != expected {
+		t.Errorf("expected %q\ngot %q", expected, got)
+	}
+}
+
+func TestPoolerSidecar(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Pooler = &postgresv1.PoolerSpec{Mode: "transaction"}
+
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	if len(podSpec.Containers) != 2 || podSpec.Containers[1].Name != POOLER_CONTAINER_NAME {
+		t.Fatalf("expected pgbouncer sidecar, got %v", podSpec.Containers)
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].Secret.SecretName != "client25-pgbouncer" {
+		t.Errorf("expected volume from secret client25-pgbouncer, got %v", podSpec.Volumes)
+	}
+	ports := getService(foo).Spec.Ports
+	if len(ports) != 2 || ports[1].Port != POOLER_PORT {
+		t.Errorf("expected pooler port on the service, got %v", ports)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// ErrDatabaseCommand is used as part of the Event 'reason' when a
+	// command executed against Postgres fails.
+	ErrDatabaseCommand = "ErrDatabaseCommand"
+)
+
+// commandError is returned by setupDatabase when executing a command fails.
+type commandError struct {
+	Command string
+	Err     error
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("database command failed: %s", describeDatabaseError(e.Err))
+}
+
+// describeDatabaseError renders the code, message, detail, hint and position
+// reported by the Postgres server. Other errors are returned as is.
+func describeDatabaseError(err error) string {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return err.Error()
+	}
+	parts := []string{fmt.Sprintf("%s (code %s)", pqErr.Message, pqErr.Code)}
+	if pqErr.Detail != "" {
+		parts = append(parts, "detail: "+pqErr.Detail)
+	}
+	if pqErr.Hint != "" {
+		parts = append(parts, "hint: "+pqErr.Hint)
+	}
+	if pqErr.Position != "" {
+		parts = append(parts, "position: "+pqErr.Position)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// getDatabaseErrorReason returns the pq error code name (e.g. syntax_error)
+// to be used as the condition reason.
+func getDatabaseErrorReason(err error) string {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() != "" {
+		return pqErr.Code.Name()
+	}
+	return ErrDatabaseCommand
+}
+
+// recordDatabaseError surfaces a failed database command as a Warning event
+// and a DegradedDatabase condition on the Postgres resource. Errors
+// that did not come from Postgres are ignored here.
+func (c *Controller) recordDatabaseError(foo *postgresv1.Postgres, err error) {
+	cmdErr, ok := err.(*commandError)
+	if !ok {
+		return
+	}
+	message := describeDatabaseError(cmdErr.Err)
+	c.recorder.Event(foo, corev1.EventTypeWarning, ErrDatabaseCommand,
+		fmt.Sprintf("%s failed: %s", redactCommand(cmdErr.Command), message))
+
+	condition := newCondition(postgresv1.DegradedDatabase, corev1.ConditionTrue,
+		getDatabaseErrorReason(cmdErr.Err), message)
+	// Re-read the resource as the status may have been updated during this sync
+	latest, getErr := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(foo.Name, metav1.GetOptions{})
+	if getErr != nil {
+		runtime.HandleError(getErr)
+		return
+	}
+	fooCopy := latest.DeepCopy()
+	setCondition(&fooCopy.Status, condition)
+	updateErr := c.patchStatus(latest, fooCopy)
+	if updateErr != nil {
+		runtime.HandleError(updateErr)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned/fake"
+)
+
+func TestRecordDatabaseErrorSurfacesPQDetail(t *testing.T) {
+	foo := newTestPostgres(nil)
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		sampleclientset: fake.NewSimpleClientset(foo),
+		recorder:        recorder,
+	}
+
+	// What lib/pq returns for "creat database moodle;"
+	err := &commandError{
+		Command: "creat database moodle;",
+		Err: &pq.Error{
+			Severity: "ERROR",
+			Code:     "42601",
+			Message:  `syntax error at or near "creat"`,
+			Position: "1",
+		},
+	}
+	c.recordDatabaseError(foo, err)
+
+	select {
+	case event := <-recorder.Events:
+		for _, expected := range []string{corev1.EventTypeWarning, ErrDatabaseCommand,
+			`syntax error at or near "creat"`, "code 42601", "position: 1"} {
+			if !strings.Contains(event, expected) {
+				t.Errorf("expected event %q to contain %q", event, expected)
+			}
+		}
+	default:
+		t.Fatalf("expected a warning event to be recorded")
+	}
+
+	updated, getErr := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Get(foo.Name, metav1.GetOptions{})
+	if getErr != nil {
+		t.Fatalf("unexpected error: %v", getErr)
+	}
+	condition := getCondition(&updated.Status, postgresv1.DegradedDatabase)
+	if condition == nil {
+		t.Fatalf("expected %s condition, got %v", postgresv1.DegradedDatabase, updated.Status.Conditions)
+	}
+	if condition.Status != corev1.ConditionTrue {
+		t.Errorf("expected %s=True, got %s", postgresv1.DegradedDatabase, condition.Status)
+	}
+	if condition.Reason != "syntax_error" {
+		t.Errorf("expected reason syntax_error, got %s", condition.Reason)
+	}
+	if !strings.Contains(condition.Message, "code 42601") {
+		t.Errorf("expected condition message to contain the error code, got %q", condition.Message)
+	}
+}
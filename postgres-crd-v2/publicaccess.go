@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getRevokePublicAccessStatements renders the revokes of the privileges
+// every role has on a new database: connecting to it and creating objects
+// in its public schema.
+func getRevokePublicAccessStatements(dbname string) []string {
+	return []string{
+		fmt.Sprintf("revoke connect on database \"%s\" from public;", dbname),
+		"revoke create on schema public from public;",
+	}
+}
+
+// getRevokePublicAccessCommands returns the commands revoking the public
+// access of the databases with RevokePublicAccess set that are new or were
+// not revoked yet according to the status. The revoke on the public schema
+// needs a connection to the database, so the commands of each database are
+// preceded by a connect command. Unsetting the flag does not grant the
+// access again.
+func getRevokePublicAccessCommands(desired []postgresv1.DatabaseSpec, status []postgresv1.DatabaseSpec,
+	current []string) []string {
+	var commands []string
+	for _, db := range desired {
+		if !db.RevokePublicAccess {
+			continue
+		}
+		if contains(current, db.Name) {
+			if statusDB, ok := findDatabase(status, db.Name); ok && statusDB.RevokePublicAccess {
+				continue
+			}
+		}
+		commands = append(commands, getConnectCommand(db.Name))
+		appendList(&commands, getRevokePublicAccessStatements(db.Name))
+	}
+	return commands
+}
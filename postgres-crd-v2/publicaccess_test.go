@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestGetRevokePublicAccessCommands(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{
+		{Name: "moodle", RevokePublicAccess: true},
+		{Name: "wordpress", RevokePublicAccess: true},
+		{Name: "blog"},
+		{Name: "lms", RevokePublicAccess: true},
+	}
+	// moodle was revoked before, wordpress only gets the flag now and lms
+	// is new
+	status := []postgresv1.DatabaseSpec{{Name: "moodle", RevokePublicAccess: true}, {Name: "wordpress"}, {Name: "blog"}}
+	current := []string{"moodle", "wordpress", "blog"}
+
+	commands := getRevokePublicAccessCommands(desired, status, current)
+	expected := []string{
+		"\\c wordpress;",
+		"revoke connect on database \"wordpress\" from public;",
+		"revoke create on schema public from public;",
+		"\\c lms;",
+		"revoke connect on database \"lms\" from public;",
+		"revoke create on schema public from public;",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+
+	// The applied flag is recorded so that the revokes are not run again
+	applied := getAppliedDatabases(desired, status, current)
+	if commands := getRevokePublicAccessCommands(desired, applied, append(current, "lms")); len(commands) != 0 {
+		t.Errorf("expected no commands once recorded, got %v", commands)
+	}
+
+	// A re-created database is revoked again
+	if commands := getRevokePublicAccessCommands(desired[:1], status, nil); len(commands) != 3 {
+		t.Errorf("expected the revokes of the re-created database, got %v", commands)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// DeploymentRecreated is used as part of the Event 'reason' when a
+	// Deployment deleted out-of-band is created again.
+	DeploymentRecreated = "DeploymentRecreated"
+)
+
+// isCreated returns true once the instance of foo has been set up. A missing
+// Deployment was then deleted out-of-band rather than never created.
+func isCreated(foo *postgresv1.Postgres) bool {
+	return foo.Status.ServiceIP != ""
+}
+
+// recreateDeployment creates the Deployment, and the Service if it is
+// missing as well, of an instance that was set up before. The databases and
+// users are then reconciled against the live state like on any update, so
+// they are re-created if the data was not kept on a volume.
+func (c *Controller) recreateDeployment(foo *postgresv1.Postgres) error {
+	deploymentName := foo.Spec.DeploymentName
+	deployment := getDeployment(foo)
+	err := c.addConfigHash(deployment, foo)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-creating deployment %s...\n", deploymentName)
+	_, err = c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace()).Create(deployment)
+	// The informer cache may not have seen a Deployment created by the last sync
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	serviceClient := c.kubeclientset.CoreV1().Services(c.getInstanceNamespace())
+	_, err = serviceClient.Get(deploymentName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// Keep the NodePort recorded in the status so that the endpoint
+		// and the connection Secret stay valid, unless one is pinned
+		service := getService(foo)
+		if nodePort, convErr := strconv.Atoi(foo.Status.ServicePort); convErr == nil && service.Spec.Ports[0].NodePort == 0 {
+			service.Spec.Ports[0].NodePort = int32(nodePort)
+		}
+		fmt.Printf("Re-creating service %s...\n", deploymentName)
+		_, err = serviceClient.Create(service)
+		err = c.checkNodePortConflict(foo, err)
+	}
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	c.recorder.Event(foo, apiv1.EventTypeWarning, DeploymentRecreated,
+		fmt.Sprintf("Deployment %s was deleted and has been re-created", deploymentName))
+	return waitForPods(c, deploymentName)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Values of RenameSpec.Kind
+	RENAME_KIND_DATABASE = "Database"
+	RENAME_KIND_ROLE     = "Role"
+)
+
+// validateRenames checks that each rename moves a name that is no longer in
+// the spec to one that is, so that the diff does not drop or re-create it.
+func validateRenames(foo *postgresv1.Postgres) []string {
+	var problems []string
+	databases := getDatabaseNames(foo.Spec.Databases)
+	var users []string
+	for _, user := range foo.Spec.Users {
+		users = append(users, user.User)
+	}
+	seen := map[string]bool{}
+	for _, rename := range foo.Spec.Renames {
+		var names []string
+		switch rename.Kind {
+		case RENAME_KIND_DATABASE:
+			names = databases
+		case RENAME_KIND_ROLE:
+			names = users
+		default:
+			problems = append(problems, fmt.Sprintf("invalid rename kind %q, must be %s or %s", rename.Kind,
+				RENAME_KIND_DATABASE, RENAME_KIND_ROLE))
+			continue
+		}
+		if !identifierPattern.MatchString(rename.From) || !identifierPattern.MatchString(rename.To) {
+			problems = append(problems, fmt.Sprintf("rename %s %s to %s: invalid name", rename.Kind, rename.From, rename.To))
+			continue
+		}
+		if contains(names, rename.From) {
+			problems = append(problems, fmt.Sprintf("rename %s %s to %s: %s is still in the spec",
+				rename.Kind, rename.From, rename.To, rename.From))
+		}
+		if !contains(names, rename.To) {
+			problems = append(problems, fmt.Sprintf("rename %s %s to %s: %s is not in the spec",
+				rename.Kind, rename.From, rename.To, rename.To))
+		}
+		if rename.Kind == RENAME_KIND_ROLE && rename.From == getSuperuserName(foo) {
+			problems = append(problems, fmt.Sprintf("the superuser %s cannot be renamed", rename.From))
+		}
+		if seen[rename.Kind+"/"+rename.From] {
+			problems = append(problems, fmt.Sprintf("%s %s is renamed more than once", rename.Kind, rename.From))
+		}
+		seen[rename.Kind+"/"+rename.From] = true
+	}
+	return problems
+}
+
+// getRenameCommands returns the commands renaming the databases and roles of
+// Spec.Renames whose old name still exists and whose new name does not yet.
+// The names in liveDatabases, liveRoles and the status are renamed in place,
+// so that they are diffed against the spec as if the renames had run. Once
+// a rename has run, its old name is gone and it is ignored.
+func getRenameCommands(foo *postgresv1.Postgres, liveDatabases []string, liveRoles []string,
+	status *postgresv1.PostgresStatus) []string {
+	var cmdList []string
+	for _, rename := range foo.Spec.Renames {
+		switch rename.Kind {
+		case RENAME_KIND_DATABASE:
+			if !renameName(liveDatabases, rename.From, rename.To) {
+				continue
+			}
+			renameStatusDatabase(status, rename.From, rename.To)
+			cmdList = append(cmdList, "alter database "+rename.From+" rename to "+rename.To+";")
+		case RENAME_KIND_ROLE:
+			if !renameName(liveRoles, rename.From, rename.To) {
+				continue
+			}
+			renameStatusRole(status, rename.From, rename.To, getPasswordEncryption(foo))
+			cmdList = append(cmdList, "alter role "+rename.From+" rename to "+rename.To+";")
+		}
+	}
+	fmt.Printf("RenameCmds: %v\n", cmdList)
+	return cmdList
+}
+
+// renameName replaces from by to in names unless to already exists.
+func renameName(names []string, from string, to string) bool {
+	if contains(names, to) {
+		return false
+	}
+	for i, name := range names {
+		if name == from {
+			names[i] = to
+			return true
+		}
+	}
+	return false
+}
+
+func renameStatusDatabase(status *postgresv1.PostgresStatus, from string, to string) {
+	for i := range status.Databases {
+		if status.Databases[i].Name == from {
+			status.Databases[i].Name = to
+		}
+	}
+	renameName(status.OrphanedDatabases, from, to)
+	for i := range status.Users {
+		for j := range status.Users[i].Grants {
+			if status.Users[i].Grants[j].Database == from {
+				status.Users[i].Grants[j].Database = to
+			}
+		}
+	}
+	if checksum, ok := status.AppliedCommandChecksums[from]; ok {
+		delete(status.AppliedCommandChecksums, from)
+		status.AppliedCommandChecksums[to] = checksum
+	}
+}
+
+// renameStatusRole renames the user and the objects it owns. Postgres clears
+// an md5 password on rename as the name is part of its hash, the password
+// recorded is then cleared too so that it is set again.
+func renameStatusRole(status *postgresv1.PostgresStatus, from string, to string, passwordEncryption string) {
+	for i := range status.Users {
+		if status.Users[i].User != from {
+			continue
+		}
+		status.Users[i].User = to
+		if passwordEncryption == "md5" {
+			status.Users[i].Password = ""
+		}
+	}
+	for i := range status.Databases {
+		if status.Databases[i].Owner == from {
+			status.Databases[i].Owner = to
+		}
+		for j := range status.Databases[i].Schemas {
+			if status.Databases[i].Schemas[j].Owner == from {
+				status.Databases[i].Schemas[j].Owner = to
+			}
+		}
+	}
+}
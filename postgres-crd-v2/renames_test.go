@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestGetRenameCommands(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.PasswordEncryption = "md5"
+	foo.Spec.Renames = []postgresv1.RenameSpec{
+		{Kind: RENAME_KIND_DATABASE, From: "moodle", To: "lms"},
+		{Kind: RENAME_KIND_ROLE, From: "devdatta", To: "dev"},
+		// Already renamed
+		{Kind: RENAME_KIND_DATABASE, From: "blog", To: "wordpress"},
+	}
+	status := &postgresv1.PostgresStatus{
+		Databases: []postgresv1.DatabaseSpec{{Name: "moodle", Owner: "devdatta"}, {Name: "wordpress"}},
+		Users: []postgresv1.UserSpec{{User: "devdatta", Password: "pass123",
+			Grants: []postgresv1.GrantSpec{{Database: "moodle"}}}},
+	}
+	liveDatabases := []string{"postgres", "moodle", "wordpress"}
+	liveRoles := []string{"postgres", "devdatta"}
+
+	commands := getRenameCommands(foo, liveDatabases, liveRoles, status)
+	expected := []string{
+		"alter database moodle rename to lms;",
+		"alter role devdatta rename to dev;",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, commands)
+	}
+	if expected := []string{"postgres", "lms", "wordpress"}; !reflect.DeepEqual(liveDatabases, expected) {
+		t.Errorf("expected live databases %v, got %v", expected, liveDatabases)
+	}
+	if expected := []string{"postgres", "dev"}; !reflect.DeepEqual(liveRoles, expected) {
+		t.Errorf("expected live roles %v, got %v", expected, liveRoles)
+	}
+	if db := status.Databases[0]; db.Name != "lms" || db.Owner != "dev" {
+		t.Errorf("expected the status database to be renamed, got %#v", db)
+	}
+	user := status.Users[0]
+	if user.User != "dev" || user.Grants[0].Database != "lms" {
+		t.Errorf("expected the status user to be renamed, got %#v", user)
+	}
+	if user.Password != "" {
+		t.Errorf("expected the md5 password to be set again, got %s", user.Password)
+	}
+
+	// Nothing is left to rename
+	if commands := getRenameCommands(foo, liveDatabases, liveRoles, status); len(commands) > 0 {
+		t.Errorf("expected no commands once renamed, got %v", commands)
+	}
+}
+
+func TestValidateRenames(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("lms", "moodle")
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "dev"}}
+	foo.Spec.Renames = []postgresv1.RenameSpec{
+		{Kind: RENAME_KIND_DATABASE, From: "blog", To: "lms"},
+		{Kind: RENAME_KIND_DATABASE, From: "moodle", To: "lms"},
+		{Kind: RENAME_KIND_ROLE, From: "postgres", To: "dev"},
+		{Kind: RENAME_KIND_ROLE, From: "devdatta", To: "analyst"},
+		{Kind: "Schema", From: "a", To: "b"},
+	}
+	problems := validateRenames(foo)
+	if len(problems) != 4 {
+		t.Errorf("expected 4 problems, got %v", problems)
+	}
+}
+
+func TestSyncRenamesDatabase(t *testing.T) {
+	f := newFixture(t)
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("lms")
+	foo.Spec.Renames = []postgresv1.RenameSpec{{Kind: RENAME_KIND_DATABASE, From: "moodle", To: "lms"}}
+	foo.Status.Status = "READY"
+	foo.Status.Databases = newDatabaseSpecs("moodle")
+	f.foos = append(f.foos, foo)
+	f.deployments = append(f.deployments, getDeployment(foo))
+	f.deployments[0].Namespace = "default"
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+
+	// Live state
+	mock := f.expectConnection()
+	mock.ExpectQuery("SELECT datname FROM pg_database").
+		WillReturnRows(sqlmock.NewRows([]string{"datname"}).AddRow("postgres").AddRow("moodle"))
+	mock.ExpectQuery("SELECT rolname FROM pg_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname"}).AddRow("postgres"))
+	mock.ExpectClose()
+	// Reconcile, neither dropped nor created
+	mock = f.expectConnection()
+	expectExec(mock, "alter database moodle rename to lms;")
+	mock.ExpectClose()
+
+	f.run("default/client25")
+
+	updated := f.getPostgres("client25")
+	if names := getDatabaseNames(updated.Status.Databases); !reflect.DeepEqual(names, []string{"lms"}) {
+		t.Errorf("expected the renamed database in the status, got %v", names)
+	}
+}
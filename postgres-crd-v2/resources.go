@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// ResourcesUpdated is used as part of the Event 'reason' when the
+	// Deployment is restarted with the changed Spec.Resources.
+	ResourcesUpdated = "ResourcesUpdated"
+	// WarnResourcesRestartBlocked is used as part of the Event 'reason' when
+	// the changed Spec.Resources are not applied as that needs a restart.
+	WarnResourcesRestartBlocked = "ResourcesRestartBlocked"
+)
+
+// getResources returns the compute resources of the Postgres container,
+// none by default.
+func getResources(foo *postgresv1.Postgres) apiv1.ResourceRequirements {
+	if foo.Spec.Resources == nil {
+		return apiv1.ResourceRequirements{}
+	}
+	return *foo.Spec.Resources.DeepCopy()
+}
+
+// addResources sets Spec.Resources on the Postgres container.
+func addResources(deployment *appsv1.Deployment, foo *postgresv1.Postgres) {
+	if container := getPostgresContainer(deployment); container != nil {
+		container.Resources = getResources(foo)
+	}
+}
+
+// syncResources rolls the Deployment to the changed Spec.Resources. As this
+// restarts Postgres it is only done when Spec.AllowRestart is set, and never
+// without persistent storage as the data directory would be lost.
+func (c *Controller) syncResources(foo *postgresv1.Postgres) error {
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
+	deployment, err := deploymentsClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	container := getPostgresContainer(deployment)
+	resources := getResources(foo)
+	// Quantities are compared by value, e.g. 1Gi equals 1024Mi
+	if container == nil || equality.Semantic.DeepEqual(container.Resources, resources) {
+		return nil
+	}
+	if !foo.Spec.AllowRestart {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnResourcesRestartBlocked,
+			fmt.Sprintf("Resources of %s are not changed as spec.allowRestart is not set", foo.Spec.DeploymentName))
+		return nil
+	}
+	if foo.Spec.Storage == nil {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnResourcesRestartBlocked,
+			fmt.Sprintf("Resources of %s are not changed as the data is not on persistent storage",
+				foo.Spec.DeploymentName))
+		return nil
+	}
+
+	fmt.Printf("Resources of %s changed, restarting\n", foo.Spec.DeploymentName)
+	deploymentCopy := deployment.DeepCopy()
+	getPostgresContainer(deploymentCopy).Resources = resources
+	// Two Pods must never run on the same data directory
+	deploymentCopy.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	_, err = deploymentsClient.Update(deploymentCopy)
+	if err != nil {
+		return err
+	}
+	c.recorder.Event(foo, apiv1.EventTypeNormal, ResourcesUpdated,
+		fmt.Sprintf("Restarted %s with the changed resources", foo.Spec.DeploymentName))
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newTestResources(memory string) *apiv1.ResourceRequirements {
+	return &apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse(memory)},
+	}
+}
+
+func TestSyncResourcesUpdatesDeployment(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.Resources = newTestResources("1Gi")
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Resources = newTestResources("2Gi")
+	foo.Spec.AllowRestart = true
+
+	if err := c.syncResources(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment := getTestDeployment(t, c)
+	memory := getPostgresContainer(deployment).Resources.Requests[apiv1.ResourceMemory]
+	if memory.String() != "2Gi" {
+		t.Errorf("expected a memory request of 2Gi, got %s", memory.String())
+	}
+	if deployment.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+		t.Errorf("expected the Recreate strategy, got %s", deployment.Spec.Strategy.Type)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, ResourcesUpdated) {
+		t.Errorf("expected a %s event, got %s", ResourcesUpdated, event)
+	}
+}
+
+func TestSyncResourcesComparesQuantities(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.Resources = newTestResources("1Gi")
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Resources = newTestResources("1024Mi")
+	foo.Spec.AllowRestart = true
+
+	if err := c.syncResources(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no restart for equal quantities, got %s", <-recorder.Events)
+	}
+}
+
+func TestSyncResourcesRequiresAllowRestart(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	c, recorder := newImageTestController(foo)
+	foo.Spec.Resources = newTestResources("2Gi")
+
+	if err := c.syncResources(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests := getPostgresContainer(getTestDeployment(t, c)).Resources.Requests; len(requests) != 0 {
+		t.Errorf("expected the resources to be kept, got %v", requests)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnResourcesRestartBlocked) {
+		t.Errorf("expected a %s event, got %s", WarnResourcesRestartBlocked, event)
+	}
+}
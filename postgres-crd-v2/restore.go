@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const RESTORE_JOB_NAME_POSTFIX = "-restore"
+
+// Restores either the per-database dumps of a backup run (see backupScript)
+// or a live copy of another instance.
+const restoreScript = `set -e
+case "$RESTORE_FROM" in
+  gs://*) gcloud auth activate-service-account --key-file="$GOOGLE_APPLICATION_CREDENTIALS" ;;
+esac
+for db in $DATABASES; do
+  case "$RESTORE_FROM" in
+    s3://*) aws s3 cp "$RESTORE_FROM/$db.sql.gz" - | gunzip | psql -v ON_ERROR_STOP=1 "$db" ;;
+    gs://*) gsutil cp "$RESTORE_FROM/$db.sql.gz" - | gunzip | psql -v ON_ERROR_STOP=1 "$db" ;;
+    *) PGPASSWORD="$SOURCE_PASSWORD" pg_dump -h "$SOURCE_HOST" -U "$SOURCE_USER" "$db" |
+         psql -v ON_ERROR_STOP=1 "$db" ;;
+  esac
+done
+`
+
+func getRestoreJobName(deploymentName string) string {
+	return deploymentName + RESTORE_JOB_NAME_POSTFIX
+}
+
+func isBackupLocation(restoreFrom string) bool {
+	return strings.HasPrefix(restoreFrom, "s3://") || strings.HasPrefix(restoreFrom, "gs://")
+}
+
+func getRestoreJob(foo *postgresv1.Postgres, source *postgresv1.Postgres, namespace string) *batchv1.Job {
+	deploymentName := foo.Spec.DeploymentName
+	image := BACKUP_IMAGE
+	if foo.Spec.Backup != nil && foo.Spec.Backup.Image != "" {
+		image = foo.Spec.Backup.Image
+	}
+	databases := getDatabaseNames(foo.Spec.Databases)
+	if len(databases) == 0 {
+		databases = []string{"postgres"}
+	}
+
+	container := apiv1.Container{
+		Name:    "restore",
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", restoreScript},
+		Env: []apiv1.EnvVar{
+			{Name: "PGHOST", Value: getServiceHost(foo, namespace)},
+			{Name: "PGPORT", Value: "5432"},
+			{Name: "PGUSER", Value: getSuperuserName(foo)},
+			getSuperuserPasswordEnv(foo, "PGPASSWORD"),
+			{Name: "DATABASES", Value: strings.Join(databases, " ")},
+			{Name: "RESTORE_FROM", Value: foo.Spec.RestoreFrom.Source},
+		},
+	}
+	podSpec := apiv1.PodSpec{
+		RestartPolicy: apiv1.RestartPolicyNever,
+	}
+
+	if source != nil {
+		container.Env = append(container.Env,
+			apiv1.EnvVar{Name: "SOURCE_HOST", Value: getServiceHost(source, namespace)},
+			apiv1.EnvVar{Name: "SOURCE_USER", Value: getSuperuserName(source)},
+			getSuperuserPasswordEnv(source, "SOURCE_PASSWORD"))
+	} else if foo.Spec.Backup != nil && foo.Spec.Backup.CredentialsSecretRef != "" {
+		// Backups are read with the credentials used to write them
+		secretRef := foo.Spec.Backup.CredentialsSecretRef
+		container.Env = append(container.Env, apiv1.EnvVar{
+			Name:  "GOOGLE_APPLICATION_CREDENTIALS",
+			Value: BACKUP_CREDENTIALS_PATH + "/key.json",
+		})
+		container.EnvFrom = []apiv1.EnvFromSource{
+			{
+				SecretRef: &apiv1.SecretEnvSource{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: secretRef},
+				},
+			},
+		}
+		container.VolumeMounts = []apiv1.VolumeMount{
+			{
+				Name:      BACKUP_CREDENTIALS_VOLUME,
+				MountPath: BACKUP_CREDENTIALS_PATH,
+				ReadOnly:  true,
+			},
+		}
+		podSpec.Volumes = []apiv1.Volume{
+			{
+				Name: BACKUP_CREDENTIALS_VOLUME,
+				VolumeSource: apiv1.VolumeSource{
+					Secret: &apiv1.SecretVolumeSource{SecretName: secretRef},
+				},
+			},
+		}
+	}
+	podSpec.Containers = []apiv1.Container{container}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getRestoreJobName(deploymentName),
+			Labels: map[string]string{
+				"app": deploymentName,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			// A failed restore is not retried as it may have partially
+			// loaded the data
+			BackoffLimit: int32Ptr(0),
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": deploymentName,
+					},
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// restoreDatabases runs the restore Job against the freshly created instance
+// and waits for it to finish. It is only called while Status.Restored is
+// false; an existing Job of the same name is waited on instead of being
+// started again. A point-in-time restore is done by addPointInTimeRestore
+// before Postgres starts instead.
+func restoreDatabases(foo *postgresv1.Postgres, c *Controller) error {
+	restoreFrom := foo.Spec.RestoreFrom.Source
+	var source *postgresv1.Postgres
+	if !isBackupLocation(restoreFrom) {
+		var err error
+		source, err = c.foosLister.Postgreses(foo.Namespace).Get(restoreFrom)
+		if err != nil {
+			return fmt.Errorf("cannot restore from %s: %v", restoreFrom, err)
+		}
+		if source.Spec.DeploymentName == "" {
+			return fmt.Errorf("cannot restore from %s: it has no instance of its own", restoreFrom)
+		}
+	}
+
+	jobsClient := c.kubeclientset.BatchV1().Jobs(c.getInstanceNamespace())
+	jobName := getRestoreJobName(foo.Spec.DeploymentName)
+	fmt.Printf("Restoring %s from %s...\n", foo.Spec.DeploymentName, restoreFrom)
+	_, err := jobsClient.Create(getRestoreJob(foo, source, c.getInstanceNamespace()))
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	for {
+		job, err := jobsClient.Get(jobName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if job.Status.Succeeded > 0 {
+			fmt.Printf("Restore job %s succeeded.\n", jobName)
+			return nil
+		}
+		if job.Status.Failed > 0 {
+			return fmt.Errorf("restore job %s failed, see its logs", jobName)
+		}
+		fmt.Println("Waiting for restore job to complete.")
+		time.Sleep(time.Second * 4)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestRestoreJobFromBackupLocation(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Spec.RestoreFrom = &postgresv1.RestoreSpec{Source: "s3://backups/client24/25771680"}
+	foo.Spec.Backup = &postgresv1.BackupSpec{CredentialsSecretRef: "aws-credentials"}
+
+	job := getRestoreJob(foo, nil, "default")
+	if job.Name != "client25-restore" || *job.Spec.BackoffLimit != 0 {
+		t.Errorf("expected job client25-restore without retries, got %s %d", job.Name, *job.Spec.BackoffLimit)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if got, _ := getEnv(container, "RESTORE_FROM"); got != foo.Spec.RestoreFrom.Source {
+		t.Errorf("expected RESTORE_FROM %s, got %s", foo.Spec.RestoreFrom.Source, got)
+	}
+	if len(container.EnvFrom) != 1 || container.EnvFrom[0].SecretRef.Name != "aws-credentials" {
+		t.Errorf("expected credentials from aws-credentials, got %v", container.EnvFrom)
+	}
+}
+
+func TestRestoreJobFromPostgres(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.RestoreFrom = &postgresv1.RestoreSpec{Source: "client24"}
+	source := newTestPostgres(nil)
+	source.Name = "client24"
+	source.Spec.DeploymentName = "client24"
+
+	container := getRestoreJob(foo, source, "default").Spec.Template.Spec.Containers[0]
+	if got, _ := getEnv(container, "SOURCE_HOST"); got != "client24.default.svc" {
+		t.Errorf("expected SOURCE_HOST client24.default.svc, got %s", got)
+	}
+	if got, _ := getEnv(container, "DATABASES"); got != "postgres" {
+		t.Errorf("expected the postgres database by default, got %s", got)
+	}
+	if len(container.EnvFrom) != 0 {
+		t.Errorf("expected no object storage credentials, got %v", container.EnvFrom)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// WarnReconcileAbandoned is used as part of the Event 'reason' when a
+	// Postgres resource is no longer retried.
+	WarnReconcileAbandoned = "ReconcileAbandoned"
+)
+
+// RetryPolicy configures how failed reconciles are retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after which a resource is given up
+	// until its spec changes. Zero retries forever.
+	MaxRetries int
+	// BaseDelay and MaxDelay bound the exponential per-item backoff
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy matches the backoff of DefaultControllerRateLimiter.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 15,
+	BaseDelay:  5 * time.Millisecond,
+	MaxDelay:   1000 * time.Second,
+}
+
+// rateLimiter returns the per-item exponential backoff combined with the
+// overall bucket limit of DefaultControllerRateLimiter.
+func (p RetryPolicy) rateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(p.BaseDelay, p.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// getRetryCount returns the number of consecutive failed reconciles of the
+// key including the current one. The workqueue counts the requeues until
+// the key is forgotten on success.
+func (c *Controller) getRetryCount(key string) int {
+	if c.workqueue == nil {
+		return 0
+	}
+	return c.workqueue.NumRequeues(key) + 1
+}
+
+// getSpecHash identifies the spec a resource was given up with.
+func getSpecHash(foo *postgresv1.Postgres) string {
+	data, _ := json.Marshal(foo.Spec)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// abandon stops retrying the resource until its spec changes. Its status
+// was already set to Failed with the last error by syncHandler.
+func (c *Controller) abandon(key string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	foo, err := c.foosLister.Postgreses(namespace).Get(name)
+	if err != nil {
+		return
+	}
+	c.abandonedLock.Lock()
+	c.abandoned[key] = getSpecHash(foo)
+	c.abandonedLock.Unlock()
+	c.recorder.Event(foo, corev1.EventTypeWarning, WarnReconcileAbandoned,
+		fmt.Sprintf("Giving up after %d retries, the resource is reconciled again once its spec changes",
+			c.maxRetries))
+}
+
+// isAbandoned returns true when the resource was given up with its current
+// spec. Resources whose spec changed since are retried again.
+func (c *Controller) isAbandoned(key string, foo *postgresv1.Postgres) bool {
+	c.abandonedLock.Lock()
+	defer c.abandonedLock.Unlock()
+	specHash, ok := c.abandoned[key]
+	if !ok {
+		return false
+	}
+	if specHash == getSpecHash(foo) {
+		return true
+	}
+	delete(c.abandoned, key)
+	return false
+}
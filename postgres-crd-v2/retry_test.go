@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	listers "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/listers/postgrescontroller/v1"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	limiter := RetryPolicy{BaseDelay: time.Second, MaxDelay: 3 * time.Second}.rateLimiter()
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, delay := range expected {
+		if when := limiter.When("default/client25"); when != delay {
+			t.Errorf("expected retry %d after %v, got %v", i, delay, when)
+		}
+	}
+	limiter.Forget("default/client25")
+	if when := limiter.When("default/client25"); when != time.Second {
+		t.Errorf("expected the backoff to be reset, got %v", when)
+	}
+}
+
+func TestAbandonedUntilSpecChanges(t *testing.T) {
+	foo := newTestPostgres(nil)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(foo)
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		foosLister: listers.NewPostgresLister(indexer),
+		recorder:   recorder,
+		maxRetries: 3,
+		abandoned:  map[string]string{},
+	}
+
+	if c.isAbandoned("default/client25", foo) {
+		t.Errorf("expected the resource not to be abandoned")
+	}
+	c.abandon("default/client25")
+	if !c.isAbandoned("default/client25", foo) {
+		t.Errorf("expected the resource to be abandoned")
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnReconcileAbandoned) {
+		t.Errorf("expected a %s event, got %s", WarnReconcileAbandoned, event)
+	}
+
+	changed := foo.DeepCopy()
+	changed.Spec.Image = "postgres:10"
+	if c.isAbandoned("default/client25", changed) {
+		t.Errorf("expected the resource to be retried once its spec changed")
+	}
+	if c.isAbandoned("default/client25", foo) {
+		t.Errorf("expected the abandoned spec to be forgotten")
+	}
+}
+
+func TestSyncSkipsAbandonedResource(t *testing.T) {
+	foo := newTestPostgres(nil)
+	c := &Controller{abandoned: map[string]string{"default/client25": getSpecHash(foo)}}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(foo)
+	c.foosLister = listers.NewPostgresLister(indexer)
+
+	if err := c.syncHandler("default/client25"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetRetryCount(t *testing.T) {
+	c := &Controller{workqueue: workqueue.NewRateLimitingQueue(RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}.rateLimiter())}
+	if count := c.getRetryCount("default/client25"); count != 1 {
+		t.Errorf("expected a retry count of 1 on the first failure, got %d", count)
+	}
+	c.workqueue.AddRateLimited("default/client25")
+	c.workqueue.AddRateLimited("default/client25")
+	if count := c.getRetryCount("default/client25"); count != 3 {
+		t.Errorf("expected a retry count of 3 after two requeues, got %d", count)
+	}
+	c.workqueue.Forget("default/client25")
+	if count := c.getRetryCount("default/client25"); count != 1 {
+		t.Errorf("expected the retry count to be reset, got %d", count)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Annotation of the Deployment with the hash of the superuser password
+	// set in the instance. It is also set on the pod template to restart
+	// the Pod once the password is rotated.
+	SUPERUSER_PASSWORD_HASH_ANNOTATION = "postgrescontroller.kubeplus/superuser-password-hash"
+	// Key of the superuser Secret holding the password set in the instance,
+	// which the controller connects with to rotate it
+	APPLIED_PASSWORD_KEY = "appliedPassword"
+
+	// Values of Spec.PasswordRotation
+	PASSWORD_ROTATION_DISABLED         = "Disabled"
+	PASSWORD_ROTATION_ON_SECRET_CHANGE = "OnSecretChange"
+
+	// SuperuserPasswordRotated is used as part of the Event 'reason' when
+	// the superuser password is changed to the one in its Secret.
+	SuperuserPasswordRotated = "SuperuserPasswordRotated"
+)
+
+func validatePasswordRotation(policy string) error {
+	if policy == "" || policy == PASSWORD_ROTATION_DISABLED || policy == PASSWORD_ROTATION_ON_SECRET_CHANGE {
+		return nil
+	}
+	return fmt.Errorf("invalid passwordRotation %q, must be %s or %s", policy,
+		PASSWORD_ROTATION_DISABLED, PASSWORD_ROTATION_ON_SECRET_CHANGE)
+}
+
+func getPasswordHash(password string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(password)))
+}
+
+// getRotatePasswordCommands sets the password of the superuser role.
+func getRotatePasswordCommands(foo *postgresv1.Postgres, password string) []string {
+	alterCmd := "alter user " + getSuperuserName(foo) + " with password '" +
+		strings.Replace(password, "'", "''", -1) + "';"
+	return setPasswordEncryption([]string{alterCmd}, getPasswordEncryption(foo))
+}
+
+// rotateSuperuserPassword sets the password of the superuser Secret in the
+// instance once it changed from the one recorded on the Deployment. The
+// controller connects with the password applied before, kept in the Secret,
+// or with the new one if a previous rotation was interrupted after the
+// password was set. The Secret and the Deployment are then updated, the Pod
+// is only restarted with persistent storage as the data would be lost
+// otherwise. With Spec.SuperuserPasswordFromFile the password is read from
+// the file and copied to the Secret, and endpoint is updated to connect with
+// it. It returns whether the Pod is restarted.
+func (c *Controller) rotateSuperuserPassword(foo *postgresv1.Postgres, endpoint *dbEndpoint) (bool, error) {
+	if foo.Spec.PasswordRotation != PASSWORD_ROTATION_ON_SECRET_CHANGE {
+		return false, nil
+	}
+	namespace := c.getInstanceNamespace()
+	secretsClient := c.kubeclientset.CoreV1().Secrets(namespace)
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(namespace)
+	secretName := getSuperuserSecretName(foo)
+	secret, err := secretsClient.Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	deployment, err := deploymentsClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	password := string(secret.Data[CREDENTIALS_PASSWORD_KEY])
+	if foo.Spec.SuperuserPasswordFromFile != "" {
+		password, err = readPasswordFile(foo.Spec.SuperuserPasswordFromFile)
+		if err != nil {
+			return false, err
+		}
+		endpoint.Password = password
+	}
+	passwordHash := getPasswordHash(password)
+	deployedHash, tracked := deployment.Annotations[SUPERUSER_PASSWORD_HASH_ANNOTATION]
+	if deployedHash == passwordHash {
+		return false, nil
+	}
+
+	if tracked {
+		applied, ok := secret.Data[APPLIED_PASSWORD_KEY]
+		if !ok {
+			return false, fmt.Errorf("secret %s has no %s key to connect with", secretName, APPLIED_PASSWORD_KEY)
+		}
+		fmt.Printf("Rotating the superuser password of %s...\n", foo.Spec.DeploymentName)
+		oldEndpoint := *endpoint
+		oldEndpoint.Password = string(applied)
+		executor := c.newDBExecutor()
+		err = executor.Connect(c.getConnectEndpoint(oldEndpoint), "")
+		if err != nil {
+			// The password may have been set before the rotation was
+			// interrupted
+			err = executor.Connect(c.getConnectEndpoint(*endpoint), "")
+			if err != nil {
+				return false, err
+			}
+		} else {
+			for _, command := range getRotatePasswordCommands(foo, password) {
+				err = executor.Exec(command)
+				if err != nil {
+					executor.Close()
+					return false, &commandError{Command: command, Err: err}
+				}
+			}
+		}
+		executor.Close()
+	}
+
+	// The password in use until now is adopted when rotation is enabled
+	secretCopy := secret.DeepCopy()
+	secretCopy.Data[CREDENTIALS_PASSWORD_KEY] = []byte(password)
+	secretCopy.Data[APPLIED_PASSWORD_KEY] = []byte(password)
+	_, err = secretsClient.Update(secretCopy)
+	if err != nil {
+		return false, err
+	}
+	deploymentCopy := deployment.DeepCopy()
+	if deploymentCopy.Annotations == nil {
+		deploymentCopy.Annotations = map[string]string{}
+	}
+	deploymentCopy.Annotations[SUPERUSER_PASSWORD_HASH_ANNOTATION] = passwordHash
+	restart := tracked && foo.Spec.Storage != nil
+	if restart {
+		// Restarts the Pod so that POSTGRES_PASSWORD is read again
+		if deploymentCopy.Spec.Template.Annotations == nil {
+			deploymentCopy.Spec.Template.Annotations = map[string]string{}
+		}
+		deploymentCopy.Spec.Template.Annotations[SUPERUSER_PASSWORD_HASH_ANNOTATION] = passwordHash
+		// Two Pods must never run on the same data directory
+		deploymentCopy.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	}
+	_, err = deploymentsClient.Update(deploymentCopy)
+	if err != nil {
+		return false, err
+	}
+	if tracked {
+		c.recorder.Event(foo, apiv1.EventTypeNormal, SuperuserPasswordRotated,
+			fmt.Sprintf("Rotated the superuser password of %s", foo.Spec.DeploymentName))
+	}
+	return restart, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newRotationTestController(foo *postgresv1.Postgres, data map[string][]byte,
+	deployedPassword string) (*Controller, *fakeExecutor, *record.FakeRecorder) {
+	deployment := getDeployment(foo)
+	deployment.Namespace = "default"
+	if deployedPassword != "" {
+		deployment.Annotations = map[string]string{SUPERUSER_PASSWORD_HASH_ANNOTATION: getPasswordHash(deployedPassword)}
+	}
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: getSuperuserSecretName(foo), Namespace: "default"},
+		Data:       data,
+	}
+	executor := &fakeExecutor{}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		kubeclientset: kubefake.NewSimpleClientset(deployment, secret),
+		newDBExecutor: func() DBExecutor { return executor },
+		recorder:      recorder,
+	}
+	return c, executor, recorder
+}
+
+func TestRotateSuperuserPassword(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.PasswordRotation = PASSWORD_ROTATION_ON_SECRET_CHANGE
+	c, executor, recorder := newRotationTestController(foo, map[string][]byte{
+		CREDENTIALS_PASSWORD_KEY: []byte("new'pass"),
+		APPLIED_PASSWORD_KEY:     []byte("oldpass"),
+	}, "oldpass")
+
+	restarted, err := c.rotateSuperuserPassword(foo, &dbEndpoint{Password: "new'pass"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !restarted {
+		t.Errorf("expected the Pod to be restarted")
+	}
+	expected := []string{
+		"set password_encryption = 'scram-sha-256';",
+		"alter user postgres with password 'new''pass';",
+	}
+	if !reflect.DeepEqual(executor.commands, expected) {
+		t.Errorf("expected commands %v\ngot %v", expected, executor.commands)
+	}
+	secret, _ := c.kubeclientset.CoreV1().Secrets("default").Get(getSuperuserSecretName(foo), metav1.GetOptions{})
+	if applied := string(secret.Data[APPLIED_PASSWORD_KEY]); applied != "new'pass" {
+		t.Errorf("expected the new password to be recorded as applied, got %s", applied)
+	}
+	deployment := getTestDeployment(t, c)
+	if deployment.Spec.Template.Annotations[SUPERUSER_PASSWORD_HASH_ANNOTATION] != getPasswordHash("new'pass") {
+		t.Errorf("expected the pod template to be annotated with the new hash, got %v", deployment.Spec.Template.Annotations)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, SuperuserPasswordRotated) {
+		t.Errorf("expected a %s event, got %s", SuperuserPasswordRotated, event)
+	}
+}
+
+func TestRotateSuperuserPasswordAdoptsPassword(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.PasswordRotation = PASSWORD_ROTATION_ON_SECRET_CHANGE
+	c, executor, _ := newRotationTestController(foo, map[string][]byte{
+		CREDENTIALS_PASSWORD_KEY: []byte("pass"),
+	}, "")
+
+	restarted, err := c.rotateSuperuserPassword(foo, &dbEndpoint{Password: "pass"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restarted || len(executor.commands) > 0 {
+		t.Errorf("expected the password to be adopted as is, got restarted %v and commands %v", restarted, executor.commands)
+	}
+	deployment := getTestDeployment(t, c)
+	if deployment.Annotations[SUPERUSER_PASSWORD_HASH_ANNOTATION] != getPasswordHash("pass") {
+		t.Errorf("expected the deployment to be annotated with the hash, got %v", deployment.Annotations)
+	}
+}
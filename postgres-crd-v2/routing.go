@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// MAINTENANCE_DATABASE is connected to for the commands acting on the
+	// instance rather than on one database
+	MAINTENANCE_DATABASE = "postgres"
+)
+
+// instanceCommands are the leading keywords of the commands acting on the
+// instance. CREATE and DROP DATABASE cannot run while connected to the
+// database, nor can ALTER DATABASE ... RENAME.
+var instanceCommands = []string{
+	"create database ", "drop database ", "alter database ",
+	"create user ", "drop user ", "alter user ",
+	"create role ", "drop role ", "alter role ",
+	"create tablespace ", "drop tablespace ",
+	"alter system ",
+}
+
+func isInstanceCommand(command string) bool {
+	lower := strings.ToLower(strings.TrimSpace(command))
+	for _, prefix := range instanceCommands {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSessionCommand returns true for the set commands, which only apply to
+// the connection they run on.
+func isSessionCommand(command string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(command)), "set ")
+}
+
+// getCommandDatabases returns the database each command runs against.
+// Commands acting on the instance run against the maintenance database,
+// other commands against the database of the last connect command, or
+// dbname before any. A set command runs against the database of the command
+// following it so that it applies to it. Connect commands get an empty name.
+func getCommandDatabases(commands []string, dbname string) []string {
+	targets := make([]string, len(commands))
+	current := dbname
+	for i, command := range commands {
+		if isConnectCommand(command) {
+			current = getConnectDatabase(command)
+			continue
+		}
+		targets[i] = current
+		if isInstanceCommand(command) {
+			targets[i] = MAINTENANCE_DATABASE
+		}
+	}
+	for i := len(commands) - 1; i >= 0; i-- {
+		if !isSessionCommand(commands[i]) {
+			continue
+		}
+		for j := i + 1; j < len(commands); j++ {
+			if isConnectCommand(commands[j]) {
+				// The following commands run against another database
+				break
+			}
+			if !isSessionCommand(commands[j]) {
+				targets[i] = targets[j]
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// routeCommands returns the commands with a connect command before each
+// command that runs against another database than the one before it, or
+// the first one. Empty database names connect to the maintenance database.
+func routeCommands(commands []string, dbname string) []string {
+	if dbname == "" {
+		dbname = MAINTENANCE_DATABASE
+	}
+	targets := getCommandDatabases(commands, dbname)
+	var routed []string
+	connected := ""
+	for i, command := range commands {
+		if isConnectCommand(command) {
+			continue
+		}
+		if targets[i] != connected {
+			routed = append(routed, getConnectCommand(targets[i]))
+			connected = targets[i]
+		}
+		routed = append(routed, command)
+	}
+	return routed
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouteCommands(t *testing.T) {
+	commands := []string{
+		"set password_encryption = 'scram-sha-256';",
+		"create user devdatta with password 'pass123';",
+		"create database moodle;",
+		"\\c moodle;",
+		"create schema app;",
+		"grant select on all tables in schema app to devdatta;",
+		"drop database wordpress;",
+		"create extension hstore;",
+	}
+	expected := []string{
+		"\\c postgres;",
+		"set password_encryption = 'scram-sha-256';",
+		"create user devdatta with password 'pass123';",
+		"create database moodle;",
+		"\\c moodle;",
+		"create schema app;",
+		"grant select on all tables in schema app to devdatta;",
+		"\\c postgres;",
+		"drop database wordpress;",
+		"\\c moodle;",
+		"create extension hstore;",
+	}
+	if routed := routeCommands(commands, ""); !reflect.DeepEqual(routed, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, routed)
+	}
+}
+
+func TestRouteCommandsStartsOnFirstDatabase(t *testing.T) {
+	commands := []string{
+		"create table t (id int);",
+		"create database reports;",
+		"insert into t values (1);",
+	}
+	expected := []string{
+		"\\c moodle;",
+		"create table t (id int);",
+		"\\c postgres;",
+		"create database reports;",
+		"\\c moodle;",
+		"insert into t values (1);",
+	}
+	if routed := routeCommands(commands, "moodle"); !reflect.DeepEqual(routed, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, routed)
+	}
+}
+
+func TestSetupDatabaseRunsInstanceCommandsOnMaintenanceDatabase(t *testing.T) {
+	executor := &fakeExecutor{}
+	c := &Controller{newDBExecutor: func() DBExecutor { return executor }}
+
+	commands := []string{"create database moodle;", "\\c moodle;", "create schema app;"}
+	if err := c.setupDatabase(nil, dbEndpoint{}, commands, []string{"moodle"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"postgres", "moodle"}; !reflect.DeepEqual(executor.connects, expected) {
+		t.Errorf("expected connects %v\ngot %v", expected, executor.connects)
+	}
+	if expected := []string{"create database moodle;", "create schema app;"}; !reflect.DeepEqual(executor.commands, expected) {
+		t.Errorf("expected commands %v\ngot %v", expected, executor.commands)
+	}
+}
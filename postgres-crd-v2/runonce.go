@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Annotation whose value is a statement run once by the controller, e.g.
+	// vacuum full. It is removed once the statement ran.
+	RUN_ONCE_ANNOTATION = "postgres.kubeplus.cloud-ark.io/run-once"
+	// Annotation naming the database the statement runs against, the first
+	// of Spec.Databases by default
+	RUN_ONCE_DATABASE_ANNOTATION = "postgres.kubeplus.cloud-ark.io/run-once-database"
+
+	// AdHocStatementRun is used as part of the Event 'reason' when a
+	// run-once statement succeeded.
+	AdHocStatementRun = "AdHocStatementRun"
+	// WarnAdHocStatementFailed is used as part of the Event 'reason' when a
+	// run-once statement failed.
+	WarnAdHocStatementFailed = "AdHocStatementFailed"
+)
+
+func getRunOnceStatement(foo *postgresv1.Postgres) string {
+	return strings.TrimSpace(foo.Annotations[RUN_ONCE_ANNOTATION])
+}
+
+func getRunOnceDatabase(foo *postgresv1.Postgres) string {
+	if database := foo.Annotations[RUN_ONCE_DATABASE_ANNOTATION]; database != "" {
+		return database
+	}
+	if len(foo.Spec.Databases) > 0 {
+		return foo.Spec.Databases[0].Name
+	}
+	return MAINTENANCE_DATABASE
+}
+
+// runOnce runs the statement of the run-once annotation of a created
+// instance. Its outcome is recorded in Status.LastAdHocResult and the
+// annotation removed, so that it is not run again. A
+// failed statement is not retried, running it again may not be safe, but
+// the annotation is kept when the instance cannot be connected to.
+func (c *Controller) runOnce(foo *postgresv1.Postgres) error {
+	if getRunOnceStatement(foo) == "" || !isCreated(foo) || foo.Spec.DryRun {
+		return nil
+	}
+	endpoint, err := c.getInstanceEndpoint(foo, foo.Status.ServiceIP, foo.Status.ServicePort)
+	if err != nil {
+		return err
+	}
+	return c.runOnceOn(foo, endpoint)
+}
+
+// runOnceOn runs the statement of the run-once annotation against the
+// endpoint the databases of foo are on, i.e. its own instance, a shared
+// instance or an external endpoint.
+func (c *Controller) runOnceOn(foo *postgresv1.Postgres, endpoint dbEndpoint) error {
+	statement := getRunOnceStatement(foo)
+	if statement == "" || foo.Spec.DryRun {
+		return nil
+	}
+	database := getRunOnceDatabase(foo)
+	cleanup, err := c.setupSSL(foo, &endpoint)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	endpoint, err = c.findPrimary(endpoint)
+	if err != nil {
+		return err
+	}
+
+	executor := c.newDBExecutor()
+	defer executor.Close()
+	err = executor.Connect(c.getConnectEndpoint(endpoint), database)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Running statement of %s against %s: %s\n", RUN_ONCE_ANNOTATION, database, statement)
+	result := &postgresv1.AdHocResult{
+		Statement: statement,
+		Database:  database,
+		Succeeded: true,
+		RunTime:   metav1.Now(),
+	}
+	if err := executor.Exec(statement); err != nil {
+		result.Succeeded = false
+		result.Error = err.Error()
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnAdHocStatementFailed,
+			fmt.Sprintf("Statement of %s failed: %s", RUN_ONCE_ANNOTATION, err.Error()))
+	} else {
+		c.recorder.Event(foo, apiv1.EventTypeNormal, AdHocStatementRun,
+			fmt.Sprintf("Ran the statement of %s against %s", RUN_ONCE_ANNOTATION, database))
+	}
+	return c.recordAdHocResult(foo, result)
+}
+
+// recordAdHocResult removes the annotation from the latest version of the
+// resource, unless the statement was changed in the meantime and is still
+// to be run, and then records the outcome of the statement in the status.
+// The annotation is removed first so that a statement is not run again
+// should the status patch fail.
+func (c *Controller) recordAdHocResult(foo *postgresv1.Postgres, result *postgresv1.AdHocResult) error {
+	foosClient := c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := foosClient.Get(foo.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if getRunOnceStatement(latest) != result.Statement {
+			return nil
+		}
+		patch, err := getRemoveRunOncePatch(latest)
+		if err != nil {
+			return err
+		}
+		_, err = foosClient.Patch(foo.Name, types.MergePatchType, patch)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fooCopy := foo.DeepCopy()
+	fooCopy.Status.LastAdHocResult = result
+	return c.patchStatus(foo, fooCopy)
+}
+
+// getRemoveRunOncePatch returns the merge patch removing the run-once
+// annotations of foo. It fails with a conflict if the annotations were
+// changed since foo was read.
+func getRemoveRunOncePatch(foo *postgresv1.Postgres) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				RUN_ONCE_ANNOTATION:          nil,
+				RUN_ONCE_DATABASE_ANNOTATION: nil,
+			},
+			"resourceVersion": foo.ResourceVersion,
+		},
+	})
+}
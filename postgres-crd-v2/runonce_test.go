@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newRunOncePostgres(statement string) *postgresv1.Postgres {
+	foo := newTestPostgres(nil)
+	foo.Annotations = map[string]string{RUN_ONCE_ANNOTATION: statement}
+	foo.Spec.Databases = newDatabaseSpecs("moodle")
+	foo.Status.Status = "READY"
+	foo.Status.ServiceIP = "10.0.0.1"
+	foo.Status.ServicePort = "5432"
+	return foo
+}
+
+func TestRunOnceConsumesAnnotation(t *testing.T) {
+	f := newFixture(t)
+	foo := newRunOncePostgres("vacuum full;")
+	f.foos = append(f.foos, foo)
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+	mock := f.expectConnection()
+	expectExec(mock, "vacuum full;")
+	mock.ExpectClose()
+
+	c := f.newController()
+	if err := c.runOnce(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := f.getPostgres("client25")
+	if _, ok := updated.Annotations[RUN_ONCE_ANNOTATION]; ok {
+		t.Errorf("expected the annotation to be removed")
+	}
+	result := updated.Status.LastAdHocResult
+	if result == nil || !result.Succeeded || result.Database != "moodle" || result.Statement != "vacuum full;" {
+		t.Errorf("expected the successful result in the status, got %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// Without the annotation nothing runs again
+	if err := c.runOnce(updated); err != nil || f.opened != 1 {
+		t.Errorf("expected no connection, got %d connections and %v", f.opened, err)
+	}
+}
+
+func TestRunOnceRecordsFailure(t *testing.T) {
+	f := newFixture(t)
+	foo := newRunOncePostgres("reindex table t;")
+	foo.Annotations[RUN_ONCE_DATABASE_ANNOTATION] = "postgres"
+	f.foos = append(f.foos, foo)
+	f.secrets = append(f.secrets, newSuperuserSecret(foo))
+	mock := f.expectConnection()
+	mock.ExpectExec(regexp.QuoteMeta("reindex table t;")).WillReturnError(fmt.Errorf("relation \"t\" does not exist"))
+	mock.ExpectClose()
+
+	c := f.newController()
+	if err := c.runOnce(foo); err != nil {
+		t.Fatalf("expected the failure to be recorded, got %v", err)
+	}
+	updated := f.getPostgres("client25")
+	result := updated.Status.LastAdHocResult
+	if result == nil || result.Succeeded || result.Database != "postgres" || result.Error == "" {
+		t.Errorf("expected the failed result in the status, got %+v", result)
+	}
+	// A failed statement is not retried
+	if len(updated.Annotations) != 0 {
+		t.Errorf("expected the annotations to be removed, got %v", updated.Annotations)
+	}
+}
+
+func TestRecordAdHocResultKeepsChangedStatement(t *testing.T) {
+	f := newFixture(t)
+	foo := newRunOncePostgres("vacuum full;")
+	f.foos = append(f.foos, foo)
+	c := f.newController()
+
+	// The statement was changed while the previous one ran
+	changed := foo.DeepCopy()
+	changed.Annotations[RUN_ONCE_ANNOTATION] = "analyze;"
+	changed.Spec.Databases = newDatabaseSpecs("moodle", "wordpress")
+	if _, err := f.client.PostgrescontrollerV1().Postgreses("default").Update(changed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := &postgresv1.AdHocResult{Statement: "vacuum full;", Database: "moodle", Succeeded: true}
+	if err := c.recordAdHocResult(foo, result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := f.getPostgres("client25")
+	if updated.Annotations[RUN_ONCE_ANNOTATION] != "analyze;" {
+		t.Errorf("expected the changed statement to be kept, got %v", updated.Annotations)
+	}
+	if len(updated.Spec.Databases) != 2 {
+		t.Errorf("expected the concurrent spec edit to be kept, got %+v", updated.Spec.Databases)
+	}
+	if updated.Status.LastAdHocResult == nil || updated.Status.LastAdHocResult.Statement != "vacuum full;" {
+		t.Errorf("expected the result in the status, got %+v", updated.Status.LastAdHocResult)
+	}
+}
+
+func TestRunOnceOnExternalEndpoint(t *testing.T) {
+	f := newFixture(t)
+	foo := newRunOncePostgres("analyze;")
+	foo.Spec.DeploymentName = ""
+	foo.Spec.ExternalEndpoint = &postgresv1.ExternalEndpointSpec{Host: "db.example.com", AdminSecretRef: "admin"}
+	foo.Status.Status = "EXTERNAL"
+	foo.Status.ServiceIP = ""
+	foo.Status.ServicePort = ""
+	f.foos = append(f.foos, foo)
+	mock := f.expectConnection()
+	expectExec(mock, "analyze;")
+	mock.ExpectClose()
+
+	c := f.newController()
+	// The resource has no instance of its own
+	if err := c.runOnce(foo); err != nil || f.opened != 0 {
+		t.Fatalf("expected no connection, got %d connections and %v", f.opened, err)
+	}
+	endpoint := dbEndpoint{Host: "db.example.com", Port: "5432", User: "postgres", Password: "admin123"}
+	if err := c.runOnceOn(foo, endpoint); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := f.getPostgres("client25")
+	if _, ok := updated.Annotations[RUN_ONCE_ANNOTATION]; ok {
+		t.Errorf("expected the annotation to be removed")
+	}
+	if result := updated.Status.LastAdHocResult; result == nil || !result.Succeeded || result.Statement != "analyze;" {
+		t.Errorf("expected the successful result in the status, got %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// validateClassNames checks the priority and runtime class names. Whether
+// the classes exist is left to the API server, which rejects the Pods
+// otherwise.
+func validateClassNames(foo *postgresv1.Postgres) []string {
+	var problems []string
+	classes := []struct {
+		field string
+		name  string
+	}{
+		{"spec.priorityClassName", foo.Spec.PriorityClassName},
+		{"spec.runtimeClassName", foo.Spec.RuntimeClassName},
+	}
+	for _, class := range classes {
+		if class.name == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(class.name); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("invalid %s %q: %s", class.field, class.name,
+				strings.Join(errs, ", ")))
+		}
+	}
+	return problems
+}
+
+// addScheduling copies the scheduling constraints of the spec into the pod
+// spec, e.g. to pin Postgres to storage optimized nodes.
+func addScheduling(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	podSpec.PriorityClassName = foo.Spec.PriorityClassName
+	if foo.Spec.RuntimeClassName != "" {
+		runtimeClassName := foo.Spec.RuntimeClassName
+		podSpec.RuntimeClassName = &runtimeClassName
+	}
+	if foo.Spec.Scheduling != nil {
+		scheduling := foo.Spec.Scheduling.DeepCopy()
+		podSpec.NodeSelector = scheduling.NodeSelector
+		podSpec.Tolerations = scheduling.Tolerations
+		podSpec.Affinity = scheduling.Affinity
+	}
+}
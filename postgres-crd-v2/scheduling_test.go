@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestSchedulingIsCopiedToPodSpec(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Scheduling = &postgresv1.SchedulingSpec{
+		NodeSelector: map[string]string{"disktype": "ssd"},
+		Tolerations: []apiv1.Toleration{
+			{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "postgres", Effect: apiv1.TaintEffectNoSchedule},
+		},
+		Affinity: &apiv1.Affinity{
+			NodeAffinity: &apiv1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+					NodeSelectorTerms: []apiv1.NodeSelectorTerm{
+						{
+							MatchExpressions: []apiv1.NodeSelectorRequirement{
+								{Key: "lifecycle", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{"spot"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	if !reflect.DeepEqual(podSpec.NodeSelector, foo.Spec.Scheduling.NodeSelector) {
+		t.Errorf("expected %v\ngot %v", foo.Spec.Scheduling.NodeSelector, podSpec.NodeSelector)
+	}
+	if !reflect.DeepEqual(podSpec.Tolerations, foo.Spec.Scheduling.Tolerations) {
+		t.Errorf("expected %v\ngot %v", foo.Spec.Scheduling.Tolerations, podSpec.Tolerations)
+	}
+	if !reflect.DeepEqual(podSpec.Affinity, foo.Spec.Scheduling.Affinity) {
+		t.Errorf("expected %v\ngot %v", foo.Spec.Scheduling.Affinity, podSpec.Affinity)
+	}
+}
+
+func TestNoSchedulingConstraintsByDefault(t *testing.T) {
+	podSpec := getDeployment(newTestPostgres(nil)).Spec.Template.Spec
+	if podSpec.NodeSelector != nil || podSpec.Tolerations != nil || podSpec.Affinity != nil {
+		t.Errorf("expected no scheduling constraints, got %v %v %v",
+			podSpec.NodeSelector, podSpec.Tolerations, podSpec.Affinity)
+	}
+}
+
+func TestPriorityAndRuntimeClassAreSet(t *testing.T) {
+	foo := newTestPostgres(nil)
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	if podSpec.PriorityClassName != "" || podSpec.RuntimeClassName != nil {
+		t.Errorf("expected no classes by default, got %q %v", podSpec.PriorityClassName, podSpec.RuntimeClassName)
+	}
+
+	foo.Spec.PriorityClassName = "database-critical"
+	foo.Spec.RuntimeClassName = "gvisor"
+	podSpec = getDeployment(foo).Spec.Template.Spec
+	if podSpec.PriorityClassName != "database-critical" {
+		t.Errorf("expected priority class database-critical, got %q", podSpec.PriorityClassName)
+	}
+	if podSpec.RuntimeClassName == nil || *podSpec.RuntimeClassName != "gvisor" {
+		t.Errorf("expected runtime class gvisor, got %v", podSpec.RuntimeClassName)
+	}
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	foo.Spec.PriorityClassName = "Database_Critical"
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected the invalid priority class name to be rejected, got %v", problems)
+	}
+}
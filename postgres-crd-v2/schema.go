@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// WarnSchemaOrphaned is used as part of the Event 'reason' when a schema
+	// removed from the spec is kept.
+	WarnSchemaOrphaned = "SchemaOrphaned"
+)
+
+func findSchema(schemas []postgresv1.SchemaSpec, name string) (postgresv1.SchemaSpec, bool) {
+	for _, schema := range schemas {
+		if schema.Name == name {
+			return schema, true
+		}
+	}
+	return postgresv1.SchemaSpec{}, false
+}
+
+// getStatusSchemas returns the schemas recorded in the status of a database.
+// A database that does not currently exist has no schemas, so that they are
+// created again along with the database.
+func getStatusSchemas(status []postgresv1.DatabaseSpec, current []string, name string) []postgresv1.SchemaSpec {
+	if !contains(current, name) {
+		return nil
+	}
+	statusDB, _ := findDatabase(status, name)
+	return statusDB.Schemas
+}
+
+// getRemovedSchemas returns, for each desired database, the schemas recorded
+// in the status that are no longer in the spec. Schemas of dropped databases
+// go away with their database.
+func getRemovedSchemas(desired []postgresv1.DatabaseSpec, status []postgresv1.DatabaseSpec,
+	current []string) []postgresv1.DatabaseSpec {
+	var removed []postgresv1.DatabaseSpec
+	for _, db := range desired {
+		var schemas []postgresv1.SchemaSpec
+		for _, schema := range getStatusSchemas(status, current, db.Name) {
+			if _, ok := findSchema(db.Schemas, schema.Name); !ok {
+				schemas = append(schemas, schema)
+			}
+		}
+		if len(schemas) > 0 {
+			removed = append(removed, postgresv1.DatabaseSpec{Name: db.Name, Schemas: schemas})
+		}
+	}
+	return removed
+}
+
+// getSchemaCommands returns the commands creating the schemas of the spec
+// that are not in the status, or whose owner changed, and the commands
+// dropping the schemas removed from the spec. Schemas live within a
+// database, so the commands of each database are preceded by a connect
+// command.
+func getSchemaCommands(desired []postgresv1.DatabaseSpec, status []postgresv1.DatabaseSpec,
+	current []string) ([]string, []string) {
+	var createCommands []string
+	var dropCommands []string
+	for _, db := range desired {
+		statusSchemas := getStatusSchemas(status, current, db.Name)
+		var commands []string
+		for _, schema := range db.Schemas {
+			statusSchema, ok := findSchema(statusSchemas, schema.Name)
+			if !ok {
+				command := fmt.Sprintf("create schema if not exists \"%s\"", schema.Name)
+				if schema.Owner != "" {
+					command = command + fmt.Sprintf(" authorization \"%s\"", schema.Owner)
+				}
+				commands = append(commands, command+";")
+			} else if schema.Owner != "" && schema.Owner != statusSchema.Owner {
+				commands = append(commands, fmt.Sprintf("alter schema \"%s\" owner to \"%s\";",
+					schema.Name, schema.Owner))
+			}
+		}
+		if len(commands) > 0 {
+			createCommands = append(createCommands, getConnectCommand(db.Name))
+			appendList(&createCommands, commands)
+		}
+	}
+	for _, db := range getRemovedSchemas(desired, status, current) {
+		dropCommands = append(dropCommands, getConnectCommand(db.Name))
+		for _, schema := range db.Schemas {
+			dropCommands = append(dropCommands, fmt.Sprintf("drop schema \"%s\";", schema.Name))
+		}
+	}
+	return createCommands, dropCommands
+}
+
+// guardSchemaDeletion returns the drop commands to run and the schemas that
+// are kept instead. Like databases, schemas removed from the spec are only
+// dropped when Spec.AllowDatabaseDeletion is set.
+func (c *Controller) guardSchemaDeletion(foo *postgresv1.Postgres, status []postgresv1.DatabaseSpec,
+	current []string, dropCommands []string) ([]string, []postgresv1.DatabaseSpec) {
+	if foo.Spec.AllowDatabaseDeletion {
+		return dropCommands, nil
+	}
+	kept := getRemovedSchemas(foo.Spec.Databases, status, current)
+	var names []string
+	for _, db := range kept {
+		for _, schema := range db.Schemas {
+			names = append(names, db.Name+"."+schema.Name)
+		}
+	}
+	if len(names) > 0 {
+		c.recorder.Event(foo, corev1.EventTypeWarning, WarnSchemaOrphaned,
+			fmt.Sprintf("Schemas %s were removed from the spec but not dropped, set allowDatabaseDeletion to drop them",
+				strings.Join(names, ", ")))
+	}
+	return nil, kept
+}
+
+// addKeptSchemas records the kept schemas in the status of their databases
+// so that they are dropped once deletion is allowed.
+func addKeptSchemas(databases []postgresv1.DatabaseSpec, kept []postgresv1.DatabaseSpec) []postgresv1.DatabaseSpec {
+	var result []postgresv1.DatabaseSpec
+	for _, db := range databases {
+		db = *db.DeepCopy()
+		if keptDB, ok := findDatabase(kept, db.Name); ok {
+			db.Schemas = append(db.Schemas, keptDB.Schemas...)
+		}
+		result = append(result, db)
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newSchemaDatabase(name string, schemas ...postgresv1.SchemaSpec) postgresv1.DatabaseSpec {
+	return postgresv1.DatabaseSpec{Name: name, Schemas: schemas}
+}
+
+func TestGetSchemaCommands(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{
+		newSchemaDatabase("moodle",
+			postgresv1.SchemaSpec{Name: "app", Owner: "devdatta"},
+			postgresv1.SchemaSpec{Name: "reporting", Owner: "analyst"}),
+		newSchemaDatabase("wordpress", postgresv1.SchemaSpec{Name: "blog"}),
+	}
+	status := []postgresv1.DatabaseSpec{
+		newSchemaDatabase("moodle",
+			postgresv1.SchemaSpec{Name: "reporting", Owner: "devdatta"},
+			postgresv1.SchemaSpec{Name: "old"}),
+	}
+	createCommands, dropCommands := getSchemaCommands(desired, status, []string{"moodle"})
+
+	expectedCreate := []string{
+		"\\c moodle;",
+		"create schema if not exists \"app\" authorization \"devdatta\";",
+		"alter schema \"reporting\" owner to \"analyst\";",
+		"\\c wordpress;",
+		"create schema if not exists \"blog\";",
+	}
+	if !reflect.DeepEqual(createCommands, expectedCreate) {
+		t.Errorf("expected %v\ngot %v", expectedCreate, createCommands)
+	}
+	expectedDrop := []string{"\\c moodle;", "drop schema \"old\";"}
+	if !reflect.DeepEqual(dropCommands, expectedDrop) {
+		t.Errorf("expected %v\ngot %v", expectedDrop, dropCommands)
+	}
+}
+
+func TestSchemasOfMissingDatabaseAreCreatedAgain(t *testing.T) {
+	desired := []postgresv1.DatabaseSpec{newSchemaDatabase("moodle", postgresv1.SchemaSpec{Name: "app"})}
+	createCommands, dropCommands := getSchemaCommands(desired, desired, nil)
+	expected := []string{"\\c moodle;", "create schema if not exists \"app\";"}
+	if !reflect.DeepEqual(createCommands, expected) {
+		t.Errorf("expected %v\ngot %v", expected, createCommands)
+	}
+	if len(dropCommands) != 0 {
+		t.Errorf("expected no drop commands, got %v", dropCommands)
+	}
+}
+
+func TestRemovedSchemaIsKeptByDefault(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = []postgresv1.DatabaseSpec{newSchemaDatabase("moodle")}
+	status := []postgresv1.DatabaseSpec{newSchemaDatabase("moodle", postgresv1.SchemaSpec{Name: "old"})}
+	current := []string{"moodle"}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder}
+
+	_, dropCommands := getSchemaCommands(foo.Spec.Databases, status, current)
+	dropCommands, kept := c.guardSchemaDeletion(foo, status, current, dropCommands)
+	if len(dropCommands) != 0 {
+		t.Errorf("expected no drop commands, got %v", dropCommands)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnSchemaOrphaned) || !strings.Contains(event, "moodle.old") {
+		t.Errorf("expected an orphaned warning for moodle.old, got %q", event)
+	}
+	applied := addKeptSchemas(foo.Spec.Databases, kept)
+	if !reflect.DeepEqual(applied, status) {
+		t.Errorf("expected the kept schema in the status\nexpected %v\ngot %v", status, applied)
+	}
+	if len(foo.Spec.Databases[0].Schemas) != 0 {
+		t.Errorf("expected the spec to be left unchanged, got %v", foo.Spec.Databases)
+	}
+
+	foo.Spec.AllowDatabaseDeletion = true
+	_, dropCommands = getSchemaCommands(foo.Spec.Databases, applied, current)
+	dropCommands, kept = c.guardSchemaDeletion(foo, applied, current, dropCommands)
+	if !reflect.DeepEqual(dropCommands, []string{"\\c moodle;", "drop schema \"old\";"}) || len(kept) != 0 {
+		t.Errorf("expected old to be dropped, got %v and kept %v", dropCommands, kept)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/connection"
+)
+
+// getConnectionInfo picks the first declared user and database from the spec.
+// The users are passed with their passwords resolved.
+// If none are declared we fall back to the admin user of the endpoint and
+// its default database.
+func getConnectionInfo(foo *postgresv1.Postgres, users []postgresv1.UserSpec, endpoint dbEndpoint) connection.Info {
+	info := connection.Info{
+		Host:     endpoint.Host,
+		Port:     endpoint.Port,
+		Database: "postgres",
+		Username: endpoint.User,
+		Password: endpoint.Password,
+		SSLMode:  endpoint.SSLMode,
+	}
+	if len(foo.Spec.Databases) > 0 {
+		info.Database = foo.Spec.Databases[0].Name
+	}
+	if len(users) > 0 {
+		info.Username = users[0].User
+		info.Password = users[0].Password
+	}
+	return info
+}
+
+func getConnectionSecretName(foo *postgresv1.Postgres) string {
+	// Resources on a shared instance have no Deployment of their own
+	name := foo.Spec.DeploymentName
+	if name == "" {
+		name = foo.Name
+	}
+	return name + "-connection"
+}
+
+// createOrUpdateConnectionSecret writes the connection info into a Secret
+// that workloads can mount directly. It returns the name of the Secret.
+func createOrUpdateConnectionSecret(foo *postgresv1.Postgres, c *Controller, info connection.Info) (string, error) {
+	secretName := getConnectionSecretName(foo)
+	secretsClient := c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace())
+
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName,
+			Labels: map[string]string{
+				"app": foo.Name,
+			},
+		},
+		Type:       apiv1.SecretTypeOpaque,
+		StringData: info.SecretData(),
+	}
+
+	current, err := secretsClient.Get(secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		fmt.Printf("Creating secret %s...\n", secretName)
+		_, err = secretsClient.Create(secret)
+		return secretName, err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secretCopy := current.DeepCopy()
+	secretCopy.Data = nil
+	secretCopy.StringData = secret.StringData
+	_, err = secretsClient.Update(secretCopy)
+	return secretName, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// UID and GID of the postgres user of the Debian based official images.
+	// The Alpine based images use 70 and need Spec.SecurityContext.
+	POSTGRES_UID = 999
+	POSTGRES_GID = 999
+)
+
+// getDefaultPodSecurityContext runs the Pod as the postgres user of the
+// image, as required by the restricted Pod Security Standard. Volumes,
+// e.g. the PVC of PGDATA, are made writable for it through the fsGroup.
+func getDefaultPodSecurityContext() *apiv1.PodSecurityContext {
+	return &apiv1.PodSecurityContext{
+		RunAsNonRoot: boolPtr(true),
+		RunAsUser:    int64Ptr(POSTGRES_UID),
+		RunAsGroup:   int64Ptr(POSTGRES_GID),
+		FSGroup:      int64Ptr(POSTGRES_GID),
+		SeccompProfile: &apiv1.SeccompProfile{
+			Type: apiv1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+func getDefaultContainerSecurityContext() *apiv1.SecurityContext {
+	return &apiv1.SecurityContext{
+		AllowPrivilegeEscalation: boolPtr(false),
+		Capabilities: &apiv1.Capabilities{
+			Drop: []apiv1.Capability{"ALL"},
+		},
+	}
+}
+
+// addSecurityContext sets the security context of the Pod and of all its
+// containers, including the sidecars and init containers, once they were
+// added. The parts of Spec.SecurityContext that are set replace the
+// defaults.
+func addSecurityContext(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	podSecurityContext := getDefaultPodSecurityContext()
+	containerSecurityContext := getDefaultContainerSecurityContext()
+	if securityContext := foo.Spec.SecurityContext; securityContext != nil {
+		if securityContext.Pod != nil {
+			podSecurityContext = securityContext.Pod.DeepCopy()
+		}
+		if securityContext.Container != nil {
+			containerSecurityContext = securityContext.Container
+		}
+	}
+	podSpec.SecurityContext = podSecurityContext
+	for i := range podSpec.InitContainers {
+		podSpec.InitContainers[i].SecurityContext = containerSecurityContext.DeepCopy()
+	}
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].SecurityContext = containerSecurityContext.DeepCopy()
+	}
+}
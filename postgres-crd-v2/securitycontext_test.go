@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestPostgresRunsAsNonRootByDefault(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.Monitoring = &postgresv1.MonitoringSpec{Enabled: true}
+	podSpec := getDeployment(foo).Spec.Template.Spec
+
+	podSecurityContext := podSpec.SecurityContext
+	if podSecurityContext == nil || podSecurityContext.RunAsNonRoot == nil || !*podSecurityContext.RunAsNonRoot {
+		t.Fatalf("expected the Pod to run as non-root, got %v", podSecurityContext)
+	}
+	if *podSecurityContext.RunAsUser != POSTGRES_UID || *podSecurityContext.FSGroup != POSTGRES_GID {
+		t.Errorf("expected the postgres user and group, got %v", podSecurityContext)
+	}
+	for _, container := range podSpec.Containers {
+		securityContext := container.SecurityContext
+		if securityContext == nil || securityContext.AllowPrivilegeEscalation == nil ||
+			*securityContext.AllowPrivilegeEscalation {
+			t.Errorf("expected %s not to allow privilege escalation, got %v", container.Name, securityContext)
+		}
+	}
+}
+
+func TestSecurityContextReplacesDefaults(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Image = "postgres:10-alpine"
+	foo.Spec.SecurityContext = &postgresv1.SecurityContextSpec{
+		Pod: &apiv1.PodSecurityContext{RunAsUser: int64Ptr(70), FSGroup: int64Ptr(70)},
+	}
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	if podSpec.SecurityContext.RunAsNonRoot != nil || *podSpec.SecurityContext.RunAsUser != 70 {
+		t.Errorf("expected the pod security context of the spec, got %v", podSpec.SecurityContext)
+	}
+	// The container part is still defaulted
+	if podSpec.Containers[0].SecurityContext == nil || podSpec.Containers[0].SecurityContext.Capabilities == nil {
+		t.Errorf("expected the default container security context, got %v", podSpec.Containers[0].SecurityContext)
+	}
+}
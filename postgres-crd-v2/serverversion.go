@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// queryServerVersion returns the version of the running Postgres server,
+// e.g. "10.5 (Debian 10.5-2.pgdg90+1)", so that the status reflects an
+// image upgraded in place. It is only informational: when the query fails
+// the last known version is kept. Instances set up by a Job are not
+// connected to.
+func (c *Controller) queryServerVersion(foo *postgresv1.Postgres, endpoint dbEndpoint) string {
+	if usesSetupJob(foo) {
+		return foo.Status.ServerVersion
+	}
+	executor := c.newDBExecutor()
+	err := executor.Connect(c.getConnectEndpoint(endpoint), "")
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("%s/%s: querying server version: %s", foo.Namespace, foo.Name, err.Error()))
+		return foo.Status.ServerVersion
+	}
+	defer executor.Close()
+
+	version, err := executor.QueryServerVersion()
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("%s/%s: querying server version: %s", foo.Namespace, foo.Name, err.Error()))
+		return foo.Status.ServerVersion
+	}
+	fmt.Printf("Server Version:%s\n", version)
+	return version
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQueryServerVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unexpected error creating sqlmock: %v", err)
+	}
+	mock.ExpectQuery("SHOW server_version").
+		WillReturnRows(sqlmock.NewRows([]string{"server_version"}).AddRow("10.5 (Debian 10.5-2.pgdg90+1)"))
+	mock.ExpectClose()
+	c := &Controller{newDBExecutor: func() DBExecutor {
+		return &pqExecutor{open: func(endpoint dbEndpoint, dbname string) (*sql.DB, error) { return db, nil }}
+	}}
+
+	foo := newTestPostgres(nil)
+	foo.Status.ServerVersion = "9.6.10"
+	if version := c.queryServerVersion(foo, dbEndpoint{}); version != "10.5 (Debian 10.5-2.pgdg90+1)" {
+		t.Errorf("expected the upgraded version, got %q", version)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryServerVersionKeepsLastKnownVersion(t *testing.T) {
+	c := &Controller{newDBExecutor: func() DBExecutor {
+		return &pqExecutor{open: func(endpoint dbEndpoint, dbname string) (*sql.DB, error) {
+			return nil, fmt.Errorf("connection refused")
+		}}
+	}}
+	foo := newTestPostgres(nil)
+	foo.Status.ServerVersion = "10.5"
+	if version := c.queryServerVersion(foo, dbEndpoint{}); version != "10.5" {
+		t.Errorf("expected the last known version on error, got %q", version)
+	}
+
+	// The controller does not connect to instances set up by a Job
+	executor := &fakeExecutor{version: "11.1"}
+	c = &Controller{newDBExecutor: func() DBExecutor { return executor }}
+	foo.Spec.UseSetupJob = true
+	if version := c.queryServerVersion(foo, dbEndpoint{}); version != "10.5" || len(executor.connects) != 0 {
+		t.Errorf("expected no connection for a setup Job, got %q and %v", version, executor.connects)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Annotation of the Service listing the annotations set from
+	// Spec.Service, so that those removed from the spec are removed too
+	SERVICE_ANNOTATIONS_KEY = "postgrescontroller.kubeplus/service-annotations"
+
+	// ServiceUpdated is used as part of the Event 'reason' when the Service
+	// is updated to Spec.Service.
+	ServiceUpdated = "ServiceUpdated"
+	// WarnNodePortConflict is used as part of the Event 'reason' when the
+	// node port of Spec.Service is already allocated to another Service.
+	WarnNodePortConflict = "NodePortConflict"
+
+	// Default range of the node ports of the API server
+	MIN_NODE_PORT = 30000
+	MAX_NODE_PORT = 32767
+)
+
+func getServiceType(foo *postgresv1.Postgres) apiv1.ServiceType {
+	if foo.Spec.Service != nil && foo.Spec.Service.Type != "" {
+		return foo.Spec.Service.Type
+	}
+	return apiv1.ServiceTypeNodePort
+}
+
+func validateService(service *postgresv1.ServiceSpec) []string {
+	var problems []string
+	if service.Type != "" && service.Type != apiv1.ServiceTypeNodePort && service.Type != apiv1.ServiceTypeLoadBalancer {
+		problems = append(problems, fmt.Sprintf("invalid service type %q, must be NodePort or LoadBalancer", service.Type))
+	}
+	if len(service.LoadBalancerSourceRanges) > 0 && service.Type != apiv1.ServiceTypeLoadBalancer {
+		problems = append(problems, "spec.service.loadBalancerSourceRanges requires the LoadBalancer type")
+	}
+	for _, sourceRange := range service.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(sourceRange); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid load balancer source range %q", sourceRange))
+		}
+	}
+	policy := service.ExternalTrafficPolicy
+	if policy != "" && policy != apiv1.ServiceExternalTrafficPolicyTypeCluster &&
+		policy != apiv1.ServiceExternalTrafficPolicyTypeLocal {
+		problems = append(problems, fmt.Sprintf("invalid externalTrafficPolicy %q, must be Cluster or Local", policy))
+	}
+	if service.NodePort != 0 && (service.NodePort < MIN_NODE_PORT || service.NodePort > MAX_NODE_PORT) {
+		problems = append(problems, fmt.Sprintf("invalid nodePort %d, must be between %d and %d",
+			service.NodePort, MIN_NODE_PORT, MAX_NODE_PORT))
+	}
+	return problems
+}
+
+// addServiceOptions applies Spec.Service to the Service. Its annotations
+// take precedence over those of Spec.Metadata.
+func addServiceOptions(service *apiv1.Service, foo *postgresv1.Postgres) {
+	service.Spec.Type = getServiceType(foo)
+	if foo.Spec.Service == nil {
+		return
+	}
+	spec := foo.Spec.Service
+	if len(spec.Annotations) > 0 {
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		var keys []string
+		for key, value := range spec.Annotations {
+			service.Annotations[key] = value
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		service.Annotations[SERVICE_ANNOTATIONS_KEY] = strings.Join(keys, ",")
+	}
+	service.Spec.LoadBalancerSourceRanges = spec.LoadBalancerSourceRanges
+	if spec.ExternalTrafficPolicy != "" {
+		service.Spec.ExternalTrafficPolicy = spec.ExternalTrafficPolicy
+	}
+	if spec.NodePort != 0 {
+		service.Spec.Ports[0].NodePort = spec.NodePort
+	}
+}
+
+// checkNodePortConflict records an event when the Service could not be
+// created or updated because its pinned node port is already allocated.
+func (c *Controller) checkNodePortConflict(foo *postgresv1.Postgres, err error) error {
+	if foo.Spec.Service == nil || foo.Spec.Service.NodePort == 0 {
+		return err
+	}
+	if errors.IsInvalid(err) && strings.Contains(err.Error(), "already allocated") {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnNodePortConflict,
+			fmt.Sprintf("Node port %d is already allocated to another Service, choose another spec.service.nodePort",
+				foo.Spec.Service.NodePort))
+	}
+	return err
+}
+
+// getServicePorts returns the ports of desired keeping the node ports the
+// existing ports of the same name were allocated, unless a node port is
+// pinned in desired.
+func getServicePorts(desired []apiv1.ServicePort, current []apiv1.ServicePort) []apiv1.ServicePort {
+	var ports []apiv1.ServicePort
+	for _, port := range desired {
+		for _, currentPort := range current {
+			if currentPort.Name == port.Name && port.NodePort == 0 {
+				port.NodePort = currentPort.NodePort
+			}
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// getServiceAddress returns the IP and port clients reach the instance at.
+// A LoadBalancer is reached at its ingress once it has been provisioned.
+func getServiceAddress(service *apiv1.Service) (string, string) {
+	if service.Spec.Type == apiv1.ServiceTypeLoadBalancer {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ingress.IP, fmt.Sprint(service.Spec.Ports[0].Port)
+			}
+			if ingress.Hostname != "" {
+				return ingress.Hostname, fmt.Sprint(service.Spec.Ports[0].Port)
+			}
+		}
+	}
+	return MINIKUBE_IP, fmt.Sprint(service.Spec.Ports[0].NodePort)
+}
+
+// syncService reconciles the type, ports and Spec.Service onto the existing
+// Service and returns the address it is reached at. The clusterIP is
+// immutable and kept, as are the node ports of existing ports so that
+// clients do not have to be reconfigured. A missing Service is re-created
+// along with its Deployment instead, an empty address is then returned.
+func (c *Controller) syncService(foo *postgresv1.Postgres) (string, string, error) {
+	serviceClient := c.kubeclientset.CoreV1().Services(c.getInstanceNamespace())
+	service, err := serviceClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	desired := getService(foo)
+
+	serviceCopy := service.DeepCopy()
+	if managed, ok := serviceCopy.Annotations[SERVICE_ANNOTATIONS_KEY]; ok {
+		for _, key := range strings.Split(managed, ",") {
+			delete(serviceCopy.Annotations, key)
+		}
+		delete(serviceCopy.Annotations, SERVICE_ANNOTATIONS_KEY)
+	}
+	if foo.Spec.Service != nil && len(foo.Spec.Service.Annotations) > 0 {
+		if serviceCopy.Annotations == nil {
+			serviceCopy.Annotations = map[string]string{}
+		}
+		for key, value := range foo.Spec.Service.Annotations {
+			serviceCopy.Annotations[key] = value
+		}
+		serviceCopy.Annotations[SERVICE_ANNOTATIONS_KEY] = desired.Annotations[SERVICE_ANNOTATIONS_KEY]
+	}
+	serviceCopy.Spec.Type = desired.Spec.Type
+	serviceCopy.Spec.Ports = getServicePorts(desired.Spec.Ports, service.Spec.Ports)
+	serviceCopy.Spec.LoadBalancerSourceRanges = desired.Spec.LoadBalancerSourceRanges
+	// An unset policy is defaulted by the API server and kept as is
+	if desired.Spec.ExternalTrafficPolicy != "" {
+		serviceCopy.Spec.ExternalTrafficPolicy = desired.Spec.ExternalTrafficPolicy
+	}
+
+	if reflect.DeepEqual(serviceCopy.Annotations, service.Annotations) &&
+		reflect.DeepEqual(serviceCopy.Spec, service.Spec) {
+		serviceIP, servicePort := getServiceAddress(service)
+		return serviceIP, servicePort, nil
+	}
+	fmt.Printf("Updating service %s...\n", service.Name)
+	updated, err := serviceClient.Update(serviceCopy)
+	if err != nil {
+		return "", "", c.checkNodePortConflict(foo, err)
+	}
+	c.recorder.Event(foo, apiv1.EventTypeNormal, ServiceUpdated,
+		fmt.Sprintf("Updated service %s to type %s", service.Name, serviceCopy.Spec.Type))
+	serviceIP, servicePort := getServiceAddress(updated)
+	return serviceIP, servicePort, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestSyncServiceUpdatesExistingService(t *testing.T) {
+	foo := newTestPostgres(nil)
+	service := getService(foo)
+	service.Namespace = "default"
+	service.Spec.Ports[0].NodePort = 31000
+	service.Annotations = map[string]string{
+		"old":                   "value",
+		SERVICE_ANNOTATIONS_KEY: "old",
+	}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{kubeclientset: fake.NewSimpleClientset(service), recorder: recorder}
+
+	foo.Spec.Service = &postgresv1.ServiceSpec{
+		Type:                     apiv1.ServiceTypeLoadBalancer,
+		Annotations:              map[string]string{"service.beta.kubernetes.io/aws-load-balancer-internal": "true"},
+		LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+		ExternalTrafficPolicy:    apiv1.ServiceExternalTrafficPolicyTypeLocal,
+	}
+	if _, _, err := c.syncService(foo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := c.kubeclientset.CoreV1().Services("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedAnnotations := map[string]string{
+		"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+		SERVICE_ANNOTATIONS_KEY:                                 "service.beta.kubernetes.io/aws-load-balancer-internal",
+	}
+	if !reflect.DeepEqual(updated.Annotations, expectedAnnotations) {
+		t.Errorf("expected annotations %v\ngot %v", expectedAnnotations, updated.Annotations)
+	}
+	if updated.Spec.Type != apiv1.ServiceTypeLoadBalancer {
+		t.Errorf("expected type LoadBalancer, got %s", updated.Spec.Type)
+	}
+	if !reflect.DeepEqual(updated.Spec.LoadBalancerSourceRanges, []string{"10.0.0.0/8"}) {
+		t.Errorf("expected source ranges [10.0.0.0/8], got %v", updated.Spec.LoadBalancerSourceRanges)
+	}
+	if updated.Spec.ExternalTrafficPolicy != apiv1.ServiceExternalTrafficPolicyTypeLocal {
+		t.Errorf("expected policy Local, got %s", updated.Spec.ExternalTrafficPolicy)
+	}
+	if updated.Spec.Ports[0].NodePort != 31000 {
+		t.Errorf("expected node port 31000 to be kept, got %d", updated.Spec.Ports[0].NodePort)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, ServiceUpdated) {
+		t.Errorf("expected a %s event, got %s", ServiceUpdated, event)
+	}
+}
+
+func TestSyncServiceKeepsNodePorts(t *testing.T) {
+	foo := newTestPostgres(nil)
+	service := getService(foo)
+	service.Namespace = "default"
+	service.Spec.ClusterIP = "10.0.0.12"
+	service.Spec.Ports[0].NodePort = 31000
+	c := &Controller{kubeclientset: fake.NewSimpleClientset(service), recorder: record.NewFakeRecorder(10)}
+
+	foo.Spec.Monitoring = &postgresv1.MonitoringSpec{Enabled: true}
+	serviceIP, servicePort, err := c.syncService(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if serviceIP != MINIKUBE_IP || servicePort != "31000" {
+		t.Errorf("expected address %s:31000, got %s:%s", MINIKUBE_IP, serviceIP, servicePort)
+	}
+	updated, err := c.kubeclientset.CoreV1().Services("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.Spec.ClusterIP != "10.0.0.12" {
+		t.Errorf("expected clusterIP 10.0.0.12 to be kept, got %s", updated.Spec.ClusterIP)
+	}
+	if len(updated.Spec.Ports) != 2 || updated.Spec.Ports[1].Name != "metrics" {
+		t.Errorf("expected the metrics port to be added, got %v", updated.Spec.Ports)
+	}
+}
+
+func TestSyncServicePinsNodePort(t *testing.T) {
+	foo := newTestPostgres(nil)
+	service := getService(foo)
+	service.Namespace = "default"
+	service.Spec.Ports[0].NodePort = 31000
+	c := &Controller{kubeclientset: fake.NewSimpleClientset(service), recorder: record.NewFakeRecorder(10)}
+
+	foo.Spec.Service = &postgresv1.ServiceSpec{NodePort: 30432}
+	_, servicePort, err := c.syncService(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if servicePort != "30432" {
+		t.Errorf("expected the pinned node port 30432, got %s", servicePort)
+	}
+}
+
+func TestSyncServiceRecordsNodePortConflict(t *testing.T) {
+	foo := newTestPostgres(nil)
+	service := getService(foo)
+	service.Namespace = "default"
+	service.Spec.Ports[0].NodePort = 31000
+	client := fake.NewSimpleClientset(service)
+	client.PrependReactor("update", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewInvalid(schema.GroupKind{Kind: "Service"}, "client25", field.ErrorList{
+			field.Invalid(field.NewPath("spec", "ports").Index(0).Child("nodePort"), 30432,
+				"provided port is already allocated"),
+		})
+	})
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{kubeclientset: client, recorder: recorder}
+
+	foo.Spec.Service = &postgresv1.ServiceSpec{NodePort: 30432}
+	if _, _, err := c.syncService(foo); !errors.IsInvalid(err) {
+		t.Fatalf("expected an invalid error, got %v", err)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnNodePortConflict) {
+		t.Errorf("expected a %s event, got %s", WarnNodePortConflict, event)
+	}
+}
+
+func TestGetServiceAddressOfLoadBalancer(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Service = &postgresv1.ServiceSpec{Type: apiv1.ServiceTypeLoadBalancer}
+	service := getService(foo)
+	service.Spec.Ports[0].NodePort = 31000
+
+	if serviceIP, servicePort := getServiceAddress(service); serviceIP != MINIKUBE_IP || servicePort != "31000" {
+		t.Errorf("expected the node port before the load balancer is provisioned, got %s:%s", serviceIP, servicePort)
+	}
+	service.Status.LoadBalancer.Ingress = []apiv1.LoadBalancerIngress{{IP: "35.1.2.3"}}
+	if serviceIP, servicePort := getServiceAddress(service); serviceIP != "35.1.2.3" || servicePort != "5432" {
+		t.Errorf("expected address 35.1.2.3:5432, got %s:%s", serviceIP, servicePort)
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	service := &postgresv1.ServiceSpec{
+		Type:                     apiv1.ServiceTypeClusterIP,
+		LoadBalancerSourceRanges: []string{"10.0.0.0"},
+		ExternalTrafficPolicy:    "Nearest",
+		NodePort:                 5432,
+	}
+	problems := validateService(service)
+	if len(problems) != 5 {
+		t.Errorf("expected 5 problems, got %v", problems)
+	}
+}
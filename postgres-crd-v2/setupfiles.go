@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getPendingSetupFiles returns the names of the .sql keys of the ConfigMap
+// that have not been applied yet, sorted by name, and their contents.
+func getPendingSetupFiles(data map[string]string, applied []string) ([]string, []string) {
+	var names []string
+	for name := range data {
+		if strings.HasSuffix(name, ".sql") && !contains(applied, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var contents []string
+	for _, name := range names {
+		contents = append(contents, data[name])
+	}
+	return names, contents
+}
+
+// applySetupFiles runs the pending files of Spec.SetupFromConfigMapRef
+// against the first database, like Spec.Commands. Each file is executed as
+// one command so it may contain several statements. Files are tracked by
+// name, a file is not re-run when its content changes.
+func (c *Controller) applySetupFiles(foo *postgresv1.Postgres, endpoint dbEndpoint) ([]string, error) {
+	if foo.Spec.SetupFromConfigMapRef == "" {
+		return nil, nil
+	}
+	configMap, err := c.kubeclientset.CoreV1().ConfigMaps(foo.Namespace).Get(foo.Spec.SetupFromConfigMapRef, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names, contents := getPendingSetupFiles(configMap.Data, foo.Status.AppliedSetupFiles)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	fmt.Printf("Applying setup files %v from configmap %s\n", names, foo.Spec.SetupFromConfigMapRef)
+
+	cleanup, err := c.setupSSL(foo, &endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	err = c.setupDatabase(foo, endpoint, contents, getDatabaseNames(foo.Spec.Databases))
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetPendingSetupFiles(t *testing.T) {
+	data := map[string]string{
+		"002-tables.sql": "create table a (id int); create table b (id int);",
+		"001-schema.sql": "create schema app;",
+		"003-seed.sql":   "insert into a values (1);",
+		"README":         "not sql",
+	}
+	names, contents := getPendingSetupFiles(data, []string{"001-schema.sql"})
+
+	expectedNames := []string{"002-tables.sql", "003-seed.sql"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("expected %v\ngot %v", expectedNames, names)
+	}
+	expectedContents := []string{data["002-tables.sql"], data["003-seed.sql"]}
+	if !reflect.DeepEqual(contents, expectedContents) {
+		t.Errorf("expected %v\ngot %v", expectedContents, contents)
+	}
+}
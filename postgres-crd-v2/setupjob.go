@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	SETUP_JOB_NAME_POSTFIX = "-setup-"
+	SETUP_SCRIPT_KEY       = "commands.sql"
+	SETUP_SCRIPT_PATH      = "/etc/postgresql/setup"
+	SETUP_SCRIPT_VOLUME    = "setup-commands"
+	// Label of the setup Jobs of an instance
+	SETUP_JOB_LABEL = "postgrescontroller.kubeplus/setup-of"
+)
+
+// usesSetupJob returns true if the commands of foo are run in a Job. Only
+// instances created by the controller are set up this way.
+func usesSetupJob(foo *postgresv1.Postgres) bool {
+	return foo.Spec.UseSetupJob && foo.Spec.SharedInstance == "" && foo.Spec.ExternalEndpoint == nil
+}
+
+// getSetupScript renders the commands as a psql script. The \c commands are
+// run by psql itself.
+func getSetupScript(commands []string) string {
+	return strings.Join(commands, "\n") + "\n"
+}
+
+// getSetupJobName names the Job after the commands and the generation of
+// the spec, so that a Job found on a retry is waited on instead of running
+// the commands again.
+func getSetupJobName(foo *postgresv1.Postgres, script string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s", foo.Generation, script)))
+	return foo.Spec.DeploymentName + SETUP_JOB_NAME_POSTFIX + fmt.Sprintf("%x", hash)[:10]
+}
+
+// getSetupJob runs the script of the Secret jobName with psql against the
+// instance, starting in dbname.
+func getSetupJob(foo *postgresv1.Postgres, namespace string, jobName string, dbname string) *batchv1.Job {
+	deploymentName := foo.Spec.DeploymentName
+	labels := map[string]string{
+		"app":           deploymentName,
+		SETUP_JOB_LABEL: deploymentName,
+	}
+	container := apiv1.Container{
+		Name:    "setup",
+		Image:   foo.Spec.Image,
+		Command: []string{"psql", "-v", "ON_ERROR_STOP=1", "-d", dbname, "-f", SETUP_SCRIPT_PATH + "/" + SETUP_SCRIPT_KEY},
+		Env: []apiv1.EnvVar{
+			{Name: "PGHOST", Value: getServiceHost(foo, namespace)},
+			{Name: "PGPORT", Value: "5432"},
+			{Name: "PGUSER", Value: getSuperuserName(foo)},
+			{Name: "PGSSLMODE", Value: getSSLMode(foo)},
+			getSuperuserPasswordEnv(foo, "PGPASSWORD"),
+		},
+		VolumeMounts: []apiv1.VolumeMount{
+			{
+				Name:      SETUP_SCRIPT_VOLUME,
+				MountPath: SETUP_SCRIPT_PATH,
+				ReadOnly:  true,
+			},
+		},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   jobName,
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			// The commands may have partially run, a failed Job is not
+			// retried until the spec changes
+			BackoffLimit: int32Ptr(0),
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: apiv1.PodSpec{
+					RestartPolicy: apiv1.RestartPolicyNever,
+					Containers:    []apiv1.Container{container},
+					Volumes: []apiv1.Volume{
+						{
+							Name: SETUP_SCRIPT_VOLUME,
+							VolumeSource: apiv1.VolumeSource{
+								Secret: &apiv1.SecretVolumeSource{SecretName: jobName},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// Owner references cannot cross namespaces
+	if foo.Namespace == namespace {
+		job.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(foo, postgresv1.SchemeGroupVersion.WithKind("Postgres")),
+		}
+	}
+	return job
+}
+
+// runSetupJob runs the commands in a Job instead of the controller process
+// and waits for it to finish. The script is passed in a Secret as it may
+// contain passwords; the Secret is deleted once the Job succeeded while the
+// Job is kept for its logs until the next setup Job of the instance
+// succeeds.
+func (c *Controller) runSetupJob(foo *postgresv1.Postgres, commands []string, dbname string) error {
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	namespace := c.getInstanceNamespace()
+	script := getSetupScript(commands)
+	jobName := getSetupJobName(foo, script)
+	secretsClient := c.kubeclientset.CoreV1().Secrets(namespace)
+	jobsClient := c.kubeclientset.BatchV1().Jobs(namespace)
+
+	_, err := jobsClient.Get(jobName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: jobName,
+				Labels: map[string]string{
+					"app": foo.Spec.DeploymentName,
+				},
+			},
+			Type: apiv1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				SETUP_SCRIPT_KEY: []byte(script),
+			},
+		}
+		_, err = secretsClient.Create(secret)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		fmt.Printf("Running setup job %s...\n", jobName)
+		_, err = jobsClient.Create(getSetupJob(foo, namespace, jobName, dbname))
+	}
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	for {
+		job, err := jobsClient.Get(jobName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if job.Status.Failed > 0 {
+			// Which command failed is only in the logs of the Job
+			c.recordCommandResults(foo, commands, 0, nil)
+			return fmt.Errorf("setup job %s failed, see its logs", jobName)
+		}
+		if job.Status.Succeeded > 0 {
+			fmt.Printf("Setup job %s succeeded.\n", jobName)
+			break
+		}
+		fmt.Println("Waiting for setup job to complete.")
+		time.Sleep(time.Second * 4)
+	}
+
+	for _, command := range commands {
+		if !isConnectCommand(command) {
+			c.recordCommandEvent(foo, command)
+		}
+	}
+	c.recordCommandResults(foo, commands, len(commands), nil)
+	err = secretsClient.Delete(jobName, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return c.deleteOldSetupJobs(foo, jobName)
+}
+
+// deleteOldSetupJobs deletes the setup Jobs of the instance other than
+// jobName, along with their Pods.
+func (c *Controller) deleteOldSetupJobs(foo *postgresv1.Postgres, jobName string) error {
+	jobsClient := c.kubeclientset.BatchV1().Jobs(c.getInstanceNamespace())
+	jobs, err := jobsClient.List(metav1.ListOptions{
+		LabelSelector: SETUP_JOB_LABEL + "=" + foo.Spec.DeploymentName,
+	})
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs.Items {
+		if job.Name == jobName {
+			continue
+		}
+		err = jobsClient.Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		// The Secret of a failed Job is kept with it
+		err = c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace()).Delete(job.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
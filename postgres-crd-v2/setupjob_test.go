@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestGetSetupJob(t *testing.T) {
+	foo := newTestPostgres(nil)
+	job := getSetupJob(foo, "default", "client25-setup-0123456789", "moodle")
+
+	container := job.Spec.Template.Spec.Containers[0]
+	expected := []string{"psql", "-v", "ON_ERROR_STOP=1", "-d", "moodle", "-f", "/etc/postgresql/setup/commands.sql"}
+	if !reflect.DeepEqual(container.Command, expected) {
+		t.Errorf("expected command %v\ngot %v", expected, container.Command)
+	}
+	if password := getSuperuserPasswordEnv(foo, "PGPASSWORD"); !reflect.DeepEqual(container.Env[4], password) {
+		t.Errorf("expected %v\ngot %v", password, container.Env[4])
+	}
+	if secret := job.Spec.Template.Spec.Volumes[0].Secret.SecretName; secret != job.Name {
+		t.Errorf("expected the script from secret %s, got %s", job.Name, secret)
+	}
+	if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].Name != foo.Name {
+		t.Errorf("expected the job to be owned by %s, got %v", foo.Name, job.OwnerReferences)
+	}
+	if job := getSetupJob(foo, "team-a", "client25-setup-0123456789", "moodle"); job.OwnerReferences != nil {
+		t.Errorf("expected no owner across namespaces, got %v", job.OwnerReferences)
+	}
+}
+
+func TestGetSetupJobNameChangesWithGeneration(t *testing.T) {
+	foo := newTestPostgres(nil)
+	script := getSetupScript([]string{"create database moodle;"})
+	name := getSetupJobName(foo, script)
+	if name != getSetupJobName(foo, script) {
+		t.Errorf("expected the same job name for the same commands")
+	}
+	foo.Generation++
+	if name == getSetupJobName(foo, script) {
+		t.Errorf("expected a new job name for a new generation")
+	}
+}
+
+func TestRunSetupJob(t *testing.T) {
+	foo := newTestPostgres(nil)
+	client := fake.NewSimpleClientset()
+	// Jobs complete as soon as they are created
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Status.Succeeded = 1
+		return false, nil, nil
+	})
+	c := &Controller{kubeclientset: client, recorder: record.NewFakeRecorder(10)}
+
+	commands := []string{"create database moodle;", "\\c moodle;", "create schema app;"}
+	if err := c.runSetupJob(foo, commands, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jobName := getSetupJobName(foo, getSetupScript(commands))
+	if _, err := client.BatchV1().Jobs("default").Get(jobName, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected job %s to be kept: %v", jobName, err)
+	}
+	if _, err := client.CoreV1().Secrets("default").Get(jobName, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the script secret to be deleted once the job succeeded")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// ErrNameConflict is used as part of the Event 'reason' when a Postgres
+	// resource declares a database or user already managed by another
+	// resource on the same instance.
+	ErrNameConflict = "ErrNameConflict"
+)
+
+// getInstanceKey returns the namespace/name of the Postgres resource that
+// owns the backing instance. Resources sharing an instance have the same key.
+func getInstanceKey(foo *postgresv1.Postgres) string {
+	if foo.Spec.SharedInstance != "" {
+		return foo.Namespace + "/" + foo.Spec.SharedInstance
+	}
+	return foo.Namespace + "/" + foo.Name
+}
+
+// checkSharedInstanceConflicts makes sure that the databases and users of foo
+// are not declared by another resource on the same instance. Only the
+// resource that introduced a collision is rejected: the instance owner is
+// never blocked, and a shared resource only by the owner or by a shared
+// resource created before it.
+func checkSharedInstanceConflicts(foo *postgresv1.Postgres, all []*postgresv1.Postgres) error {
+	if foo.Spec.SharedInstance == "" {
+		return nil
+	}
+	instanceKey := getInstanceKey(foo)
+	for _, other := range all {
+		if other.Namespace == foo.Namespace && other.Name == foo.Name {
+			continue
+		}
+		if getInstanceKey(other) != instanceKey {
+			continue
+		}
+		if other.Spec.SharedInstance != "" && !createdBefore(other, foo) {
+			continue
+		}
+		for _, db := range getDatabaseNames(foo.Spec.Databases) {
+			for _, otherDB := range getDatabaseNames(other.Spec.Databases) {
+				if db == otherDB {
+					return fmt.Errorf("database %s on instance %s is already managed by %s",
+						db, instanceKey, other.Name)
+				}
+			}
+		}
+		for _, user := range foo.Spec.Users {
+			for _, otherUser := range other.Spec.Users {
+				if user.User == otherUser.User {
+					return fmt.Errorf("user %s on instance %s is already managed by %s",
+						user.User, instanceKey, other.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// createdBefore returns true if a was created before b. Resources created
+// in the same second are ordered by name.
+func createdBefore(a *postgresv1.Postgres, b *postgresv1.Postgres) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Time.Before(b.CreationTimestamp.Time)
+	}
+	return a.Name < b.Name
+}
+
+// syncSharedInstance reconciles the databases and users of a Postgres
+// resource that runs on the instance of another Postgres resource. No
+// Deployment or Service is created for it.
+func (c *Controller) syncSharedInstance(foo *postgresv1.Postgres) error {
+	instance, err := c.foosLister.Postgreses(foo.Namespace).Get(foo.Spec.SharedInstance)
+	if err != nil {
+		return err
+	}
+	if instance.Spec.SharedInstance != "" {
+		runtime.HandleError(fmt.Errorf("%s/%s: shared instance %s is itself a shared resource",
+			foo.Namespace, foo.Name, instance.Name))
+		return nil
+	}
+	if instance.Status.Status != "READY" && instance.Status.Status != "EXTERNAL" {
+		return fmt.Errorf("shared instance %s is not ready yet", getInstanceKey(foo))
+	}
+
+	var endpoint dbEndpoint
+	if instance.Spec.ExternalEndpoint != nil {
+		endpoint, err = c.getExternalEndpoint(instance)
+		if err != nil {
+			return err
+		}
+	} else {
+		endpoint, err = c.getInstanceEndpoint(instance, instance.Status.ServiceIP, instance.Status.ServicePort)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.syncWithFailover(foo, endpoint, "READY")
+	if err != nil {
+		return err
+	}
+	return c.runOnceOn(foo, endpoint)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newSharedPostgres(name string, sharedInstance string, databases []string, users []string) *postgresv1.Postgres {
+	var userSpecs []postgresv1.UserSpec
+	for _, user := range users {
+		userSpecs = append(userSpecs, postgresv1.UserSpec{User: user, Password: "pass123"})
+	}
+	return &postgresv1.Postgres{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: postgresv1.PostgresSpec{
+			SharedInstance: sharedInstance,
+			Databases:      newDatabaseSpecs(databases...),
+			Users:          userSpecs,
+		},
+	}
+}
+
+func TestSharedInstanceDisjointDatabases(t *testing.T) {
+	instance := newSharedPostgres("client25", "", []string{"moodle"}, []string{"devdatta"})
+	app1 := newSharedPostgres("app1", "client25", []string{"wordpress"}, []string{"app1user"})
+	app2 := newSharedPostgres("app2", "client25", []string{"ecommerce"}, []string{"app2user"})
+	all := []*postgresv1.Postgres{instance, app1, app2}
+
+	if getInstanceKey(app1) != getInstanceKey(app2) || getInstanceKey(app1) != getInstanceKey(instance) {
+		t.Fatalf("expected app1, app2 and client25 to share instance key, got %s, %s, %s",
+			getInstanceKey(app1), getInstanceKey(app2), getInstanceKey(instance))
+	}
+	for _, foo := range all {
+		if err := checkSharedInstanceConflicts(foo, all); err != nil {
+			t.Errorf("unexpected conflict for %s: %v", foo.Name, err)
+		}
+	}
+}
+
+func TestSharedInstanceConflicts(t *testing.T) {
+	testCases := []struct {
+		name  string
+		other *postgresv1.Postgres
+	}{
+		{"database owned by instance", newSharedPostgres("client25", "", []string{"wordpress"}, nil)},
+		{"database owned by other shared resource", newSharedPostgres("app2", "client25", []string{"wordpress"}, nil)},
+		{"user owned by other shared resource", newSharedPostgres("app2", "client25", nil, []string{"app1user"})},
+	}
+	for _, tc := range testCases {
+		app1 := newSharedPostgres("app1", "client25", []string{"wordpress"}, []string{"app1user"})
+		app1.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+		tc.other.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+		all := []*postgresv1.Postgres{app1, tc.other}
+		if err := checkSharedInstanceConflicts(app1, all); err == nil {
+			t.Errorf("%s: expected a conflict", tc.name)
+		}
+	}
+}
+
+func TestSharedInstanceConflictRejectsNewerResource(t *testing.T) {
+	instance := newSharedPostgres("client25", "", []string{"moodle"}, []string{"devdatta"})
+	instance.CreationTimestamp = metav1.NewTime(time.Unix(300, 0))
+	app1 := newSharedPostgres("app1", "client25", []string{"wordpress"}, nil)
+	app1.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	app2 := newSharedPostgres("app2", "client25", []string{"moodle", "wordpress"}, nil)
+	app2.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+	all := []*postgresv1.Postgres{instance, app1, app2}
+
+	if err := checkSharedInstanceConflicts(instance, all); err != nil {
+		t.Errorf("expected the instance owner never to be blocked, got %v", err)
+	}
+	if err := checkSharedInstanceConflicts(app1, all); err != nil {
+		t.Errorf("expected the older shared resource not to be blocked, got %v", err)
+	}
+	if err := checkSharedInstanceConflicts(app2, all); err == nil {
+		t.Errorf("expected a conflict for the newer shared resource")
+	}
+}
+
+func TestSeparateInstancesDoNotConflict(t *testing.T) {
+	app1 := newSharedPostgres("app1", "client25", []string{"wordpress"}, []string{"app1user"})
+	app2 := newSharedPostgres("app2", "client26", []string{"wordpress"}, []string{"app1user"})
+	all := []*postgresv1.Postgres{app1, app2}
+	if err := checkSharedInstanceConflicts(app1, all); err != nil {
+		t.Errorf("unexpected conflict across instances: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// DEFAULT_TERMINATION_GRACE_PERIOD_SECONDS leaves Postgres time for
+	// the shutdown checkpoint, which pg_ctl waits up to 60s for.
+	DEFAULT_TERMINATION_GRACE_PERIOD_SECONDS = 90
+)
+
+// Stops Postgres with a fast shutdown: open sessions are disconnected and a
+// checkpoint is written, so that the next start needs no crash recovery.
+// The default smart shutdown on SIGTERM waits for the clients to
+// disconnect and is killed at the end of the grace period. pg_ctl refuses
+// to run as root; the official images have gosu (Debian) or su-exec
+// (Alpine). Without root, e.g. with the default security context, pg_ctl
+// already runs as the postgres user.
+const preStopScript = `run=
+if [ "$(id -u)" = 0 ]; then
+  run="gosu postgres"
+  command -v gosu >/dev/null 2>&1 || run="su-exec postgres"
+fi
+exec $run pg_ctl stop -m fast -w
+`
+
+func validateTerminationGracePeriod(foo *postgresv1.Postgres) error {
+	seconds := foo.Spec.TerminationGracePeriodSeconds
+	if seconds != nil && *seconds < 0 {
+		return fmt.Errorf("spec.terminationGracePeriodSeconds must not be negative")
+	}
+	return nil
+}
+
+func getTerminationGracePeriodSeconds(foo *postgresv1.Postgres) int64 {
+	if foo.Spec.TerminationGracePeriodSeconds != nil {
+		return *foo.Spec.TerminationGracePeriodSeconds
+	}
+	return DEFAULT_TERMINATION_GRACE_PERIOD_SECONDS
+}
+
+// addShutdown sets the grace period of the Pod and the preStop hook
+// stopping Postgres cleanly.
+func addShutdown(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	seconds := getTerminationGracePeriodSeconds(foo)
+	podSpec.TerminationGracePeriodSeconds = &seconds
+
+	container := &podSpec.Containers[0]
+	container.Lifecycle = &apiv1.Lifecycle{
+		PreStop: &apiv1.Handler{
+			Exec: &apiv1.ExecAction{
+				Command: []string{"/bin/sh", "-c", preStopScript},
+			},
+		},
+	}
+}
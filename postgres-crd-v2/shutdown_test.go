@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestPostgresShutsDownCleanly(t *testing.T) {
+	foo := newTestPostgres(nil)
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	if seconds := podSpec.TerminationGracePeriodSeconds; seconds == nil || *seconds != DEFAULT_TERMINATION_GRACE_PERIOD_SECONDS {
+		t.Errorf("expected the default grace period, got %v", seconds)
+	}
+	lifecycle := podSpec.Containers[0].Lifecycle
+	if lifecycle == nil || lifecycle.PreStop == nil || lifecycle.PreStop.Exec == nil {
+		t.Fatalf("expected a preStop exec hook, got %v", lifecycle)
+	}
+	if command := lifecycle.PreStop.Exec.Command; len(command) != 3 || command[2] != preStopScript {
+		t.Errorf("expected the preStop script, got %v", command)
+	}
+
+	seconds := int64(300)
+	foo.Spec.TerminationGracePeriodSeconds = &seconds
+	podSpec = getDeployment(foo).Spec.Template.Spec
+	if *podSpec.TerminationGracePeriodSeconds != 300 {
+		t.Errorf("expected a grace period of 300, got %d", *podSpec.TerminationGracePeriodSeconds)
+	}
+	// Sidecars are stopped by SIGTERM
+	foo.Spec.Monitoring = &postgresv1.MonitoringSpec{Enabled: true}
+	for _, container := range getDeployment(foo).Spec.Template.Spec.Containers[1:] {
+		if container.Lifecycle != nil {
+			t.Errorf("expected no preStop hook on %s", container.Name)
+		}
+	}
+}
+
+func TestValidateTerminationGracePeriod(t *testing.T) {
+	foo := newTestPostgres(nil)
+	seconds := int64(-1)
+	foo.Spec.TerminationGracePeriodSeconds = &seconds
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected a negative grace period to be rejected, got %v", problems)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// getSSLMode returns the sslmode the controller connects with, "disable"
+// by default or "verify-full" with a client certificate.
+func getSSLMode(foo *postgresv1.Postgres) string {
+	if foo.Spec.SSLMode != "" {
+		return foo.Spec.SSLMode
+	}
+	if usesClientCert(foo) {
+		return "verify-full"
+	}
+	return "disable"
+}
+
+func usesClientCert(foo *postgresv1.Postgres) bool {
+	return foo.Spec.SSL != nil && foo.Spec.SSL.ClientCertSecretRef != ""
+}
+
+func validateSSLMode(sslMode string) error {
+	if sslMode == "" || contains(sslModes, sslMode) {
+		return nil
+	}
+	return fmt.Errorf("invalid sslMode %q, must be one of %v", sslMode, sslModes)
+}
+
+// validateClientCert checks that the server is verified when the
+// controller authenticates with a client certificate.
+func validateClientCert(foo *postgresv1.Postgres) []string {
+	if !usesClientCert(foo) {
+		return nil
+	}
+	var problems []string
+	if getSSLMode(foo) != "verify-full" {
+		problems = append(problems, "spec.ssl.clientCertSecretRef requires sslMode verify-full")
+	}
+	if foo.Spec.SSL.CASecretRef == "" {
+		problems = append(problems, "spec.ssl.clientCertSecretRef requires spec.ssl.caSecretRef")
+	}
+	return problems
+}
+
+// setupSSL writes the CA certificate and the client certificate and key
+// referenced by the spec to temp files the pq driver can read and points
+// the endpoint to them. The returned function removes the files and must be
+// called once the connections to the endpoint are closed.
+func (c *Controller) setupSSL(foo *postgresv1.Postgres, endpoint *dbEndpoint) (func(), error) {
+	var files []string
+	cleanup := func() {
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+	if foo.Spec.SSL == nil {
+		return cleanup, nil
+	}
+	writeKey := func(secretName string, key string, prefix string) (string, error) {
+		secret, err := c.kubeclientset.CoreV1().Secrets(foo.Namespace).Get(secretName, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		data, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("secret %s has no %s key", secretName, key)
+		}
+		// Temp files are only readable by the owner as lib/pq requires
+		// for the private key
+		file, err := writeTempFile(prefix, data)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, file)
+		return file, nil
+	}
+
+	var err error
+	if foo.Spec.SSL.CASecretRef != "" {
+		endpoint.SSLRootCert, err = writeKey(foo.Spec.SSL.CASecretRef, "ca.crt", "postgres-ca-")
+	}
+	if err == nil && usesClientCert(foo) {
+		endpoint.SSLCert, err = writeKey(foo.Spec.SSL.ClientCertSecretRef, "tls.crt", "postgres-client-crt-")
+		if err == nil {
+			endpoint.SSLKey, err = writeKey(foo.Spec.SSL.ClientCertSecretRef, "tls.key", "postgres-client-key-")
+		}
+	}
+	if err != nil {
+		// Callers only defer the cleanup on success
+		cleanup()
+		return func() {}, err
+	}
+	return cleanup, nil
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	file, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func TestGetPsqlInfoSSLMode(t *testing.T) {
+	endpoint := dbEndpoint{Host: "10.0.0.1", Port: "5432", User: "postgres", Password: "secret"}
+	psqlInfo := getPsqlInfo(endpoint, "moodle")
+	if !strings.Contains(psqlInfo, "sslmode=disable") {
+		t.Errorf("expected sslmode=disable by default\ngot %s", psqlInfo)
+	}
+
+	endpoint.SSLMode = "verify-full"
+	endpoint.SSLRootCert = "/tmp/postgres-ca-123"
+	psqlInfo = getPsqlInfo(endpoint, "moodle")
+	for _, expected := range []string{"sslmode=verify-full", "sslrootcert=/tmp/postgres-ca-123", "dbname=moodle"} {
+		if !strings.Contains(psqlInfo, expected) {
+			t.Errorf("expected %s\ngot %s", expected, psqlInfo)
+		}
+	}
+}
+
+func TestValidateSSLMode(t *testing.T) {
+	for _, sslMode := range []string{"", "disable", "require", "verify-ca", "verify-full"} {
+		if err := validateSSLMode(sslMode); err != nil {
+			t.Errorf("expected %q to be valid, got %v", sslMode, err)
+		}
+	}
+	if err := validateSSLMode("prefer"); err == nil {
+		t.Errorf("expected prefer to be rejected")
+	}
+}
+
+func TestGetPsqlInfoClientCert(t *testing.T) {
+	endpoint := dbEndpoint{Host: "10.0.0.1", Port: "5432", User: "postgres", SSLMode: "verify-full",
+		SSLRootCert: "/tmp/postgres-ca-1", SSLCert: "/tmp/postgres-client-crt-1", SSLKey: "/tmp/postgres-client-key-1"}
+	psqlInfo := getPsqlInfo(endpoint, "")
+	for _, expected := range []string{"sslcert=/tmp/postgres-client-crt-1", "sslkey=/tmp/postgres-client-key-1"} {
+		if !strings.Contains(psqlInfo, expected) {
+			t.Errorf("expected %s\ngot %s", expected, psqlInfo)
+		}
+	}
+	if strings.Contains(psqlInfo, "password=") {
+		t.Errorf("expected no password\ngot %s", psqlInfo)
+	}
+}
+
+func TestSetupSSLClientCert(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.SSL = &postgresv1.SSLSpec{CASecretRef: "client25-ca", ClientCertSecretRef: "client25-cert"}
+	c := &Controller{kubeclientset: fake.NewSimpleClientset(
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "client25-ca", Namespace: "default"},
+			Data:       map[string][]byte{"ca.crt": []byte("ca")},
+		},
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "client25-cert", Namespace: "default"},
+			Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		},
+	)}
+
+	endpoint := &dbEndpoint{}
+	cleanup, err := c.setupSSL(foo, endpoint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]string{endpoint.SSLRootCert: "ca", endpoint.SSLCert: "cert", endpoint.SSLKey: "key"}
+	for file, expected := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil || string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q (%v)", file, expected, data, err)
+		}
+	}
+	if info, err := os.Stat(endpoint.SSLKey); err == nil && info.Mode().Perm()&0077 != 0 {
+		t.Errorf("expected the key file to be private, got mode %v", info.Mode())
+	}
+
+	cleanup()
+	for file := range files {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", file)
+		}
+	}
+}
+
+func TestValidateClientCert(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.SSL = &postgresv1.SSLSpec{CASecretRef: "client25-ca", ClientCertSecretRef: "client25-cert"}
+	if getSSLMode(foo) != "verify-full" {
+		t.Errorf("expected verify-full by default with a client certificate, got %s", getSSLMode(foo))
+	}
+	if problems := validateClientCert(foo); len(problems) > 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	foo.Spec.SSLMode = "require"
+	foo.Spec.SSL.CASecretRef = ""
+	if problems := validateClientCert(foo); len(problems) != 2 {
+		t.Errorf("expected sslMode and caSecretRef to be rejected, got %v", problems)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// queryCurrentState connects to the instance and returns the names of all
+// databases and roles that actually exist.
+func (c *Controller) queryCurrentState(endpoint dbEndpoint) ([]string, []string, error) {
+	endpoint = c.getConnectEndpoint(endpoint)
+	executor := c.newDBExecutor()
+	err := executor.Connect(endpoint, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer executor.Close()
+
+	databases, err := executor.QueryDatabases()
+	if err != nil {
+		return nil, nil, err
+	}
+	roles, err := executor.QueryRoles()
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("Live Databases:%v\n", databases)
+	fmt.Printf("Live Roles:%v\n", roles)
+	return databases, roles, nil
+}
+
+func contains(list []string, name string) bool {
+	for _, v := range list {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getCurrentDatabases returns the databases managed by this resource that
+// actually exist. Databases the resource does not know about (system
+// databases, manually created ones) are left out so they are never dropped.
+func getCurrentDatabases(liveDatabases []string, statusDatabases []string, desiredDatabases []string) []string {
+	var current []string
+	for _, db := range liveDatabases {
+		if contains(statusDatabases, db) || contains(desiredDatabases, db) {
+			current = append(current, db)
+		}
+	}
+	return current
+}
+
+// getCurrentUsers returns the users managed by this resource that actually
+// exist. The password is taken from the status as it cannot be read back;
+// a role that exists but was not created by us gets its password set.
+func getCurrentUsers(liveRoles []string, statusUsers []postgresv1.UserSpec, desiredUsers []postgresv1.UserSpec) []postgresv1.UserSpec {
+	var current []postgresv1.UserSpec
+	for _, role := range liveRoles {
+		found := false
+		for _, user := range statusUsers {
+			if user.User == role {
+				current = append(current, user)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, user := range desiredUsers {
+			if user.User == role {
+				current = append(current, postgresv1.UserSpec{User: role})
+				break
+			}
+		}
+	}
+	return current
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getStatusPatch returns the merge patch changing the status of foo, as
+// stored, to that of fooCopy. The spec is left out, so that a spec edited
+// since fooCopy was read is kept. Changed finalizers are included along
+// with the resourceVersion of foo, as the whole list is replaced.
+func getStatusPatch(foo *postgresv1.Postgres, fooCopy *postgresv1.Postgres) ([]byte, error) {
+	original, err := json.Marshal(postgresv1.Postgres{Status: foo.Status})
+	if err != nil {
+		return nil, err
+	}
+	modified, err := json.Marshal(postgresv1.Postgres{Status: fooCopy.Status})
+	if err != nil {
+		return nil, err
+	}
+	// Without patch strategies on the status types this is a JSON merge
+	// patch: lists are replaced and removed fields set to null
+	statusPatch, err := strategicpatch.CreateTwoWayMergePatch(original, modified, postgresv1.Postgres{})
+	if err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(foo.Finalizers, fooCopy.Finalizers) {
+		return statusPatch, nil
+	}
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(statusPatch, &patch); err != nil {
+		return nil, err
+	}
+	patch["metadata"] = map[string]interface{}{
+		"finalizers":      fooCopy.Finalizers,
+		"resourceVersion": foo.ResourceVersion,
+	}
+	return json.Marshal(patch)
+}
+
+// patchStatus writes the status of fooCopy over that of foo as stored. The
+// CRD has no status subresource and Update would write back the spec as
+// read, overwriting a concurrent edit of the spec.
+func (c *Controller) patchStatus(foo *postgresv1.Postgres, fooCopy *postgresv1.Postgres) error {
+	patch, err := getStatusPatch(foo, fooCopy)
+	if err != nil {
+		return err
+	}
+	if string(patch) == "{}" {
+		return nil
+	}
+	_, err = c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Patch(foo.Name,
+		types.MergePatchType, patch)
+	return err
+}
+
+// patchStatusIfUnchanged is patchStatus failing with a conflict when foo was
+// updated since it was read. It is used to add to lists of the status, which
+// a merge patch replaces as a whole.
+func (c *Controller) patchStatusIfUnchanged(foo *postgresv1.Postgres, fooCopy *postgresv1.Postgres) error {
+	patch, err := getStatusPatch(foo, fooCopy)
+	if err != nil {
+		return err
+	}
+	if string(patch) == "{}" {
+		return nil
+	}
+	patch, err = addPatchResourceVersion(patch, foo.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	_, err = c.sampleclientset.PostgrescontrollerV1().Postgreses(foo.Namespace).Patch(foo.Name,
+		types.MergePatchType, patch)
+	return err
+}
+
+// addPatchResourceVersion adds the resourceVersion a patch applies to, so
+// that the API server rejects it with a conflict for any other version.
+func addPatchResourceVersion(patch []byte, resourceVersion string) ([]byte, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return nil, err
+	}
+	metadata, ok := fields["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		fields["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = resourceVersion
+	return json.Marshal(fields)
+}
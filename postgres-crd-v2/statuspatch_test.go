@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	"github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/clientset/versioned/fake"
+)
+
+func TestUpdateFooStatusKeepsConcurrentSpecEdit(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Spec.Databases = []postgresv1.DatabaseSpec{{Name: "moodle"}}
+	client := fake.NewSimpleClientset(foo)
+	c := &Controller{
+		kubeclientset:   kubefake.NewSimpleClientset(),
+		sampleclientset: client,
+		recorder:        record.NewFakeRecorder(10),
+	}
+
+	// The controller synced foo as read before the user added a database
+	stale := foo.DeepCopy()
+	edited := foo.DeepCopy()
+	edited.Spec.Databases = append(edited.Spec.Databases, postgresv1.DatabaseSpec{Name: "wordpress"})
+	if _, err := client.PostgrescontrollerV1().Postgreses("default").Update(edited); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	users := []postgresv1.UserSpec{{User: "devdatta"}}
+	databases := []postgresv1.DatabaseSpec{{Name: "moodle"}}
+	err := c.updateFooStatus(stale, stale, nil, &users, &databases, "psql", MINIKUBE_IP, "30123", "", "client25", "READY")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := client.PostgrescontrollerV1().Postgreses("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated.Spec.Databases) != 2 || updated.Spec.Databases[1].Name != "wordpress" {
+		t.Errorf("expected the concurrent spec edit to be kept, got %+v", updated.Spec.Databases)
+	}
+	if updated.Status.Status != "READY" || updated.Status.ServicePort != "30123" {
+		t.Errorf("expected the status to be updated, got %+v", updated.Status)
+	}
+	if !hasFinalizer(updated) {
+		t.Errorf("expected the finalizer to be added, got %v", updated.Finalizers)
+	}
+}
+
+func TestUpdateFooStatusKeepsConcurrentActionHistory(t *testing.T) {
+	foo := newTestPostgres(nil)
+	client := fake.NewSimpleClientset(foo)
+	c := &Controller{
+		kubeclientset:   kubefake.NewSimpleClientset(),
+		sampleclientset: client,
+		recorder:        record.NewFakeRecorder(10),
+	}
+
+	// The history is appended to after the controller read foo
+	stale := foo.DeepCopy()
+	if err := c.appendActionHistory(foo, []string{"create database moodle;"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	users := []postgresv1.UserSpec{}
+	databases := []postgresv1.DatabaseSpec{{Name: "moodle"}}
+	err := c.updateFooStatus(stale, stale, nil, &users, &databases, "psql", MINIKUBE_IP, "30123", "", "client25", "UPDATING")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := client.PostgrescontrollerV1().Postgreses("default").Get("client25", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated.Status.ActionHistory) != 1 || updated.Status.ActionHistory[0] != "create database moodle;" {
+		t.Errorf("expected the concurrent history to be kept, got %v", updated.Status.ActionHistory)
+	}
+	if updated.Status.Status != "UPDATING" {
+		t.Errorf("expected status UPDATING, got %s", updated.Status.Status)
+	}
+}
+
+func TestGetStatusPatchOnlyChangesStatus(t *testing.T) {
+	foo := newTestPostgres(nil)
+	foo.Status.PlannedCommands = []string{"create database moodle;"}
+	fooCopy := foo.DeepCopy()
+	fooCopy.Spec.Image = "postgres:10"
+	fooCopy.Status.PlannedCommands = nil
+	fooCopy.Status.Status = "READY"
+
+	patch, err := getStatusPatch(foo, fooCopy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"status":{"plannedCommands":null,"status":"READY"}}`
+	if string(patch) != expected {
+		t.Errorf("expected patch %s, got %s", expected, patch)
+	}
+	if strings.Contains(string(patch), "finalizers") {
+		t.Errorf("expected the finalizers to be left out, got %s", patch)
+	}
+}
+
+func TestAddPatchResourceVersion(t *testing.T) {
+	patch, err := addPatchResourceVersion([]byte(`{"status":{"actionHistory":["create database moodle;"]}}`), "42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"metadata":{"resourceVersion":"42"},"status":{"actionHistory":["create database moodle;"]}}`
+	if string(patch) != expected {
+		t.Errorf("expected %s\ngot %s", expected, patch)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Mount path of the data volume in the official Postgres image
+	DATA_MOUNT_PATH = "/var/lib/postgresql/data"
+	// Default subdirectory of the data volume used for PGDATA. Volumes
+	// such as ext4 block devices contain a lost+found directory at the
+	// mount point which makes initdb fail.
+	DEFAULT_PGDATA_SUBDIR = "pgdata"
+	DATA_VOLUME_NAME      = "postgres-data"
+)
+
+func getPVCName(deploymentName string) string {
+	return deploymentName + "-data"
+}
+
+// getPGDataDir returns the value for PGDATA, or "" when the image default
+// should be used (no storage attached and no subdirectory requested).
+func getPGDataDir(storage *postgresv1.StorageSpec) string {
+	if storage == nil {
+		return ""
+	}
+	return path.Join(DATA_MOUNT_PATH, getPGDataSubdir(storage))
+}
+
+// getPGDataSubdir returns the subdirectory of the data volume PGDATA is in.
+// It is the same with or without SubPath so that the data stays in place
+// when SubPath is set on an existing volume.
+func getPGDataSubdir(storage *postgresv1.StorageSpec) string {
+	if storage.SubPath != "" {
+		return storage.SubPath
+	}
+	if storage.PGDataSubdir != "" {
+		return storage.PGDataSubdir
+	}
+	return DEFAULT_PGDATA_SUBDIR
+}
+
+func validateStorage(foo *postgresv1.Postgres) []string {
+	storage := foo.Spec.Storage
+	if storage == nil || storage.SubPath == "" {
+		return nil
+	}
+	var problems []string
+	if storage.PGDataSubdir != "" && storage.PGDataSubdir != storage.SubPath {
+		problems = append(problems, "spec.storage.subPath and spec.storage.pgdataSubdir must be the same if both are set")
+	}
+	if path.IsAbs(storage.SubPath) || strings.HasPrefix(path.Clean(storage.SubPath), "..") {
+		problems = append(problems, fmt.Sprintf("spec.storage.subPath %s must be a relative path within the volume", storage.SubPath))
+	}
+	// The restored WAL is put next to the data directory, outside the mount
+	if isPointInTimeRestore(foo) {
+		problems = append(problems, "spec.storage.subPath cannot be used with a point-in-time restore")
+	}
+	return problems
+}
+
+func getPVC(foo *postgresv1.Postgres) (*apiv1.PersistentVolumeClaim, error) {
+	storage := foo.Spec.Storage
+	size, err := resource.ParseQuantity(storage.Size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage size %q: %s", storage.Size, err.Error())
+	}
+	pvc := &apiv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getPVCName(foo.Spec.DeploymentName),
+			Labels: map[string]string{
+				"app": foo.Spec.DeploymentName,
+			},
+		},
+		Spec: apiv1.PersistentVolumeClaimSpec{
+			AccessModes: []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOnce},
+			Resources: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{
+					apiv1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	if storage.StorageClassName != "" {
+		pvc.Spec.StorageClassName = &storage.StorageClassName
+	}
+	return pvc, nil
+}
+
+// createPVC creates the claim backing the data volume. An already existing
+// claim is reused so that data survives re-creation of the Deployment.
+func createPVC(foo *postgresv1.Postgres, c *Controller) error {
+	pvc, err := getPVC(foo)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Creating persistent volume claim %s...\n", pvc.Name)
+	_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(c.getInstanceNamespace()).Create(pvc)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// addStorage mounts the data volume, or its SubPath, into the Postgres
+// container and points PGDATA to a subdirectory of the volume.
+func addStorage(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if foo.Spec.Storage == nil {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+		Name: DATA_VOLUME_NAME,
+		VolumeSource: apiv1.VolumeSource{
+			PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{
+				ClaimName: getPVCName(foo.Spec.DeploymentName),
+			},
+		},
+	})
+	mount := apiv1.VolumeMount{
+		Name:      DATA_VOLUME_NAME,
+		MountPath: DATA_MOUNT_PATH,
+	}
+	if foo.Spec.Storage.SubPath != "" {
+		mount.MountPath = getPGDataDir(foo.Spec.Storage)
+		mount.SubPath = foo.Spec.Storage.SubPath
+	}
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+	container.Env = append(container.Env, apiv1.EnvVar{
+		Name:  "PGDATA",
+		Value: getPGDataDir(foo.Spec.Storage),
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newTestPostgres(storage *postgresv1.StorageSpec) *postgresv1.Postgres {
+	return &postgresv1.Postgres{
+		ObjectMeta: metav1.ObjectMeta{Name: "client25", Namespace: "default"},
+		Spec: postgresv1.PostgresSpec{
+			DeploymentName: "client25",
+			Image:          "postgres:9.3",
+			Storage:        storage,
+		},
+	}
+}
+
+func getEnv(container apiv1.Container, name string) (string, bool) {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestPGDataPointsToSubdirWithStorage(t *testing.T) {
+	testCases := []struct {
+		storage  *postgresv1.StorageSpec
+		expected string
+	}{
+		{&postgresv1.StorageSpec{Size: "1Gi"}, "/var/lib/postgresql/data/pgdata"},
+		{&postgresv1.StorageSpec{Size: "1Gi", PGDataSubdir: "data"}, "/var/lib/postgresql/data/data"},
+	}
+	for _, tc := range testCases {
+		deployment := getDeployment(newTestPostgres(tc.storage))
+		container := deployment.Spec.Template.Spec.Containers[0]
+		pgdata, ok := getEnv(container, "PGDATA")
+		if !ok {
+			t.Fatalf("PGDATA not set for storage %+v", *tc.storage)
+		}
+		if pgdata != tc.expected {
+			t.Errorf("expected PGDATA %q, got %q", tc.expected, pgdata)
+		}
+		if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != DATA_MOUNT_PATH {
+			t.Errorf("expected data volume mounted at %s, got %v", DATA_MOUNT_PATH, container.VolumeMounts)
+		}
+		volumes := deployment.Spec.Template.Spec.Volumes
+		if len(volumes) != 1 || volumes[0].PersistentVolumeClaim == nil ||
+			volumes[0].PersistentVolumeClaim.ClaimName != "client25-data" {
+			t.Errorf("expected volume backed by claim client25-data, got %v", volumes)
+		}
+	}
+}
+
+func TestPGDataUnsetWithoutStorage(t *testing.T) {
+	deployment := getDeployment(newTestPostgres(nil))
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if pgdata, ok := getEnv(container, "PGDATA"); ok {
+		t.Errorf("expected PGDATA to be unset, got %q", pgdata)
+	}
+	if len(deployment.Spec.Template.Spec.Volumes) != 0 {
+		t.Errorf("expected no volumes, got %v", deployment.Spec.Template.Spec.Volumes)
+	}
+}
+
+func TestDataVolumeSubPath(t *testing.T) {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi", SubPath: "pgdata"})
+	container := getDeployment(foo).Spec.Template.Spec.Containers[0]
+	if pgdata, _ := getEnv(container, "PGDATA"); pgdata != "/var/lib/postgresql/data/pgdata" {
+		t.Errorf("expected PGDATA /var/lib/postgresql/data/pgdata, got %q", pgdata)
+	}
+	expected := apiv1.VolumeMount{
+		Name:      DATA_VOLUME_NAME,
+		MountPath: "/var/lib/postgresql/data/pgdata",
+		SubPath:   "pgdata",
+	}
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0] != expected {
+		t.Errorf("expected mount %v\ngot %v", expected, container.VolumeMounts)
+	}
+
+	foo.Spec.Storage.PGDataSubdir = "data"
+	if problems := validateStorage(foo); len(problems) != 1 {
+		t.Errorf("expected a conflicting pgdataSubdir to be rejected, got %v", problems)
+	}
+	foo.Spec.Storage.PGDataSubdir = ""
+	foo.Spec.Storage.SubPath = "../other"
+	if problems := validateStorage(foo); len(problems) != 1 {
+		t.Errorf("expected a subPath outside the volume to be rejected, got %v", problems)
+	}
+}
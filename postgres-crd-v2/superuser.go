@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// getSuperuserSecretName returns the name of the Secret holding the
+// password of the admin role of an instance created by the controller.
+func getSuperuserSecretName(foo *postgresv1.Postgres) string {
+	return foo.Spec.DeploymentName + "-superuser"
+}
+
+// getSuperuserPasswordEnv returns an env var read from the superuser Secret
+// of the instance.
+func getSuperuserPasswordEnv(foo *postgresv1.Postgres, name string) apiv1.EnvVar {
+	return apiv1.EnvVar{
+		Name: name,
+		ValueFrom: &apiv1.EnvVarSource{
+			SecretKeyRef: &apiv1.SecretKeySelector{
+				LocalObjectReference: apiv1.LocalObjectReference{
+					Name: getSuperuserSecretName(foo),
+				},
+				Key: CREDENTIALS_PASSWORD_KEY,
+			},
+		},
+	}
+}
+
+// createSuperuserSecret generates the superuser password of a new instance,
+// or reads it from Spec.SuperuserPasswordFromFile, unless its Secret already
+// exists. An existing password is never replaced as the data directory may
+// already have been initialized with it.
+func (c *Controller) createSuperuserSecret(foo *postgresv1.Postgres) error {
+	secretName := getSuperuserSecretName(foo)
+	secretsClient := c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace())
+
+	_, err := secretsClient.Get(secretName, metav1.GetOptions{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	password, err := getSuperuserPasswordSource(foo)
+	if err != nil {
+		return err
+	}
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName,
+			Labels: map[string]string{
+				"app": foo.Spec.DeploymentName,
+			},
+		},
+		Type: apiv1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			CREDENTIALS_USERNAME_KEY: []byte(getSuperuserName(foo)),
+			CREDENTIALS_PASSWORD_KEY: []byte(password),
+		},
+	}
+	fmt.Printf("Creating secret %s...\n", secretName)
+	_, err = secretsClient.Create(secret)
+	return err
+}
+
+// getSuperuserPassword reads the superuser password from the Secret
+// referenced in the status, or from the Secret of a new instance. A missing
+// Secret fails the reconcile instead of falling back to a default password.
+func (c *Controller) getSuperuserPassword(foo *postgresv1.Postgres) (string, error) {
+	secretName := foo.Status.SuperuserSecret
+	if secretName == "" {
+		secretName = getSuperuserSecretName(foo)
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets(c.getInstanceNamespace()).Get(secretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", fmt.Errorf("superuser secret %s of %s not found, create it with the superuser password in its %s key",
+			secretName, foo.Spec.DeploymentName, CREDENTIALS_PASSWORD_KEY)
+	}
+	if err != nil {
+		return "", err
+	}
+	password, ok := secret.Data[CREDENTIALS_PASSWORD_KEY]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %s key", secretName, CREDENTIALS_PASSWORD_KEY)
+	}
+	return string(password), nil
+}
+
+// getInstanceEndpoint returns the endpoint of an instance created by the
+// controller with the superuser password read from its Secret.
+func (c *Controller) getInstanceEndpoint(foo *postgresv1.Postgres, serviceIP string, servicePort string) (dbEndpoint, error) {
+	endpoint := getDefaultEndpoint(foo, c.getInstanceNamespace(), serviceIP, servicePort)
+	password, err := c.getSuperuserPassword(foo)
+	if err != nil {
+		return endpoint, err
+	}
+	endpoint.Password = password
+	return endpoint, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Default parent directory of the tablespace mounts
+	TABLESPACE_MOUNT_ROOT = "/var/lib/postgresql/tablespaces"
+	// Subdirectory of the tablespace mount used as the location. Postgres
+	// requires an empty directory owned by the postgres user, which the
+	// mount point itself is not (e.g. it may contain lost+found).
+	TABLESPACE_DATA_SUBDIR    = "data"
+	TABLESPACE_INIT_CONTAINER = "tablespace-init"
+
+	// WarnTablespaceKept is used as part of the Event 'reason' when a
+	// tablespace removed from the spec is kept.
+	WarnTablespaceKept = "TablespaceKept"
+)
+
+func getTablespaceMountPath(tablespace postgresv1.TablespaceSpec) string {
+	if tablespace.MountPath != "" {
+		return tablespace.MountPath
+	}
+	return path.Join(TABLESPACE_MOUNT_ROOT, tablespace.Name)
+}
+
+// getTablespaceLocation returns the directory passed to CREATE TABLESPACE.
+func getTablespaceLocation(tablespace postgresv1.TablespaceSpec) string {
+	return path.Join(getTablespaceMountPath(tablespace), TABLESPACE_DATA_SUBDIR)
+}
+
+func getTablespaceVolumeName(name string) string {
+	return "tablespace-" + strings.Replace(name, "_", "-", -1)
+}
+
+func getTablespacePVCName(deploymentName string, name string) string {
+	return deploymentName + "-" + getTablespaceVolumeName(name)
+}
+
+func getTablespaceNames(tablespaces []postgresv1.TablespaceSpec) []string {
+	var names []string
+	for _, tablespace := range tablespaces {
+		names = append(names, tablespace.Name)
+	}
+	return names
+}
+
+func validateTablespaces(tablespaces []postgresv1.TablespaceSpec) []string {
+	var problems []string
+	var names []string
+	for _, tablespace := range tablespaces {
+		if tablespace.Name == "" {
+			problems = append(problems, "spec.tablespaces: name must be specified")
+			continue
+		}
+		if strings.HasPrefix(tablespace.Name, "pg_") {
+			problems = append(problems, fmt.Sprintf("tablespace %s: the pg_ prefix is reserved", tablespace.Name))
+		}
+		if contains(names, tablespace.Name) {
+			problems = append(problems, fmt.Sprintf("tablespace %s is specified more than once", tablespace.Name))
+		}
+		names = append(names, tablespace.Name)
+		if _, err := resource.ParseQuantity(tablespace.Size); err != nil {
+			problems = append(problems, fmt.Sprintf("tablespace %s: invalid size %q", tablespace.Name, tablespace.Size))
+		}
+	}
+	return problems
+}
+
+func getTablespacePVC(foo *postgresv1.Postgres, tablespace postgresv1.TablespaceSpec) (*apiv1.PersistentVolumeClaim, error) {
+	size, err := resource.ParseQuantity(tablespace.Size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size %q of tablespace %s: %s", tablespace.Size, tablespace.Name, err.Error())
+	}
+	pvc := &apiv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getTablespacePVCName(foo.Spec.DeploymentName, tablespace.Name),
+			Labels: map[string]string{
+				"app": foo.Spec.DeploymentName,
+			},
+		},
+		Spec: apiv1.PersistentVolumeClaimSpec{
+			AccessModes: []apiv1.PersistentVolumeAccessMode{apiv1.ReadWriteOnce},
+			Resources: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{
+					apiv1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	if tablespace.StorageClassName != "" {
+		pvc.Spec.StorageClassName = &tablespace.StorageClassName
+	}
+	return pvc, nil
+}
+
+// createTablespacePVCs creates the claims backing the tablespace volumes.
+// Like the data volume, existing claims are reused.
+func createTablespacePVCs(foo *postgresv1.Postgres, c *Controller) error {
+	for _, tablespace := range foo.Spec.Tablespaces {
+		pvc, err := getTablespacePVC(foo, tablespace)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Creating persistent volume claim %s...\n", pvc.Name)
+		_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(c.getInstanceNamespace()).Create(pvc)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTablespaces mounts a volume for each tablespace into the Postgres
+// container. An init container prepares the tablespace locations as the
+// volumes are mounted owned by root. Volumes already in the pod are kept,
+// so that tablespaces removed from the spec stay readable.
+func addTablespaces(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if len(foo.Spec.Tablespaces) == 0 {
+		return
+	}
+	container := &podSpec.Containers[0]
+	for _, tablespace := range foo.Spec.Tablespaces {
+		volumeName := getTablespaceVolumeName(tablespace.Name)
+		if hasVolume(podSpec, volumeName) {
+			continue
+		}
+		podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+			Name: volumeName,
+			VolumeSource: apiv1.VolumeSource{
+				PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{
+					ClaimName: getTablespacePVCName(foo.Spec.DeploymentName, tablespace.Name),
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+			Name:      volumeName,
+			MountPath: getTablespaceMountPath(tablespace),
+		})
+	}
+
+	// The init container prepares the locations of all mounted tablespaces
+	var mounts []apiv1.VolumeMount
+	var script []string
+	for _, mount := range container.VolumeMounts {
+		if !strings.HasPrefix(mount.Name, getTablespaceVolumeName("")) {
+			continue
+		}
+		location := path.Join(mount.MountPath, TABLESPACE_DATA_SUBDIR)
+		mounts = append(mounts, mount)
+		script = append(script, fmt.Sprintf("mkdir -p %s && chown postgres:postgres %s && chmod 700 %s",
+			location, location, location))
+	}
+	initContainer := apiv1.Container{
+		Name:         TABLESPACE_INIT_CONTAINER,
+		Image:        foo.Spec.Image,
+		Command:      []string{"sh", "-c", strings.Join(script, " && ")},
+		VolumeMounts: mounts,
+	}
+	for i := range podSpec.InitContainers {
+		if podSpec.InitContainers[i].Name == TABLESPACE_INIT_CONTAINER {
+			podSpec.InitContainers[i] = initContainer
+			return
+		}
+	}
+	podSpec.InitContainers = append(podSpec.InitContainers, initContainer)
+}
+
+func hasVolume(podSpec *apiv1.PodSpec, name string) bool {
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getTablespaceCommands returns the commands creating the desired
+// tablespaces that are not current and dropping the current ones that are
+// no longer desired.
+func getTablespaceCommands(desired []postgresv1.TablespaceSpec, current []string) ([]string, []string) {
+	var createCommands []string
+	var dropCommands []string
+	for _, tablespace := range desired {
+		if !contains(current, tablespace.Name) {
+			createCommands = append(createCommands, fmt.Sprintf("create tablespace \"%s\" location '%s';",
+				tablespace.Name, getTablespaceLocation(tablespace)))
+		}
+	}
+	for _, name := range getDiffList(current, getTablespaceNames(desired)) {
+		dropCommands = append(dropCommands, fmt.Sprintf("drop tablespace \"%s\";", name))
+	}
+	return createCommands, dropCommands
+}
+
+// guardTablespaceDeletion returns the drop commands to run and the
+// tablespaces that are kept instead. Unless Spec.AllowTablespaceDeletion is
+// set, tablespaces removed from the spec are never dropped. The claims are
+// never deleted.
+func (c *Controller) guardTablespaceDeletion(foo *postgresv1.Postgres, current []string,
+	dropCommands []string) ([]string, []string) {
+	if foo.Spec.AllowTablespaceDeletion {
+		return dropCommands, nil
+	}
+	kept := getDiffList(current, getTablespaceNames(foo.Spec.Tablespaces))
+	if len(kept) > 0 {
+		c.recorder.Event(foo, apiv1.EventTypeWarning, WarnTablespaceKept,
+			fmt.Sprintf("Tablespaces %s were removed from the spec but not dropped, set allowTablespaceDeletion to drop them",
+				strings.Join(kept, ", ")))
+	}
+	return nil, kept
+}
+
+// syncTablespaces makes sure that the Deployment mounts the volumes of all
+// tablespaces in the spec. It returns true when the Deployment was updated,
+// in which case the caller has to wait for the new Pod before creating the
+// tablespaces.
+func (c *Controller) syncTablespaces(foo *postgresv1.Postgres) (bool, error) {
+	if len(foo.Spec.Tablespaces) == 0 {
+		return false, nil
+	}
+	err := createTablespacePVCs(foo, c)
+	if err != nil {
+		return false, err
+	}
+	deploymentsClient := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace())
+	deployment, err := deploymentsClient.Get(foo.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	deploymentCopy := deployment.DeepCopy()
+	addTablespaces(&deploymentCopy.Spec.Template.Spec, foo)
+	if reflect.DeepEqual(deploymentCopy.Spec.Template.Spec, deployment.Spec.Template.Spec) {
+		return false, nil
+	}
+	fmt.Printf("Mounting tablespace volumes into %s\n", foo.Spec.DeploymentName)
+	// The claims can only be attached to one Pod at a time
+	deploymentCopy.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	_, err = deploymentsClient.Update(deploymentCopy)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newTablespaceTestPostgres(tablespaces ...postgresv1.TablespaceSpec) *postgresv1.Postgres {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.Tablespaces = tablespaces
+	return foo
+}
+
+func getTablespaceMounts(container apiv1.Container) map[string]string {
+	mounts := map[string]string{}
+	for _, mount := range container.VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	return mounts
+}
+
+func TestTablespaceVolumesAreMounted(t *testing.T) {
+	foo := newTablespaceTestPostgres(
+		postgresv1.TablespaceSpec{Name: "fast", Size: "5Gi"},
+		postgresv1.TablespaceSpec{Name: "archive_2", Size: "20Gi", MountPath: "/mnt/archive"})
+	podSpec := getDeployment(foo).Spec.Template.Spec
+
+	expected := map[string]string{
+		DATA_VOLUME_NAME:       DATA_MOUNT_PATH,
+		"tablespace-fast":      "/var/lib/postgresql/tablespaces/fast",
+		"tablespace-archive-2": "/mnt/archive",
+	}
+	if mounts := getTablespaceMounts(podSpec.Containers[0]); !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("expected mounts %v\ngot %v", expected, mounts)
+	}
+	if !hasVolume(&podSpec, "tablespace-archive-2") ||
+		podSpec.Volumes[2].PersistentVolumeClaim.ClaimName != "client25-tablespace-archive-2" {
+		t.Errorf("expected volume backed by claim client25-tablespace-archive-2, got %v", podSpec.Volumes)
+	}
+	if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Name != TABLESPACE_INIT_CONTAINER {
+		t.Fatalf("expected the %s init container, got %v", TABLESPACE_INIT_CONTAINER, podSpec.InitContainers)
+	}
+	script := podSpec.InitContainers[0].Command[2]
+	if !strings.Contains(script, "chown postgres:postgres /var/lib/postgresql/tablespaces/fast/data") ||
+		!strings.Contains(script, "chown postgres:postgres /mnt/archive/data") {
+		t.Errorf("expected the init container to prepare both locations, got %q", script)
+	}
+}
+
+func TestAddTablespacesKeepsMountedVolumes(t *testing.T) {
+	foo := newTablespaceTestPostgres(postgresv1.TablespaceSpec{Name: "fast", Size: "5Gi"})
+	podSpec := getDeployment(foo).Spec.Template.Spec
+
+	foo.Spec.Tablespaces = []postgresv1.TablespaceSpec{{Name: "archive", Size: "20Gi"}}
+	addTablespaces(&podSpec, foo)
+	if len(podSpec.Volumes) != 3 {
+		t.Errorf("expected the data and both tablespace volumes, got %v", podSpec.Volumes)
+	}
+	if len(podSpec.InitContainers) != 1 || len(podSpec.InitContainers[0].VolumeMounts) != 2 {
+		t.Errorf("expected one init container mounting both tablespaces, got %v", podSpec.InitContainers)
+	}
+}
+
+func TestGetTablespaceCommands(t *testing.T) {
+	desired := []postgresv1.TablespaceSpec{{Name: "fast"}, {Name: "archive", MountPath: "/mnt/archive"}}
+	createCommands, dropCommands := getTablespaceCommands(desired, []string{"fast", "old"})
+
+	expectedCreate := []string{"create tablespace \"archive\" location '/mnt/archive/data';"}
+	if !reflect.DeepEqual(createCommands, expectedCreate) {
+		t.Errorf("expected %v\ngot %v", expectedCreate, createCommands)
+	}
+	expectedDrop := []string{"drop tablespace \"old\";"}
+	if !reflect.DeepEqual(dropCommands, expectedDrop) {
+		t.Errorf("expected %v\ngot %v", expectedDrop, dropCommands)
+	}
+}
+
+func TestRemovedTablespaceIsKeptByDefault(t *testing.T) {
+	foo := newTablespaceTestPostgres(postgresv1.TablespaceSpec{Name: "fast", Size: "5Gi"})
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder}
+
+	current := []string{"fast", "old"}
+	_, dropCommands := getTablespaceCommands(foo.Spec.Tablespaces, current)
+	dropCommands, kept := c.guardTablespaceDeletion(foo, current, dropCommands)
+	if len(dropCommands) != 0 {
+		t.Errorf("expected no drop commands, got %v", dropCommands)
+	}
+	if !reflect.DeepEqual(kept, []string{"old"}) {
+		t.Errorf("expected [old] to be kept\ngot %v", kept)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, WarnTablespaceKept) {
+		t.Errorf("expected a %s event, got %s", WarnTablespaceKept, event)
+	}
+
+	foo.Spec.AllowTablespaceDeletion = true
+	dropCommands, kept = c.guardTablespaceDeletion(foo, current, []string{"drop tablespace \"old\";"})
+	if len(dropCommands) != 1 || len(kept) != 0 {
+		t.Errorf("expected old to be dropped, got %v and kept %v", dropCommands, kept)
+	}
+}
+
+func TestSyncTablespacesMountsNewVolumes(t *testing.T) {
+	foo := newTablespaceTestPostgres()
+	c, _ := newImageTestController(foo)
+	foo.Spec.Tablespaces = []postgresv1.TablespaceSpec{{Name: "fast", Size: "5Gi"}}
+
+	updated, err := c.syncTablespaces(foo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !updated {
+		t.Errorf("expected the Deployment to be updated")
+	}
+	deployment := getTestDeployment(t, c)
+	if !hasVolume(&deployment.Spec.Template.Spec, "tablespace-fast") {
+		t.Errorf("expected the tablespace volume, got %v", deployment.Spec.Template.Spec.Volumes)
+	}
+	_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims("default").Get("client25-tablespace-fast", metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("expected the tablespace claim to be created: %v", err)
+	}
+
+	updated, err = c.syncTablespaces(foo)
+	if err != nil || updated {
+		t.Errorf("expected no further update, got %v, %v", updated, err)
+	}
+}
+
+func TestValidateTablespaces(t *testing.T) {
+	foo := newTablespaceTestPostgres(postgresv1.TablespaceSpec{Name: "fast", Size: "5Gi"})
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	foo.Spec.Tablespaces = append(foo.Spec.Tablespaces,
+		postgresv1.TablespaceSpec{Name: "fast", Size: "5Gi"},
+		postgresv1.TablespaceSpec{Name: "pg_fast", Size: "lots"})
+	if problems := validatePostgresSpec(foo); len(problems) != 3 {
+		t.Errorf("expected 3 problems, got %v", problems)
+	}
+	foo.Spec.SharedInstance = "client26"
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected tablespaces on a shared instance to be rejected, got %v", problems)
+	}
+}
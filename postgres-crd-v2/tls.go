@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// The server certificate Secret is mounted at SERVER_TLS_SECRET_DIR and
+	// copied to SERVER_TLS_DIR, as Postgres refuses a key file not owned by
+	// it or readable by others.
+	SERVER_TLS_SECRET_VOLUME  = "server-tls-secret"
+	SERVER_TLS_SECRET_DIR     = "/etc/postgresql/tls-secret"
+	SERVER_TLS_VOLUME         = "server-tls"
+	SERVER_TLS_DIR            = "/etc/postgresql/tls"
+	SERVER_TLS_INIT_CONTAINER = "server-tls"
+	SERVER_TLS_CERT_KEY       = "tls.crt"
+	SERVER_TLS_KEY_KEY        = "tls.key"
+)
+
+func isServerTLSEnabled(foo *postgresv1.Postgres) bool {
+	return foo.Spec.TLS != nil && foo.Spec.TLS.ServerCertSecretRef != ""
+}
+
+func validateServerTLS(foo *postgresv1.Postgres) []string {
+	if foo.Spec.TLS != nil && foo.Spec.TLS.ServerCertSecretRef == "" {
+		return []string{"spec.tls.serverCertSecretRef is required"}
+	}
+	return nil
+}
+
+// addServerTLS turns on ssl with the certificate and key of
+// Spec.TLS.ServerCertSecretRef. An init container copies them from the
+// Secret volume, owned by the postgres user with the key only readable by it.
+func addServerTLS(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if !isServerTLSEnabled(foo) {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes,
+		apiv1.Volume{
+			Name: SERVER_TLS_SECRET_VOLUME,
+			VolumeSource: apiv1.VolumeSource{
+				Secret: &apiv1.SecretVolumeSource{SecretName: foo.Spec.TLS.ServerCertSecretRef},
+			},
+		},
+		apiv1.Volume{
+			Name:         SERVER_TLS_VOLUME,
+			VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}},
+		})
+	tlsMount := apiv1.VolumeMount{
+		Name:      SERVER_TLS_VOLUME,
+		MountPath: SERVER_TLS_DIR,
+	}
+
+	certFile := path.Join(SERVER_TLS_DIR, SERVER_TLS_CERT_KEY)
+	keyFile := path.Join(SERVER_TLS_DIR, SERVER_TLS_KEY_KEY)
+	podSpec.InitContainers = append(podSpec.InitContainers, apiv1.Container{
+		Name:  SERVER_TLS_INIT_CONTAINER,
+		Image: foo.Spec.Image,
+		Command: []string{"sh", "-c", fmt.Sprintf(
+			"cp %s/%s %s/%s %s && chown postgres:postgres %s %s && chmod 600 %s",
+			SERVER_TLS_SECRET_DIR, SERVER_TLS_CERT_KEY, SERVER_TLS_SECRET_DIR, SERVER_TLS_KEY_KEY,
+			SERVER_TLS_DIR, certFile, keyFile, keyFile)},
+		VolumeMounts: []apiv1.VolumeMount{
+			{
+				Name:      SERVER_TLS_SECRET_VOLUME,
+				MountPath: SERVER_TLS_SECRET_DIR,
+				ReadOnly:  true,
+			},
+			tlsMount,
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, tlsMount)
+	if len(container.Args) == 0 {
+		container.Args = []string{"postgres"}
+	}
+	container.Args = append(container.Args,
+		"-c", "ssl=on",
+		"-c", "ssl_cert_file="+certFile,
+		"-c", "ssl_key_file="+keyFile)
+}
+
+// hasServerTLS returns true if the Pods of the Deployment are configured
+// with a server certificate.
+func hasServerTLS(podSpec *apiv1.PodSpec) bool {
+	return hasVolume(podSpec, SERVER_TLS_VOLUME)
+}
+
+// getServerTLSEnabled returns whether the running instance serves TLS. TLS
+// is configured when the Deployment is created, so this is read from it
+// rather than from the spec.
+func (c *Controller) getServerTLSEnabled(foo *postgresv1.Postgres) bool {
+	if foo.Spec.DeploymentName == "" || foo.Spec.SharedInstance != "" || foo.Spec.ExternalEndpoint != nil {
+		return false
+	}
+	deployment, err := c.kubeclientset.AppsV1().Deployments(c.getInstanceNamespace()).Get(foo.Spec.DeploymentName,
+		metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			runtime.HandleError(err)
+		}
+		return false
+	}
+	return hasServerTLS(&deployment.Spec.Template.Spec)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func newServerTLSTestPostgres() *postgresv1.Postgres {
+	foo := newTestPostgres(nil)
+	foo.Spec.TLS = &postgresv1.TLSSpec{ServerCertSecretRef: "client25-tls"}
+	return foo
+}
+
+func TestServerTLSConfiguresPostgres(t *testing.T) {
+	podSpec := getDeployment(newServerTLSTestPostgres()).Spec.Template.Spec
+
+	args := strings.Join(podSpec.Containers[0].Args, " ")
+	expected := "postgres -c ssl=on -c ssl_cert_file=/etc/postgresql/tls/tls.crt -c ssl_key_file=/etc/postgresql/tls/tls.key"
+	if args != expected {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+	if !hasVolume(&podSpec, SERVER_TLS_SECRET_VOLUME) || !hasServerTLS(&podSpec) {
+		t.Errorf("expected the server TLS volumes, got %v", podSpec.Volumes)
+	}
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == SERVER_TLS_SECRET_VOLUME && volume.Secret.SecretName != "client25-tls" {
+			t.Errorf("expected the client25-tls Secret, got %s", volume.Secret.SecretName)
+		}
+	}
+
+	initContainer := getContainer(podSpec, SERVER_TLS_INIT_CONTAINER)
+	if initContainer == nil {
+		t.Fatalf("expected the %s init container, got %v", SERVER_TLS_INIT_CONTAINER, podSpec.InitContainers)
+	}
+	script := initContainer.Command[2]
+	if !strings.Contains(script, "chown postgres:postgres") || !strings.Contains(script, "chmod 600 /etc/postgresql/tls/tls.key") {
+		t.Errorf("expected the key to be owned and only readable by postgres, got %q", script)
+	}
+}
+
+func TestServerTLSDisabledByDefault(t *testing.T) {
+	podSpec := getDeployment(newTestPostgres(nil)).Spec.Template.Spec
+	if hasServerTLS(&podSpec) || getContainer(podSpec, SERVER_TLS_INIT_CONTAINER) != nil {
+		t.Errorf("expected no server TLS, got %v", podSpec.Volumes)
+	}
+}
+
+func TestGetServerTLSEnabledReadsDeployment(t *testing.T) {
+	foo := newServerTLSTestPostgres()
+	deployment := getDeployment(foo)
+	deployment.Namespace = "default"
+	c := &Controller{kubeclientset: kubefake.NewSimpleClientset(deployment)}
+	if !c.getServerTLSEnabled(foo) {
+		t.Errorf("expected TLS to be enabled")
+	}
+
+	// Adding TLS to the spec does not change the running Deployment
+	plain := newTestPostgres(nil)
+	deployment = getDeployment(plain)
+	deployment.Namespace = "default"
+	c = &Controller{kubeclientset: kubefake.NewSimpleClientset(deployment)}
+	if c.getServerTLSEnabled(foo) {
+		t.Errorf("expected TLS to be disabled on a Deployment created without it")
+	}
+}
+
+func TestValidateServerTLS(t *testing.T) {
+	foo := newServerTLSTestPostgres()
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	foo.Spec.TLS.ServerCertSecretRef = ""
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected the missing serverCertSecretRef to be rejected, got %v", problems)
+	}
+	foo = newServerTLSTestPostgres()
+	foo.Spec.SharedInstance = "client24"
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected TLS on a shared instance to be rejected, got %v", problems)
+	}
+}
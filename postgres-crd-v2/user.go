@@ -2,7 +2,9 @@ package main
 
 import (
         "fmt"
+	"strconv"
 	"strings"
+	"github.com/lib/pq"
         postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
 )
 
@@ -11,33 +13,144 @@ func getCreateUserCommands(desiredList []postgresv1.UserSpec) []string {
      for _, user := range desiredList {
      	 username := user.User
 	 password := user.Password 
-     	 createUserCmd := strings.Fields("create user " + username + " with password '" + password + "';")
-    	 var cmdString = strings.Join(createUserCmd, " ")
+	 // The password is quoted as a literal so that it is set exactly as
+	 // given, including quotes and whitespace
+     	 cmdString := "create user " + getQuotedUserName(username) + " with password " + pq.QuoteLiteral(password) + getCreateRoleAttributes(user) + ";"
 	 fmt.Printf("CreateUserCmd: %v\n", cmdString)
 	 cmdList = append(cmdList, cmdString)
      }
      return cmdList
 }
 
-func getDropUserCommands(desiredList []postgresv1.UserSpec) []string {
+// getDropUserCommands reassigns and drops the objects owned by each user in
+// every database before dropping the role. Postgres refuses to drop a role
+// that still owns objects or has been granted privileges.
+func getDropUserCommands(desiredList []postgresv1.UserSpec, databases []string, superuser string) []string {
      var cmdList []string
      for _, user := range desiredList {
      	 username := user.User
-     	 dropUserCmd := strings.Fields("drop user " + username + ";")
-    	 var cmdString = strings.Join(dropUserCmd, " ")
+	 quotedName := getQuotedUserName(username)
+	 if len(databases) == 0 {
+	    appendList(&cmdList, getDropOwnedCommands(quotedName, superuser))
+	 }
+	 for _, db := range databases {
+	     cmdList = append(cmdList, getConnectCommand(db))
+	     appendList(&cmdList, getDropOwnedCommands(quotedName, superuser))
+	 }
+	 cmdString := "drop user " + quotedName + ";"
 	 fmt.Printf("DropUserCmd: %v\n", cmdString)
 	 cmdList = append(cmdList, cmdString)
      }
      return cmdList
 }
 
-func getAlterUserCommands(desiredList []postgresv1.UserSpec) []string {
+// getQuotedUserName quotes the name of a user as an identifier. Unquoted
+// names are folded to lower case by Postgres, so the name is lower cased
+// first to refer to the same role as before.
+func getQuotedUserName(username string) string {
+     return pq.QuoteIdentifier(strings.ToLower(username))
+}
+
+func getDropOwnedCommands(quotedName string, superuser string) []string {
+     reassignCmd := "reassign owned by " + quotedName + " to " + pq.QuoteIdentifier(superuser) + ";"
+     dropOwnedCmd := "drop owned by " + quotedName + ";"
+     fmt.Printf("DropOwnedCmds: %v %v\n", reassignCmd, dropOwnedCmd)
+     return []string{reassignCmd, dropOwnedCmd}
+}
+
+// setPasswordEncryption prepends the SET that makes Postgres hash the
+// passwords of the following commands with the given method. It is added to
+// each list as the drop commands may reconnect, which resets the setting.
+func setPasswordEncryption(cmdList []string, method string) []string {
+     if len(cmdList) == 0 {
+     	return cmdList
+     }
+     setCmd := "set password_encryption = '" + method + "';"
+     return append([]string{setCmd}, cmdList...)
+}
+
+// getCreateRoleAttributes renders only the attributes that differ from the
+// defaults of CREATE USER so that plain users keep their original command.
+func getCreateRoleAttributes(user postgresv1.UserSpec) string {
+     attributes := ""
+     if user.Superuser {
+     	attributes = attributes + " superuser"
+     }
+     if user.CreateDB {
+     	attributes = attributes + " createdb"
+     }
+     if user.CreateRole {
+     	attributes = attributes + " createrole"
+     }
+     if !getLogin(user) {
+     	attributes = attributes + " nologin"
+     }
+     if getConnectionLimit(user) != -1 {
+     	attributes = attributes + " connection limit " + strconv.Itoa(int(getConnectionLimit(user)))
+     }
+     return attributes
+}
+
+// getAlterRoleAttributes renders the complete attribute set so that an
+// ALTER USER also resets attributes that were removed from the spec.
+func getAlterRoleAttributes(user postgresv1.UserSpec) string {
+     attributes := ""
+     attributes = attributes + getRoleAttribute(user.Superuser, "superuser")
+     attributes = attributes + getRoleAttribute(user.CreateDB, "createdb")
+     attributes = attributes + getRoleAttribute(user.CreateRole, "createrole")
+     attributes = attributes + getRoleAttribute(getLogin(user), "login")
+     attributes = attributes + " connection limit " + strconv.Itoa(int(getConnectionLimit(user)))
+     return attributes
+}
+
+func getRoleAttribute(enabled bool, attribute string) string {
+     if enabled {
+     	return " " + attribute
+     }
+     return " no" + attribute
+}
+
+func getLogin(user postgresv1.UserSpec) bool {
+     if user.Login == nil {
+     	return true
+     }
+     return *user.Login
+}
+
+func getConnectionLimit(user postgresv1.UserSpec) int32 {
+     if user.ConnectionLimit == nil {
+     	return -1
+     }
+     return *user.ConnectionLimit
+}
+
+func roleAttributesChanged(desired postgresv1.UserSpec, current postgresv1.UserSpec) bool {
+     return desired.Superuser != current.Superuser ||
+     	    desired.CreateDB != current.CreateDB ||
+	    desired.CreateRole != current.CreateRole ||
+	    getLogin(desired) != getLogin(current) ||
+	    getConnectionLimit(desired) != getConnectionLimit(current)
+}
+
+func getAlterUserCommands(desiredList []postgresv1.UserSpec, currentList []postgresv1.UserSpec) []string {
      var cmdList []string
      for _, user := range desiredList {
      	 username := user.User
 	 password := user.Password
-     	 dropUserCmd := strings.Fields("alter user " + username +  " with password '" + password + "';")
-    	 var cmdString = strings.Join(dropUserCmd, " ")
+	 var current postgresv1.UserSpec
+	 for _, v := range currentList {
+	     if v.User == username {
+	     	current = v
+	     }
+	 }
+	 alterCmd := "alter user " + getQuotedUserName(username) + " with"
+	 if !samePassword(user, current) {
+	    alterCmd = alterCmd + " password " + pq.QuoteLiteral(password)
+	 }
+	 if roleAttributesChanged(user, current) {
+	    alterCmd = alterCmd + getAlterRoleAttributes(user)
+	 }
+	 cmdString := alterCmd + ";"
 	 fmt.Printf("AlterUserCmd: %v\n", cmdString)
 	 cmdList = append(cmdList, cmdString)
      }
@@ -66,7 +179,7 @@ func getUserCommonList(desired []postgresv1.UserSpec, current []postgresv1.UserS
      for _, v := range desired {
      	 for _, v1 := range current {
 	     if v.User == v1.User {
-	     	if v.Password != v1.Password {
+	     	if !samePassword(v, v1) || roleAttributesChanged(v, v1) {
 		   modifyList = append(modifyList, v)
 		}
 	     }
@@ -76,7 +189,7 @@ func getUserCommonList(desired []postgresv1.UserSpec, current []postgresv1.UserS
      return modifyList
 }
 
-func getUserCommands(desiredList []postgresv1.UserSpec, currentList []postgresv1.UserSpec) ([]string, []string, []string) {
+func getUserCommands(desiredList []postgresv1.UserSpec, currentList []postgresv1.UserSpec, databases []string, superuser string) ([]string, []string, []string) {
 
      var createUserCommands []string
      var dropUserCommands []string
@@ -89,10 +202,10 @@ func getUserCommands(desiredList []postgresv1.UserSpec, currentList []postgresv1
 	createUserCommands = getCreateUserCommands(addList)
 
        	dropList := getUserDiffList(currentList, desiredList)
-	dropUserCommands = getDropUserCommands(dropList)
+	dropUserCommands = getDropUserCommands(dropList, databases, superuser)
 
 	alterList := getUserCommonList(desiredList, currentList)
-	alterUserCommands = getAlterUserCommands(alterList)
+	alterUserCommands = getAlterUserCommands(alterList, currentList)
      }
      return createUserCommands, dropUserCommands, alterUserCommands
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// A role owning a table in moodle must have its objects reassigned and
+// dropped in every database before the role itself is dropped.
+func TestDropUserReassignsOwnedObjects(t *testing.T) {
+	current := []postgresv1.UserSpec{
+		{User: "devdatta", Password: "pass123"},
+		{User: "tableowner", Password: "pass234"},
+	}
+	desired := []postgresv1.UserSpec{
+		{User: "devdatta", Password: "pass123"},
+	}
+	databases := []string{"moodle", "wordpress"}
+
+	_, dropUserCmds, _ := getUserCommands(desired, current, databases, "postgres")
+
+	expected := []string{
+		"\\c moodle;",
+		"reassign owned by \"tableowner\" to \"postgres\";",
+		"drop owned by \"tableowner\";",
+		"\\c wordpress;",
+		"reassign owned by \"tableowner\" to \"postgres\";",
+		"drop owned by \"tableowner\";",
+		"drop user \"tableowner\";",
+	}
+	if !reflect.DeepEqual(dropUserCmds, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, dropUserCmds)
+	}
+}
+
+func TestDropUserWithoutDatabases(t *testing.T) {
+	current := []postgresv1.UserSpec{{User: "TableOwner", Password: "pass234"}}
+
+	_, dropUserCmds, _ := getUserCommands(nil, current, nil, "admin")
+
+	expected := []string{
+		"reassign owned by \"tableowner\" to \"admin\";",
+		"drop owned by \"tableowner\";",
+		"drop user \"tableowner\";",
+	}
+	if !reflect.DeepEqual(dropUserCmds, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, dropUserCmds)
+	}
+}
+
+func TestGetUserCommands(t *testing.T) {
+	devdatta := postgresv1.UserSpec{User: "devdatta", Password: "pass123"}
+	pallavi := postgresv1.UserSpec{User: "pallavi", Password: "pass234"}
+	login := false
+	limit := int32(5)
+	admin := postgresv1.UserSpec{User: "admin", Password: "pass345", Superuser: true, CreateDB: true,
+		Login: &login, ConnectionLimit: &limit}
+
+	testCases := []struct {
+		name           string
+		desired        []postgresv1.UserSpec
+		current        []postgresv1.UserSpec
+		expectedCreate []string
+		expectedDrop   []string
+		expectedAlter  []string
+	}{
+		{
+			name:    "all creates when nothing exists",
+			desired: []postgresv1.UserSpec{devdatta, pallavi},
+			current: nil,
+			expectedCreate: []string{
+				"create user \"devdatta\" with password 'pass123';",
+				"create user \"pallavi\" with password 'pass234';",
+			},
+		},
+		{
+			name:    "no commands for matching sets",
+			desired: []postgresv1.UserSpec{devdatta, pallavi},
+			current: []postgresv1.UserSpec{pallavi, devdatta},
+		},
+		{
+			name:           "added user is created",
+			desired:        []postgresv1.UserSpec{devdatta, pallavi},
+			current:        []postgresv1.UserSpec{devdatta},
+			expectedCreate: []string{"create user \"pallavi\" with password 'pass234';"},
+		},
+		{
+			name:    "removed user is dropped",
+			desired: []postgresv1.UserSpec{devdatta},
+			current: []postgresv1.UserSpec{devdatta, pallavi},
+			expectedDrop: []string{
+				"reassign owned by \"pallavi\" to \"postgres\";",
+				"drop owned by \"pallavi\";",
+				"drop user \"pallavi\";",
+			},
+		},
+		{
+			name:          "password change only alters",
+			desired:       []postgresv1.UserSpec{{User: "devdatta", Password: "newpass"}},
+			current:       []postgresv1.UserSpec{devdatta},
+			expectedAlter: []string{"alter user \"devdatta\" with password 'newpass';"},
+		},
+		{
+			name:           "passwords are quoted as literals",
+			desired:        []postgresv1.UserSpec{{User: "Reader", Password: "it's  a secret"}},
+			expectedCreate: []string{"create user \"reader\" with password 'it''s  a secret';"},
+		},
+		{
+			name:           "attributes are rendered on create",
+			desired:        []postgresv1.UserSpec{devdatta, admin},
+			current:        []postgresv1.UserSpec{devdatta},
+			expectedCreate: []string{"create user \"admin\" with password 'pass345' superuser createdb nologin connection limit 5;"},
+		},
+		{
+			name:          "attribute change alters without password",
+			desired:       []postgresv1.UserSpec{{User: "devdatta", Password: "pass123", CreateDB: true}},
+			current:       []postgresv1.UserSpec{devdatta},
+			expectedAlter: []string{"alter user \"devdatta\" with nosuperuser createdb nocreaterole login connection limit -1;"},
+		},
+		{
+			name:          "attribute removal resets attributes",
+			desired:       []postgresv1.UserSpec{{User: "admin", Password: "newpass"}},
+			current:       []postgresv1.UserSpec{admin},
+			expectedAlter: []string{"alter user \"admin\" with password 'newpass' nosuperuser nocreatedb nocreaterole login connection limit -1;"},
+		},
+		{
+			name:    "duplicate names",
+			desired: []postgresv1.UserSpec{devdatta, devdatta},
+			current: []postgresv1.UserSpec{devdatta},
+		},
+	}
+	for _, tc := range testCases {
+		create, drop, alter := getUserCommands(tc.desired, tc.current, nil, "postgres")
+		if !reflect.DeepEqual(create, tc.expectedCreate) {
+			t.Errorf("%s: expected creates %#v\ngot %#v", tc.name, tc.expectedCreate, create)
+		}
+		if !reflect.DeepEqual(drop, tc.expectedDrop) {
+			t.Errorf("%s: expected drops %#v\ngot %#v", tc.name, tc.expectedDrop, drop)
+		}
+		if !reflect.DeepEqual(alter, tc.expectedAlter) {
+			t.Errorf("%s: expected alters %#v\ngot %#v", tc.name, tc.expectedAlter, alter)
+		}
+	}
+}
+
+func TestSetPasswordEncryption(t *testing.T) {
+	alterUserCmds := []string{"alter user devdatta with password 'newpass';"}
+	expected := []string{
+		"set password_encryption = 'md5';",
+		"alter user devdatta with password 'newpass';",
+	}
+	if got := setPasswordEncryption(alterUserCmds, "md5"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v\ngot %#v", expected, got)
+	}
+	if got := setPasswordEncryption(nil, "scram-sha-256"); len(got) != 0 {
+		t.Errorf("expected no commands without users, got %#v", got)
+	}
+}
@@ -5,20 +5,35 @@ import (
        "strings"
 )
 
+// getCommandsToRun returns the setup commands that are not yet in the
+// action history. A command given n times is run until it is recorded n
+// times. Connect commands are never recorded, so the one preceding a new
+// command is kept to run it against the right database. The history only
+// holds redacted commands, so commands are compared without their passwords.
 func getCommandsToRun(actionHistory []string, setupCommands []string) []string {
+     applied := make(map[string]int)
+     for _, v := range actionHistory {
+     	 applied[redactCommand(strings.TrimSpace(v))]++
+     }
      var commandsToRun []string
+     var connectCommand string
+     var lastConnectCommand string
      for _, v := range setupCommands {
-     	 var found bool = false
-     	 for _, v1 := range actionHistory {
-	     if v == v1 {
-	     	found = true
-	     }
+     	 if isConnectCommand(v) {
+	    connectCommand = v
+	    continue
 	 }
-	 if !found {
-	    commandsToRun = append(commandsToRun, v)
+	 if applied[redactCommand(strings.TrimSpace(v))] > 0 {
+	    applied[redactCommand(strings.TrimSpace(v))]--
+	    continue
+	 }
+	 if connectCommand != lastConnectCommand {
+	    commandsToRun = append(commandsToRun, connectCommand)
+	    lastConnectCommand = connectCommand
 	 }
+	 commandsToRun = append(commandsToRun, v)
      }
-     fmt.Printf("-- commandsToRun: %v--\n", commandsToRun)     
+     fmt.Printf("-- commandsToRun: %v--\n", commandsToRun)
      return commandsToRun
 }
 
@@ -48,6 +63,21 @@ func canonicalize(setupCommands1 []string) []string {
   return setupCommands
 }
 
+// getConnectCommand returns a psql style connect command. setupDatabase
+// reconnects to the named database when it encounters one.
+func getConnectCommand(dbname string) string {
+     return "\\c " + dbname + ";"
+}
+
+func isConnectCommand(command string) bool {
+     return strings.HasPrefix(command, "\\c ")
+}
+
+func getConnectDatabase(command string) string {
+     dbname := strings.TrimSpace(strings.TrimPrefix(command, "\\c"))
+     return strings.TrimSuffix(dbname, ";")
+}
+
 func appendList(parentList *[]string, childList []string) {
      for _, val := range childList {
      	 *parentList = append(*parentList, val)
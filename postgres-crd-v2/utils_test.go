@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectCommand(t *testing.T) {
+	command := getConnectCommand("moodle")
+	if !isConnectCommand(command) {
+		t.Fatalf("expected %q to be a connect command", command)
+	}
+	if dbname := getConnectDatabase(command); dbname != "moodle" {
+		t.Errorf("expected database moodle, got %q", dbname)
+	}
+	if isConnectCommand("create database moodle;") {
+		t.Errorf("expected create database not to be a connect command")
+	}
+}
+
+func TestGetCommandsToRun(t *testing.T) {
+	testCases := []struct {
+		name          string
+		actionHistory []string
+		setupCommands []string
+		expected      []string
+	}{
+		{
+			name:          "all commands are new",
+			setupCommands: []string{"create table t (id int);", "insert into t values (1);"},
+			expected:      []string{"create table t (id int);", "insert into t values (1);"},
+		},
+		{
+			name:          "applied commands are skipped",
+			actionHistory: []string{"create database moodle;", "create table t (id int);"},
+			setupCommands: []string{"create table t (id int);", "insert into t values (1);"},
+			expected:      []string{"insert into t values (1);"},
+		},
+		{
+			name:          "nothing to run",
+			actionHistory: []string{"create table t (id int);", "insert into t values (1);"},
+			setupCommands: []string{"create table t (id int);", "insert into t values (1);"},
+		},
+		{
+			name:          "repeated commands run as often as given",
+			actionHistory: []string{"insert into t values (1);"},
+			setupCommands: []string{"insert into t values (1);", "insert into t values (1);"},
+			expected:      []string{"insert into t values (1);"},
+		},
+		{
+			name:          "connect command is kept for new commands",
+			actionHistory: []string{"create table t (id int);", "create table u (id int);"},
+			setupCommands: []string{"\\c wordpress;", "create table t (id int);", "create table u (id int);",
+				"insert into u values (1);"},
+			expected: []string{"\\c wordpress;", "insert into u values (1);"},
+		},
+		{
+			name:          "connect command of applied commands is dropped",
+			actionHistory: []string{"create table t (id int);"},
+			setupCommands: []string{"\\c wordpress;", "create table t (id int);", "\\c moodle;",
+				"create table u (id int);"},
+			expected: []string{"\\c moodle;", "create table u (id int);"},
+		},
+		{
+			name:          "redacted commands are applied",
+			actionHistory: []string{"create role reader with password '***';"},
+			setupCommands: []string{"create role reader with password 'secret';", "grant select on t to reader;"},
+			expected:      []string{"grant select on t to reader;"},
+		},
+	}
+	for _, tc := range testCases {
+		if got := getCommandsToRun(tc.actionHistory, tc.setupCommands); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %#v\ngot %#v", tc.name, tc.expected, got)
+		}
+	}
+}
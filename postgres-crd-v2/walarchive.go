@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+const (
+	// Directory archive_command copies the WAL segments to. The archiver
+	// sidecar uploads and removes them.
+	WAL_ARCHIVE_DIR                    = "/var/lib/postgresql/wal-archive"
+	WAL_ARCHIVE_VOLUME                 = "wal-archive"
+	WAL_ARCHIVE_CREDENTIALS_VOLUME     = "wal-archive-credentials"
+	WAL_ARCHIVER_CONTAINER             = "wal-archiver"
+	WAL_ARCHIVE_POSTFIX                = "-wal"
+	DEFAULT_BASE_BACKUP_INTERVAL_HOURS = 24
+
+	// Segments needed for a point-in-time recovery are downloaded next to
+	// PGDATA on the data volume
+	WAL_RESTORE_DIR                = DATA_MOUNT_PATH + "/wal-restore"
+	WAL_RESTORE_CONTAINER          = "wal-restore"
+	WAL_RESTORE_CREDENTIALS_VOLUME = "restore-credentials"
+)
+
+// Uploads the archived segments and takes an exclusive base backup of the
+// shared data volume every BASE_BACKUP_INTERVAL seconds.
+const walArchiveScript = `case "$WAL_LOCATION" in
+  gs://*) gcloud auth activate-service-account --key-file="$GOOGLE_APPLICATION_CREDENTIALS" ;;
+esac
+upload() {
+  case "$WAL_LOCATION" in
+    gs://*) gsutil cp "$1" "$2" ;;
+    *) aws s3 cp "$1" "$2" ;;
+  esac
+}
+last_base=0
+while true; do
+  for f in "$WAL_DIR"/*; do
+    case "$f" in
+      *.tmp|"$WAL_DIR/*") continue ;;
+    esac
+    upload "$f" "$WAL_LOCATION/wal/$(basename "$f")" && rm -f "$f"
+  done
+  now=$(date +%s)
+  if [ $((now - last_base)) -ge "$BASE_BACKUP_INTERVAL" ] && pg_isready -h localhost -q; then
+    if psql -h localhost -c "select pg_start_backup('kubeplus', true)"; then
+      tar -czf /tmp/base.tar.gz -C "$PGDATA" --exclude='./pg_wal/*' --exclude='./pg_xlog/*' \
+        --exclude=./postmaster.pid --exclude=./postmaster.opts .
+      tar_status=$?
+      psql -h localhost -c "select pg_stop_backup()"
+      if [ $tar_status -eq 0 ] && upload /tmp/base.tar.gz "$WAL_LOCATION/base/$now/base.tar.gz"; then
+        last_base=$now
+      fi
+      rm -f /tmp/base.tar.gz
+    fi
+  fi
+  sleep 10
+done
+`
+
+// Restores the latest base backup taken before the target time and
+// configures Postgres to replay the archived WAL up to it. Data that already
+// exists is never overwritten, so the Pod can be restarted safely.
+const walRestoreScript = `set -e
+if [ -s "$PGDATA/PG_VERSION" ]; then
+  exit 0
+fi
+case "$RESTORE_FROM" in
+  gs://*) gcloud auth activate-service-account --key-file="$GOOGLE_APPLICATION_CREDENTIALS" ;;
+esac
+list() {
+  case "$RESTORE_FROM" in
+    gs://*) gsutil ls "$1" | sed 's#/$##; s#.*/##' ;;
+    *) aws s3 ls "$1" | awk '{print $NF}' | sed 's#/$##' ;;
+  esac
+}
+base=$(list "$RESTORE_FROM/base/" | awk -v t="$TARGET_EPOCH" '$1 <= t' | sort -n | tail -n 1)
+if [ -z "$base" ]; then
+  echo "no base backup in $RESTORE_FROM taken before $RECOVERY_TARGET_TIME"
+  exit 1
+fi
+mkdir -p "$PGDATA" "$WAL_RESTORE_DIR"
+case "$RESTORE_FROM" in
+  gs://*)
+    gsutil cp "$RESTORE_FROM/base/$base/base.tar.gz" - | tar -xzf - -C "$PGDATA"
+    gsutil -m cp "$RESTORE_FROM/wal/*" "$WAL_RESTORE_DIR/" ;;
+  *)
+    aws s3 cp "$RESTORE_FROM/base/$base/base.tar.gz" - | tar -xzf - -C "$PGDATA"
+    aws s3 cp --recursive "$RESTORE_FROM/wal/" "$WAL_RESTORE_DIR/" ;;
+esac
+settings="restore_command = 'cp $WAL_RESTORE_DIR/%f %p'
+recovery_target_time = '$RECOVERY_TARGET_TIME'
+recovery_target_action = 'promote'"
+if [ "$(cut -d. -f1 "$PGDATA/PG_VERSION")" -ge 12 ]; then
+  echo "$settings" >> "$PGDATA/postgresql.auto.conf"
+  touch "$PGDATA/recovery.signal"
+else
+  echo "$settings" > "$PGDATA/recovery.conf"
+fi
+# The postgres user of the official image
+chown -R 999:999 "$PGDATA" "$WAL_RESTORE_DIR"
+chmod 700 "$PGDATA"
+`
+
+// getWALArchivePrefix returns the bucket URL the WAL and the base backups
+// of this resource are stored under. It is also the source of a
+// point-in-time restore.
+func getWALArchivePrefix(foo *postgresv1.Postgres) string {
+	return strings.TrimSuffix(foo.Spec.WALArchive.Bucket, "/") + "/" + foo.Spec.DeploymentName + WAL_ARCHIVE_POSTFIX
+}
+
+func isPointInTimeRestore(foo *postgresv1.Postgres) bool {
+	return foo.Spec.RestoreFrom != nil && foo.Spec.RestoreFrom.PointInTime != ""
+}
+
+func validateWALArchive(foo *postgresv1.Postgres) []string {
+	var problems []string
+	walArchive := foo.Spec.WALArchive
+	if walArchive != nil {
+		if !isBackupLocation(walArchive.Bucket) {
+			problems = append(problems, "spec.walArchive.bucket must be an s3:// or gs:// URL")
+		}
+		if walArchive.BaseBackupIntervalHours < 0 {
+			problems = append(problems, "spec.walArchive.baseBackupIntervalHours must not be negative")
+		}
+		if foo.Spec.Storage == nil {
+			problems = append(problems, "spec.walArchive requires spec.storage")
+		}
+		// Base backups only cover the data directory
+		if len(foo.Spec.Tablespaces) > 0 {
+			problems = append(problems, "spec.walArchive cannot be used with spec.tablespaces")
+		}
+	}
+	if isPointInTimeRestore(foo) {
+		if _, err := time.Parse(time.RFC3339, foo.Spec.RestoreFrom.PointInTime); err != nil {
+			problems = append(problems, fmt.Sprintf("spec.restoreFrom.pointInTime must be an RFC 3339 timestamp: %s", err.Error()))
+		}
+		if !isBackupLocation(foo.Spec.RestoreFrom.Source) {
+			problems = append(problems, "spec.restoreFrom.source must be a WAL archive location for a point-in-time restore")
+		}
+		if foo.Spec.Storage == nil {
+			problems = append(problems, "spec.restoreFrom.pointInTime requires spec.storage")
+		}
+	}
+	return problems
+}
+
+// addStorageCredentials makes the object storage credentials of the Secret
+// available to the container, like for the backup CronJob.
+func addStorageCredentials(podSpec *apiv1.PodSpec, container *apiv1.Container, volumeName string, secretRef string) {
+	if secretRef == "" {
+		return
+	}
+	mountPath := path.Join(BACKUP_CREDENTIALS_PATH, volumeName)
+	container.Env = append(container.Env, apiv1.EnvVar{
+		Name:  "GOOGLE_APPLICATION_CREDENTIALS",
+		Value: mountPath + "/key.json",
+	})
+	container.EnvFrom = append(container.EnvFrom, apiv1.EnvFromSource{
+		SecretRef: &apiv1.SecretEnvSource{
+			LocalObjectReference: apiv1.LocalObjectReference{Name: secretRef},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+		Name:      volumeName,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+		Name: volumeName,
+		VolumeSource: apiv1.VolumeSource{
+			Secret: &apiv1.SecretVolumeSource{SecretName: secretRef},
+		},
+	})
+}
+
+// getDataVolumeMount mounts the data volume into a sidecar or init
+// container at the path used by the Postgres container.
+func getDataVolumeMount() apiv1.VolumeMount {
+	return apiv1.VolumeMount{
+		Name:      DATA_VOLUME_NAME,
+		MountPath: DATA_MOUNT_PATH,
+	}
+}
+
+// addWALArchive turns on WAL archiving and adds the archiver sidecar.
+// archive_command copies each segment to a volume shared with the sidecar,
+// as the Postgres image has no object storage client.
+func addWALArchive(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	walArchive := foo.Spec.WALArchive
+	if walArchive == nil || foo.Spec.Storage == nil {
+		return
+	}
+	image := walArchive.Image
+	if image == "" {
+		image = BACKUP_IMAGE
+	}
+	interval := walArchive.BaseBackupIntervalHours
+	if interval == 0 {
+		interval = DEFAULT_BASE_BACKUP_INTERVAL_HOURS
+	}
+
+	podSpec.Volumes = append(podSpec.Volumes, apiv1.Volume{
+		Name:         WAL_ARCHIVE_VOLUME,
+		VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}},
+	})
+	walArchiveMount := apiv1.VolumeMount{
+		Name:      WAL_ARCHIVE_VOLUME,
+		MountPath: WAL_ARCHIVE_DIR,
+	}
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, walArchiveMount)
+	if len(container.Args) == 0 {
+		container.Args = []string{"postgres"}
+	}
+	segment := path.Join(WAL_ARCHIVE_DIR, "%f")
+	container.Args = append(container.Args,
+		"-c", "wal_level=replica",
+		"-c", "archive_mode=on",
+		"-c", fmt.Sprintf("archive_command=test ! -f %s && cp %%p %s.tmp && mv %s.tmp %s",
+			segment, segment, segment, segment),
+		// Bounds the data lost with the Pod on an idle instance
+		"-c", "archive_timeout=60")
+
+	archiver := apiv1.Container{
+		Name:    WAL_ARCHIVER_CONTAINER,
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", walArchiveScript},
+		Env: []apiv1.EnvVar{
+			{Name: "WAL_DIR", Value: WAL_ARCHIVE_DIR},
+			{Name: "WAL_LOCATION", Value: getWALArchivePrefix(foo)},
+			{Name: "BASE_BACKUP_INTERVAL", Value: fmt.Sprint(interval * 3600)},
+			{Name: "PGDATA", Value: getPGDataDir(foo.Spec.Storage)},
+			{Name: "PGUSER", Value: getSuperuserName(foo)},
+			getSuperuserPasswordEnv(foo, "PGPASSWORD"),
+		},
+		VolumeMounts: []apiv1.VolumeMount{walArchiveMount, getDataVolumeMount()},
+	}
+	addStorageCredentials(podSpec, &archiver, WAL_ARCHIVE_CREDENTIALS_VOLUME, walArchive.CredentialsSecretRef)
+	podSpec.Containers = append(podSpec.Containers, archiver)
+}
+
+// addPointInTimeRestore adds the init container restoring a new instance
+// to Spec.RestoreFrom.PointInTime. The WAL archive is read with the
+// credentials of Spec.WALArchive, or else of Spec.Backup.
+func addPointInTimeRestore(podSpec *apiv1.PodSpec, foo *postgresv1.Postgres) {
+	if !isPointInTimeRestore(foo) || foo.Status.Restored || foo.Spec.Storage == nil {
+		return
+	}
+	image := BACKUP_IMAGE
+	var secretRef string
+	if foo.Spec.WALArchive != nil {
+		secretRef = foo.Spec.WALArchive.CredentialsSecretRef
+		if foo.Spec.WALArchive.Image != "" {
+			image = foo.Spec.WALArchive.Image
+		}
+	} else if foo.Spec.Backup != nil {
+		secretRef = foo.Spec.Backup.CredentialsSecretRef
+		if foo.Spec.Backup.Image != "" {
+			image = foo.Spec.Backup.Image
+		}
+	}
+	// Validated by the webhook, an invalid time fails the restore
+	var targetEpoch int64
+	if target, err := time.Parse(time.RFC3339, foo.Spec.RestoreFrom.PointInTime); err == nil {
+		targetEpoch = target.Unix()
+	}
+
+	restore := apiv1.Container{
+		Name:    WAL_RESTORE_CONTAINER,
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", walRestoreScript},
+		Env: []apiv1.EnvVar{
+			{Name: "RESTORE_FROM", Value: strings.TrimSuffix(foo.Spec.RestoreFrom.Source, "/")},
+			{Name: "RECOVERY_TARGET_TIME", Value: foo.Spec.RestoreFrom.PointInTime},
+			{Name: "TARGET_EPOCH", Value: fmt.Sprint(targetEpoch)},
+			{Name: "PGDATA", Value: getPGDataDir(foo.Spec.Storage)},
+			{Name: "WAL_RESTORE_DIR", Value: WAL_RESTORE_DIR},
+		},
+		VolumeMounts: []apiv1.VolumeMount{getDataVolumeMount()},
+	}
+	addStorageCredentials(podSpec, &restore, WAL_RESTORE_CREDENTIALS_VOLUME, secretRef)
+	podSpec.InitContainers = append(podSpec.InitContainers, restore)
+}
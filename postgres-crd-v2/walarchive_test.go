@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+func getContainer(podSpec apiv1.PodSpec, name string) *apiv1.Container {
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == name {
+			return &podSpec.Containers[i]
+		}
+	}
+	for i := range podSpec.InitContainers {
+		if podSpec.InitContainers[i].Name == name {
+			return &podSpec.InitContainers[i]
+		}
+	}
+	return nil
+}
+
+func newWALArchiveTestPostgres() *postgresv1.Postgres {
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.WALArchive = &postgresv1.WALArchiveSpec{
+		Bucket:               "s3://wal/",
+		CredentialsSecretRef: "aws-credentials",
+	}
+	return foo
+}
+
+func TestWALArchiveConfiguresArchiving(t *testing.T) {
+	foo := newWALArchiveTestPostgres()
+	foo.Spec.ConfigMapRef = "client25-config"
+	podSpec := getDeployment(foo).Spec.Template.Spec
+
+	args := strings.Join(podSpec.Containers[0].Args, " ")
+	if !strings.HasPrefix(args, "postgres -c config_file=") {
+		t.Errorf("expected the config file to be kept, got %q", args)
+	}
+	if !strings.Contains(args, "-c archive_mode=on") ||
+		!strings.Contains(args, "archive_command=test ! -f /var/lib/postgresql/wal-archive/%f && cp %p") {
+		t.Errorf("expected archiving to be turned on, got %q", args)
+	}
+
+	archiver := getContainer(podSpec, WAL_ARCHIVER_CONTAINER)
+	if archiver == nil {
+		t.Fatalf("expected the %s sidecar, got %v", WAL_ARCHIVER_CONTAINER, podSpec.Containers)
+	}
+	expectedEnv := map[string]string{
+		"WAL_LOCATION":         "s3://wal/client25-wal",
+		"BASE_BACKUP_INTERVAL": "86400",
+		"PGDATA":               "/var/lib/postgresql/data/pgdata",
+	}
+	for name, expected := range expectedEnv {
+		if got, _ := getEnv(*archiver, name); got != expected {
+			t.Errorf("expected %s %s, got %s", name, expected, got)
+		}
+	}
+	if len(archiver.EnvFrom) != 1 || archiver.EnvFrom[0].SecretRef.Name != "aws-credentials" {
+		t.Errorf("expected credentials from aws-credentials, got %v", archiver.EnvFrom)
+	}
+}
+
+func TestWALArchiveRequiresStorage(t *testing.T) {
+	foo := newWALArchiveTestPostgres()
+	foo.Spec.Storage = nil
+	podSpec := getDeployment(foo).Spec.Template.Spec
+	if len(podSpec.Containers[0].Args) != 0 || getContainer(podSpec, WAL_ARCHIVER_CONTAINER) != nil {
+		t.Errorf("expected no archiving without storage, got %v", podSpec.Containers)
+	}
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected storage to be required, got %v", problems)
+	}
+}
+
+func TestPointInTimeRestore(t *testing.T) {
+	foo := newWALArchiveTestPostgres()
+	foo.Spec.RestoreFrom = &postgresv1.RestoreSpec{
+		Source:      "s3://wal/client24-wal/",
+		PointInTime: "2018-06-01T12:00:00Z",
+	}
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+
+	restore := getContainer(getDeployment(foo).Spec.Template.Spec, WAL_RESTORE_CONTAINER)
+	if restore == nil {
+		t.Fatalf("expected the %s init container", WAL_RESTORE_CONTAINER)
+	}
+	if got, _ := getEnv(*restore, "RESTORE_FROM"); got != "s3://wal/client24-wal" {
+		t.Errorf("expected RESTORE_FROM s3://wal/client24-wal, got %s", got)
+	}
+	if got, _ := getEnv(*restore, "TARGET_EPOCH"); got != "1527854400" {
+		t.Errorf("expected TARGET_EPOCH 1527854400, got %s", got)
+	}
+
+	foo.Status.Restored = true
+	if getContainer(getDeployment(foo).Spec.Template.Spec, WAL_RESTORE_CONTAINER) != nil {
+		t.Errorf("expected no restore once the instance was restored")
+	}
+
+	foo.Spec.RestoreFrom.PointInTime = "yesterday"
+	if problems := validatePostgresSpec(foo); len(problems) != 1 {
+		t.Errorf("expected an invalid point in time to be rejected, got %v", problems)
+	}
+}
+
+func TestRestoreFromAcceptsPlainSource(t *testing.T) {
+	var spec postgresv1.PostgresSpec
+	if err := json.Unmarshal([]byte(`{"restoreFrom": "client24"}`), &spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec.RestoreFrom == nil || spec.RestoreFrom.Source != "client24" || isPointInTimeRestore(&postgresv1.Postgres{Spec: spec}) {
+		t.Errorf("expected a restore from client24, got %+v", spec.RestoreFrom)
+	}
+}
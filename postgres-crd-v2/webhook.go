@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+)
+
+// identifierPattern matches the database and user names accepted by the
+// controller. Database names are not quoted in the commands, and longer
+// names would be truncated by Postgres.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// validateNames checks the names of the databases and users.
+func validateNames(foo *postgresv1.Postgres) []string {
+	var problems []string
+	for _, user := range foo.Spec.Users {
+		if !identifierPattern.MatchString(user.User) {
+			problems = append(problems, fmt.Sprintf("invalid user name %q, must start with a letter or underscore "+
+				"followed by at most 62 letters, digits or underscores", user.User))
+		}
+	}
+	for _, db := range foo.Spec.Databases {
+		if !identifierPattern.MatchString(db.Name) {
+			problems = append(problems, fmt.Sprintf("invalid database name %q, must start with a letter or underscore "+
+				"followed by at most 62 letters, digits or underscores", db.Name))
+		}
+	}
+	return problems
+}
+
+// validateOwners checks that the owners of the databases and schemas are
+// declared in the spec. Only the superuser may own objects without being
+// declared.
+func validateOwners(foo *postgresv1.Postgres) []string {
+	var problems []string
+	owners := []string{getSuperuserName(foo)}
+	for _, user := range foo.Spec.Users {
+		owners = append(owners, user.User)
+	}
+	for _, db := range foo.Spec.Databases {
+		if db.Owner != "" && !contains(owners, db.Owner) {
+			problems = append(problems, fmt.Sprintf("database %s: owner %s is not declared in spec.users", db.Name, db.Owner))
+		}
+		for _, schema := range db.Schemas {
+			if schema.Owner != "" && !contains(owners, schema.Owner) {
+				problems = append(problems, fmt.Sprintf("schema %s.%s: owner %s is not declared in spec.users",
+					db.Name, schema.Name, schema.Owner))
+			}
+		}
+	}
+	return problems
+}
+
+// validatePostgresSpec checks the fields syncHandler cannot do without.
+func validatePostgresSpec(foo *postgresv1.Postgres) []string {
+	var problems []string
+	problems = append(problems, validateNames(foo)...)
+	problems = append(problems, validateOwners(foo)...)
+	problems = append(problems, validateGrants(foo)...)
+	if err := validateSSLMode(foo.Spec.SSLMode); err != nil {
+		problems = append(problems, err.Error())
+	}
+	problems = append(problems, validateClientCert(foo)...)
+	problems = append(problems, validateCommands(foo)...)
+	problems = append(problems, validateRenames(foo)...)
+	problems = append(problems, validateDatabaseConnectionLimits(foo)...)
+	problems = append(problems, validateExtensions(foo)...)
+	if err := validatePasswordEncryption(foo.Spec.PasswordEncryption); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := validatePasswordRotation(foo.Spec.PasswordRotation); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := validateDriftCheckInterval(foo); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := validateTerminationGracePeriod(foo); err != nil {
+		problems = append(problems, err.Error())
+	}
+	problems = append(problems, validatePasswordSources(foo)...)
+	if foo.Spec.Backup != nil {
+		problems = append(problems, validateBackup(foo.Spec.Backup)...)
+	}
+	if foo.Spec.RestoreFrom != nil && foo.Spec.DeploymentName == "" {
+		problems = append(problems, "spec.restoreFrom requires spec.deploymentName")
+	}
+	if foo.Spec.Pooler != nil {
+		if err := validatePoolMode(foo.Spec.Pooler.Mode); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	// Resources on a shared or external instance have no Deployment
+	if foo.Spec.SharedInstance != "" || foo.Spec.ExternalEndpoint != nil {
+		if foo.Spec.SharedInstance != "" && foo.Spec.ExternalEndpoint != nil {
+			problems = append(problems, "only one of spec.sharedInstance and spec.externalEndpoint can be set")
+		}
+		if foo.Spec.ExternalEndpoint != nil {
+			problems = append(problems, validateExternalHosts(foo.Spec.ExternalEndpoint.Hosts)...)
+		}
+		if foo.Spec.Storage != nil {
+			problems = append(problems, "spec.storage requires an instance created by the controller")
+		}
+		if len(foo.Spec.Tablespaces) > 0 {
+			problems = append(problems, "spec.tablespaces requires an instance created by the controller")
+		}
+		if foo.Spec.WALArchive != nil {
+			problems = append(problems, "spec.walArchive requires an instance created by the controller")
+		}
+		if foo.Spec.Service != nil {
+			problems = append(problems, "spec.service requires an instance created by the controller")
+		}
+		if len(foo.Spec.Parameters) > 0 {
+			problems = append(problems, "spec.parameters requires an instance created by the controller")
+		}
+		if foo.Spec.UseSetupJob {
+			problems = append(problems, "spec.useSetupJob requires an instance created by the controller")
+		}
+		if foo.Spec.SuperuserPasswordFromFile != "" {
+			problems = append(problems, "spec.superuserPasswordFromFile requires an instance created by the controller")
+		}
+		if foo.Spec.TLS != nil {
+			problems = append(problems, "spec.tls requires an instance created by the controller")
+		}
+		if foo.Spec.TerminationGracePeriodSeconds != nil {
+			problems = append(problems, "spec.terminationGracePeriodSeconds requires an instance created by the controller")
+		}
+		if foo.Spec.InitScriptsConfigMapRef != "" {
+			problems = append(problems, "spec.initScriptsConfigMapRef requires an instance created by the controller")
+		}
+		if foo.Spec.PriorityClassName != "" || foo.Spec.RuntimeClassName != "" {
+			problems = append(problems, "spec.priorityClassName and spec.runtimeClassName require an instance created by the controller")
+		}
+		return problems
+	}
+	problems = append(problems, validateStorage(foo)...)
+	problems = append(problems, validateTablespaces(foo.Spec.Tablespaces)...)
+	problems = append(problems, validateWALArchive(foo)...)
+	problems = append(problems, validateServerTLS(foo)...)
+	if foo.Spec.Service != nil {
+		problems = append(problems, validateService(foo.Spec.Service)...)
+	}
+	problems = append(problems, validateParameters(foo.Spec.Parameters)...)
+	problems = append(problems, validateInitScripts(foo)...)
+	problems = append(problems, validateClassNames(foo)...)
+	// The Job has no CA to verify the server certificate with
+	if sslMode := getSSLMode(foo); foo.Spec.UseSetupJob && (sslMode == "verify-ca" || sslMode == "verify-full") {
+		problems = append(problems, fmt.Sprintf("spec.useSetupJob cannot be used with sslMode %s", sslMode))
+	}
+	// The Job connects with the password of the Secret, which is not set yet
+	if foo.Spec.UseSetupJob && foo.Spec.PasswordRotation == PASSWORD_ROTATION_ON_SECRET_CHANGE {
+		problems = append(problems, "spec.passwordRotation cannot be used with spec.useSetupJob")
+	}
+	if foo.Spec.Replicas != nil && *foo.Spec.Replicas < 1 {
+		problems = append(problems, "spec.replicas must be at least 1")
+	}
+	// Without storage every replica would be an independent empty instance
+	if foo.Spec.Replicas != nil && *foo.Spec.Replicas > 1 && foo.Spec.Storage == nil {
+		problems = append(problems, "spec.replicas greater than 1 requires spec.storage")
+	}
+	if foo.Spec.DeploymentName == "" {
+		problems = append(problems, "spec.deploymentName must be specified")
+	}
+	if foo.Spec.Image == "" {
+		problems = append(problems, "spec.image must be specified")
+	}
+	return problems
+}
+
+// validateDeploymentName rejects a new Postgres resource whose Deployment
+// would collide with an existing Deployment or another Postgres resource.
+func (c *Controller) validateDeploymentName(foo *postgresv1.Postgres) error {
+	deploymentName := foo.Spec.DeploymentName
+	if deploymentName == "" {
+		return nil
+	}
+	// createDeployment creates all Deployments in the instance namespace
+	namespace := c.getInstanceNamespace()
+	_, err := c.deploymentsLister.Deployments(namespace).Get(deploymentName)
+	if err == nil {
+		return fmt.Errorf("deployment %s already exists in namespace %s", deploymentName, namespace)
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	all, err := c.foosLister.Postgreses(c.namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, other := range all {
+		if other.Namespace == foo.Namespace && other.Name == foo.Name {
+			continue
+		}
+		if other.Spec.DeploymentName == deploymentName {
+			return fmt.Errorf("deployment name %s is already used by postgres %s/%s",
+				deploymentName, other.Namespace, other.Name)
+		}
+	}
+	return nil
+}
+
+func (c *Controller) admit(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	foo := &postgresv1.Postgres{}
+	if err := json.Unmarshal(request.Object.Raw, foo); err != nil {
+		return toAdmissionResponse(err.Error())
+	}
+	// The defaults are set by the mutating webhook, or else by the
+	// controller
+	setDefaults(foo)
+	problems := validatePostgresSpec(foo)
+	checkDeploymentName := request.Operation == admissionv1beta1.Create
+	if request.Operation == admissionv1beta1.Update {
+		oldFoo := &postgresv1.Postgres{}
+		if err := json.Unmarshal(request.OldObject.Raw, oldFoo); err != nil {
+			return toAdmissionResponse(err.Error())
+		}
+		setDefaults(oldFoo)
+		// The Deployment, Service and Secrets of the instance are named
+		// after it and would be left behind
+		if oldFoo.Spec.DeploymentName != "" && foo.Spec.DeploymentName != oldFoo.Spec.DeploymentName {
+			problems = append(problems, fmt.Sprintf("spec.deploymentName cannot be changed from %s to %s",
+				oldFoo.Spec.DeploymentName, foo.Spec.DeploymentName))
+		}
+		checkDeploymentName = oldFoo.Spec.DeploymentName == "" && foo.Spec.DeploymentName != ""
+	}
+	if checkDeploymentName {
+		if err := c.validateDeploymentName(foo); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return toAdmissionResponse(strings.Join(problems, "; "))
+	}
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+// mutate sets the defaults of the Postgres resource with a JSON patch.
+func (c *Controller) mutate(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	foo := &postgresv1.Postgres{}
+	if err := json.Unmarshal(request.Object.Raw, foo); err != nil {
+		return toAdmissionResponse(err.Error())
+	}
+	patch, err := getDefaultsPatch(foo)
+	if err != nil {
+		return toAdmissionResponse(err.Error())
+	}
+	response := &admissionv1beta1.AdmissionResponse{Allowed: true}
+	if patch != nil {
+		patchType := admissionv1beta1.PatchTypeJSONPatch
+		response.Patch = patch
+		response.PatchType = &patchType
+	}
+	return response
+}
+
+func toAdmissionResponse(message string) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: message,
+		},
+	}
+}
+
+// serveValidate handles validating AdmissionReview requests for Postgres
+// resources.
+func (c *Controller) serveValidate(w http.ResponseWriter, r *http.Request) {
+	serveAdmission(w, r, c.admit)
+}
+
+// serveMutate handles mutating AdmissionReview requests for Postgres
+// resources.
+func (c *Controller) serveMutate(w http.ResponseWriter, r *http.Request) {
+	serveAdmission(w, r, c.mutate)
+}
+
+func serveAdmission(w http.ResponseWriter, r *http.Request,
+	review func(*admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	admissionReview := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &admissionReview); err != nil || admissionReview.Request == nil {
+		http.Error(w, "could not decode admission review", http.StatusBadRequest)
+		return
+	}
+	admissionReview.Response = review(admissionReview.Request)
+	admissionReview.Response.UID = admissionReview.Request.UID
+	glog.V(4).Infof("Admission of postgres %s/%s allowed: %v", admissionReview.Request.Namespace,
+		admissionReview.Request.Name, admissionReview.Response.Allowed)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(admissionReview); err != nil {
+		glog.Errorf("Error writing admission response: %s", err.Error())
+	}
+}
+
+// RunWebhookServer serves the validating and mutating webhooks over TLS. It blocks until
+// the server fails.
+func (c *Controller) RunWebhookServer(addr string, certFile string, keyFile string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", c.serveValidate)
+	mux.HandleFunc("/mutate", c.serveMutate)
+	server := &http.Server{Addr: addr, Handler: mux}
+	glog.Infof("Starting webhook server on %s", addr)
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
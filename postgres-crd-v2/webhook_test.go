@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+
+	postgresv1 "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/apis/postgrescontroller/v1"
+	listers "github.com/cloud-ark/kubeplus/postgres-crd-v2/pkg/client/listers/postgrescontroller/v1"
+)
+
+func newTestWebhookController(deployments []*appsv1.Deployment, foos []*postgresv1.Postgres) *Controller {
+	deploymentIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, d := range deployments {
+		deploymentIndexer.Add(d)
+	}
+	fooIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, f := range foos {
+		fooIndexer.Add(f)
+	}
+	return &Controller{
+		deploymentsLister: appslisters.NewDeploymentLister(deploymentIndexer),
+		foosLister:        listers.NewPostgresLister(fooIndexer),
+	}
+}
+
+func TestValidatePostgresSpec(t *testing.T) {
+	foo := newTestPostgres(nil)
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	foo.Spec.DeploymentName = ""
+	foo.Spec.Image = ""
+	if problems := validatePostgresSpec(foo); len(problems) != 2 {
+		t.Errorf("expected 2 problems, got %v", problems)
+	}
+}
+
+func TestValidateSpecCombinations(t *testing.T) {
+	replicas := int32(2)
+	testCases := []struct {
+		name   string
+		modify func(foo *postgresv1.Postgres)
+	}{
+		{"external with storage", func(foo *postgresv1.Postgres) {
+			foo.Spec.ExternalEndpoint = &postgresv1.ExternalEndpointSpec{Host: "db.example.com", AdminSecretRef: "admin"}
+			foo.Spec.Storage = &postgresv1.StorageSpec{Size: "1Gi"}
+		}},
+		{"shared and external", func(foo *postgresv1.Postgres) {
+			foo.Spec.SharedInstance = "client24"
+			foo.Spec.ExternalEndpoint = &postgresv1.ExternalEndpointSpec{Host: "db.example.com", AdminSecretRef: "admin"}
+		}},
+		{"replicas without storage", func(foo *postgresv1.Postgres) {
+			foo.Spec.Replicas = &replicas
+		}},
+		{"no replicas", func(foo *postgresv1.Postgres) {
+			none := int32(0)
+			foo.Spec.Replicas = &none
+		}},
+		{"undeclared owner", func(foo *postgresv1.Postgres) {
+			foo.Spec.Databases = []postgresv1.DatabaseSpec{{Name: "moodle", Owner: "devdatta"}}
+		}},
+		{"undeclared schema owner", func(foo *postgresv1.Postgres) {
+			foo.Spec.Databases = []postgresv1.DatabaseSpec{{Name: "moodle",
+				Schemas: []postgresv1.SchemaSpec{{Name: "app", Owner: "devdatta"}}}}
+		}},
+		{"invalid database name", func(foo *postgresv1.Postgres) {
+			foo.Spec.Databases = newDatabaseSpecs("moodle; drop database postgres")
+		}},
+		{"invalid user name", func(foo *postgresv1.Postgres) {
+			foo.Spec.Users = []postgresv1.UserSpec{{User: "dev-datta", Password: "pass123"}}
+		}},
+	}
+	for _, tc := range testCases {
+		foo := newTestPostgres(nil)
+		tc.modify(foo)
+		if problems := validatePostgresSpec(foo); len(problems) != 1 {
+			t.Errorf("%s: expected 1 problem, got %v", tc.name, problems)
+		}
+	}
+
+	foo := newTestPostgres(&postgresv1.StorageSpec{Size: "1Gi"})
+	foo.Spec.Replicas = &replicas
+	foo.Spec.Users = []postgresv1.UserSpec{{User: "devdatta", Password: "pass123"}}
+	foo.Spec.Databases = []postgresv1.DatabaseSpec{{Name: "moodle", Owner: "devdatta",
+		Schemas: []postgresv1.SchemaSpec{{Name: "app", Owner: "postgres"}}}}
+	if problems := validatePostgresSpec(foo); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+}
+
+func TestValidateDeploymentName(t *testing.T) {
+	existing := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "client25", Namespace: "default"}}
+	c := newTestWebhookController([]*appsv1.Deployment{existing}, nil)
+	if err := c.validateDeploymentName(newTestPostgres(nil)); err == nil {
+		t.Errorf("expected duplicate deployment name to be rejected")
+	}
+
+	other := newTestPostgres(nil)
+	other.Name = "other"
+	other.Namespace = "team1"
+	c = newTestWebhookController(nil, []*postgresv1.Postgres{other})
+	if err := c.validateDeploymentName(newTestPostgres(nil)); err == nil {
+		t.Errorf("expected deployment name used by another postgres to be rejected")
+	}
+
+	c = newTestWebhookController(nil, nil)
+	if err := c.validateDeploymentName(newTestPostgres(nil)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateDeploymentNameNamespaced(t *testing.T) {
+	existing := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "client25", Namespace: "default"}}
+	other := newTestPostgres(nil)
+	other.Name = "other"
+	other.Namespace = "team1"
+	c := newTestWebhookController([]*appsv1.Deployment{existing}, []*postgresv1.Postgres{other})
+	c.namespace = "team2"
+	foo := newTestPostgres(nil)
+	foo.Namespace = "team2"
+	if err := c.validateDeploymentName(foo); err != nil {
+		t.Errorf("expected names used outside of the watched namespace to be allowed, got %v", err)
+	}
+
+	existing.Namespace = "team2"
+	c = newTestWebhookController([]*appsv1.Deployment{existing}, nil)
+	c.namespace = "team2"
+	if err := c.validateDeploymentName(foo); err == nil {
+		t.Errorf("expected duplicate deployment name in the watched namespace to be rejected")
+	}
+}
+
+func TestAdmitDeploymentNameOnUpdate(t *testing.T) {
+	other := newTestPostgres(nil)
+	other.Name = "other"
+	other.Spec.DeploymentName = "moodle-db"
+	c := newTestWebhookController(nil, []*postgresv1.Postgres{other, newTestPostgres(nil)})
+
+	newUpdateRequest := func(oldFoo, foo *postgresv1.Postgres) *admissionv1beta1.AdmissionRequest {
+		request := newAdmissionRequest(t, foo)
+		oldRaw, err := json.Marshal(oldFoo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		request.Operation = admissionv1beta1.Update
+		request.OldObject = runtime.RawExtension{Raw: oldRaw}
+		return request
+	}
+
+	if response := c.admit(newUpdateRequest(newTestPostgres(nil), newTestPostgres(nil))); !response.Allowed {
+		t.Errorf("expected an unchanged deployment name to be allowed, got %v", response.Result)
+	}
+
+	renamed := newTestPostgres(nil)
+	renamed.Spec.DeploymentName = "moodle-db"
+	if response := c.admit(newUpdateRequest(newTestPostgres(nil), renamed)); response.Allowed {
+		t.Errorf("expected a changed deployment name to be rejected")
+	}
+
+	// Moving off a shared instance gives the resource a Deployment of its own
+	shared := newTestPostgres(nil)
+	shared.Spec.DeploymentName = ""
+	shared.Spec.SharedInstance = "client24"
+	if response := c.admit(newUpdateRequest(shared, renamed)); response.Allowed {
+		t.Errorf("expected a deployment name used by another postgres to be rejected")
+	}
+}